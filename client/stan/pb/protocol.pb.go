@@ -4,6 +4,7 @@
 package pb
 
 import (
+	encoding_binary "encoding/binary"
 	fmt "fmt"
 	_ "github.com/gogo/protobuf/gogoproto"
 	proto "github.com/gogo/protobuf/proto"
@@ -405,6 +406,8 @@ type SubscriptionRequest struct {
 	StartPosition  StartPosition `protobuf:"varint,10,opt,name=startPosition,proto3,enum=pb.StartPosition" json:"startPosition,omitempty"`
 	StartSequence  uint64        `protobuf:"varint,11,opt,name=startSequence,proto3" json:"startSequence,omitempty"`
 	StartTimeDelta int64         `protobuf:"varint,12,opt,name=startTimeDelta,proto3" json:"startTimeDelta,omitempty"`
+	ReplaySpeed    float64       `protobuf:"fixed64,13,opt,name=replaySpeed,proto3" json:"replaySpeed,omitempty"`
+	Filter         string        `protobuf:"bytes,14,opt,name=filter,proto3" json:"filter,omitempty"`
 }
 
 func (m *SubscriptionRequest) Reset()         { *m = SubscriptionRequest{} }
@@ -616,61 +619,62 @@ func init() {
 func init() { proto.RegisterFile("protocol.proto", fileDescriptor_2bc2336598a3f7e0) }
 
 var fileDescriptor_2bc2336598a3f7e0 = []byte{
-	// 850 bytes of a gzipped FileDescriptorProto
+	// 876 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x94, 0x4f, 0x6f, 0xe3, 0x44,
-	0x18, 0xc6, 0x33, 0xf9, 0xd7, 0xf4, 0x6d, 0x92, 0xcd, 0x0e, 0xd5, 0xca, 0xaa, 0x56, 0x56, 0x65,
-	0x2d, 0xa8, 0xaa, 0x44, 0x57, 0xea, 0x0a, 0x38, 0x70, 0x82, 0x54, 0x0b, 0x11, 0x74, 0x37, 0x72,
-	0x41, 0x5c, 0x19, 0x3b, 0xb3, 0xee, 0x50, 0x67, 0xc6, 0xeb, 0x19, 0x97, 0xf6, 0x08, 0x9f, 0x80,
-	0x0f, 0xc2, 0x07, 0xd9, 0x03, 0x87, 0x3d, 0x72, 0x84, 0xf6, 0xc6, 0xa7, 0x40, 0xf3, 0xda, 0x71,
-	0xc6, 0x29, 0x5b, 0x90, 0xb8, 0xf9, 0xf9, 0xe5, 0xf5, 0x64, 0xde, 0xe7, 0x79, 0x5f, 0xc3, 0x38,
-	0xcb, 0x95, 0x51, 0xb1, 0x4a, 0x8f, 0xf0, 0x81, 0xb6, 0xb3, 0x68, 0xef, 0xc3, 0x44, 0x98, 0xf3,
-	0x22, 0x3a, 0x8a, 0xd5, 0xf2, 0x69, 0xa2, 0x12, 0xf5, 0x14, 0x7f, 0x8a, 0x8a, 0x57, 0xa8, 0x50,
-	0xe0, 0x53, 0xf9, 0x4a, 0xf0, 0x2b, 0x81, 0xfe, 0xbc, 0x88, 0x4e, 0x75, 0x42, 0xf7, 0x60, 0x10,
-	0xa7, 0x82, 0x4b, 0x33, 0x3b, 0xf1, 0xc8, 0x3e, 0x39, 0xd8, 0x0e, 0x6b, 0x4d, 0x29, 0x74, 0x93,
-	0x42, 0x2c, 0xbc, 0x36, 0x72, 0x7c, 0xa6, 0x1e, 0x6c, 0xe9, 0x22, 0xfa, 0x81, 0xc7, 0xc6, 0xeb,
-	0x20, 0x5e, 0x49, 0xba, 0x0b, 0xbd, 0x9c, 0x67, 0xe9, 0xb5, 0xd7, 0x45, 0x5e, 0x0a, 0x7b, 0xc6,
-	0x82, 0x19, 0xe6, 0xf5, 0xf6, 0xc9, 0xc1, 0x30, 0xc4, 0x67, 0xfa, 0x08, 0xfa, 0xb1, 0x92, 0x72,
-	0x76, 0xe2, 0xf5, 0x91, 0x56, 0xca, 0x72, 0x7d, 0xce, 0x8e, 0x3f, 0xfa, 0xd8, 0x83, 0x92, 0x97,
-	0x2a, 0x38, 0xc6, 0xdb, 0x7e, 0x16, 0x5f, 0xd4, 0x37, 0x22, 0xce, 0x8d, 0x76, 0xa1, 0xc7, 0xf3,
-	0x5c, 0xe5, 0xd5, 0x35, 0x4b, 0x11, 0xfc, 0x45, 0x60, 0x70, 0xaa, 0x93, 0x39, 0x5a, 0xb4, 0x07,
-	0x03, 0xcd, 0x5f, 0x17, 0x5c, 0xc6, 0x1c, 0x5f, 0xed, 0x86, 0xb5, 0x76, 0x1b, 0x6a, 0xbf, 0xa3,
-	0xa1, 0xce, 0x3f, 0x35, 0xd4, 0x75, 0x1a, 0x7a, 0x0c, 0xdb, 0x46, 0x2c, 0xb9, 0x36, 0x6c, 0x99,
-	0x61, 0xa7, 0x9d, 0x70, 0x0d, 0xe8, 0x3e, 0xec, 0xe4, 0x7c, 0xc1, 0x53, 0x71, 0xc9, 0x73, 0xbe,
-	0xc0, 0x9e, 0x07, 0xa1, 0x8b, 0xe8, 0x01, 0x3c, 0xa8, 0xe5, 0xf5, 0x54, 0x15, 0xd2, 0x78, 0x5b,
-	0xfb, 0xe4, 0x60, 0x14, 0x6e, 0x62, 0x7b, 0xa7, 0x69, 0x38, 0x7d, 0x76, 0x8c, 0x0e, 0x8d, 0xc2,
-	0x52, 0x04, 0x9f, 0x42, 0xc7, 0xba, 0xe3, 0xb4, 0x42, 0x9a, 0xad, 0xb8, 0x06, 0xb4, 0x9b, 0x06,
-	0x04, 0xbf, 0x11, 0x18, 0x4f, 0x95, 0x94, 0x3c, 0x36, 0xa1, 0x65, 0xda, 0xdc, 0x3b, 0x14, 0x1f,
-	0xc0, 0xf8, 0x9c, 0xb3, 0xdc, 0x44, 0x9c, 0x99, 0x99, 0x8c, 0xd4, 0x55, 0x65, 0xdb, 0x06, 0xb5,
-	0x67, 0xac, 0x06, 0x15, 0x0d, 0xec, 0x85, 0xb5, 0x76, 0x06, 0xa0, 0xdb, 0x18, 0x80, 0x00, 0x86,
-	0x99, 0x90, 0xc9, 0x4c, 0x1a, 0x9e, 0x5f, 0xb2, 0x14, 0xad, 0xec, 0x85, 0x0d, 0x46, 0x7d, 0x00,
-	0xab, 0x4f, 0xd9, 0xd5, 0xcb, 0xc2, 0xa0, 0x99, 0xbd, 0xd0, 0x21, 0xc1, 0x4f, 0x1d, 0x78, 0x50,
-	0xb7, 0xa3, 0x33, 0x25, 0x35, 0xb7, 0xf9, 0x64, 0x45, 0x34, 0xcf, 0xf9, 0x2b, 0x71, 0x55, 0x35,
-	0xb4, 0x06, 0x36, 0x1f, 0x5d, 0x44, 0x55, 0xef, 0xba, 0x6a, 0xc7, 0x45, 0xf4, 0x09, 0x8c, 0x0a,
-	0xe9, 0xd6, 0x94, 0x13, 0xd1, 0x84, 0xb6, 0x2a, 0x4e, 0x95, 0xe6, 0x75, 0x55, 0xb9, 0x08, 0x4d,
-	0xb8, 0x1e, 0xd7, 0x9e, 0x33, 0xae, 0xf4, 0x10, 0x26, 0xba, 0x88, 0xa6, 0x8d, 0xd7, 0xfb, 0x58,
-	0x70, 0x87, 0xaf, 0x5c, 0xaa, 0xeb, 0xb6, 0xb0, 0xae, 0xc1, 0xee, 0x38, 0x39, 0xf8, 0x57, 0x27,
-	0xb7, 0x37, 0x9d, 0x6c, 0x24, 0x08, 0x1b, 0x09, 0x96, 0x8e, 0xa6, 0x22, 0xfe, 0x8a, 0x5f, 0x7b,
-	0x8b, 0xda, 0xd1, 0x12, 0x04, 0x3e, 0x74, 0xe7, 0x42, 0x26, 0x4e, 0xce, 0xc4, 0xcd, 0x39, 0x78,
-	0x02, 0xc3, 0x39, 0xde, 0xb6, 0xca, 0xa7, 0xf6, 0x84, 0x34, 0x56, 0xb8, 0x0d, 0xef, 0x9d, 0x15,
-	0x91, 0x8e, 0x73, 0x91, 0x19, 0xa1, 0xe4, 0x7f, 0x99, 0xce, 0x77, 0x6f, 0xf3, 0x23, 0xe8, 0xbf,
-	0xfe, 0x22, 0x57, 0x45, 0x56, 0x85, 0x57, 0x29, 0xfb, 0xdf, 0x02, 0xc7, 0xb8, 0xfa, 0x6c, 0xa1,
-	0xb0, 0x33, 0xb1, 0x64, 0x57, 0x33, 0xf9, 0x3c, 0x15, 0xc9, 0xb9, 0xa9, 0x06, 0xd1, 0x45, 0x36,
-	0x6d, 0x16, 0x5f, 0x7c, 0xc7, 0x84, 0x99, 0xc9, 0x33, 0x1e, 0xeb, 0x6a, 0x14, 0x9b, 0xd0, 0x9e,
-	0xb3, 0x28, 0x72, 0x16, 0xa5, 0xfc, 0x05, 0x5b, 0xf2, 0x2a, 0x2a, 0x17, 0xd1, 0x4f, 0x60, 0xa4,
-	0x0d, 0xcb, 0xcd, 0x5c, 0x69, 0x61, 0xbb, 0x44, 0xab, 0xc7, 0xc7, 0x0f, 0x8f, 0xb2, 0xe8, 0xe8,
-	0xcc, 0xfd, 0x21, 0x6c, 0xd6, 0xd9, 0x0b, 0x20, 0x38, 0x5b, 0x2d, 0xf6, 0x0e, 0x2e, 0x76, 0x13,
-	0xda, 0x75, 0x45, 0xf0, 0x8d, 0x58, 0xf2, 0x13, 0x9e, 0x1a, 0xe6, 0x0d, 0xf1, 0xfb, 0xb4, 0x41,
-	0x83, 0x2f, 0x61, 0xb7, 0xe9, 0x75, 0x15, 0xcd, 0x1e, 0x0c, 0x58, 0x7c, 0xe1, 0x2e, 0x7a, 0xad,
-	0xd7, 0xb1, 0x75, 0xdc, 0xd8, 0x7e, 0x26, 0x40, 0xbf, 0xb5, 0x8b, 0x61, 0x0f, 0x8b, 0xf8, 0xff,
-	0x4b, 0xad, 0x4e, 0xa7, 0xb3, 0x91, 0x8e, 0xeb, 0x6a, 0xf7, 0x8e, 0xab, 0xc1, 0x21, 0x0c, 0xdd,
-	0xa5, 0xb9, 0xef, 0xdf, 0x83, 0xf7, 0x61, 0x54, 0xd5, 0xde, 0x37, 0x8e, 0x87, 0xdf, 0xc3, 0xa8,
-	0x91, 0x07, 0xdd, 0x81, 0xad, 0x17, 0xfc, 0xc7, 0x97, 0x32, 0xbd, 0x9e, 0xb4, 0xe8, 0x04, 0x86,
-	0x5f, 0x33, 0x6d, 0x42, 0x1e, 0x73, 0x71, 0xc9, 0x17, 0x13, 0x42, 0x29, 0x8c, 0x6b, 0x7b, 0xf1,
-	0xc5, 0x49, 0x9b, 0x3e, 0x84, 0xd1, 0x2a, 0x99, 0x12, 0x75, 0xe8, 0x36, 0xf4, 0x9e, 0x8b, 0x5c,
-	0x9b, 0x49, 0xf7, 0xf3, 0xc7, 0x6f, 0xfe, 0xf4, 0x5b, 0x6f, 0x6e, 0x7c, 0xf2, 0xf6, 0xc6, 0x27,
-	0x7f, 0xdc, 0xf8, 0xe4, 0x97, 0x5b, 0xbf, 0xf5, 0xf6, 0xd6, 0x6f, 0xfd, 0x7e, 0xeb, 0xb7, 0xa2,
-	0x3e, 0x2e, 0xdf, 0xb3, 0xbf, 0x03, 0x00, 0x00, 0xff, 0xff, 0x92, 0x8c, 0x71, 0xda, 0x00, 0x08,
-	0x00, 0x00,
+	0x18, 0xc6, 0x33, 0xf9, 0xd7, 0xf4, 0x6d, 0x92, 0xcd, 0x0e, 0xd5, 0xca, 0xaa, 0x56, 0x56, 0x64,
+	0x2d, 0x28, 0xaa, 0x44, 0x57, 0xea, 0x0a, 0x38, 0x70, 0x82, 0x54, 0x0b, 0x11, 0x74, 0x37, 0x72,
+	0x40, 0x5c, 0x19, 0x3b, 0x6f, 0xdd, 0xa1, 0xce, 0xd8, 0xeb, 0x19, 0x97, 0xe6, 0x08, 0x9f, 0x80,
+	0x0f, 0xc1, 0x91, 0x0f, 0xb2, 0x07, 0x0e, 0x7b, 0xe4, 0x08, 0xed, 0x8d, 0x4f, 0x81, 0x66, 0xec,
+	0xb8, 0xe3, 0x94, 0x2d, 0x48, 0x7b, 0xf3, 0xf3, 0xcb, 0x6b, 0x67, 0xde, 0xe7, 0x79, 0xdf, 0x81,
+	0x61, 0x9a, 0x25, 0x2a, 0x09, 0x93, 0xf8, 0xc8, 0x3c, 0xd0, 0x66, 0x1a, 0x1c, 0x7c, 0x18, 0x71,
+	0x75, 0x9e, 0x07, 0x47, 0x61, 0xb2, 0x7a, 0x1a, 0x25, 0x51, 0xf2, 0xd4, 0xfc, 0x14, 0xe4, 0x67,
+	0x46, 0x19, 0x61, 0x9e, 0x8a, 0x57, 0xbc, 0xdf, 0x08, 0x74, 0xe7, 0x79, 0x70, 0x2a, 0x23, 0x7a,
+	0x00, 0xbd, 0x30, 0xe6, 0x28, 0xd4, 0xec, 0xc4, 0x21, 0x63, 0x32, 0xd9, 0xf5, 0x2b, 0x4d, 0x29,
+	0xb4, 0xa3, 0x9c, 0x2f, 0x9d, 0xa6, 0xe1, 0xe6, 0x99, 0x3a, 0xb0, 0x23, 0xf3, 0xe0, 0x07, 0x0c,
+	0x95, 0xd3, 0x32, 0x78, 0x23, 0xe9, 0x3e, 0x74, 0x32, 0x4c, 0xe3, 0xb5, 0xd3, 0x36, 0xbc, 0x10,
+	0xfa, 0x1b, 0x4b, 0xa6, 0x98, 0xd3, 0x19, 0x93, 0x49, 0xdf, 0x37, 0xcf, 0xf4, 0x11, 0x74, 0xc3,
+	0x44, 0x88, 0xd9, 0x89, 0xd3, 0x35, 0xb4, 0x54, 0x9a, 0xcb, 0x73, 0x76, 0xfc, 0xd1, 0xc7, 0x0e,
+	0x14, 0xbc, 0x50, 0xde, 0xb1, 0x39, 0xed, 0x67, 0xe1, 0x45, 0x75, 0x22, 0x62, 0x9d, 0x68, 0x1f,
+	0x3a, 0x98, 0x65, 0x49, 0x56, 0x1e, 0xb3, 0x10, 0xde, 0xdf, 0x04, 0x7a, 0xa7, 0x32, 0x9a, 0x1b,
+	0x8b, 0x0e, 0xa0, 0x27, 0xf1, 0x55, 0x8e, 0x22, 0x44, 0xf3, 0x6a, 0xdb, 0xaf, 0xb4, 0xdd, 0x50,
+	0xf3, 0x2d, 0x0d, 0xb5, 0xfe, 0xad, 0xa1, 0xb6, 0xd5, 0xd0, 0x63, 0xd8, 0x55, 0x7c, 0x85, 0x52,
+	0xb1, 0x55, 0x6a, 0x3a, 0x6d, 0xf9, 0xb7, 0x80, 0x8e, 0x61, 0x2f, 0xc3, 0x25, 0xc6, 0xfc, 0x12,
+	0x33, 0x5c, 0x9a, 0x9e, 0x7b, 0xbe, 0x8d, 0xe8, 0x04, 0x1e, 0x54, 0x72, 0x3d, 0x4d, 0x72, 0xa1,
+	0x9c, 0x9d, 0x31, 0x99, 0x0c, 0xfc, 0x6d, 0xac, 0xcf, 0x34, 0xf5, 0xa7, 0xcf, 0x8e, 0x8d, 0x43,
+	0x03, 0xbf, 0x10, 0xde, 0xa7, 0xd0, 0xd2, 0xee, 0x58, 0xad, 0x90, 0x7a, 0x2b, 0xb6, 0x01, 0xcd,
+	0xba, 0x01, 0xde, 0xef, 0x04, 0x86, 0xd3, 0x44, 0x08, 0x0c, 0x95, 0xaf, 0x99, 0x54, 0xf7, 0x0e,
+	0xc5, 0x07, 0x30, 0x3c, 0x47, 0x96, 0xa9, 0x00, 0x99, 0x9a, 0x89, 0x20, 0xb9, 0x2a, 0x6d, 0xdb,
+	0xa2, 0xfa, 0x1b, 0x9b, 0x41, 0x35, 0x06, 0x76, 0xfc, 0x4a, 0x5b, 0x03, 0xd0, 0xae, 0x0d, 0x80,
+	0x07, 0xfd, 0x94, 0x8b, 0x68, 0x26, 0x14, 0x66, 0x97, 0x2c, 0x36, 0x56, 0x76, 0xfc, 0x1a, 0xa3,
+	0x2e, 0x80, 0xd6, 0xa7, 0xec, 0xea, 0x65, 0xae, 0x8c, 0x99, 0x1d, 0xdf, 0x22, 0xde, 0x4f, 0x2d,
+	0x78, 0x50, 0xb5, 0x23, 0xd3, 0x44, 0x48, 0xd4, 0xf9, 0xa4, 0x79, 0x30, 0xcf, 0xf0, 0x8c, 0x5f,
+	0x95, 0x0d, 0xdd, 0x02, 0x9d, 0x8f, 0xcc, 0x83, 0xb2, 0x77, 0x59, 0xb6, 0x63, 0x23, 0xfa, 0x04,
+	0x06, 0xb9, 0xb0, 0x6b, 0x8a, 0x89, 0xa8, 0x43, 0x5d, 0x15, 0xc6, 0x89, 0xc4, 0xaa, 0xaa, 0x58,
+	0x84, 0x3a, 0xbc, 0x1d, 0xd7, 0x8e, 0x35, 0xae, 0xf4, 0x10, 0x46, 0x32, 0x0f, 0xa6, 0xb5, 0xd7,
+	0xbb, 0xa6, 0xe0, 0x0e, 0xdf, 0xb8, 0x54, 0xd5, 0xed, 0x98, 0xba, 0x1a, 0xbb, 0xe3, 0x64, 0xef,
+	0x3f, 0x9d, 0xdc, 0xdd, 0x76, 0xb2, 0x96, 0x20, 0x6c, 0x25, 0x58, 0x38, 0x1a, 0xf3, 0xf0, 0x2b,
+	0x5c, 0x3b, 0xcb, 0xca, 0xd1, 0x02, 0x78, 0x2e, 0xb4, 0xe7, 0x5c, 0x44, 0x56, 0xce, 0xc4, 0xce,
+	0xd9, 0x7b, 0x02, 0xfd, 0xb9, 0x39, 0x6d, 0x99, 0x4f, 0xe5, 0x09, 0xb1, 0x57, 0xf8, 0xd7, 0x16,
+	0xbc, 0xb7, 0xc8, 0x03, 0x19, 0x66, 0x3c, 0x55, 0x3c, 0x11, 0xff, 0x67, 0x3a, 0xdf, 0xbe, 0xcd,
+	0x8f, 0xa0, 0xfb, 0xea, 0x8b, 0x2c, 0xc9, 0xd3, 0x32, 0xbc, 0x52, 0xe9, 0xff, 0xe6, 0x66, 0x8c,
+	0xcb, 0x6b, 0xcb, 0x08, 0x3d, 0x13, 0x2b, 0x76, 0x35, 0x13, 0xcf, 0x63, 0x1e, 0x9d, 0xab, 0x72,
+	0x10, 0x6d, 0xa4, 0xd3, 0x66, 0xe1, 0xc5, 0x77, 0x8c, 0xab, 0x99, 0x58, 0x60, 0x28, 0xcb, 0x51,
+	0xac, 0x43, 0xfd, 0x9d, 0x65, 0x9e, 0xb1, 0x20, 0xc6, 0x17, 0x6c, 0x85, 0x65, 0x54, 0x36, 0xa2,
+	0x9f, 0xc0, 0x40, 0x2a, 0x96, 0xa9, 0x79, 0x22, 0xb9, 0xee, 0xd2, 0x58, 0x3d, 0x3c, 0x7e, 0x78,
+	0x94, 0x06, 0x47, 0x0b, 0xfb, 0x07, 0xbf, 0x5e, 0xa7, 0x0f, 0x60, 0xc0, 0x62, 0xb3, 0xd8, 0x7b,
+	0x66, 0xb1, 0xeb, 0x50, 0xaf, 0xab, 0x01, 0xdf, 0xf0, 0x15, 0x9e, 0x60, 0xac, 0x98, 0xd3, 0x37,
+	0xf7, 0xd3, 0x16, 0x2d, 0x2e, 0xa9, 0x34, 0x66, 0xeb, 0x45, 0x8a, 0xb8, 0x74, 0x06, 0x63, 0x32,
+	0x21, 0xbe, 0x8d, 0xb4, 0x81, 0x67, 0x3c, 0x56, 0x98, 0x39, 0xc3, 0xc2, 0xc0, 0x42, 0x79, 0x5f,
+	0xc2, 0x7e, 0x3d, 0xa5, 0x32, 0xd4, 0x03, 0xe8, 0xb1, 0xf0, 0xc2, 0xbe, 0x22, 0x2a, 0x7d, 0x1b,
+	0x78, 0xcb, 0x0e, 0xfc, 0x67, 0x02, 0xf4, 0x5b, 0x21, 0x8b, 0x8f, 0x05, 0xf8, 0x6e, 0x79, 0x57,
+	0xb9, 0xb6, 0xb6, 0x72, 0xb5, 0xf3, 0x68, 0xdf, 0xc9, 0xc3, 0x3b, 0x84, 0xbe, 0xbd, 0x6e, 0xf7,
+	0xfd, 0xbb, 0xf7, 0x3e, 0x0c, 0xca, 0xda, 0xfb, 0x06, 0xf9, 0xf0, 0x7b, 0x18, 0xd4, 0x92, 0xa4,
+	0x7b, 0xb0, 0xf3, 0x02, 0x7f, 0x7c, 0x29, 0xe2, 0xf5, 0xa8, 0x41, 0x47, 0xd0, 0xff, 0x9a, 0x49,
+	0xe5, 0x63, 0x88, 0xfc, 0x12, 0x97, 0x23, 0x42, 0x29, 0x0c, 0xab, 0x60, 0xcc, 0x8b, 0xa3, 0x26,
+	0x7d, 0x08, 0x83, 0x4d, 0xa6, 0x05, 0x6a, 0xd1, 0x5d, 0xe8, 0x3c, 0xe7, 0x99, 0x54, 0xa3, 0xf6,
+	0xe7, 0x8f, 0x5f, 0xff, 0xe5, 0x36, 0x5e, 0x5f, 0xbb, 0xe4, 0xcd, 0xb5, 0x4b, 0xfe, 0xbc, 0x76,
+	0xc9, 0x2f, 0x37, 0x6e, 0xe3, 0xcd, 0x8d, 0xdb, 0xf8, 0xe3, 0xc6, 0x6d, 0x04, 0x5d, 0xb3, 0xb6,
+	0xcf, 0xfe, 0x19, 0x00, 0x4a, 0x9f, 0xa2, 0x8d, 0x3a, 0x08, 0x00, 0x00,
 }
 
 func (m *PubMsg) Marshal() (dAtA []byte, err error) {
@@ -1126,6 +1130,19 @@ func (m *SubscriptionRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Filter) > 0 {
+		i -= len(m.Filter)
+		copy(dAtA[i:], m.Filter)
+		i = encodeVarintProtocol(dAtA, i, uint64(len(m.Filter)))
+		i--
+		dAtA[i] = 0x72
+	}
+	if m.ReplaySpeed != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.ReplaySpeed))))
+		i--
+		dAtA[i] = 0x69
+	}
 	if m.StartTimeDelta != 0 {
 		i = encodeVarintProtocol(dAtA, i, uint64(m.StartTimeDelta))
 		i--
@@ -1601,6 +1618,13 @@ func (m *SubscriptionRequest) Size() (n int) {
 	if m.StartTimeDelta != 0 {
 		n += 1 + sovProtocol(uint64(m.StartTimeDelta))
 	}
+	if m.ReplaySpeed != 0 {
+		n += 9
+	}
+	l = len(m.Filter)
+	if l > 0 {
+		n += 1 + l + sovProtocol(uint64(l))
+	}
 	return n
 }
 
@@ -3435,6 +3459,49 @@ func (m *SubscriptionRequest) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 13:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReplaySpeed", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.ReplaySpeed = float64(math.Float64frombits(v))
+		case 14:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Filter", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowProtocol
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthProtocol
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthProtocol
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Filter = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipProtocol(dAtA[iNdEx:])
@@ -132,6 +132,12 @@ type SubscriptionOptions struct {
 	StartTime time.Time
 	// Option to do Manual Acks
 	ManualAcks bool
+	// ReplaySpeed, if positive, asks the cluster to deliver stored messages
+	// at their original pacing multiplied by this factor, until caught up.
+	ReplaySpeed float64
+	// Filter, if not empty, is an expression evaluated by the cluster
+	// against each message. Messages that do not match are not delivered.
+	Filter string
 }
 
 // DefaultSubscriptionOptions are the default subscriptions' options
@@ -217,6 +223,30 @@ func SetManualAckMode() SubscriptionOption {
 	}
 }
 
+// ReplaySpeed sets the pacing multiplier used when delivering stored messages.
+// A value of 1 replays messages at the rate they were originally published,
+// 2 twice as fast, 0.5 half as fast. Once the subscription has caught up with
+// the channel, messages are delivered as they arrive. Zero disables pacing.
+func ReplaySpeed(speed float64) SubscriptionOption {
+	return func(o *SubscriptionOptions) error {
+		o.ReplaySpeed = speed
+		return nil
+	}
+}
+
+// Filter sets an expression that messages must match to be delivered to
+// this subscription, for instance `data.type == "order" && data.total > 100`.
+// Fields are `subject`, `reply`, `sequence`, `timestamp` and `data`, the
+// JSON payload, whose members are accessed with dots. Messages that do not
+// match are skipped by the cluster and do not need to be acknowledged.
+// All members of a queue group must use the same filter.
+func Filter(expr string) SubscriptionOption {
+	return func(o *SubscriptionOptions) error {
+		o.Filter = expr
+		return nil
+	}
+}
+
 // DurableName sets the DurableName for the subscriber.
 func DurableName(name string) SubscriptionOption {
 	return func(o *SubscriptionOptions) error {
@@ -288,6 +318,8 @@ func (sc *conn) subscribe(subject, qgroup string, cb MsgHandler, options ...Subs
 		AckWaitInSecs: int32(sub.opts.AckWait / time.Second),
 		StartPosition: sub.opts.StartAt,
 		DurableName:   sub.opts.DurableName,
+		ReplaySpeed:   sub.opts.ReplaySpeed,
+		Filter:        sub.opts.Filter,
 	}
 
 	// Conditionals
@@ -27,6 +27,7 @@ import (
 	_ "github.com/go-sql-driver/mysql"                             // mysql driver
 	_ "github.com/kubemq-io/broker/server/stan/stores/pqdeadlines" // wrapper for postgres that gives read/write deadlines
 	_ "github.com/lib/pq"                                          // postgres driver
+	_ "github.com/mattn/go-sqlite3"                                // sqlite driver
 )
 
 var usageStr = `
@@ -34,8 +35,9 @@ Usage: nats-streaming-server [options]
 
 Streaming Server Options:
     -cid, --cluster_id  <string>         Cluster ID (default: test-cluster)
-    -st,  --store <string>               Store type: MEMORY|FILE|SQL (default: MEMORY)
-          --dir <string>                 For FILE store type, this is the root directory
+    -st,  --store <string>               Store type: MEMORY|FILE|SQL|BOLT|HYBRID (default: MEMORY)
+          --dir <string>                 For FILE, BOLT and HYBRID store types, this is the root directory
+          --hybrid_memory_budget <size>  For HYBRID store type, memory used to cache the most recent messages of all channels (messages are also written to disk)
     -mc,  --max_channels <int>           Max number of channels (0 for unlimited)
     -msu, --max_subs <int>               Max number of subscriptions per channel (0 for unlimited)
     -mm,  --max_msgs <int>               Max number of messages per channel (0 for unlimited)
@@ -52,6 +54,14 @@ Streaming Server Options:
           --encrypt <bool>               Specify if server should use encryption at rest
           --encryption_cipher <string>   Cipher to use for encryption. Currently support AES and CHAHA (ChaChaPoly). Defaults to AES
           --encryption_key <string>      Encryption Key. It is recommended to specify it through the NATS_STREAMING_ENCRYPTION_KEY environment variable instead
+          --encryption_keys_dir <string> Directory of the keys of the channels configured with a dedicated key (dedicated_key in channel limits)
+          --encryption_old_keys <string> Comma separated list of keys that were rotated out, only used to decrypt. It is recommended to use the NATS_STREAMING_ENCRYPTION_OLD_KEYS environment variable instead
+          --compression <string>         Compress messages payload with this codec. Currently support DEFLATE. Disabled by default
+          --store_metrics <bool>         Record latency histograms of the store operations, reported in /streaming/storez
+          --backup_dir <string>          Directory in which backups requested through the monitoring endpoint (/streaming/backup, see --monitor_admin) are created
+          --restore_from <string>        Backup directory to restore the state from if the store is empty. Not supported in clustering mode
+          --monitor_admin <bool>         Enable the administrative endpoints of the monitoring server (pause, resume, backup, export, import). They are not authenticated (default: false)
+          --pub_rate_max_delay <duration> Accept messages exceeding the publish rate limits and delay their ack until the limits allow them, up to this duration (default: 0, reject them)
           --replace_durable <bool>       Replace the existing durable subscription instead of reporting a duplicate durable error
 
 Streaming Server Clustering Options:
@@ -64,6 +74,8 @@ Streaming Server Clustering Options:
     --cluster_log_snapshots <int>          Number of log snapshots to retain (default: 2)
     --cluster_trailing_logs <int>          Number of log entries to leave after a snapshot and compaction
     --cluster_sync <bool>                  Do a file sync after every write to the replication log and message store
+    --cluster_log_segments <bool>          Store the replication log in segment files instead of a bolt database
+    --cluster_log_segment_size <size>      Size at which a new replication log segment file is started (default: 64MB)
     --cluster_raft_logging <bool>          Enable logging from the Raft library (disabled by default)
     --cluster_allow_add_remove_node <bool> Enable the ability to send NATS requests to the leader to add/remove cluster nodes
 
@@ -80,18 +92,29 @@ Streaming Server File Store Options:
     --file_slice_max_bytes <size>        Maximum file slice size - including index file (subject to channel limits)
     --file_slice_max_age <duration>      Maximum file slice duration starting when the first message is stored (subject to channel limits)
     --file_slice_archive_script <string> Path to script to use if you want to archive a file slice being removed
+    --file_slice_archive_dir <string>    Directory where file slices being removed are archived, messages can still be looked up from there
     --file_fds_limit <int>               Store will try to use no more file descriptors than this given limit
     --file_parallel_recovery <int>       On startup, number of channels that can be recovered in parallel
     --file_truncate_bad_eof <bool>       Truncate files for which there is an unexpected EOF on recovery, dataloss may occur
     --file_read_buffer_size <size>       Size of messages read ahead buffer (0 to disable)
     --file_auto_sync <duration>          Interval at which the store should be automatically flushed and sync'ed on disk (<= 0 to disable)
+    --file_sub_checkpoint_records <int>  Number of subscription records after which the state of the subscriptions is checkpointed in the background, replacing compaction (0 to use compaction, not used by the SQL store)
+    --file_lazy_recovery <bool>          Recover the messages of a channel on first access or in the background instead of on startup
 
 Streaming Server SQL Store Options:
-    --sql_driver <string>            Name of the SQL Driver ("mysql" or "postgres")
+    --sql_driver <string>            Name of the SQL Driver ("mysql", "postgres" or "sqlite3")
     --sql_source <string>            Datasource used when opening an SQL connection to the database
     --sql_no_caching <bool>          Enable/Disable caching for improved performance
     --sql_max_open_conns <int>       Maximum number of opened connections to the database
     --sql_bulk_insert_limit <int>    Maximum number of messages stored with a single SQL "INSERT" statement
+    --sql_no_migration <bool>        Do not create or migrate the database schema on startup
+    --sql_read_ahead <int>           Number of messages fetched with a single SQL query when looking up a message (0 to disable)
+    --sql_partition_messages <bool>  Store the messages of each channel in a dedicated table, dropped when the channel is deleted.
+                                     Messages removed due to limits or age are still deleted row by row.
+
+Streaming Server Bolt Store Options:
+    --bolt_no_sync <bool>            Do not sync the database file after each write, only on flush
+    --bolt_open_timeout <duration>   How long to wait for the database file to be unlocked on startup
 
 Streaming Server TLS Options:
     -secure <bool>                   Use a TLS connection to the NATS server without
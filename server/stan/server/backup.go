@@ -0,0 +1,410 @@
+// Copyright 2017-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kubemq-io/broker/server/stan/spb"
+	"github.com/kubemq-io/broker/server/stan/stores"
+)
+
+// Route for online backups
+const (
+	BackupPath = RootPath + "/backup"
+)
+
+// Content of a backup directory. The snapshot file is written last, so
+// a backup without it is incomplete.
+const (
+	backupMsgsDir      = "msgs"
+	backupSnapshotFile = "snapshot"
+)
+
+// Status of a backup requested through the monitoring endpoint.
+const (
+	BackupStatusRunning   = "running"
+	BackupStatusCompleted = "completed"
+	BackupStatusFailed    = "failed"
+)
+
+// ID of the client added to the store while a backup is restored, and
+// removed once the restore is complete. It is not a valid client ID, so
+// it can't be the one of an actual client.
+const restoreMarkerClientID = "_STAN.restore"
+
+// ErrIncompleteRestore is returned on startup if a previous restore from a
+// backup did not complete.
+var ErrIncompleteRestore = errors.New("stan: store contains an incomplete restore from a backup, it must be emptied before restoring again")
+
+// Backupz describes a backup created with Backup.
+type Backupz struct {
+	Dir           string        `json:"dir"`
+	Now           time.Time     `json:"now"`
+	Duration      time.Duration `json:"duration"`
+	Clients       int           `json:"clients"`
+	Channels      int           `json:"channels"`
+	Msgs          int           `json:"msgs"`
+	Subscriptions int           `json:"subscriptions"`
+	Status        string        `json:"status,omitempty"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// Backup copies the clients, channels, messages and subscriptions of the
+// server into `dir`, which must not exist or be empty. The state is
+// captured at a point where no message is being stored: in standalone
+// mode, incoming messages are held while it is captured, in clustering
+// mode, the raft snapshot lock is used instead. Messages are then copied
+// into a FileStore, so the backup can be restored whatever the type of the
+// store, using the RestoreFrom option.
+// If the store is encrypted, payloads are encrypted in the backup with the
+// same keys, so the messages of a channel whose dedicated key has been
+// deleted can't be restored.
+func (s *StanServer) Backup(dir string) (*Backupz, error) {
+	start := time.Now()
+	if err := createBackupDir(dir); err != nil {
+		return nil, err
+	}
+
+	snap := &spb.RaftSnapshot{}
+	ss := &serverSnapshot{s}
+	var err error
+	if s.isClustered {
+		s.raft.fsm.Lock()
+		ss.snapshotClients(snap, nil)
+		err = ss.snapshotChannels(snap)
+		s.raft.fsm.Unlock()
+	} else {
+		sc, sdc := s.sendSynchronziationRequest()
+		select {
+		case <-sc:
+		case <-s.ioChannelQuit:
+			close(sdc)
+			return nil, fmt.Errorf("server shutting down")
+		}
+		ss.snapshotClients(snap, nil)
+		err = ss.snapshotChannels(snap)
+		close(sdc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to capture state: %v", err)
+	}
+
+	bz := &Backupz{Dir: dir, Now: start, Clients: len(snap.Clients), Channels: len(snap.Channels)}
+	if err := s.backupMsgs(filepath.Join(dir, backupMsgsDir), snap, bz); err != nil {
+		return nil, err
+	}
+	b, err := snap.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	tmpFile := filepath.Join(dir, backupSnapshotFile+".tmp")
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return nil, err
+	}
+	err = writeSnapshot(f, b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile, filepath.Join(dir, backupSnapshotFile))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to write snapshot: %v", err)
+	}
+	bz.Duration = time.Since(start)
+	s.log.Noticef("Backup of %v channel(s) and %v message(s) created in %q in %v",
+		bz.Channels, bz.Msgs, dir, bz.Duration)
+	return bz, nil
+}
+
+func createBackupDir(dir string) error {
+	if err := os.MkdirAll(dir, os.ModeDir+os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create backup directory: %v", err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("backup directory %q is not empty", dir)
+	}
+	return nil
+}
+
+// backupMsgs copies the messages referenced by the channels of `snap`
+// into a FileStore created in `dir`. Since the state has been captured,
+// new messages may have been stored and old ones removed due to limits,
+// so the first sequence of a channel is updated to the first copied one.
+func (s *StanServer) backupMsgs(dir string, snap *spb.RaftSnapshot, bz *Backupz) error {
+	var fs stores.Store
+	fs, err := stores.NewFileStore(s.log, dir, &stores.StoreLimits{})
+	if err != nil {
+		return fmt.Errorf("unable to create backup store: %v", err)
+	}
+	if cs := s.cryptoStore(); cs != nil {
+		fs = cs.WrapStore(fs)
+	}
+	defer fs.Close()
+	s.mu.RLock()
+	info := s.info
+	s.mu.RUnlock()
+	if err := fs.Init(&info); err != nil {
+		return err
+	}
+	for _, sc := range snap.Channels {
+		bz.Subscriptions += len(sc.Subscriptions)
+		c := s.channels.get(sc.Channel)
+		if c == nil {
+			// Channel has been deleted since the state was captured.
+			sc.First, sc.Last = 0, 0
+			continue
+		}
+		bc, err := fs.CreateChannel(sc.Channel)
+		if err != nil {
+			return err
+		}
+		first := uint64(0)
+		for seq := sc.First; sc.First > 0 && seq <= sc.Last; seq++ {
+			m, err := c.store.Msgs.Lookup(seq)
+			if err != nil {
+				return fmt.Errorf("channel %q: unable to lookup message %v: %v", sc.Channel, seq, err)
+			}
+			if m == nil {
+				continue
+			}
+			if first == 0 {
+				first = seq
+			}
+			cm := *m
+			if _, err := bc.Msgs.Store(&cm); err != nil {
+				return fmt.Errorf("channel %q: unable to store message %v: %v", sc.Channel, seq, err)
+			}
+			bz.Msgs++
+		}
+		if first == 0 {
+			sc.First, sc.Last = 0, 0
+		} else {
+			sc.First = first
+		}
+		if err := bc.Msgs.Flush(); err != nil {
+			return err
+		}
+	}
+	return fs.Close()
+}
+
+// restoreFromBackup creates in the (empty) store the clients, channels,
+// messages and subscriptions of a backup created with Backup, and returns
+// them as if they had been recovered from the store.
+// A marker client is stored first and removed once everything has been
+// restored, so that a restore interrupted by a failure or a crash is
+// detected on the next start (see isIncompleteRestore).
+func (s *StanServer) restoreFromBackup(dir string) (*stores.RecoveredState, error) {
+	f, err := os.Open(filepath.Join(dir, backupSnapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	snap, err := readSnapshot(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if snap == nil {
+		return nil, fmt.Errorf("empty snapshot")
+	}
+	var fs stores.Store
+	fs, err = stores.NewFileStore(s.log, filepath.Join(dir, backupMsgsDir), &stores.StoreLimits{})
+	if err != nil {
+		return nil, err
+	}
+	if cs := s.cryptoStore(); cs != nil {
+		fs = cs.WrapStore(fs)
+	}
+	defer fs.Close()
+	backupState, err := fs.Recover()
+	if err != nil {
+		return nil, err
+	}
+	if backupState == nil {
+		return nil, fmt.Errorf("no message store in backup")
+	}
+	if backupState.Info.ClusterID != s.info.ClusterID {
+		return nil, fmt.Errorf("backup is for cluster ID %q, not %q", backupState.Info.ClusterID, s.info.ClusterID)
+	}
+
+	if _, err := s.store.AddClient(&spb.ClientInfo{ID: restoreMarkerClientID}); err != nil {
+		return nil, err
+	}
+	rs := &stores.RecoveredState{Info: &s.info, Channels: make(map[string]*stores.RecoveredChannel, len(snap.Channels))}
+	for _, ci := range snap.Clients {
+		c, err := s.store.AddClient(ci)
+		if err != nil {
+			return nil, err
+		}
+		rs.Clients = append(rs.Clients, c)
+	}
+	for _, sc := range snap.Channels {
+		c, err := s.store.CreateChannel(sc.Channel)
+		if err != nil {
+			return nil, err
+		}
+		var bms stores.MsgStore
+		if bc := backupState.Channels[sc.Channel]; bc != nil {
+			bms = bc.Channel.Msgs
+		}
+		for seq := sc.First; bms != nil && sc.First > 0 && seq <= sc.Last; seq++ {
+			m, err := bms.Lookup(seq)
+			if errors.Is(err, stores.ErrUnknownEncryptionKey) {
+				s.log.Warnf("Messages of channel %q can't be restored, its key has been deleted", sc.Channel)
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("channel %q: unable to lookup message %v: %v", sc.Channel, seq, err)
+			}
+			if m == nil {
+				continue
+			}
+			// Wrapping stores may replace the payload, so store a copy.
+			cm := *m
+			if _, err := c.Msgs.Store(&cm); err != nil {
+				return nil, fmt.Errorf("channel %q: unable to store message %v: %v", sc.Channel, seq, err)
+			}
+		}
+		rc := &stores.RecoveredChannel{Channel: c}
+		for _, bsub := range sc.Subscriptions {
+			// The store assigns the subscription ID.
+			sub := *bsub.State
+			if err := c.Subs.CreateSub(&sub); err != nil {
+				return nil, err
+			}
+			sort.Slice(bsub.AcksPending, func(i, j int) bool { return bsub.AcksPending[i] < bsub.AcksPending[j] })
+			pending := make(stores.PendingAcks, len(bsub.AcksPending))
+			for _, seq := range bsub.AcksPending {
+				if err := c.Subs.AddSeqPending(sub.ID, seq); err != nil {
+					return nil, err
+				}
+				pending[seq] = struct{}{}
+			}
+			rc.Subscriptions = append(rc.Subscriptions, &stores.RecoveredSubscription{Sub: &sub, Pending: pending})
+		}
+		if err := c.Msgs.Flush(); err != nil {
+			return nil, err
+		}
+		if err := c.Subs.Flush(); err != nil {
+			return nil, err
+		}
+		rs.Channels[sc.Channel] = rc
+	}
+	if err := s.store.DeleteClient(restoreMarkerClientID); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// isIncompleteRestore returns true if the recovered state contains the
+// marker of a restore from a backup that did not complete.
+func isIncompleteRestore(rs *stores.RecoveredState) bool {
+	for _, c := range rs.Clients {
+		if c.ID == restoreMarkerClientID {
+			return true
+		}
+	}
+	return false
+}
+
+// cryptoStore returns the CryptoStore that encrypts the payloads of the
+// server's store, or nil if payloads are not encrypted.
+func (s *StanServer) cryptoStore() *stores.CryptoStore {
+	store := s.store
+	for {
+		switch st := store.(type) {
+		case *stores.CryptoStore:
+			return st
+		case *stores.CompressedStore:
+			store = st.Store
+		default:
+			return nil
+		}
+	}
+}
+
+// HandleBackupz starts, on a POST request, the creation of a backup in a
+// new directory under the BackupDir option, and returns right away. A GET
+// request returns the state of the last backup started this way.
+// Only one backup can be running at a time.
+func (s *StanServer) HandleBackupz(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.backupMu.Lock()
+		var bz *Backupz
+		if s.backup != nil {
+			cbz := *s.backup
+			bz = &cbz
+		}
+		s.backupMu.Unlock()
+		if bz == nil {
+			http.Error(w, "No backup requested", http.StatusNotFound)
+			return
+		}
+		s.sendResponse(w, r, bz)
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.opts.BackupDir == "" {
+		http.Error(w, "Backup directory not configured", http.StatusServiceUnavailable)
+		return
+	}
+	now := time.Now()
+	dir := filepath.Join(s.opts.BackupDir, "backup-"+now.UTC().Format("20060102-150405.000"))
+	bz := &Backupz{Dir: dir, Now: now, Status: BackupStatusRunning}
+	s.backupMu.Lock()
+	if s.backup != nil && s.backup.Status == BackupStatusRunning {
+		s.backupMu.Unlock()
+		http.Error(w, "A backup is already running", http.StatusConflict)
+		return
+	}
+	s.backup = bz
+	res := *bz
+	s.backupMu.Unlock()
+
+	s.startGoRoutine(func() {
+		defer s.wg.Done()
+		rbz, err := s.Backup(dir)
+		s.backupMu.Lock()
+		if err != nil {
+			s.log.Errorf("Error creating backup in %q: %v", dir, err)
+			bz.Status, bz.Error = BackupStatusFailed, err.Error()
+		} else {
+			*bz = *rbz
+			bz.Status = BackupStatusCompleted
+		}
+		s.backupMu.Unlock()
+	})
+	s.sendResponse(w, r, &res)
+}
@@ -0,0 +1,305 @@
+// Copyright 2017-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kubemq-io/broker/client/stan"
+	"github.com/kubemq-io/broker/server/stan/stores"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "streaming_backup")
+	if err != nil {
+		t.Fatal("Could not create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+	dir := filepath.Join(tmpDir, "backup")
+
+	opts := GetDefaultOptions()
+	s := runServerWithOpts(t, opts, nil)
+	defer shutdownRestartedServerOnTestExit(&s)
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+	for i := 0; i < 5; i++ {
+		if err := sc.Publish("foo", []byte(fmt.Sprintf("msg%d", i+1))); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	if err := sc.Publish("bar", []byte("hello")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	// Durable that does not ack the messages it receives.
+	ch := make(chan bool, 5)
+	if _, err := sc.Subscribe("foo", func(_ *stan.Msg) { ch <- true },
+		stan.DurableName("dur"), stan.DeliverAllAvailable(), stan.SetManualAckMode(),
+		stan.AckWait(time.Second)); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := Wait(ch); err != nil {
+			t.Fatal("Did not get our messages")
+		}
+	}
+	sc.Close()
+
+	bz, err := s.Backup(dir)
+	if err != nil {
+		t.Fatalf("Error on backup: %v", err)
+	}
+	if bz.Dir != dir || bz.Channels != 2 || bz.Msgs != 6 || bz.Subscriptions != 1 {
+		t.Fatalf("Unexpected backup result: %+v", bz)
+	}
+	if _, err := s.Backup(dir); err == nil {
+		t.Fatal("Expected error creating a backup in a non empty directory")
+	}
+	s.Shutdown()
+
+	opts.RestoreFrom = dir
+	s = runServerWithOpts(t, opts, nil)
+	if n, _ := msgStoreState(t, channelsGet(t, s.channels, "foo").store.Msgs); n != 5 {
+		t.Fatalf("Expected 5 messages in foo, got %v", n)
+	}
+	if n, _ := msgStoreState(t, channelsGet(t, s.channels, "bar").store.Msgs); n != 1 {
+		t.Fatalf("Expected 1 message in bar, got %v", n)
+	}
+
+	// The durable should get its unacknowledged messages redelivered,
+	// and then the new ones.
+	sc = NewDefaultConnection(t)
+	defer sc.Close()
+	if err := sc.Publish("foo", []byte("msg6")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	rch := make(chan *stan.Msg, 10)
+	if _, err := sc.Subscribe("foo", func(m *stan.Msg) {
+		rch <- m
+		m.Ack()
+	}, stan.DurableName("dur"), stan.SetManualAckMode()); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 6; i++ {
+		select {
+		case m := <-rch:
+			seq := uint64(i + 1)
+			if m.Sequence != seq || string(m.Data) != fmt.Sprintf("msg%d", seq) || m.Redelivered != (seq <= 5) {
+				t.Fatalf("Unexpected message: %v", m)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Did not get our messages")
+		}
+	}
+}
+
+func TestBackupRestoreErrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "streaming_backup")
+	if err != nil {
+		t.Fatal("Could not create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	opts := GetDefaultOptions()
+	opts.RestoreFrom = filepath.Join(tmpDir, "missing")
+	if s, err := RunServerWithOpts(opts, nil); err == nil {
+		s.Shutdown()
+		t.Fatal("Expected error restoring from a missing backup")
+	}
+
+	// A backup of another cluster can't be restored.
+	dir := filepath.Join(tmpDir, "backup")
+	opts = GetDefaultOptions()
+	opts.ID = "other"
+	s := runServerWithOpts(t, opts, nil)
+	defer s.Shutdown()
+	if _, err := s.Backup(dir); err != nil {
+		t.Fatalf("Error on backup: %v", err)
+	}
+	s.Shutdown()
+	opts = GetDefaultOptions()
+	opts.RestoreFrom = dir
+	if s, err := RunServerWithOpts(opts, nil); err == nil {
+		s.Shutdown()
+		t.Fatal("Expected error restoring from a backup of another cluster")
+	}
+}
+
+func TestBackupIncompleteRestore(t *testing.T) {
+	cleanupDatastore(t)
+	defer cleanupDatastore(t)
+
+	tmpDir, err := os.MkdirTemp("", "streaming_backup")
+	if err != nil {
+		t.Fatal("Could not create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+	dir := filepath.Join(tmpDir, "backup")
+
+	s := runServer(t, clusterName)
+	defer s.Shutdown()
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+	for _, channel := range []string{"foo", "bar"} {
+		if err := sc.Publish(channel, []byte("hello")); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	sc.Close()
+	if _, err := s.Backup(dir); err != nil {
+		t.Fatalf("Error on backup: %v", err)
+	}
+	s.Shutdown()
+
+	// Make the restore fail after some of the state has been stored.
+	fi := stores.NewFaultInjector()
+	fi.Inject(stores.Fault{Op: "Store", Channel: "bar", Err: errOnPurpose})
+	opts := getTestDefaultOptsForPersistentStore()
+	opts.RestoreFrom = dir
+	opts.StoreFaultInjector = fi
+	if s, err := RunServerWithOpts(opts, nil); err == nil {
+		s.Shutdown()
+		t.Fatal("Expected restore to fail")
+	}
+	// The server should refuse to start with the partially restored store.
+	opts.StoreFaultInjector = nil
+	if s, err := RunServerWithOpts(opts, nil); err != ErrIncompleteRestore {
+		if s != nil {
+			s.Shutdown()
+		}
+		t.Fatalf("Expected error %v, got %v", ErrIncompleteRestore, err)
+	}
+}
+
+func TestMonitorBackup(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "streaming_backup")
+	if err != nil {
+		t.Fatal("Could not create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	resetPreviousHTTPConnections()
+	opts := GetDefaultOptions()
+	opts.BackupDir = tmpDir
+	s := runMonitorServer(t, opts)
+	defer s.Shutdown()
+
+	post := func(expectedStatus int) *Backupz {
+		t.Helper()
+		url := fmt.Sprintf("http://%s:%d%s", monitorHost, monitorPort, BackupPath)
+		resp, err := http.Post(url, "", nil)
+		if err != nil {
+			t.Fatalf("Expected no error: Got %v\n", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != expectedStatus {
+			t.Fatalf("Expected a %d response, got %d\n", expectedStatus, resp.StatusCode)
+		}
+		if expectedStatus != http.StatusOK {
+			return nil
+		}
+		bz := &Backupz{}
+		if err := json.NewDecoder(resp.Body).Decode(bz); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+		return bz
+	}
+	// Disabled unless MonitorAdmin is set
+	post(http.StatusNotFound)
+	s.Shutdown()
+
+	resetPreviousHTTPConnections()
+	opts.BackupDir = ""
+	opts.MonitorAdmin = true
+	s = runMonitorServer(t, opts)
+	defer s.Shutdown()
+	monitorExpectStatus(t, BackupPath, http.StatusNotFound)
+	// No backup directory configured
+	post(http.StatusServiceUnavailable)
+	s.Shutdown()
+
+	resetPreviousHTTPConnections()
+	opts.BackupDir = tmpDir
+	s = runMonitorServer(t, opts)
+	defer s.Shutdown()
+	if bz := post(http.StatusOK); bz.Status != BackupStatusRunning || filepath.Dir(bz.Dir) != tmpDir {
+		t.Fatalf("Unexpected response: %+v", bz)
+	}
+	waitFor(t, 5*time.Second, 15*time.Millisecond, func() error {
+		resp, body := getBody(t, BackupPath, expectedJSON)
+		resp.Body.Close()
+		bz := &Backupz{}
+		if err := json.Unmarshal(body, bz); err != nil {
+			return err
+		}
+		if bz.Status != BackupStatusCompleted {
+			return fmt.Errorf("backup status is %q (%s)", bz.Status, bz.Error)
+		}
+		return nil
+	})
+	files, err := filepath.Glob(filepath.Join(tmpDir, "backup-*", backupSnapshotFile))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected a backup to be created, got %v (err=%v)", files, err)
+	}
+}
+
+func TestBackupRestoreDeletedChannelKey(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "streaming_backup")
+	if err != nil {
+		t.Fatal("Could not create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+	dir := filepath.Join(tmpDir, "backup")
+
+	opts := GetDefaultOptions()
+	opts.Encrypt = true
+	opts.EncryptionKey = []byte("testkey")
+	opts.EncryptionKeysDir = filepath.Join(tmpDir, "keys")
+	opts.AddPerChannel("tenant.>", &stores.ChannelLimits{DedicatedKey: true})
+	s := runServerWithOpts(t, opts, nil)
+	defer shutdownRestartedServerOnTestExit(&s)
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+	for _, channel := range []string{"tenant.a", "bar"} {
+		if err := sc.Publish(channel, []byte("hello")); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	sc.Close()
+	if _, err := s.Backup(dir); err != nil {
+		t.Fatalf("Error on backup: %v", err)
+	}
+	s.Shutdown()
+
+	// Deleting the key of the channel makes its messages unrecoverable.
+	if err := os.Remove(filepath.Join(opts.EncryptionKeysDir, "tenant.a.key")); err != nil {
+		t.Fatalf("Error removing key: %v", err)
+	}
+	opts.EncryptionKey = []byte("testkey")
+	opts.RestoreFrom = dir
+	s = runServerWithOpts(t, opts, nil)
+	if n, _ := msgStoreState(t, channelsGet(t, s.channels, "tenant.a").store.Msgs); n != 0 {
+		t.Fatalf("Expected no message in tenant.a, got %v", n)
+	}
+	if n, _ := msgStoreState(t, channelsGet(t, s.channels, "bar").store.Msgs); n != 1 {
+		t.Fatalf("Expected 1 message in bar, got %v", n)
+	}
+}
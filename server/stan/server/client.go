@@ -35,6 +35,7 @@ type clientStore struct {
 	waitOnRegister map[string]chan struct{}
 	knownInvalid   map[string]struct{}
 	store          stores.Store
+	limits         *stores.StoreLimits // for per-client publish rate limits
 }
 
 // client has information needed by the server. A client is also
@@ -45,6 +46,8 @@ type client struct {
 	hbt  *time.Timer
 	fhb  int
 	subs []*subState
+	// Immutable after creation, so no lock needed to access it.
+	pubRate *pubRateLimiter
 }
 
 // newClientStore creates a new clientStore instance using `store` as the backing storage.
@@ -57,6 +60,16 @@ func newClientStore(store stores.Store) *clientStore {
 	}
 }
 
+// newClient creates a client object for the given stored client, setting
+// the publish rate limiter if a per-client limit applies.
+func (cs *clientStore) newClient(sc *stores.Client) *client {
+	c := &client{info: sc, subs: make([]*subState, 0, 4)}
+	if cs.limits != nil {
+		c.pubRate = newPubRateLimiter(cs.limits.GetClientPubRateLimits(sc.ID))
+	}
+	return c
+}
+
 // getSubsCopy returns a copy of the client's subscribers array.
 // At least Read-lock must be held by the caller.
 func (c *client) getSubsCopy() []*subState {
@@ -84,7 +97,7 @@ func (cs *clientStore) register(info *spb.ClientInfo) (*client, error) {
 	if err != nil {
 		return nil, err
 	}
-	c = &client{info: sc, subs: make([]*subState, 0, 4)}
+	c = cs.newClient(sc)
 	cs.clients[c.info.ID] = c
 	if len(c.info.ConnID) > 0 {
 		cs.connIDs[string(c.info.ConnID)] = c
@@ -273,7 +286,7 @@ func (cs *clientStore) removeSub(ID string, sub *subState) bool {
 func (cs *clientStore) recoverClients(clients []*stores.Client) {
 	cs.Lock()
 	for _, sc := range clients {
-		client := &client{info: sc, subs: make([]*subState, 0, 4)}
+		client := cs.newClient(sc)
 		cs.clients[client.info.ID] = client
 		if len(client.info.ConnID) > 0 {
 			cs.connIDs[string(client.info.ConnID)] = client
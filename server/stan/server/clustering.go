@@ -122,6 +122,15 @@ type ClusteringOptions struct {
 	// The "hashmap which is faster in almost all circumstances but doesn't guarantee
 	// that it offers the smallest page id available. In normal case it is safe.
 	BoltFreeListMap bool
+
+	// LogSegments stores the Raft logs in append-only segment files instead
+	// of bolt DB, which is still used for the Raft configuration. Logs present
+	// in an existing bolt DB are moved to the segment files on startup.
+	LogSegments bool
+
+	// LogSegmentSize is the size at which a new segment file is started.
+	// Defaults to DefaultLogSegmentSize.
+	LogSegmentSize int64
 }
 
 // raftNode is a handle to a member in a Raft consensus group.
@@ -67,7 +67,7 @@ func shutdownAndCleanupState(t *testing.T, s *StanServer, nodeID string) {
 	s.Shutdown()
 	os.RemoveAll(filepath.Join(defaultRaftLog, nodeID))
 	switch persistentStoreType {
-	case stores.TypeFile:
+	case stores.TypeFile, stores.TypeBolt:
 		os.RemoveAll(filepath.Join(defaultDataStore, nodeID))
 	case stores.TypeSQL:
 		test.CleanupSQLDatastore(t, testSQLDriver, testSQLSource+"_"+nodeID)
@@ -81,6 +81,8 @@ func getTestDefaultOptsForClustering(id string, bootstrap bool) *Options {
 	if persistentStoreType == stores.TypeFile {
 		opts.FilestoreDir = filepath.Join(defaultDataStore, id)
 		opts.FileStoreOpts.BufferSize = 1024
+	} else if persistentStoreType == stores.TypeBolt {
+		opts.FilestoreDir = filepath.Join(defaultDataStore, id)
 	} else if persistentStoreType == stores.TypeSQL {
 		// Since we need to have the databases created for all possible
 		// IDs, make sure that if someone adds a test with a new ID
@@ -9151,3 +9153,84 @@ func TestClusteringLeaderChangeInLeadershipAcquired(t *testing.T) {
 	close(channels[li])
 	getLeader(t, 10*time.Second, servers...)
 }
+
+func TestClusteringLogSnapshotRestoreSubFilter(t *testing.T) {
+	cleanupDatastore(t)
+	defer cleanupDatastore(t)
+	cleanupRaftLog(t)
+	defer cleanupRaftLog(t)
+
+	// For this test, use a central NATS server.
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+
+	s1sOpts := getTestDefaultOptsForClustering("a", true)
+	s1sOpts.Clustering.TrailingLogs = 0
+	s1 := runServerWithOpts(t, s1sOpts, nil)
+	defer s1.Shutdown()
+
+	s2sOpts := getTestDefaultOptsForClustering("b", false)
+	s2sOpts.Clustering.TrailingLogs = 0
+	s2 := runServerWithOpts(t, s2sOpts, nil)
+	defer s2.Shutdown()
+
+	s3sOpts := getTestDefaultOptsForClustering("c", false)
+	s3sOpts.Clustering.TrailingLogs = 0
+	s3 := runServerWithOpts(t, s3sOpts, nil)
+	defer s3.Shutdown()
+
+	servers := []*StanServer{s1, s2, s3}
+	leader := getLeader(t, 10*time.Second, servers...)
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	// Create the channel before killing a follower, so that the follower
+	// restores the subscription from the snapshot.
+	if err := sc.Publish("foo", []byte(`{"type":"refund"}`)); err != nil {
+		t.Fatalf("Unexpected error on publish: %v", err)
+	}
+	var follower *StanServer
+	for _, s := range servers {
+		if leader != s {
+			follower = s
+			break
+		}
+	}
+	servers = removeServer(servers, follower)
+	follower.Shutdown()
+
+	expr := `data.type == "order"`
+	if _, err := sc.QueueSubscribe("foo", "group", func(_ *stan.Msg) {},
+		stan.Filter(expr), stan.ReplaySpeed(2)); err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	if err := leader.raft.Snapshot().Error(); err != nil {
+		t.Fatalf("Unexpected error on snapshot: %v", err)
+	}
+
+	follower = runServerWithOpts(t, follower.opts, nil)
+	defer follower.Shutdown()
+	servers = append(servers, follower)
+	getLeader(t, 10*time.Second, servers...)
+
+	waitFor(t, 5*time.Second, 15*time.Millisecond, func() error {
+		c := follower.channels.get("foo")
+		if c == nil {
+			return fmt.Errorf("channel not restored")
+		}
+		c.ss.RLock()
+		qs := c.ss.qsubs["group"]
+		c.ss.RUnlock()
+		if qs == nil {
+			return fmt.Errorf("queue group not restored")
+		}
+		qs.RLock()
+		speed, filter := qs.replaySpeed, qs.filter.expression()
+		qs.RUnlock()
+		if speed != 2 || filter != expr {
+			return fmt.Errorf("expected replay speed 2 and filter %q, got %v and %q", expr, speed, filter)
+		}
+		return nil
+	})
+}
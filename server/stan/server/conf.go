@@ -70,7 +70,7 @@ func ProcessConfigFile(configFile string, opts *Options) error {
 			}
 			st := strings.ToUpper(v.(string))
 			switch st {
-			case stores.TypeFile, stores.TypeMemory, stores.TypeSQL:
+			case stores.TypeFile, stores.TypeMemory, stores.TypeSQL, stores.TypeBolt, stores.TypeHybrid:
 				opts.StoreType = st
 			default:
 				return fmt.Errorf("unknown store type: %v", v.(string))
@@ -80,6 +80,11 @@ func ProcessConfigFile(configFile string, opts *Options) error {
 				return err
 			}
 			opts.FilestoreDir = v.(string)
+		case "hybrid_memory_budget":
+			if err := checkType(k, reflect.Int64, v); err != nil {
+				return err
+			}
+			opts.HybridMemBudget = v.(int64)
 		case "sd", "stan_debug":
 			if err := checkType(k, reflect.Bool, v); err != nil {
 				return err
@@ -121,6 +126,10 @@ func ProcessConfigFile(configFile string, opts *Options) error {
 			if err := parseSQLOptions(v, opts); err != nil {
 				return err
 			}
+		case "bolt", "bolt_options":
+			if err := parseBoltOptions(v, opts); err != nil {
+				return err
+			}
 		case "hbi", "hb_interval", "server_to_client_hb_interval":
 			if err := checkType(k, reflect.String, v); err != nil {
 				return err
@@ -179,6 +188,60 @@ func ProcessConfigFile(configFile string, opts *Options) error {
 			}
 			opts.Encrypt = true
 			opts.EncryptionKey = []byte(v.(string))
+		case "encryption_keys_dir":
+			if err := checkType(k, reflect.String, v); err != nil {
+				return err
+			}
+			opts.EncryptionKeysDir = v.(string)
+		case "encryption_old_keys":
+			if err := checkType(k, reflect.Slice, v); err != nil {
+				return err
+			}
+			opts.EncryptionOldKeys = nil
+			for _, key := range v.([]interface{}) {
+				if err := checkType(k, reflect.String, key); err != nil {
+					return err
+				}
+				opts.EncryptionOldKeys = append(opts.EncryptionOldKeys, []byte(key.(string)))
+			}
+		case "compression":
+			if err := checkType(k, reflect.String, v); err != nil {
+				return err
+			}
+			opts.Compression = v.(string)
+		case "compression_channels", "compression_per_channel":
+			if err := parseCompressionChannels(v, opts); err != nil {
+				return err
+			}
+		case "store_metrics":
+			if err := checkType(k, reflect.Bool, v); err != nil {
+				return err
+			}
+			opts.StoreMetrics = v.(bool)
+		case "backup_dir":
+			if err := checkType(k, reflect.String, v); err != nil {
+				return err
+			}
+			opts.BackupDir = v.(string)
+		case "monitor_admin":
+			if err := checkType(k, reflect.Bool, v); err != nil {
+				return err
+			}
+			opts.MonitorAdmin = v.(bool)
+		case "pub_rate_max_delay":
+			if err := checkType(k, reflect.String, v); err != nil {
+				return err
+			}
+			dur, err := time.ParseDuration(v.(string))
+			if err != nil {
+				return err
+			}
+			opts.PubRateMaxDelay = dur
+		case "restore_from":
+			if err := checkType(k, reflect.String, v); err != nil {
+				return err
+			}
+			opts.RestoreFrom = v.(string)
 		case "username", "user":
 			if err := checkType(k, reflect.String, v); err != nil {
 				return err
@@ -258,6 +321,22 @@ func parseTLS(itf interface{}, opts *Options) error {
 	return nil
 }
 
+// parseCompressionChannels updates `opts` with the per-channel compression codecs
+func parseCompressionChannels(itf interface{}, opts *Options) error {
+	m, ok := itf.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected compression channels to be a map/struct, got %v", itf)
+	}
+	opts.ChannelCompression = make(map[string]string, len(m))
+	for channel, v := range m {
+		if err := checkType(channel, reflect.String, v); err != nil {
+			return err
+		}
+		opts.ChannelCompression[channel] = v.(string)
+	}
+	return nil
+}
+
 // parseCluster updates `opts` with cluster config
 func parseCluster(itf interface{}, opts *Options) error {
 	m, ok := itf.(map[string]interface{})
@@ -366,6 +445,16 @@ func parseCluster(itf interface{}, opts *Options) error {
 				return err
 			}
 			opts.Clustering.NodesConnections = v.(bool)
+		case "log_segments":
+			if err := checkType(k, reflect.Bool, v); err != nil {
+				return err
+			}
+			opts.Clustering.LogSegments = v.(bool)
+		case "log_segment_size":
+			if err := checkType(k, reflect.Int64, v); err != nil {
+				return err
+			}
+			opts.Clustering.LogSegmentSize = v.(int64)
 		}
 	}
 	return nil
@@ -389,6 +478,10 @@ func parseStoreLimits(itf interface{}, opts *Options) error {
 			if err := parsePerChannelLimits(v, opts); err != nil {
 				return err
 			}
+		case "clients", "clients_limits", "clientslimits", "per_client", "per_client_limits":
+			if err := parsePerClientLimits(v, opts); err != nil {
+				return err
+			}
 		default:
 			// Check for the global limits (MaxMsgs, MaxBytes, etc..)
 			if err := parseChannelLimits(&opts.ChannelLimits, k, name, v, true); err != nil {
@@ -450,6 +543,44 @@ func parseChannelLimits(cl *stores.ChannelLimits, k, name string, v interface{},
 		if !isGlobal && cl.MaxInactivity == 0 {
 			cl.MaxInactivity = -1
 		}
+	case "max_pub_msgs_rate", "max_pub_msgs_per_sec":
+		if err := checkType(k, reflect.Int64, v); err != nil {
+			return err
+		}
+		cl.MaxPubMsgsRate = int(v.(int64))
+		if !isGlobal && cl.MaxPubMsgsRate == 0 {
+			cl.MaxPubMsgsRate = -1
+		}
+	case "max_pub_bytes_rate", "max_pub_bytes_per_sec":
+		if err := checkType(k, reflect.Int64, v); err != nil {
+			return err
+		}
+		cl.MaxPubBytesRate = v.(int64)
+		if !isGlobal && cl.MaxPubBytesRate == 0 {
+			cl.MaxPubBytesRate = -1
+		}
+	case "dedicated_key":
+		if err := checkType(k, reflect.Bool, v); err != nil {
+			return err
+		}
+		cl.DedicatedKey = v.(bool)
+	}
+	return nil
+}
+
+// parsePubRateLimits updates `pl` with per client publish rate limits.
+func parsePubRateLimits(pl *stores.PubRateLimits, k, name string, v interface{}) error {
+	switch name {
+	case "max_pub_msgs_rate", "max_pub_msgs_per_sec":
+		if err := checkType(k, reflect.Int64, v); err != nil {
+			return err
+		}
+		pl.MaxPubMsgsRate = int(v.(int64))
+	case "max_pub_bytes_rate", "max_pub_bytes_per_sec":
+		if err := checkType(k, reflect.Int64, v); err != nil {
+			return err
+		}
+		pl.MaxPubBytesRate = v.(int64)
 	}
 	return nil
 }
@@ -481,6 +612,30 @@ func parsePerChannelLimits(itf interface{}, opts *Options) error {
 	return nil
 }
 
+// parsePerClientLimits updates `opts` with per client publish rate limits.
+func parsePerClientLimits(itf interface{}, opts *Options) error {
+	m, ok := itf.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected per client limits to be a map/struct, got %v", itf)
+	}
+	for pattern, limits := range m {
+		limitsMap, ok := limits.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected client limits to be a map/struct, got %v", limits)
+		}
+		pl := &stores.PubRateLimits{}
+		for k, v := range limitsMap {
+			name := strings.ToLower(k)
+			if err := parsePubRateLimits(pl, k, name, v); err != nil {
+				return err
+			}
+		}
+		sl := &opts.StoreLimits
+		sl.AddPerClient(pattern, pl)
+	}
+	return nil
+}
+
 func parseFileOptions(itf interface{}, opts *Options) error {
 	m, ok := itf.(map[string]interface{})
 	if !ok {
@@ -553,6 +708,11 @@ func parseFileOptions(itf interface{}, opts *Options) error {
 				return err
 			}
 			opts.FileStoreOpts.SliceArchiveScript = v.(string)
+		case "slice_archive_dir", "archive_dir":
+			if err := checkType(k, reflect.String, v); err != nil {
+				return err
+			}
+			opts.FileStoreOpts.SliceArchiveDir = v.(string)
 		case "file_descriptors_limit", "fds_limit":
 			if err := checkType(k, reflect.Int64, v); err != nil {
 				return err
@@ -577,6 +737,16 @@ func parseFileOptions(itf interface{}, opts *Options) error {
 				return err
 			}
 			opts.FileStoreOpts.AutoSync = dur
+		case "sub_checkpoint_records":
+			if err := checkType(k, reflect.Int64, v); err != nil {
+				return err
+			}
+			opts.FileStoreOpts.SubCheckpointRecords = int(v.(int64))
+		case "lazy_recovery":
+			if err := checkType(k, reflect.Bool, v); err != nil {
+				return err
+			}
+			opts.FileStoreOpts.LazyRecovery = v.(bool)
 		}
 	}
 	return nil
@@ -615,6 +785,49 @@ func parseSQLOptions(itf interface{}, opts *Options) error {
 				return err
 			}
 			opts.SQLStoreOpts.BulkInsertLimit = int(v.(int64))
+		case "no_migration":
+			if err := checkType(name, reflect.Bool, v); err != nil {
+				return err
+			}
+			opts.SQLStoreOpts.NoMigration = v.(bool)
+		case "read_ahead":
+			if err := checkType(name, reflect.Int64, v); err != nil {
+				return err
+			}
+			opts.SQLStoreOpts.ReadAhead = int(v.(int64))
+		case "partition_messages":
+			if err := checkType(name, reflect.Bool, v); err != nil {
+				return err
+			}
+			opts.SQLStoreOpts.PartitionMessages = v.(bool)
+		}
+	}
+	return nil
+}
+
+// parseBoltOptions updates `opts` with Bolt store options
+func parseBoltOptions(itf interface{}, opts *Options) error {
+	m, ok := itf.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected Bolt options to be a map/struct, got %v", itf)
+	}
+	for k, v := range m {
+		name := strings.ToLower(k)
+		switch name {
+		case "no_sync":
+			if err := checkType(name, reflect.Bool, v); err != nil {
+				return err
+			}
+			opts.BoltStoreOpts.NoSync = v.(bool)
+		case "open_timeout":
+			if err := checkType(name, reflect.String, v); err != nil {
+				return err
+			}
+			dur, err := time.ParseDuration(v.(string))
+			if err != nil {
+				return err
+			}
+			opts.BoltStoreOpts.OpenTimeout = dur
 		}
 	}
 	return nil
@@ -633,6 +846,7 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 		natsConfigFile string
 		clusterPeers   string
 		encryptionKey  string
+		oldKeys        string
 	)
 
 	fs.StringVar(&sopts.ID, "cluster_id", DefaultClusterID, "stan.ID")
@@ -640,6 +854,7 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 	fs.StringVar(&sopts.StoreType, "store", stores.TypeMemory, "stan.StoreType")
 	fs.StringVar(&sopts.StoreType, "st", stores.TypeMemory, "stan.StoreType")
 	fs.StringVar(&sopts.FilestoreDir, "dir", "", "stan.FilestoreDir")
+	fs.String("hybrid_memory_budget", "0", "stan.HybridMemBudget")
 	fs.IntVar(&sopts.MaxChannels, "max_channels", stores.DefaultStoreLimits.MaxChannels, "stan.MaxChannels")
 	fs.IntVar(&sopts.MaxChannels, "mc", stores.DefaultStoreLimits.MaxChannels, "stan.MaxChannels")
 	fs.IntVar(&sopts.MaxSubscriptions, "max_subs", stores.DefaultStoreLimits.MaxSubscriptions, "stan.MaxSubscriptions")
@@ -684,10 +899,13 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 	fs.String("file_slice_max_bytes", fmt.Sprintf("%v", stores.DefaultFileStoreOptions.SliceMaxBytes), "stan.FileStoreOpts.SliceMaxBytes")
 	fs.DurationVar(&sopts.FileStoreOpts.SliceMaxAge, "file_slice_max_age", stores.DefaultFileStoreOptions.SliceMaxAge, "stan.FileStoreOpts.SliceMaxAge")
 	fs.StringVar(&sopts.FileStoreOpts.SliceArchiveScript, "file_slice_archive_script", "", "stan.FileStoreOpts.SliceArchiveScript")
+	fs.StringVar(&sopts.FileStoreOpts.SliceArchiveDir, "file_slice_archive_dir", "", "stan.FileStoreOpts.SliceArchiveDir")
 	fs.Int64Var(&sopts.FileStoreOpts.FileDescriptorsLimit, "file_fds_limit", stores.DefaultFileStoreOptions.FileDescriptorsLimit, "stan.FileStoreOpts.FileDescriptorsLimit")
 	fs.IntVar(&sopts.FileStoreOpts.ParallelRecovery, "file_parallel_recovery", stores.DefaultFileStoreOptions.ParallelRecovery, "stan.FileStoreOpts.ParallelRecovery")
 	fs.BoolVar(&sopts.FileStoreOpts.TruncateUnexpectedEOF, "file_truncate_bad_eof", stores.DefaultFileStoreOptions.TruncateUnexpectedEOF, "Truncate files for which there is an unexpected EOF on recovery, dataloss may occur")
 	fs.DurationVar(&sopts.FileStoreOpts.AutoSync, "file_auto_sync", stores.DefaultFileStoreOptions.AutoSync, "Interval at which the store should be automatically flushed and sync'ed on disk (<= 0 to disable)")
+	fs.IntVar(&sopts.FileStoreOpts.SubCheckpointRecords, "file_sub_checkpoint_records", stores.DefaultFileStoreOptions.SubCheckpointRecords, "Number of subscription records after which the state of the subscriptions is checkpointed in the background, replacing compaction (0 to use compaction, not used by the SQL store)")
+	fs.BoolVar(&sopts.FileStoreOpts.LazyRecovery, "file_lazy_recovery", stores.DefaultFileStoreOptions.LazyRecovery, "Recover the messages of a channel on first access or in the background instead of on startup")
 	fs.IntVar(&sopts.IOBatchSize, "io_batch_size", DefaultIOBatchSize, "stan.IOBatchSize")
 	fs.Int64Var(&sopts.IOSleepTime, "io_sleep_time", DefaultIOSleepTime, "stan.IOSleepTime")
 	fs.StringVar(&sopts.FTGroupName, "ft_group", "", "stan.FTGroupName")
@@ -700,6 +918,8 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 	fs.IntVar(&sopts.Clustering.LogSnapshots, "cluster_log_snapshots", DefaultLogSnapshots, "stan.Clustering.LogSnapshots")
 	fs.Int64Var(&sopts.Clustering.TrailingLogs, "cluster_trailing_logs", DefaultTrailingLogs, "stan.Clustering.TrailingLogs")
 	fs.BoolVar(&sopts.Clustering.Sync, "cluster_sync", false, "stan.Clustering.Sync")
+	fs.BoolVar(&sopts.Clustering.LogSegments, "cluster_log_segments", false, "stan.Clustering.LogSegments")
+	fs.String("cluster_log_segment_size", fmt.Sprintf("%v", DefaultLogSegmentSize), "stan.Clustering.LogSegmentSize")
 	fs.BoolVar(&sopts.Clustering.RaftLogging, "cluster_raft_logging", false, "")
 	fs.BoolVar(&sopts.Clustering.ProceedOnRestoreFailure, "cluster_proceed_on_restore_failure", false, "")
 	fs.BoolVar(&sopts.Clustering.AllowAddRemoveNode, "cluster_allow_add_remove_node", false, "")
@@ -709,10 +929,23 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 	fs.BoolVar(&sopts.SQLStoreOpts.NoCaching, "sql_no_caching", defSQLOpts.NoCaching, "Enable/Disable caching")
 	fs.IntVar(&sopts.SQLStoreOpts.MaxOpenConns, "sql_max_open_conns", defSQLOpts.MaxOpenConns, "Max opened connections to the database")
 	fs.IntVar(&sopts.SQLStoreOpts.BulkInsertLimit, "sql_bulk_insert_limit", 0, "Limit the number of messages inserted in one SQL query")
+	fs.BoolVar(&sopts.SQLStoreOpts.NoMigration, "sql_no_migration", defSQLOpts.NoMigration, "Do not create or migrate the database schema")
+	fs.IntVar(&sopts.SQLStoreOpts.ReadAhead, "sql_read_ahead", 0, "Number of messages fetched with a single query when looking up a message")
+	fs.BoolVar(&sopts.SQLStoreOpts.PartitionMessages, "sql_partition_messages", defSQLOpts.PartitionMessages, "Store the messages of each channel in a dedicated table, dropped when the channel is deleted (messages removed due to limits or age are still deleted row by row)")
+	fs.BoolVar(&sopts.BoltStoreOpts.NoSync, "bolt_no_sync", false, "Do not sync the Bolt database on every write")
+	fs.DurationVar(&sopts.BoltStoreOpts.OpenTimeout, "bolt_open_timeout", stores.DefaultBoltStoreOptions().OpenTimeout, "How long to wait for the Bolt database to be opened")
 	fs.StringVar(&sopts.SyslogName, "syslog_name", "", "Syslog Name")
 	fs.BoolVar(&sopts.Encrypt, "encrypt", false, "Specify if server should use encryption at rest")
 	fs.StringVar(&sopts.EncryptionCipher, "encryption_cipher", stores.CryptoCipherAutoSelect, "Encryption cipher. Supported are AES and CHACHA (default is AES)")
 	fs.StringVar(&encryptionKey, "encryption_key", "", "Encryption Key. It is recommended to specify it through the NATS_STREAMING_ENCRYPTION_KEY environment variable instead")
+	fs.StringVar(&sopts.EncryptionKeysDir, "encryption_keys_dir", "", "Directory of the keys of the channels configured with a dedicated key")
+	fs.StringVar(&oldKeys, "encryption_old_keys", "", "Comma separated list of keys used to decrypt payloads encrypted before a key rotation. It is recommended to specify them through the NATS_STREAMING_ENCRYPTION_OLD_KEYS environment variable instead")
+	fs.StringVar(&sopts.Compression, "compression", "", "Compress messages payload with this codec. Supported is DEFLATE")
+	fs.BoolVar(&sopts.StoreMetrics, "store_metrics", false, "Record latency histograms of the store operations")
+	fs.StringVar(&sopts.BackupDir, "backup_dir", "", "Directory in which backups requested through the monitoring endpoint are created")
+	fs.BoolVar(&sopts.MonitorAdmin, "monitor_admin", false, "Enable the administrative endpoints of the monitoring server, which are not authenticated")
+	fs.DurationVar(&sopts.PubRateMaxDelay, "pub_rate_max_delay", 0, "Delay the ack of messages exceeding the publish rate limits, up to this duration, instead of rejecting them")
+	fs.StringVar(&sopts.RestoreFrom, "restore_from", "", "Backup directory to restore the state from if the store is empty")
 	fs.BoolVar(&sopts.ReplaceDurable, "replace_durable", false, "Replace the existing durable subscription instead of reporting a duplicate durable error")
 
 	// First, we need to call NATS's ConfigureOptions() with above flag set.
@@ -738,6 +971,12 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 		sopts.Encrypt = true
 		sopts.EncryptionKey = []byte(encryptionKey)
 	}
+	if oldKeys != "" {
+		sopts.EncryptionOldKeys = nil
+		for _, k := range strings.Split(oldKeys, ",") {
+			sopts.EncryptionOldKeys = append(sopts.EncryptionOldKeys, []byte(k))
+		}
+	}
 
 	// If both nats and streaming configuration files are used, then
 	// we only use the config file for the corresponding module.
@@ -778,6 +1017,8 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 			sopts.Trace, sopts.Debug = boolValue, boolValue
 		case "max_bytes", "mb":
 			sopts.MaxBytes, flagErr = getBytes(f)
+		case "hybrid_memory_budget":
+			sopts.HybridMemBudget, flagErr = getBytes(f)
 		case "file_compact_min_size":
 			sopts.FileStoreOpts.CompactMinFileSize, flagErr = getBytes(f)
 		case "file_buffer_size":
@@ -790,6 +1031,8 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 			sopts.FileStoreOpts.ReadBufferSize = int(i64)
 		case "file_slice_max_bytes":
 			sopts.FileStoreOpts.SliceMaxBytes, flagErr = getBytes(f)
+		case "cluster_log_segment_size":
+			sopts.Clustering.LogSegmentSize, flagErr = getBytes(f)
 		}
 	})
 	if flagErr != nil {
@@ -53,6 +53,9 @@ func TestParseConfig(t *testing.T) {
 	if opts.FilestoreDir != "/path/to/datastore" {
 		t.Fatalf("Expected FilestoreDir to be %q, got %q", "file", opts.FilestoreDir)
 	}
+	if opts.HybridMemBudget != 1024 {
+		t.Fatalf("Expected HybridMemBudget to be 1024, got %v", opts.HybridMemBudget)
+	}
 	if !opts.Debug {
 		t.Fatalf("Expected Debug to be true, got false")
 	}
@@ -143,6 +146,12 @@ func TestParseConfig(t *testing.T) {
 	if opts.FileStoreOpts.AutoSync != 2*time.Minute {
 		t.Fatalf("Expected AutoSync to be 2minutes, got %v", opts.FileStoreOpts.AutoSync)
 	}
+	if opts.FileStoreOpts.SubCheckpointRecords != 11 {
+		t.Fatalf("Expected SubCheckpointRecords to be 11, got %v", opts.FileStoreOpts.SubCheckpointRecords)
+	}
+	if !opts.FileStoreOpts.LazyRecovery {
+		t.Fatalf("Expected LazyRecovery to be true, got false")
+	}
 	if opts.MaxChannels != 11 {
 		t.Fatalf("Expected MaxChannels to be 11, got %v", opts.MaxChannels)
 	}
@@ -161,6 +170,12 @@ func TestParseConfig(t *testing.T) {
 	if opts.MaxInactivity != 16*time.Second {
 		t.Fatalf("Expected MaxInactivity to be 16s, got %v", opts.MaxInactivity)
 	}
+	if opts.MaxPubMsgsRate != 17 {
+		t.Fatalf("Expected MaxPubMsgsRate to be 17, got %v", opts.MaxPubMsgsRate)
+	}
+	if opts.MaxPubBytesRate != 18 {
+		t.Fatalf("Expected MaxPubBytesRate to be 18, got %v", opts.MaxPubBytesRate)
+	}
 	if len(opts.PerChannel) != 2 {
 		t.Fatalf("Expected PerChannel map to have 2 elements, got %v", len(opts.PerChannel))
 	}
@@ -183,6 +198,12 @@ func TestParseConfig(t *testing.T) {
 	if cl.MaxInactivity != 5*time.Second {
 		t.Fatalf("Expected MaxInactivity to be 5s, got %v", cl.MaxInactivity)
 	}
+	if cl.MaxPubMsgsRate != 6 {
+		t.Fatalf("Expected MaxPubMsgsRate to be 6, got %v", cl.MaxPubMsgsRate)
+	}
+	if cl.MaxPubBytesRate != 7 {
+		t.Fatalf("Expected MaxPubBytesRate to be 7, got %v", cl.MaxPubBytesRate)
+	}
 	cl, ok = opts.PerChannel["bar"]
 	if !ok {
 		t.Fatal("Expected channel bar to be found")
@@ -202,6 +223,22 @@ func TestParseConfig(t *testing.T) {
 	if cl.MaxInactivity != 9*time.Second {
 		t.Fatalf("Expected MaxInactivity to be 9s, got %v", cl.MaxInactivity)
 	}
+	if !cl.DedicatedKey {
+		t.Fatal("Expected DedicatedKey to be true")
+	}
+	if len(opts.PerClient) != 1 {
+		t.Fatalf("Expected PerClient map to have 1 element, got %v", len(opts.PerClient))
+	}
+	pl, ok := opts.PerClient["loader_*"]
+	if !ok {
+		t.Fatal("Expected client pattern loader_* to be found")
+	}
+	if pl.MaxPubMsgsRate != 19 {
+		t.Fatalf("Expected MaxPubMsgsRate to be 19, got %v", pl.MaxPubMsgsRate)
+	}
+	if pl.MaxPubBytesRate != 20 {
+		t.Fatalf("Expected MaxPubBytesRate to be 20, got %v", pl.MaxPubBytesRate)
+	}
 	if opts.ClientHBInterval != 10*time.Second {
 		t.Fatalf("Expected ClientHBInterval to be 10s, got %v", opts.ClientHBInterval)
 	}
@@ -283,6 +320,12 @@ func TestParseConfig(t *testing.T) {
 	if !opts.Clustering.NodesConnections {
 		t.Fatal("Expected NodesConnections to be true")
 	}
+	if !opts.Clustering.LogSegments {
+		t.Fatal("Expected LogSegments to be true")
+	}
+	if opts.Clustering.LogSegmentSize != 1024*1024 {
+		t.Fatalf("Expected LogSegmentSize to be 1MB, got %v", opts.Clustering.LogSegmentSize)
+	}
 	if opts.SQLStoreOpts.Driver != "mysql" {
 		t.Fatalf("Expected SQL Driver to be %q, got %q", "mysql", opts.SQLStoreOpts.Driver)
 	}
@@ -298,6 +341,21 @@ func TestParseConfig(t *testing.T) {
 	if opts.SQLStoreOpts.BulkInsertLimit != 1000 {
 		t.Fatalf("Expected SQL BulkInsertLimit to be 1000, got %v", opts.SQLStoreOpts.BulkInsertLimit)
 	}
+	if !opts.SQLStoreOpts.NoMigration {
+		t.Fatal("Expected SQL NoMigration to be true")
+	}
+	if opts.SQLStoreOpts.ReadAhead != 100 {
+		t.Fatalf("Expected SQL ReadAhead to be 100, got %v", opts.SQLStoreOpts.ReadAhead)
+	}
+	if !opts.SQLStoreOpts.PartitionMessages {
+		t.Fatal("Expected SQL PartitionMessages to be true")
+	}
+	if !opts.BoltStoreOpts.NoSync {
+		t.Fatal("Expected Bolt NoSync to be true, got false")
+	}
+	if opts.BoltStoreOpts.OpenTimeout != 3*time.Second {
+		t.Fatalf("Expected Bolt OpenTimeout to be 3s, got %v", opts.BoltStoreOpts.OpenTimeout)
+	}
 	if !opts.Encrypt {
 		t.Fatal("Expected Encrypt to be true")
 	}
@@ -307,6 +365,33 @@ func TestParseConfig(t *testing.T) {
 	if string(opts.EncryptionKey) != "key" {
 		t.Fatalf("Expected EncryptionKey to be %q, got %q", "key", opts.EncryptionKey)
 	}
+	if len(opts.EncryptionOldKeys) != 2 || string(opts.EncryptionOldKeys[0]) != "old1" || string(opts.EncryptionOldKeys[1]) != "old2" {
+		t.Fatalf("Unexpected EncryptionOldKeys: %q", opts.EncryptionOldKeys)
+	}
+	if opts.EncryptionKeysDir != "/keys" {
+		t.Fatalf("Expected EncryptionKeysDir to be %q, got %q", "/keys", opts.EncryptionKeysDir)
+	}
+	if opts.Compression != "deflate" {
+		t.Fatalf("Expected Compression to be %q, got %q", "deflate", opts.Compression)
+	}
+	if len(opts.ChannelCompression) != 1 || opts.ChannelCompression["bar.>"] != "none" {
+		t.Fatalf("Unexpected ChannelCompression: %v", opts.ChannelCompression)
+	}
+	if !opts.StoreMetrics {
+		t.Fatal("Expected StoreMetrics to be true")
+	}
+	if opts.BackupDir != "/backups" {
+		t.Fatalf("Expected BackupDir to be %q, got %q", "/backups", opts.BackupDir)
+	}
+	if opts.RestoreFrom != "/backups/last" {
+		t.Fatalf("Expected RestoreFrom to be %q, got %q", "/backups/last", opts.RestoreFrom)
+	}
+	if !opts.MonitorAdmin {
+		t.Fatal("Expected MonitorAdmin to be true")
+	}
+	if opts.PubRateMaxDelay != 2*time.Second {
+		t.Fatalf("Expected PubRateMaxDelay to be 2s, got %v", opts.PubRateMaxDelay)
+	}
 }
 
 func TestParsePermError(t *testing.T) {
@@ -398,7 +483,7 @@ func TestParsePerChannelLimitsSetToZero(t *testing.T) {
 	confFile := "config.conf"
 	defer os.Remove(confFile)
 	if err := os.WriteFile(confFile,
-		[]byte("store_limits: {channels: {foo: {max_msgs: 0, max_bytes: 0, max_age: \"0\", max_subs: 0, max_inactivity: \"0\"}}}"), 0660); err != nil {
+		[]byte("store_limits: {channels: {foo: {max_msgs: 0, max_bytes: 0, max_age: \"0\", max_subs: 0, max_inactivity: \"0\", max_pub_msgs_rate: 0, max_pub_bytes_rate: 0}}}"), 0660); err != nil {
 		t.Fatalf("Unexpected error creating conf file: %v", err)
 	}
 	opts := Options{}
@@ -417,6 +502,8 @@ func TestParsePerChannelLimitsSetToZero(t *testing.T) {
 	expected.MaxAge = -1
 	expected.MaxSubscriptions = -1
 	expected.MaxInactivity = -1
+	expected.MaxPubMsgsRate = -1
+	expected.MaxPubBytesRate = -1
 	if !reflect.DeepEqual(*cl, expected) {
 		t.Fatalf("Expected channel limits for foo to be %v, got %v", expected, *cl)
 	}
@@ -427,10 +514,13 @@ func TestParseMapStruct(t *testing.T) {
 	expectFailureFor(t, "store_limits: xxx", mapStructErr)
 	expectFailureFor(t, "store_limits: {\nchannels: xxx\n}", mapStructErr)
 	expectFailureFor(t, "store_limits: {\nchannels: {\n\"foo\": xxx\n}\n}", mapStructErr)
+	expectFailureFor(t, "store_limits: {\nclients: xxx\n}", mapStructErr)
+	expectFailureFor(t, "store_limits: {\nclients: {\n\"foo\": xxx\n}\n}", mapStructErr)
 	expectFailureFor(t, "tls: xxx", mapStructErr)
 	expectFailureFor(t, "file: xxx", mapStructErr)
 	expectFailureFor(t, "cluster: xxx", mapStructErr)
 	expectFailureFor(t, "sql: xxx", mapStructErr)
+	expectFailureFor(t, "bolt: xxx", mapStructErr)
 }
 
 func TestParseWrongTypes(t *testing.T) {
@@ -439,6 +529,7 @@ func TestParseWrongTypes(t *testing.T) {
 	expectFailureFor(t, "discover_prefix: 123", wrongTypeErr)
 	expectFailureFor(t, "store: 123", wrongTypeErr)
 	expectFailureFor(t, "dir: 123", wrongTypeErr)
+	expectFailureFor(t, "hybrid_memory_budget: false", wrongTypeErr)
 	expectFailureFor(t, "sd: 123", wrongTypeErr)
 	expectFailureFor(t, "sv: 123", wrongTypeErr)
 	expectFailureFor(t, "ns: 123", wrongTypeErr)
@@ -460,6 +551,9 @@ func TestParseWrongTypes(t *testing.T) {
 	expectFailureFor(t, "store_limits:{max_subs:false}", wrongTypeErr)
 	expectFailureFor(t, "store_limits:{max_inactivity:false}", wrongTypeErr)
 	expectFailureFor(t, "store_limits:{max_inactivity:\"foo\"}", wrongTimeErr)
+	expectFailureFor(t, "store_limits:{max_pub_msgs_rate:false}", wrongTypeErr)
+	expectFailureFor(t, "store_limits:{max_pub_bytes_rate:false}", wrongTypeErr)
+	expectFailureFor(t, "store_limits:{clients:{foo:{max_pub_msgs_rate:false}}}", wrongTypeErr)
 	expectFailureFor(t, "store_limits:{channels:{\"foo\":{max_msgs:false}}}", wrongTypeErr)
 	expectFailureFor(t, "store_limits:{channels:{\"foo\":{max_bytes:false}}}", wrongTypeErr)
 	expectFailureFor(t, "store_limits:{channels:{\"foo\":{max_age:\"1h:0m\"}}}", wrongTimeErr)
@@ -467,6 +561,7 @@ func TestParseWrongTypes(t *testing.T) {
 	expectFailureFor(t, "store_limits:{channels:{\"foo\":{max_subs:false}}}", wrongTypeErr)
 	expectFailureFor(t, "store_limits:{channels:{\"foo\":{max_inactivity:false}}}", wrongTypeErr)
 	expectFailureFor(t, "store_limits:{channels:{\"foo\":{max_inactivity:\"1L0m\"}}}", wrongTimeErr)
+	expectFailureFor(t, "store_limits:{channels:{\"foo\":{dedicated_key:123}}}", wrongTypeErr)
 	expectFailureFor(t, "store_limits:{channels:{\"foo.*bar\":{}}}", wrongChanErr)
 	expectFailureFor(t, "store_limits:{channels:{\"foo.>.>\":{}}}", wrongChanErr)
 	expectFailureFor(t, "store_limits:{channels:{\"foo..bar\":{}}}", wrongChanErr)
@@ -490,10 +585,13 @@ func TestParseWrongTypes(t *testing.T) {
 	expectFailureFor(t, "file:{slice_max_age:123}", wrongTypeErr)
 	expectFailureFor(t, "file:{slice_max_age:\"1h:0m\"}", wrongTimeErr)
 	expectFailureFor(t, "file:{slice_archive_script:123}", wrongTypeErr)
+	expectFailureFor(t, "file:{slice_archive_dir:123}", wrongTypeErr)
 	expectFailureFor(t, "file:{fds_limit:false}", wrongTypeErr)
 	expectFailureFor(t, "file:{parallel_recovery:false}", wrongTypeErr)
 	expectFailureFor(t, "file:{auto_sync:123}", wrongTypeErr)
 	expectFailureFor(t, "file:{auto_sync:\"1h:0m\"}", wrongTimeErr)
+	expectFailureFor(t, "file:{sub_checkpoint_records:false}", wrongTypeErr)
+	expectFailureFor(t, "file:{lazy_recovery:123}", wrongTypeErr)
 	expectFailureFor(t, "cluster:{node_id:false}", wrongTypeErr)
 	expectFailureFor(t, "cluster:{bootstrap:1}", wrongTypeErr)
 	expectFailureFor(t, "cluster:{peers:1}", wrongTypeErr)
@@ -515,13 +613,33 @@ func TestParseWrongTypes(t *testing.T) {
 	expectFailureFor(t, "cluster:{allow_add_remove_node:1}", wrongTypeErr)
 	expectFailureFor(t, "cluster:{bolt_free_list_sync:123}", wrongTypeErr)
 	expectFailureFor(t, "cluster:{bolt_free_list_map:123}", wrongTypeErr)
+	expectFailureFor(t, "cluster:{log_segments:123}", wrongTypeErr)
+	expectFailureFor(t, "cluster:{log_segment_size:false}", wrongTypeErr)
 	expectFailureFor(t, "sql:{driver:false}", wrongTypeErr)
 	expectFailureFor(t, "sql:{source:false}", wrongTypeErr)
 	expectFailureFor(t, "sql:{no_caching:123}", wrongTypeErr)
+	expectFailureFor(t, "sql:{no_migration:123}", wrongTypeErr)
+	expectFailureFor(t, "sql:{read_ahead:false}", wrongTypeErr)
+	expectFailureFor(t, "sql:{partition_messages:123}", wrongTypeErr)
 	expectFailureFor(t, "sql:{max_open_conns:false}", wrongTypeErr)
+	expectFailureFor(t, "bolt:{no_sync:123}", wrongTypeErr)
+	expectFailureFor(t, "bolt:{open_timeout:123}", wrongTypeErr)
+	expectFailureFor(t, "bolt:{open_timeout:\"not_a_time\"}", wrongTimeErr)
 	expectFailureFor(t, "encrypt: 123", wrongTypeErr)
 	expectFailureFor(t, "encryption_cipher: 123", wrongTypeErr)
 	expectFailureFor(t, "encryption_key: 123", wrongTypeErr)
+	expectFailureFor(t, "encryption_keys_dir: 123", wrongTypeErr)
+	expectFailureFor(t, "encryption_old_keys: 123", wrongTypeErr)
+	expectFailureFor(t, "encryption_old_keys: [\n  123\n]\n", wrongTypeErr)
+	expectFailureFor(t, "compression: 123", wrongTypeErr)
+	expectFailureFor(t, "compression_channels: 123", mapStructErr)
+	expectFailureFor(t, "compression_channels: {foo: 123}", wrongTypeErr)
+	expectFailureFor(t, "store_metrics: 123", wrongTypeErr)
+	expectFailureFor(t, "backup_dir: 123", wrongTypeErr)
+	expectFailureFor(t, "restore_from: 123", wrongTypeErr)
+	expectFailureFor(t, "monitor_admin: 123", wrongTypeErr)
+	expectFailureFor(t, "pub_rate_max_delay: 123", wrongTypeErr)
+	expectFailureFor(t, "pub_rate_max_delay: \"foo\"", wrongTimeErr)
 	expectFailureFor(t, "credentials: 123", wrongTypeErr)
 	expectFailureFor(t, "username: 123", wrongTypeErr)
 	expectFailureFor(t, "password: 123", wrongTypeErr)
@@ -604,7 +722,7 @@ func TestParseConfigureOptions(t *testing.T) {
 	}
 
 	// Test bytes values
-	sopts, _ = mustNotFail([]string{"-max_bytes", "100KB", "-mb", "100KB", "-file_compact_min_size", "200KB", "-file_buffer_size", "300KB", "-file_read_buffer_size", "1MB"})
+	sopts, _ = mustNotFail([]string{"-max_bytes", "100KB", "-mb", "100KB", "-file_compact_min_size", "200KB", "-file_buffer_size", "300KB", "-file_read_buffer_size", "1MB", "-hybrid_memory_budget", "2MB"})
 	if sopts.MaxBytes != 100*1024 {
 		t.Fatalf("Expected max_bytes to be 100KB, got %v", sopts.MaxBytes)
 	}
@@ -617,6 +735,9 @@ func TestParseConfigureOptions(t *testing.T) {
 	if sopts.FileStoreOpts.ReadBufferSize != 1024*1024 {
 		t.Fatalf("Expected file_read_buffer_size to be 1MB, got %v", sopts.FileStoreOpts.ReadBufferSize)
 	}
+	if sopts.HybridMemBudget != 2*1024*1024 {
+		t.Fatalf("Expected hybrid_memory_budget to be 2MB, got %v", sopts.HybridMemBudget)
+	}
 
 	// Failures with bytes
 	expectToFail([]string{"-max_bytes", "12abc"}, "should be a size")
@@ -0,0 +1,514 @@
+// Copyright 2017-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+	"github.com/kubemq-io/broker/server/stan/spb"
+	"github.com/kubemq-io/broker/server/stan/stores"
+	"github.com/kubemq-io/broker/server/stan/util"
+)
+
+// Routes for export and import of a channel's messages
+const (
+	ExportPath = RootPath + "/export"
+	ImportPath = RootPath + "/import"
+)
+
+// Formats of exported messages.
+const (
+	// ExportFormatJSON is one JSON object per line, see ExportedMsg.
+	ExportFormatJSON = "json"
+	// ExportFormatProto is a stream of pb.MsgProto, each one prefixed with
+	// its size encoded as an unsigned varint.
+	ExportFormatProto = "proto"
+)
+
+// Maximum size of an imported message in the protobuf format, to protect
+// against corrupted files.
+const maxImportedMsgSize = 64 * 1024 * 1024
+
+// Number of imported messages stored (or replicated) at once.
+const importBatchSize = 1024
+
+// ErrInvalidExportFormat is returned for an unknown export format.
+var ErrInvalidExportFormat = errors.New("stan: invalid export format")
+
+// ExportedMsg is the representation of a message in the JSON format.
+type ExportedMsg struct {
+	Sequence  uint64 `json:"seq"`
+	Subject   string `json:"subject"`
+	Reply     string `json:"reply,omitempty"`
+	Data      []byte `json:"data"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ExportOptions select the messages to export. Zero values mean no bound.
+type ExportOptions struct {
+	Format   string    // ExportFormatJSON (default) or ExportFormatProto
+	FirstSeq uint64    // Sequence of the first message to export
+	LastSeq  uint64    // Sequence of the last message to export
+	Start    time.Time // Export messages stored at or after this time
+	End      time.Time // Export messages stored before this time
+}
+
+// ImportOptions are the options of an import.
+type ImportOptions struct {
+	Format string // ExportFormatJSON (default) or ExportFormatProto
+}
+
+// ImportResult is the result of an import.
+type ImportResult struct {
+	Msgs int // Number of messages imported
+	// Number of messages whose timestamp was raised to the one of the
+	// last message of the channel, since timestamps of a channel can't
+	// go back in time (see ImportChannel).
+	AdjustedTimestamps int
+}
+
+func checkExportFormat(format string) (string, error) {
+	switch format {
+	case "":
+		return ExportFormatJSON, nil
+	case ExportFormatJSON, ExportFormatProto:
+		return format, nil
+	}
+	return "", ErrInvalidExportFormat
+}
+
+// msgWriter writes messages in one of the export formats.
+type msgWriter struct {
+	w      *bufio.Writer
+	format string
+	buf    []byte
+}
+
+func (mw *msgWriter) write(m *pb.MsgProto) error {
+	if mw.format == ExportFormatJSON {
+		b, err := json.Marshal(&ExportedMsg{
+			Sequence:  m.Sequence,
+			Subject:   m.Subject,
+			Reply:     m.Reply,
+			Data:      m.Data,
+			Timestamp: m.Timestamp,
+		})
+		if err != nil {
+			return err
+		}
+		mw.w.Write(b)
+		return mw.w.WriteByte('\n')
+	}
+	size := m.Size()
+	mw.buf = util.EnsureBufBigEnough(mw.buf, binary.MaxVarintLen64+size)
+	n := binary.PutUvarint(mw.buf, uint64(size))
+	if _, err := m.MarshalTo(mw.buf[n:]); err != nil {
+		return err
+	}
+	_, err := mw.w.Write(mw.buf[:n+size])
+	return err
+}
+
+// ExportMsgs writes the messages of `ms` selected by `opts` to `w` and
+// returns the number of messages written.
+func ExportMsgs(ms stores.MsgStore, w io.Writer, opts *ExportOptions) (int, error) {
+	format, err := checkExportFormat(opts.Format)
+	if err != nil {
+		return 0, err
+	}
+	first, last, err := ms.FirstAndLastSequence()
+	if err != nil {
+		return 0, err
+	}
+	if first == 0 {
+		return 0, nil
+	}
+	if opts.FirstSeq > first {
+		first = opts.FirstSeq
+	}
+	if opts.LastSeq > 0 && opts.LastSeq < last {
+		last = opts.LastSeq
+	}
+	if !opts.Start.IsZero() {
+		seq, err := ms.GetSequenceFromTimestamp(opts.Start.UnixNano())
+		if err != nil {
+			return 0, err
+		}
+		if seq > first {
+			first = seq
+		}
+	}
+	var end int64
+	if !opts.End.IsZero() {
+		end = opts.End.UnixNano()
+	}
+	mw := &msgWriter{w: bufio.NewWriter(w), format: format}
+	count := 0
+	for seq := first; seq <= last; seq++ {
+		m, err := ms.Lookup(seq)
+		if err != nil {
+			return count, fmt.Errorf("unable to lookup message %v: %v", seq, err)
+		}
+		if m == nil {
+			continue
+		}
+		if end != 0 && m.Timestamp >= end {
+			break
+		}
+		if err := mw.write(m); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, mw.w.Flush()
+}
+
+// ReadExportedMsgs reads the messages written by ExportMsgs from `r` and
+// invokes `f` for each of them, until the end of `r` is reached or `f`
+// returns an error.
+func ReadExportedMsgs(r io.Reader, format string, f func(m *pb.MsgProto) error) error {
+	format, err := checkExportFormat(format)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(r)
+	if format == ExportFormatJSON {
+		dec := json.NewDecoder(br)
+		for {
+			em := ExportedMsg{}
+			if err := dec.Decode(&em); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("invalid message: %v", err)
+			}
+			m := &pb.MsgProto{
+				Sequence:  em.Sequence,
+				Subject:   em.Subject,
+				Reply:     em.Reply,
+				Data:      em.Data,
+				Timestamp: em.Timestamp,
+			}
+			if err := f(m); err != nil {
+				return err
+			}
+		}
+	}
+	var buf []byte
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid message size: %v", err)
+		}
+		if size > maxImportedMsgSize {
+			return fmt.Errorf("invalid message size: %v", size)
+		}
+		buf = util.EnsureBufBigEnough(buf, int(size))
+		if _, err := io.ReadFull(br, buf[:size]); err != nil {
+			return fmt.Errorf("unable to read message: %v", err)
+		}
+		m := &pb.MsgProto{}
+		if err := m.Unmarshal(buf[:size]); err != nil {
+			return fmt.Errorf("invalid message: %v", err)
+		}
+		if err := f(m); err != nil {
+			return err
+		}
+	}
+}
+
+// msgImporter assigns the sequences of the imported messages. Timestamps
+// are preserved, unless older than the ones of the messages already in
+// the channel, in which case they are raised so that they remain ordered.
+type msgImporter struct {
+	channel   string
+	nextSeq   uint64
+	timestamp int64
+	adjusted  int
+}
+
+func newMsgImporter(channel string, ms stores.MsgStore, nextSeq uint64) (*msgImporter, error) {
+	lm, err := ms.LastMsg()
+	if err != nil {
+		return nil, err
+	}
+	mi := &msgImporter{channel: channel, nextSeq: nextSeq}
+	if lm != nil {
+		mi.timestamp = lm.Timestamp
+	}
+	return mi, nil
+}
+
+func (mi *msgImporter) assign(m *pb.MsgProto) {
+	m.Sequence = mi.nextSeq
+	m.Subject = mi.channel
+	m.Redelivered, m.RedeliveryCount = false, 0
+	if m.Timestamp < mi.timestamp {
+		m.Timestamp = mi.timestamp
+		mi.adjusted++
+	}
+	mi.timestamp = m.Timestamp
+	mi.nextSeq++
+}
+
+// ImportMsgs stores the messages read from `r` in `ms`, the message store
+// of `channel`, after the last message of the store. This is meant for
+// tools that work on a store while the server is stopped. Timestamps are
+// handled as in ImportChannel. It returns the number of messages stored,
+// even on error.
+func ImportMsgs(channel string, ms stores.MsgStore, r io.Reader, opts *ImportOptions) (ImportResult, error) {
+	var res ImportResult
+	if !util.IsChannelNameValid(channel, false) {
+		return res, ErrInvalidSubject
+	}
+	last, err := ms.LastSequence()
+	if err != nil {
+		return res, err
+	}
+	mi, err := newMsgImporter(channel, ms, last+1)
+	if err != nil {
+		return res, err
+	}
+	err = ReadExportedMsgs(r, opts.Format, func(m *pb.MsgProto) error {
+		mi.assign(m)
+		if _, err := ms.Store(m); err != nil {
+			return fmt.Errorf("unable to store message %v: %v", m.Sequence, err)
+		}
+		res.Msgs++
+		return nil
+	})
+	if ferr := ms.Flush(); err == nil {
+		err = ferr
+	}
+	res.AdjustedTimestamps = mi.adjusted
+	return res, err
+}
+
+// ExportChannel writes the messages of `channel` selected by `opts` to `w`
+// and returns the number of messages written.
+func (s *StanServer) ExportChannel(channel string, w io.Writer, opts *ExportOptions) (int, error) {
+	c := s.channels.get(channel)
+	if c == nil {
+		return 0, ErrUnknownChannel
+	}
+	return ExportMsgs(c.store.Msgs, w, opts)
+}
+
+// ImportChannel adds the messages read from `r` to channel `name`, which is
+// created if needed, as if they had been published (see ExportOptions for
+// the formats). Messages get new sequences, starting after the last one of
+// the channel, and are sent to the channel's subscriptions. In clustering
+// mode, messages are replicated. Messages are read in batches, and
+// published messages are held only while a batch is stored.
+// Timestamps are preserved, except that the timestamps of a channel can't
+// go back in time: a message older than the last one of the channel (for
+// instance when importing in a channel that already has newer messages)
+// gets the timestamp of that last message. The number of such messages is
+// reported in the result, along with the number of messages imported, even
+// on error.
+func (s *StanServer) ImportChannel(name string, r io.Reader, opts *ImportOptions) (ImportResult, error) {
+	var res ImportResult
+	if !util.IsChannelNameValid(name, false) {
+		return res, ErrInvalidSubject
+	}
+	if _, err := checkExportFormat(opts.Format); err != nil {
+		return res, err
+	}
+	if s.isClustered && !s.isLeader() {
+		return res, fmt.Errorf("stan: import must be done on the leader")
+	}
+	var (
+		c     *channel
+		mi    *msgImporter
+		batch = make([]*pb.MsgProto, 0, importBatchSize)
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		// Pause the processing of published messages so that sequences
+		// can be assigned to the messages of this batch.
+		sc, sdc := s.sendSynchronziationRequest()
+		select {
+		case <-sc:
+		case <-s.ioChannelQuit:
+			close(sdc)
+			return fmt.Errorf("server shutting down")
+		}
+		defer close(sdc)
+
+		if c == nil {
+			var err error
+			if c, err = s.lookupOrCreateChannel(name); err != nil {
+				return err
+			}
+			if mi, err = newMsgImporter(name, c.store.Msgs, c.nextSequence); err != nil {
+				return err
+			}
+		}
+		// Messages may have been published since the previous batch.
+		mi.nextSeq = c.nextSequence
+		if c.lTimestamp > mi.timestamp {
+			mi.timestamp = c.lTimestamp
+		}
+		for _, m := range batch {
+			mi.assign(m)
+		}
+		err := s.storeImportedMsgs(c, batch)
+		if err == nil {
+			res.Msgs += len(batch)
+			c.nextSequence = mi.nextSeq
+			c.lTimestamp = mi.timestamp
+			if c.activity != nil {
+				c.activity.last = time.Unix(0, c.lTimestamp)
+			}
+			err = c.store.Msgs.Flush()
+		} else if last, lerr := c.store.Msgs.LastSequence(); lerr == nil && c.nextSequence <= last {
+			// Some messages of the batch may have been stored.
+			c.nextSequence = last + 1
+		}
+		batch = batch[:0]
+		if err != nil {
+			return err
+		}
+		s.processMsg(c)
+		return c.store.Subs.Flush()
+	}
+	err := ReadExportedMsgs(r, opts.Format, func(m *pb.MsgProto) error {
+		batch = append(batch, m)
+		if len(batch) == importBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if ferr := flush(); err == nil {
+		err = ferr
+	}
+	if mi != nil {
+		res.AdjustedTimestamps = mi.adjusted
+	}
+	if res.Msgs > 0 {
+		s.log.Noticef("Imported %v message(s) in channel %q", res.Msgs, name)
+	}
+	if res.AdjustedTimestamps > 0 {
+		s.log.Warnf("Timestamp of %v imported message(s) raised to keep the timestamps of channel %q ordered",
+			res.AdjustedTimestamps, name)
+	}
+	return res, err
+}
+
+// storeImportedMsgs stores, or replicates in clustering mode, a batch of
+// imported messages.
+func (s *StanServer) storeImportedMsgs(c *channel, msgs []*pb.MsgProto) error {
+	if !s.isClustered {
+		for _, m := range msgs {
+			if _, err := c.store.Msgs.Store(m); err != nil {
+				return fmt.Errorf("unable to store message %v: %v", m.Sequence, err)
+			}
+		}
+		return nil
+	}
+	op := &spb.RaftOperation{
+		OpType:       spb.RaftOperation_Publish,
+		PublishBatch: &spb.Batch{Messages: msgs},
+		ChannelID:    c.id,
+	}
+	data, err := op.Marshal()
+	if err != nil {
+		return err
+	}
+	return s.raft.Apply(data, 0).Error()
+}
+
+// HandleExportz writes the messages of the channel given by the `channel`
+// query parameter. The `format`, `first_seq`, `last_seq`, `start` and
+// `end` (RFC3339) query parameters correspond to the ExportOptions.
+func (s *StanServer) HandleExportz(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	channel := q.Get("channel")
+	opts := &ExportOptions{Format: q.Get("format")}
+	var err error
+	parseSeq := func(name string, seq *uint64) {
+		if v := q.Get(name); v != "" && err == nil {
+			*seq, err = strconv.ParseUint(v, 10, 64)
+		}
+	}
+	parseTime := func(name string, t *time.Time) {
+		if v := q.Get(name); v != "" && err == nil {
+			*t, err = time.Parse(time.RFC3339Nano, v)
+		}
+	}
+	parseSeq("first_seq", &opts.FirstSeq)
+	parseSeq("last_seq", &opts.LastSeq)
+	parseTime("start", &opts.Start)
+	parseTime("end", &opts.End)
+	if err == nil {
+		opts.Format, err = checkExportFormat(opts.Format)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid export request: %v", err), http.StatusBadRequest)
+		return
+	}
+	c := s.channels.get(channel)
+	if c == nil {
+		http.Error(w, fmt.Sprintf("Channel %q not found", channel), http.StatusNotFound)
+		return
+	}
+	if opts.Format == ExportFormatJSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	// Headers are sent with the first message, so errors can only be
+	// reported in the log from here.
+	if _, err := ExportMsgs(c.store.Msgs, w, opts); err != nil {
+		s.log.Errorf("Error exporting channel %q: %v", channel, err)
+	}
+}
+
+// Importz is the result of an import through the monitoring endpoint.
+type Importz struct {
+	Channel            string `json:"channel"`
+	Msgs               int    `json:"msgs"`
+	AdjustedTimestamps int    `json:"adjusted_timestamps,omitempty"`
+}
+
+// HandleImportz imports the messages in the body of the request in the
+// channel given by the `channel` query parameter, in the format given by
+// the `format` query parameter. Only POST requests are accepted.
+func (s *StanServer) HandleImportz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	channel := q.Get("channel")
+	res, err := s.ImportChannel(channel, r.Body, &ImportOptions{Format: q.Get("format")})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error importing messages (%v imported): %v", res.Msgs, err), http.StatusInternalServerError)
+		return
+	}
+	s.sendResponse(w, r, &Importz{Channel: channel, Msgs: res.Msgs, AdjustedTimestamps: res.AdjustedTimestamps})
+}
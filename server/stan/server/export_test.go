@@ -0,0 +1,223 @@
+// Copyright 2017-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kubemq-io/broker/client/stan"
+	"github.com/kubemq-io/broker/client/stan/pb"
+)
+
+func TestExportImportChannel(t *testing.T) {
+	opts := GetDefaultOptions()
+	s := runServerWithOpts(t, opts, nil)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+	for i := 0; i < 10; i++ {
+		if err := sc.Publish("foo", []byte(fmt.Sprintf("msg%d", i+1))); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	c := s.channels.get("foo")
+	lookup := func(seq uint64) *pb.MsgProto {
+		t.Helper()
+		m, err := c.store.Msgs.Lookup(seq)
+		if err != nil || m == nil {
+			t.Fatalf("Error looking up message %v: %v", seq, err)
+		}
+		return m
+	}
+
+	if _, err := s.ExportChannel("unknown", io.Discard, &ExportOptions{}); err != ErrUnknownChannel {
+		t.Fatalf("Expected error %v, got %v", ErrUnknownChannel, err)
+	}
+	if _, err := s.ExportChannel("foo", io.Discard, &ExportOptions{Format: "xml"}); err != ErrInvalidExportFormat {
+		t.Fatalf("Expected error %v, got %v", ErrInvalidExportFormat, err)
+	}
+
+	// JSON lines, by sequence.
+	var buf bytes.Buffer
+	n, err := s.ExportChannel("foo", &buf, &ExportOptions{FirstSeq: 3, LastSeq: 8})
+	if err != nil || n != 6 {
+		t.Fatalf("Expected 6 messages exported, got %v (err=%v)", n, err)
+	}
+	scanner := bufio.NewScanner(&buf)
+	seq := uint64(3)
+	for scanner.Scan() {
+		em := ExportedMsg{}
+		if err := json.Unmarshal(scanner.Bytes(), &em); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		m := lookup(seq)
+		if em.Sequence != seq || em.Subject != "foo" || string(em.Data) != string(m.Data) || em.Timestamp != m.Timestamp {
+			t.Fatalf("Unexpected exported message: %+v", em)
+		}
+		seq++
+	}
+	if seq != 9 {
+		t.Fatalf("Expected 6 lines, got %v", seq-3)
+	}
+
+	// Protobuf, by time range.
+	buf.Reset()
+	n, err = s.ExportChannel("foo", &buf, &ExportOptions{
+		Format: ExportFormatProto,
+		Start:  time.Unix(0, lookup(5).Timestamp),
+		End:    time.Unix(0, lookup(10).Timestamp),
+	})
+	if err != nil || n == 0 {
+		t.Fatalf("Expected messages exported, got %v (err=%v)", n, err)
+	}
+	exported := buf.Bytes()
+	var msgs []*pb.MsgProto
+	if err := ReadExportedMsgs(bytes.NewReader(exported), ExportFormatProto, func(m *pb.MsgProto) error {
+		msgs = append(msgs, m)
+		return nil
+	}); err != nil {
+		t.Fatalf("Error reading messages: %v", err)
+	}
+	if len(msgs) != n || msgs[0].Sequence > 5 || msgs[len(msgs)-1].Sequence != 9 {
+		t.Fatalf("Unexpected exported messages: %v", msgs)
+	}
+
+	// Import in another channel, subscribers get the messages.
+	ch := make(chan *stan.Msg, 2*n)
+	if _, err := sc.Subscribe("bar", func(m *stan.Msg) { ch <- m }); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := s.ImportChannel("bar", bytes.NewReader(exported), &ImportOptions{Format: ExportFormatProto})
+		if err != nil || res.Msgs != n {
+			t.Fatalf("Expected %v messages imported, got %v (err=%v)", n, res.Msgs, err)
+		}
+		// The second import can't go back in time, all but the last
+		// message get the timestamp of the last message of the channel.
+		if expected := i * (n - 1); res.AdjustedTimestamps != expected {
+			t.Fatalf("Expected %v adjusted timestamps, got %v", expected, res.AdjustedTimestamps)
+		}
+	}
+	for i := 0; i < 2*n; i++ {
+		select {
+		case m := <-ch:
+			om := msgs[i%n]
+			if m.Sequence != uint64(i+1) || string(m.Data) != string(om.Data) {
+				t.Fatalf("Unexpected message: %v", m)
+			}
+			// The second import can't go back in time.
+			if i < n && m.Timestamp != om.Timestamp {
+				t.Fatalf("Expected timestamp %v, got %v", om.Timestamp, m.Timestamp)
+			} else if i >= n && m.Timestamp != msgs[n-1].Timestamp {
+				t.Fatalf("Expected timestamp %v, got %v", msgs[n-1].Timestamp, m.Timestamp)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Did not get our messages")
+		}
+	}
+	// Publishing still works after the import.
+	if err := sc.Publish("bar", []byte("new")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	select {
+	case m := <-ch:
+		if m.Sequence != uint64(2*n+1) {
+			t.Fatalf("Unexpected message: %v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not get our message")
+	}
+	if _, last, _ := s.channels.get("bar").store.Msgs.FirstAndLastSequence(); last != uint64(2*n+1) {
+		t.Fatalf("Expected last sequence to be %v, got %v", 2*n+1, last)
+	}
+
+	if _, err := s.ImportChannel("foo.*", bytes.NewReader(exported), &ImportOptions{}); err != ErrInvalidSubject {
+		t.Fatalf("Expected error %v, got %v", ErrInvalidSubject, err)
+	}
+	if res, err := s.ImportChannel("baz", bytes.NewReader([]byte("not json")), &ImportOptions{}); err == nil || res.Msgs != 0 {
+		t.Fatalf("Expected error importing invalid data, got %v (n=%v)", err, res.Msgs)
+	}
+}
+
+func TestMonitorExportImportDisabled(t *testing.T) {
+	resetPreviousHTTPConnections()
+	s := runMonitorServer(t, GetDefaultOptions())
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+	if err := sc.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	monitorExpectStatus(t, ExportPath+"?channel=foo", http.StatusNotFound)
+	monitorExpectStatus(t, ImportPath+"?channel=foo", http.StatusNotFound)
+}
+
+func TestMonitorExportImport(t *testing.T) {
+	resetPreviousHTTPConnections()
+	opts := GetDefaultOptions()
+	opts.MonitorAdmin = true
+	s := runMonitorServer(t, opts)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+	for i := 0; i < 5; i++ {
+		if err := sc.Publish("foo", []byte(fmt.Sprintf("msg%d", i+1))); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+
+	monitorExpectStatus(t, ExportPath+"?channel=bar", http.StatusNotFound)
+	monitorExpectStatus(t, ExportPath+"?channel=foo&first_seq=x", http.StatusBadRequest)
+	monitorExpectStatus(t, ImportPath+"?channel=foo", http.StatusMethodNotAllowed)
+
+	url := fmt.Sprintf("http://%s:%d%s", monitorHost, monitorPort, ExportPath+"?channel=foo&first_seq=2")
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Expected no error: Got %v\n", err)
+	}
+	exported, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected response: %v (err=%v)", resp.StatusCode, err)
+	}
+
+	url = fmt.Sprintf("http://%s:%d%s", monitorHost, monitorPort, ImportPath+"?channel=bar")
+	resp, err = http.Post(url, "application/x-ndjson", bytes.NewReader(exported))
+	if err != nil {
+		t.Fatalf("Expected no error: Got %v\n", err)
+	}
+	iz := Importz{}
+	err = json.NewDecoder(resp.Body).Decode(&iz)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected response: %v (err=%v)", resp.StatusCode, err)
+	}
+	if iz.Channel != "bar" || iz.Msgs != 4 || iz.AdjustedTimestamps != 0 {
+		t.Fatalf("Unexpected import result: %+v", iz)
+	}
+	m, err := s.channels.get("bar").store.Msgs.Lookup(1)
+	if err != nil || m == nil || string(m.Data) != "msg2" {
+		t.Fatalf("Unexpected imported message: %v (err=%v)", m, err)
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright 2017-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+)
+
+// A subscription filter is a boolean expression evaluated against each
+// message before it is delivered. The grammar is:
+//
+//	expr    := and { "||" and }
+//	and     := unary { "&&" unary }
+//	unary   := "!" unary | "(" expr ")" | field [ op literal ]
+//	field   := "subject" | "reply" | "sequence" | "timestamp" | "data" { "." name }
+//	op      := "==" | "!=" | "<" | "<=" | ">" | ">="
+//	literal := string | number | "true" | "false" | "null"
+//
+// `data` refers to the payload decoded as JSON, and `data.a.b` to a field
+// of that payload (a numeric name indexes an array). A field alone is true
+// if it is present. A comparison with a missing field is false, and values
+// of different types are never equal.
+
+// msgFilter is a compiled subscription filter.
+type msgFilter struct {
+	expr string
+	root filterNode
+}
+
+type filterNode interface {
+	eval(fm *filterMsg) bool
+}
+
+// filterMsg gives access to the fields of the message being filtered.
+// The payload is decoded at most once.
+type filterMsg struct {
+	m       *pb.MsgProto
+	decoded bool
+	data    interface{}
+	isJSON  bool
+}
+
+type filterOr struct{ left, right filterNode }
+type filterAnd struct{ left, right filterNode }
+type filterNot struct{ node filterNode }
+
+type filterCmp struct {
+	path  []string
+	op    string // empty for a presence test
+	value interface{}
+}
+
+// newMsgFilter compiles the given expression. Returns nil if the
+// expression is empty.
+func newMsgFilter(expr string) (*msgFilter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	p := &filterParser{input: expr}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != filterTokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &msgFilter{expr: strings.TrimSpace(expr), root: root}, nil
+}
+
+// expression returns the expression of this filter, or "" if f is nil.
+func (f *msgFilter) expression() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// matches returns true if the message should be delivered.
+func (f *msgFilter) matches(m *pb.MsgProto) bool {
+	if f == nil {
+		return true
+	}
+	return f.root.eval(&filterMsg{m: m})
+}
+
+func (n *filterOr) eval(fm *filterMsg) bool  { return n.left.eval(fm) || n.right.eval(fm) }
+func (n *filterAnd) eval(fm *filterMsg) bool { return n.left.eval(fm) && n.right.eval(fm) }
+func (n *filterNot) eval(fm *filterMsg) bool { return !n.node.eval(fm) }
+
+func (n *filterCmp) eval(fm *filterMsg) bool {
+	v, ok := fm.lookup(n.path)
+	if !ok {
+		return false
+	}
+	if n.op == "" {
+		return true
+	}
+	switch lit := n.value.(type) {
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return n.op == "!="
+		}
+		return compareOp(n.op, strings.Compare(s, lit))
+	case float64:
+		f, ok := v.(float64)
+		if !ok {
+			return n.op == "!="
+		}
+		c := 0
+		if f < lit {
+			c = -1
+		} else if f > lit {
+			c = 1
+		}
+		return compareOp(n.op, c)
+	default:
+		// bool or nil, only equality makes sense.
+		switch n.op {
+		case "==":
+			return v == lit
+		case "!=":
+			return v != lit
+		}
+		return false
+	}
+}
+
+func compareOp(op string, c int) bool {
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// lookup returns the value of the field at the given path, and false if
+// it is not present.
+func (fm *filterMsg) lookup(path []string) (interface{}, bool) {
+	switch path[0] {
+	case "subject":
+		return fm.m.Subject, fm.m.Subject != ""
+	case "reply":
+		return fm.m.Reply, fm.m.Reply != ""
+	case "sequence":
+		return float64(fm.m.Sequence), true
+	case "timestamp":
+		return float64(fm.m.Timestamp), true
+	}
+	// "data"
+	if !fm.decoded {
+		fm.decoded = true
+		fm.isJSON = json.Unmarshal(fm.m.Data, &fm.data) == nil
+	}
+	if !fm.isJSON {
+		return nil, false
+	}
+	v := fm.data
+	for _, name := range path[1:] {
+		switch cur := v.(type) {
+		case map[string]interface{}:
+			fv, ok := cur[name]
+			if !ok {
+				return nil, false
+			}
+			v = fv
+		case []interface{}:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(cur) {
+				return nil, false
+			}
+			v = cur[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+const (
+	filterTokEOF = iota
+	filterTokIdent
+	filterTokString
+	filterTokNumber
+	filterTokOp
+)
+
+type filterToken struct {
+	kind int
+	text string
+}
+
+type filterParser struct {
+	input string
+	pos   int
+	tok   filterToken
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid filter %q: %s", p.input, fmt.Sprintf(format, args...))
+}
+
+// next reads the next token.
+func (p *filterParser) next() error {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+	if p.pos >= len(p.input) {
+		p.tok = filterToken{kind: filterTokEOF}
+		return nil
+	}
+	start := p.pos
+	c := p.input[p.pos]
+	switch {
+	case c == '"':
+		p.pos++
+		for p.pos < len(p.input) && p.input[p.pos] != '"' {
+			if p.input[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.input) {
+			return p.errorf("unterminated string")
+		}
+		p.pos++
+		s, err := strconv.Unquote(p.input[start:p.pos])
+		if err != nil {
+			return p.errorf("bad string %s", p.input[start:p.pos])
+		}
+		p.tok = filterToken{kind: filterTokString, text: s}
+	case c == '-' || (c >= '0' && c <= '9'):
+		p.pos++
+		for p.pos < len(p.input) && strings.IndexByte("0123456789.eE+-", p.input[p.pos]) >= 0 {
+			p.pos++
+		}
+		p.tok = filterToken{kind: filterTokNumber, text: p.input[start:p.pos]}
+	case isFilterIdentChar(c):
+		for p.pos < len(p.input) && (isFilterIdentChar(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = filterToken{kind: filterTokIdent, text: p.input[start:p.pos]}
+	default:
+		for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+			if strings.HasPrefix(p.input[p.pos:], op) {
+				p.pos += len(op)
+				p.tok = filterToken{kind: filterTokOp, text: op}
+				return nil
+			}
+		}
+		return p.errorf("unexpected character %q", c)
+	}
+	return nil
+}
+
+func isFilterIdentChar(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *filterParser) isOp(op string) bool {
+	return p.tok.kind == filterTokOp && p.tok.text == op
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	switch {
+	case p.isOp("!"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{node: node}, nil
+	case p.isOp("("):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, p.errorf("missing closing parenthesis")
+		}
+		return node, p.next()
+	case p.tok.kind == filterTokIdent:
+		return p.parseCmp()
+	case p.tok.kind == filterTokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected %q", p.tok.text)
+}
+
+func (p *filterParser) parseCmp() (filterNode, error) {
+	path := strings.Split(p.tok.text, ".")
+	switch path[0] {
+	case "subject", "reply", "sequence", "timestamp":
+		if len(path) != 1 {
+			return nil, p.errorf("field %q has no sub-field", path[0])
+		}
+	case "data":
+	default:
+		return nil, p.errorf("unknown field %q", p.tok.text)
+	}
+	for _, name := range path {
+		if name == "" {
+			return nil, p.errorf("invalid field %q", p.tok.text)
+		}
+	}
+	cmp := &filterCmp{path: path}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != filterTokOp {
+		return cmp, nil
+	}
+	switch p.tok.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		cmp.op = p.tok.text
+	default:
+		return cmp, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	switch p.tok.kind {
+	case filterTokString:
+		cmp.value = p.tok.text
+	case filterTokNumber:
+		f, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", p.tok.text)
+		}
+		cmp.value = f
+	case filterTokIdent:
+		switch p.tok.text {
+		case "true":
+			cmp.value = true
+		case "false":
+			cmp.value = false
+		case "null":
+			cmp.value = nil
+		default:
+			return nil, p.errorf("unexpected %q, expected a value", p.tok.text)
+		}
+		if cmp.op != "==" && cmp.op != "!=" {
+			return nil, p.errorf("operator %q not supported for %s", cmp.op, p.tok.text)
+		}
+	default:
+		return nil, p.errorf("missing value after %q", cmp.op)
+	}
+	return cmp, p.next()
+}
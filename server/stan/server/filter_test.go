@@ -0,0 +1,95 @@
+// Copyright 2017-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+)
+
+func TestMsgFilter(t *testing.T) {
+	m := &pb.MsgProto{
+		Sequence:  5,
+		Subject:   "foo",
+		Timestamp: 1000,
+		Data:      []byte(`{"type":"order","total":150,"vip":true,"note":null,"items":[{"sku":"a"},{"sku":"b"}]}`),
+	}
+	for _, test := range []struct {
+		expr     string
+		expected bool
+	}{
+		{"", true},
+		{`subject == "foo"`, true},
+		{`subject != "foo"`, false},
+		{"reply", false},
+		{"sequence > 4 && timestamp == 1000", true},
+		{`data.type == "order"`, true},
+		{`data.type > "apple"`, true},
+		{"data.total >= 150", true},
+		{"data.total < 100", false},
+		{`data.total == "150"`, false},
+		{`data.total != "150"`, true},
+		{"data.vip == true", true},
+		{"data.note == null", true},
+		{"data.missing", false},
+		{"data.missing != 1", false},
+		{"!data.missing", true},
+		{`data.items.1.sku == "b"`, true},
+		{"data.items.2", false},
+		{`data.type == "refund" || data.total > 100`, true},
+		{`data.type == "refund" || data.total > 100 && data.vip == false`, false},
+		{`(data.type == "refund" || data.total > 100) && !(data.vip == false)`, true},
+		{`data.total>-1.5e2`, true},
+	} {
+		f, err := newMsgFilter(test.expr)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", test.expr, err)
+		}
+		if res := f.matches(m); res != test.expected {
+			t.Fatalf("Expected %q to be %v, got %v", test.expr, test.expected, res)
+		}
+	}
+
+	// A payload that is not JSON has no data field.
+	f, _ := newMsgFilter("data || !data.foo")
+	if !f.matches(&pb.MsgProto{Data: []byte("not json")}) {
+		t.Fatal("Expected the filter to match")
+	}
+	f, _ = newMsgFilter("data")
+	if f.matches(&pb.MsgProto{Data: []byte("not json")}) {
+		t.Fatal("Expected the filter to not match")
+	}
+
+	for _, expr := range []string{
+		"data ==",
+		"data.foo = 1",
+		"data..foo",
+		"subject.foo",
+		"payload.foo",
+		`data.foo == "bar`,
+		"data.foo < true",
+		"data.foo == bar",
+		"(data.foo",
+		"data.foo)",
+		"data.foo &&",
+		"data.foo == 1 2",
+		"data.foo == 1e",
+		"data.foo # 1",
+	} {
+		if _, err := newMsgFilter(expr); err == nil {
+			t.Fatalf("Expected error for %q", expr)
+		}
+	}
+}
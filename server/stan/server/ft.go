@@ -185,9 +185,11 @@ func (s *StanServer) ftSendHBLoop(activationTime time.Time) {
 // Note that FTGroupName has to be set before server invokes this function,
 // so this parameter is not checked here.
 func (s *StanServer) ftSetup() error {
-	// Check that store type is ok. So far only support for FileStore
-	if s.opts.StoreType != stores.TypeFile && s.opts.StoreType != stores.TypeSQL {
-		return fmt.Errorf("ft: only %v or %v stores supported in FT mode", stores.TypeFile, stores.TypeSQL)
+	// Check that store type is ok.
+	switch s.opts.StoreType {
+	case stores.TypeFile, stores.TypeSQL, stores.TypeBolt:
+	default:
+		return fmt.Errorf("ft: only %v, %v or %v stores supported in FT mode", stores.TypeFile, stores.TypeSQL, stores.TypeBolt)
 	}
 	// So far, those are not exposed to users, just used in tests.
 	// Still make sure that the missed HB interval is > than the HB
@@ -79,6 +79,8 @@ func getTestFTDefaultOptions() *Options {
 	if persistentStoreType == stores.TypeFile {
 		opts.FilestoreDir = defaultDataStore
 		opts.FileStoreOpts.BufferSize = 1024
+	} else if persistentStoreType == stores.TypeBolt {
+		opts.FilestoreDir = defaultDataStore
 	} else {
 		opts.SQLStoreOpts.Driver = testSQLDriver
 		opts.SQLStoreOpts.Source = testSQLSource
@@ -346,7 +348,7 @@ func TestFTPartition(t *testing.T) {
 
 	sOpts := getTestFTDefaultOptions()
 	sOpts.NATSServerURL = natsURL
-	if persistentStoreType == stores.TypeFile {
+	if persistentStoreType == stores.TypeFile || persistentStoreType == stores.TypeBolt {
 		sOpts.FilestoreDir = ds
 	}
 	s := runServerWithOpts(t, sOpts, nil)
@@ -516,7 +518,7 @@ func TestFTPartitionReversed(t *testing.T) {
 		// Now start our streaming server, it should be a standby
 		sOpts := getTestFTDefaultOptions()
 		sOpts.NATSServerURL = natsURL
-		if persistentStoreType == stores.TypeFile {
+		if persistentStoreType == stores.TypeFile || persistentStoreType == stores.TypeBolt {
 			sOpts.FilestoreDir = ds
 		}
 		s := runServerWithOpts(t, sOpts, nil)
@@ -555,7 +557,7 @@ func TestFTPartitionReversed(t *testing.T) {
 	} else {
 		sOpts := getTestFTDefaultOptions()
 		sOpts.NATSServerURL = natsURL
-		if persistentStoreType == stores.TypeFile {
+		if persistentStoreType == stores.TypeFile || persistentStoreType == stores.TypeBolt {
 			sOpts.FilestoreDir = ds
 		}
 		s := runServerWithOpts(t, sOpts, nil)
@@ -37,6 +37,7 @@ const (
 	ClientsPath    = RootPath + "/clientsz"
 	ChannelsPath   = RootPath + "/channelsz"
 	IsFTActivePath = RootPath + "/isFTActive"
+	LagPath        = RootPath + "/lagz"
 
 	defaultMonitorListLimit = 1024
 )
@@ -75,6 +76,21 @@ type Storez struct {
 	Limits     stores.StoreLimits `json:"limits"`
 	TotalMsgs  int                `json:"total_msgs"`
 	TotalBytes uint64             `json:"total_bytes"`
+	// Only set when payload compression is enabled.
+	Compression *Compressionz `json:"compression,omitempty"`
+	// Only set when store metrics are enabled.
+	Metrics *stores.StoreMetrics `json:"metrics,omitempty"`
+	// Only set when channels have been recovered lazily.
+	Recovery *stores.RecoveryProgress `json:"recovery,omitempty"`
+}
+
+// Compressionz reports how well messages payload compress, overall and per
+// channel, for the messages stored since the server started.
+type Compressionz struct {
+	UncompressedBytes uint64                              `json:"uncompressed_bytes"`
+	CompressedBytes   uint64                              `json:"compressed_bytes"`
+	Ratio             float64                             `json:"ratio"`
+	Channels          map[string]*stores.CompressionStats `json:"channels,omitempty"`
 }
 
 // Clientsz lists the client connections
@@ -91,10 +107,13 @@ type Clientsz struct {
 
 // Clientz describes a NATS Streaming Client connection
 type Clientz struct {
-	ID            string                      `json:"id"`
-	HBInbox       string                      `json:"hb_inbox"`
-	SubsCount     int                         `json:"subs_count"`
-	Subscriptions map[string][]*Subscriptionz `json:"subscriptions,omitempty"`
+	ID              string                      `json:"id"`
+	HBInbox         string                      `json:"hb_inbox"`
+	SubsCount       int                         `json:"subs_count"`
+	MaxPubMsgsRate  int                         `json:"max_pub_msgs_rate,omitempty"`
+	MaxPubBytesRate int64                       `json:"max_pub_bytes_rate,omitempty"`
+	PubRejected     uint64                      `json:"pub_rejected,omitempty"`
+	Subscriptions   map[string][]*Subscriptionz `json:"subscriptions,omitempty"`
 }
 
 // Channelsz lists the name of all NATS Streaming Channelsz
@@ -135,8 +154,59 @@ type Subscriptionz struct {
 	LastSent     uint64 `json:"last_sent"`
 	PendingCount int    `json:"pending_count"`
 	IsStalled    bool   `json:"is_stalled"`
+	IsPaused     bool   `json:"is_paused,omitempty"`
+	// Number of messages in the channel not yet sent to this subscription
+	// (or to its queue group).
+	SeqLag uint64 `json:"seq_lag,omitempty"`
+	// Estimated size of those messages, based on the channel's average
+	// message size.
+	ByteLag uint64 `json:"byte_lag,omitempty"`
+	// Age of the oldest message sent but not yet acknowledged.
+	OldestPendingAge string `json:"oldest_pending_age,omitempty"`
+}
+
+// Lagz lists subscriptions and queue groups along with their lag
+type Lagz struct {
+	ClusterID     string              `json:"cluster_id"`
+	ServerID      string              `json:"server_id"`
+	Now           time.Time           `json:"now"`
+	Sort          string              `json:"sort"`
+	Offset        int                 `json:"offset"`
+	Limit         int                 `json:"limit"`
+	Count         int                 `json:"count"`
+	Total         int                 `json:"total"`
+	Subscriptions []*SubscriptionLagz `json:"subscriptions,omitempty"`
+}
+
+// SubscriptionLagz describes the lag of a subscription or of a queue group
+type SubscriptionLagz struct {
+	Channel          string `json:"channel"`
+	ClientID         string `json:"client_id,omitempty"`
+	Inbox            string `json:"inbox,omitempty"`
+	DurableName      string `json:"durable_name,omitempty"`
+	QueueName        string `json:"queue_name,omitempty"`
+	QueueMembers     int    `json:"queue_members,omitempty"`
+	IsOffline        bool   `json:"is_offline"`
+	IsPaused         bool   `json:"is_paused,omitempty"`
+	LastSeq          uint64 `json:"last_seq"`
+	LastSent         uint64 `json:"last_sent"`
+	PendingCount     int    `json:"pending_count"`
+	SeqLag           uint64 `json:"seq_lag"`
+	ByteLag          uint64 `json:"byte_lag"`
+	OldestPendingAge string `json:"oldest_pending_age,omitempty"`
+
+	oldestSeq     uint64
+	oldestPending time.Duration
 }
 
+// Sort options for the lagz endpoint
+const (
+	lagzSortBySeqLag     = "seq_lag"
+	lagzSortByByteLag    = "byte_lag"
+	lagzSortByPending    = "pending_count"
+	lagzSortByPendingAge = "pending_age"
+)
+
 func (s *StanServer) startMonitoring(nOpts *natsd.Options) error {
 	var hh http.Handler
 	// If we are connecting to remote NATS Server, we start our own
@@ -165,6 +235,16 @@ func (s *StanServer) startMonitoring(nOpts *natsd.Options) error {
 	mux.HandleFunc(ClientsPath, s.HandleClientsz)
 	mux.HandleFunc(ChannelsPath, s.HandleChannelsz)
 	mux.HandleFunc(IsFTActivePath, s.HandleIsFTActivez)
+	mux.HandleFunc(LagPath, s.HandleLagz)
+	// The administrative endpoints are not authenticated, so they are
+	// registered only if explicitly enabled.
+	if s.opts.MonitorAdmin {
+		mux.HandleFunc(PausePath, s.HandlePausez)
+		mux.HandleFunc(ResumePath, s.HandleResumez)
+		mux.HandleFunc(BackupPath, s.HandleBackupz)
+		mux.HandleFunc(ExportPath, s.HandleExportz)
+		mux.HandleFunc(ImportPath, s.HandleImportz)
+	}
 
 	return nil
 }
@@ -185,10 +265,11 @@ func (s *StanServer) HandleRootz(w http.ResponseWriter, r *http.Request) {
 	<a href=.%s>store</a><br/>
 	<a href=.%s>clients</a><br/>
 	<a href=.%s>channels</a><br/>
+	<a href=.%s>lag</a><br/>
     <br/>
     <a href=https://docs.nats.io/legacy/stan/intro/monitoring/>help</a>
   </body>
-</html>`, ServerPath, StorePath, ClientsPath, ChannelsPath)
+</html>`, ServerPath, StorePath, ClientsPath, ChannelsPath, LagPath)
 }
 
 func (s *StanServer) HandleServerz(w http.ResponseWriter, r *http.Request) {
@@ -304,10 +385,41 @@ func (s *StanServer) HandleStorez(w http.ResponseWriter, r *http.Request) {
 		TotalMsgs:  count,
 		TotalBytes: bytes,
 	}
+	cs, compressed := s.store.(*stores.CompressedStore)
+	compressed = compressed && cs.Enabled()
+	metrics := s.storeMetrics
+	lazyFS := s.lazyFileStore
 	s.mu.RUnlock()
+	if compressed {
+		storez.Compression = s.getCompressionz()
+	}
+	if metrics != nil {
+		storez.Metrics = metrics.Stats()
+	}
+	if lazyFS != nil {
+		storez.Recovery = lazyFS.RecoveryProgress()
+	}
 	s.sendResponse(w, r, storez)
 }
 
+func (s *StanServer) getCompressionz() *Compressionz {
+	cz := &Compressionz{Channels: make(map[string]*stores.CompressionStats)}
+	for name, c := range s.channels.getAll() {
+		cms, ok := c.store.Msgs.(*stores.CompressedMsgStore)
+		if !ok {
+			continue
+		}
+		stats := cms.Stats()
+		cz.Channels[name] = stats
+		cz.UncompressedBytes += stats.UncompressedBytes
+		cz.CompressedBytes += stats.CompressedBytes
+	}
+	if cz.CompressedBytes > 0 {
+		cz.Ratio = float64(cz.UncompressedBytes) / float64(cz.CompressedBytes)
+	}
+	return cz
+}
+
 type byClientID []*Clientz
 
 func (c byClientID) Len() int           { return len(c) }
@@ -348,10 +460,13 @@ func (s *StanServer) HandleClientsz(w http.ResponseWriter, r *http.Request) {
 				client.RLock()
 				c.HBInbox = client.info.HbInbox
 				c.SubsCount = len(client.subs)
+				setClientzPubRate(c, client)
+				var ages pendingAges
 				if subsOption == 1 {
-					c.Subscriptions = getMonitorClientSubs(client)
+					c.Subscriptions = getMonitorClientSubs(s, client, &ages)
 				}
 				client.RUnlock()
+				ages.resolve()
 				carrSize++
 			}
 		}
@@ -378,27 +493,58 @@ func getMonitorClient(s *StanServer, clientID string, subsOption int) *Clientz {
 		return nil
 	}
 	cli.RLock()
-	defer cli.RUnlock()
 	cz := &Clientz{
 		HBInbox:   cli.info.HbInbox,
 		ID:        cli.info.ID,
 		SubsCount: len(cli.subs),
 	}
+	setClientzPubRate(cz, cli)
+	var ages pendingAges
 	if subsOption == 1 {
-		cz.Subscriptions = getMonitorClientSubs(cli)
+		cz.Subscriptions = getMonitorClientSubs(s, cli, &ages)
 	}
+	cli.RUnlock()
+	ages.resolve()
 	return cz
 }
 
-func getMonitorClientSubs(client *client) map[string][]*Subscriptionz {
+func setClientzPubRate(cz *Clientz, client *client) {
+	if rl := client.pubRate; rl != nil {
+		cz.MaxPubMsgsRate = rl.limits.MaxPubMsgsRate
+		cz.MaxPubBytesRate = rl.limits.MaxPubBytesRate
+		cz.PubRejected = rl.numRejected()
+	}
+}
+
+// getMonitorClientSubs returns the subscriptions of `client`, whose lock is
+// held on entry. The ages of their oldest pending messages are added to
+// `ages`, to be resolved once the lock is released.
+func getMonitorClientSubs(s *StanServer, client *client, ages *pendingAges) map[string][]*Subscriptionz {
 	subs := client.subs
-	var subsz map[string][]*Subscriptionz
+	var (
+		subsz    map[string][]*Subscriptionz
+		lagInfos map[string]*channelLagInfo
+	)
 	for _, sub := range subs {
 		if subsz == nil {
 			subsz = make(map[string][]*Subscriptionz)
+			lagInfos = make(map[string]*channelLagInfo)
+		}
+		li, ok := lagInfos[sub.subject]
+		if !ok {
+			li = s.getChannelLagInfo(s.channels.get(sub.subject))
+			lagInfos[sub.subject] = li
+		}
+		var subz *Subscriptionz
+		if qs := sub.qstate; qs != nil {
+			qs.RLock()
+			subz = createSubscriptionz(sub, li, qs, ages)
+			qs.RUnlock()
+		} else {
+			subz = createSubscriptionz(sub, li, nil, ages)
 		}
 		array := subsz[sub.subject]
-		newArray := append(array, createSubscriptionz(sub))
+		newArray := append(array, subz)
 		if &newArray != &array {
 			subsz[sub.subject] = newArray
 		}
@@ -406,31 +552,35 @@ func getMonitorClientSubs(client *client) map[string][]*Subscriptionz {
 	return subsz
 }
 
-func getMonitorChannelSubs(ss *subStore) []*Subscriptionz {
+func getMonitorChannelSubs(s *StanServer, c *channel) []*Subscriptionz {
+	li := s.getChannelLagInfo(c)
+	ss := c.ss
+	var ages pendingAges
 	ss.RLock()
-	defer ss.RUnlock()
 	subsz := make([]*Subscriptionz, 0)
 	for _, sub := range ss.psubs {
-		subsz = append(subsz, createSubscriptionz(sub))
+		subsz = append(subsz, createSubscriptionz(sub, li, nil, &ages))
 	}
 	// Get only offline durables (the online also appear in ss.psubs)
 	for _, sub := range ss.durables {
 		if sub.ClientID == "" {
-			subsz = append(subsz, createSubscriptionz(sub))
+			subsz = append(subsz, createSubscriptionz(sub, li, nil, &ages))
 		}
 	}
 	for _, qsub := range ss.qsubs {
 		qsub.RLock()
 		for _, sub := range qsub.subs {
-			subsz = append(subsz, createSubscriptionz(sub))
+			subsz = append(subsz, createSubscriptionz(sub, li, qsub, &ages))
 		}
 		// If this is a durable queue subscription and all members
 		// are offline, qsub.shadow will be not nil. Report this one.
 		if qsub.shadow != nil {
-			subsz = append(subsz, createSubscriptionz(qsub.shadow))
+			subsz = append(subsz, createSubscriptionz(qsub.shadow, li, qsub, &ages))
 		}
 		qsub.RUnlock()
 	}
+	ss.RUnlock()
+	ages.resolve()
 	return subsz
 }
 
@@ -457,7 +607,13 @@ func getMonitorChannelSubsCount(ss *subStore) int {
 	return count
 }
 
-func createSubscriptionz(sub *subState) *Subscriptionz {
+// createSubscriptionz returns the monitoring representation of `sub`.
+// If `li` is not nil, the lag fields are set, and the age of the oldest
+// pending message is added to `ages` since looking it up in the store is
+// done without holding the subscriptions locks. For a queue member, `qs`
+// is the queue group (with its read lock held) from which the lag and
+// paused state are reported.
+func createSubscriptionz(sub *subState, li *channelLagInfo, qs *queueState, ages *pendingAges) *Subscriptionz {
 	sub.RLock()
 	subz := &Subscriptionz{
 		ClientID:     sub.ClientID,
@@ -477,10 +633,287 @@ func createSubscriptionz(sub *subState) *Subscriptionz {
 	if sub.ClientID == "" {
 		subz.ClientID = sub.savedClientID
 	}
+	lastSent := sub.LastSent
+	if qs != nil {
+		if qs.lastSent > lastSent {
+			lastSent = qs.lastSent
+		}
+		subz.IsPaused = qs.paused
+	} else {
+		subz.IsPaused = sub.paused
+	}
+	oldestSeq := sub.getOldestPendingSeq()
 	sub.RUnlock()
+	if li != nil {
+		subz.SeqLag = li.seqLag(lastSent)
+		subz.ByteLag = li.byteLag(subz.SeqLag)
+		if oldestSeq > 0 {
+			*ages = append(*ages, pendingAge{subz: subz, li: li, seq: oldestSeq})
+		}
+	}
 	return subz
 }
 
+// pendingAge is a subscription whose oldest pending message age is to be
+// looked up in the store.
+type pendingAge struct {
+	subz *Subscriptionz
+	li   *channelLagInfo
+	seq  uint64
+}
+
+// pendingAges collects the lookups of oldest pending message ages made
+// while holding locks, so that they can be done once released.
+type pendingAges []pendingAge
+
+// Sets the oldest pending age of the collected subscriptions.
+func (pa pendingAges) resolve() {
+	for _, p := range pa {
+		if age := p.li.msgAge(p.seq); age > 0 {
+			p.subz.OldestPendingAge = age.String()
+		}
+	}
+}
+
+// channelLagInfo holds the state of a channel used to compute
+// the lag of its subscriptions.
+type channelLagInfo struct {
+	c        *channel
+	now      time.Time
+	firstSeq uint64
+	lastSeq  uint64
+	msgs     int
+	bytes    uint64
+	// Ages of the messages already looked up, since subscriptions of
+	// the same channel often have the same oldest pending message.
+	ages map[uint64]time.Duration
+}
+
+// Returns the lag info for this channel, or nil if `c` is nil or
+// the channel state cannot be retrieved.
+func (s *StanServer) getChannelLagInfo(c *channel) *channelLagInfo {
+	if c == nil {
+		return nil
+	}
+	msgs, bytes, err := c.store.Msgs.State()
+	if err != nil {
+		return nil
+	}
+	first, last, err := s.getChannelFirstAndlLastSeq(c)
+	if err != nil {
+		return nil
+	}
+	return &channelLagInfo{c: c, now: time.Now(), firstSeq: first, lastSeq: last, msgs: msgs, bytes: bytes}
+}
+
+// Returns the number of messages available in the channel after `lastSent`.
+func (li *channelLagInfo) seqLag(lastSent uint64) uint64 {
+	if li.lastSeq <= lastSent {
+		return 0
+	}
+	// Messages before the first available one have been removed.
+	if li.firstSeq > 0 && lastSent < li.firstSeq-1 {
+		lastSent = li.firstSeq - 1
+	}
+	return li.lastSeq - lastSent
+}
+
+// Returns the estimated size of `seqLag` messages.
+func (li *channelLagInfo) byteLag(seqLag uint64) uint64 {
+	if li.msgs == 0 || seqLag == 0 {
+		return 0
+	}
+	return seqLag * (li.bytes / uint64(li.msgs))
+}
+
+// Returns the age of the message with sequence `seq`, or 0 if
+// `seq` is 0 or the message is no longer in the channel. The store
+// is looked up only once per sequence for a given lag info.
+func (li *channelLagInfo) msgAge(seq uint64) time.Duration {
+	if seq == 0 {
+		return 0
+	}
+	if age, ok := li.ages[seq]; ok {
+		return age
+	}
+	var age time.Duration
+	if m, err := li.c.store.Msgs.Lookup(seq); err == nil && m != nil {
+		age = li.now.Sub(time.Unix(0, m.Timestamp))
+	}
+	if li.ages == nil {
+		li.ages = make(map[uint64]time.Duration)
+	}
+	li.ages[seq] = age
+	return age
+}
+
+// HandleLagz returns the lag of the subscriptions and queue groups, sorted
+// in decreasing order of the metric selected with the `sort` parameter
+// (seq_lag, byte_lag, pending_count or pending_age). The `channel`
+// parameter restricts the result to a single channel.
+func (s *StanServer) HandleLagz(w http.ResponseWriter, r *http.Request) {
+	sortBy := r.URL.Query().Get("sort")
+	switch sortBy {
+	case "":
+		sortBy = lagzSortBySeqLag
+	case lagzSortBySeqLag, lagzSortByByteLag, lagzSortByPending, lagzSortByPendingAge:
+	default:
+		http.Error(w, fmt.Sprintf("Invalid sort option %q", sortBy), http.StatusBadRequest)
+		return
+	}
+	var channels map[string]*channel
+	if channelName := r.URL.Query().Get("channel"); channelName != "" {
+		c := s.channels.get(channelName)
+		if c == nil {
+			http.Error(w, fmt.Sprintf("Channel %s not found", channelName), http.StatusNotFound)
+			return
+		}
+		channels = map[string]*channel{channelName: c}
+	} else {
+		channels = s.channels.getAll()
+	}
+	offset, limit := getOffsetAndLimit(r)
+	larr := make([]*SubscriptionLagz, 0)
+	for _, c := range channels {
+		larr = append(larr, s.getChannelLagz(c)...)
+	}
+	sort.Sort(&lagzSorter{arr: larr, by: sortBy})
+	total := len(larr)
+	minoff, maxoff := getMinMaxOffset(offset, limit, total)
+	larr = larr[minoff:maxoff]
+	s.mu.RLock()
+	lagz := &Lagz{
+		ClusterID:     s.info.ClusterID,
+		ServerID:      s.serverID,
+		Now:           time.Now(),
+		Sort:          sortBy,
+		Offset:        offset,
+		Limit:         limit,
+		Count:         len(larr),
+		Total:         total,
+		Subscriptions: larr,
+	}
+	s.mu.RUnlock()
+	s.sendResponse(w, r, lagz)
+}
+
+// Returns the lag of each subscription of this channel. Queue
+// members are aggregated in a single entry per queue group.
+func (s *StanServer) getChannelLagz(c *channel) []*SubscriptionLagz {
+	li := s.getChannelLagInfo(c)
+	if li == nil {
+		return nil
+	}
+	ss := c.ss
+	ss.RLock()
+	larr := make([]*SubscriptionLagz, 0, len(ss.psubs))
+	addSub := func(sub *subState) {
+		sub.RLock()
+		lz := &SubscriptionLagz{
+			Channel:      c.name,
+			ClientID:     sub.ClientID,
+			Inbox:        sub.Inbox,
+			DurableName:  sub.DurableName,
+			IsOffline:    sub.ClientID == "",
+			IsPaused:     sub.paused,
+			LastSent:     sub.LastSent,
+			PendingCount: len(sub.acksPending),
+		}
+		if sub.ClientID == "" {
+			lz.ClientID = sub.savedClientID
+		}
+		lz.oldestSeq = sub.getOldestPendingSeq()
+		sub.RUnlock()
+		larr = append(larr, lz)
+	}
+	for _, sub := range ss.psubs {
+		addSub(sub)
+	}
+	// Get only offline durables (the online also appear in ss.psubs)
+	for _, sub := range ss.durables {
+		if sub.ClientID == "" {
+			addSub(sub)
+		}
+	}
+	for qname, qs := range ss.qsubs {
+		qs.RLock()
+		lz := &SubscriptionLagz{
+			Channel:      c.name,
+			QueueName:    qname,
+			QueueMembers: len(qs.subs),
+			IsOffline:    len(qs.subs) == 0,
+			IsPaused:     qs.paused,
+			LastSent:     qs.lastSent,
+		}
+		members := qs.subs
+		if qs.shadow != nil {
+			members = []*subState{qs.shadow}
+		}
+		// The oldest pending message of the group is the one with the
+		// lowest sequence, so the store is looked up once per group.
+		for _, sub := range members {
+			sub.RLock()
+			lz.PendingCount += len(sub.acksPending)
+			if seq := sub.getOldestPendingSeq(); seq > 0 && (lz.oldestSeq == 0 || seq < lz.oldestSeq) {
+				lz.oldestSeq = seq
+			}
+			sub.RUnlock()
+		}
+		qs.RUnlock()
+		larr = append(larr, lz)
+	}
+	ss.RUnlock()
+	// Look up the oldest pending messages without holding the locks, since
+	// this may read from disk or the database.
+	for _, lz := range larr {
+		lz.oldestPending = li.msgAge(lz.oldestSeq)
+		lz.LastSeq = li.lastSeq
+		lz.SeqLag = li.seqLag(lz.LastSent)
+		lz.ByteLag = li.byteLag(lz.SeqLag)
+		if lz.oldestPending > 0 {
+			lz.OldestPendingAge = lz.oldestPending.String()
+		}
+	}
+	return larr
+}
+
+// Sorts lag entries in decreasing order of the selected metric, and
+// then by channel, queue name and client ID for a stable output.
+type lagzSorter struct {
+	arr []*SubscriptionLagz
+	by  string
+}
+
+func (ls *lagzSorter) Len() int      { return len(ls.arr) }
+func (ls *lagzSorter) Swap(i, j int) { ls.arr[i], ls.arr[j] = ls.arr[j], ls.arr[i] }
+func (ls *lagzSorter) Less(i, j int) bool {
+	a, b := ls.arr[i], ls.arr[j]
+	var va, vb int64
+	switch ls.by {
+	case lagzSortByByteLag:
+		va, vb = int64(a.ByteLag), int64(b.ByteLag)
+	case lagzSortByPending:
+		va, vb = int64(a.PendingCount), int64(b.PendingCount)
+	case lagzSortByPendingAge:
+		va, vb = int64(a.oldestPending), int64(b.oldestPending)
+	default:
+		va, vb = int64(a.SeqLag), int64(b.SeqLag)
+	}
+	if va != vb {
+		return va > vb
+	}
+	if a.Channel != b.Channel {
+		return a.Channel < b.Channel
+	}
+	if a.QueueName != b.QueueName {
+		return a.QueueName < b.QueueName
+	}
+	if a.ClientID != b.ClientID {
+		return a.ClientID < b.ClientID
+	}
+	return a.Inbox < b.Inbox
+}
+
 // When we support only Go 1.8+, replace sort with sort.Slice
 type byName []string
 
@@ -574,7 +1007,7 @@ func (s *StanServer) updateChannelz(cz *Channelz, c *channel, subsOption int) er
 	cz.LastSeq = lseq
 	var subsCount int
 	if subsOption == 1 {
-		cz.Subscriptions = getMonitorChannelSubs(c.ss)
+		cz.Subscriptions = getMonitorChannelSubs(s, c)
 		subsCount = len(cz.Subscriptions)
 	} else {
 		subsCount = getMonitorChannelSubsCount(c.ss)
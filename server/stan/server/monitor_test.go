@@ -14,6 +14,7 @@
 package server
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -602,6 +603,178 @@ func TestMonitorStorez(t *testing.T) {
 	testStore(s, persistentStoreType)
 }
 
+func TestMonitorStorezCompression(t *testing.T) {
+	resetPreviousHTTPConnections()
+	opts := GetDefaultOptions()
+	opts.Compression = stores.CompressionDeflate
+	opts.ChannelCompression = map[string]string{"bar": stores.CompressionNone}
+	s := runMonitorServer(t, opts)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	msg := []byte(strings.Repeat("compressible ", 100))
+	for _, channel := range []string{"foo", "bar"} {
+		if err := sc.Publish(channel, msg); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+
+	resp, body := getBody(t, StorePath, expectedJSON)
+	defer resp.Body.Close()
+	sz := Storez{}
+	if err := json.Unmarshal(body, &sz); err != nil {
+		t.Fatalf("Got an error unmarshalling the body: %v", err)
+	}
+	cz := sz.Compression
+	if cz == nil {
+		t.Fatal("Expected compression stats")
+	}
+	if cz.UncompressedBytes != uint64(2*len(msg)) || cz.Ratio <= 1 {
+		t.Fatalf("Unexpected compression stats: %+v", cz)
+	}
+	if stats := cz.Channels["foo"]; stats == nil || stats.Codec != stores.CompressionDeflate || stats.Ratio <= 1 {
+		t.Fatalf("Unexpected stats for foo: %+v", stats)
+	}
+	if stats := cz.Channels["bar"]; stats == nil || stats.Codec != stores.CompressionNone || stats.Ratio != 1 {
+		t.Fatalf("Unexpected stats for bar: %+v", stats)
+	}
+	// Messages must be delivered decompressed.
+	ch := make(chan []byte, 1)
+	if _, err := sc.Subscribe("foo", func(m *stan.Msg) {
+		ch <- m.Data
+	}, stan.DeliverAllAvailable()); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	select {
+	case data := <-ch:
+		if !bytes.Equal(data, msg) {
+			t.Fatalf("Unexpected payload: %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not get message")
+	}
+}
+
+func TestMonitorStorezMetrics(t *testing.T) {
+	resetPreviousHTTPConnections()
+	opts := GetDefaultOptions()
+	opts.StoreMetrics = true
+	opts.Compression = stores.CompressionDeflate
+	stored := int32(0)
+	opts.StoreOpHook = func(channel, op string, d time.Duration, err error) {
+		if channel == "foo" && op == "Store" && err == nil {
+			atomic.AddInt32(&stored, 1)
+		}
+	}
+	s := runMonitorServer(t, opts)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := sc.Publish("foo", []byte("hello")); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&stored); n != 2 {
+		t.Fatalf("Expected hook to be invoked for 2 stored messages, got %v", n)
+	}
+
+	resp, body := getBody(t, StorePath, expectedJSON)
+	defer resp.Body.Close()
+	sz := Storez{}
+	if err := json.Unmarshal(body, &sz); err != nil {
+		t.Fatalf("Got an error unmarshalling the body: %v", err)
+	}
+	if sz.Metrics == nil {
+		t.Fatal("Expected store metrics")
+	}
+	if st := sz.Metrics.Store["CreateChannel"]; st == nil || st.Count != 1 {
+		t.Fatalf("Unexpected CreateChannel stats: %+v", st)
+	}
+	if st := sz.Metrics.Channels["foo"]["Store"]; st == nil || st.Count != 2 || st.Errors != 0 || len(st.Buckets) == 0 {
+		t.Fatalf("Unexpected Store stats: %+v", st)
+	}
+	// The metrics store should not prevent reporting compression stats.
+	if sz.Compression == nil || sz.Compression.Channels["foo"] == nil {
+		t.Fatalf("Expected compression stats, got %+v", sz.Compression)
+	}
+}
+
+func TestMonitorStorezRecovery(t *testing.T) {
+	resetPreviousHTTPConnections()
+	cleanupDatastore(t)
+	defer cleanupDatastore(t)
+
+	opts := GetDefaultOptions()
+	opts.StoreType = stores.TypeFile
+	opts.FilestoreDir = defaultDataStore
+	opts.FileStoreOpts.LazyRecovery = true
+	s := runMonitorServer(t, opts)
+	defer s.Shutdown()
+
+	getStorez := func() *Storez {
+		t.Helper()
+		resp, body := getBody(t, StorePath, expectedJSON)
+		defer resp.Body.Close()
+		sz := &Storez{}
+		if err := json.Unmarshal(body, sz); err != nil {
+			t.Fatalf("Got an error unmarshalling the body: %v", err)
+		}
+		return sz
+	}
+
+	sc := NewDefaultConnection(t)
+	for _, channel := range []string{"foo", "bar"} {
+		for i := 0; i < 10; i++ {
+			if err := sc.Publish(channel, []byte("hello")); err != nil {
+				t.Fatalf("Unexpected error on publish: %v", err)
+			}
+		}
+	}
+	sc.Close()
+	// Nothing was recovered lazily on first start.
+	sz := getStorez()
+	if sz.Recovery != nil {
+		t.Fatalf("Unexpected recovery progress: %+v", sz.Recovery)
+	}
+	totalMsgs, totalBytes := sz.TotalMsgs, sz.TotalBytes
+	s.Shutdown()
+
+	resetPreviousHTTPConnections()
+	s = runMonitorServer(t, opts)
+	defer s.Shutdown()
+
+	waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		sz = getStorez()
+		if rp := sz.Recovery; rp == nil || rp.Channels != 2 || rp.Recovered != 2 || rp.Failed != 0 {
+			return fmt.Errorf("unexpected recovery progress: %+v", rp)
+		}
+		return nil
+	})
+	if sz.TotalMsgs != totalMsgs || sz.TotalBytes != totalBytes {
+		t.Fatalf("Expected %v msgs and %v bytes, got %v and %v", totalMsgs, totalBytes, sz.TotalMsgs, sz.TotalBytes)
+	}
+
+	sc = NewDefaultConnection(t)
+	defer sc.Close()
+	ch := make(chan bool, 1)
+	count := 0
+	if _, err := sc.Subscribe("foo", func(_ *stan.Msg) {
+		if count++; count == 10 {
+			ch <- true
+		}
+	}, stan.DeliverAllAvailable()); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := Wait(ch); err != nil {
+		t.Fatal("Did not get all messages")
+	}
+}
+
 func TestMonitorClientsz(t *testing.T) {
 	resetPreviousHTTPConnections()
 	s := runMonitorServer(t, GetDefaultOptions())
@@ -688,6 +861,31 @@ func TestMonitorClientsz(t *testing.T) {
 	}
 }
 
+func TestMonitorClientzPubRate(t *testing.T) {
+	resetPreviousHTTPConnections()
+	opts := GetDefaultOptions()
+	opts.AddPerClient("me*", &stores.PubRateLimits{MaxPubMsgsRate: 1, MaxPubBytesRate: 1024})
+	s := runMonitorServer(t, opts)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+	sc.Publish("foo", []byte("hello"))
+	if err := sc.Publish("foo", []byte("hello")); err == nil {
+		t.Fatal("Expected publish to be rejected")
+	}
+
+	resp, body := getBody(t, ClientsPath+"?client="+clientName, expectedJSON)
+	defer resp.Body.Close()
+	cz := Clientz{}
+	if err := json.Unmarshal(body, &cz); err != nil {
+		t.Fatalf("Got an error unmarshalling the body: %v", err)
+	}
+	if cz.MaxPubMsgsRate != 1 || cz.MaxPubBytesRate != 1024 || cz.PubRejected != 1 {
+		t.Fatalf("Unexpected publish rate info: %+v", cz)
+	}
+}
+
 func getCliSubs(subs []*subState) map[string][]*Subscriptionz {
 	if len(subs) == 0 {
 		return nil
@@ -1136,6 +1334,207 @@ func TestMonitorChannelz(t *testing.T) {
 	}
 }
 
+func TestMonitorLagz(t *testing.T) {
+	resetPreviousHTTPConnections()
+	s := runMonitorServer(t, GetDefaultOptions())
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := sc.Publish("foo", []byte("hello")); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	// Subscriptions do not ack, so they stop at MaxInflight.
+	noAck := func(_ *stan.Msg) {}
+	if _, err := sc.Subscribe("foo", noAck, stan.DeliverAllAvailable(),
+		stan.SetManualAckMode(), stan.MaxInflight(2)); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if _, err := sc.Subscribe("foo", noAck); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if _, err := sc.QueueSubscribe("foo", "group", noAck, stan.DeliverAllAvailable(),
+		stan.SetManualAckMode(), stan.MaxInflight(5)); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	waitForNumSubs(t, s, clientName, 3)
+	c := s.channels.get("foo")
+	waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if n := getMonitorChannelSubsCount(c.ss); n != 3 {
+			return fmt.Errorf("expected 3 subs, got %v", n)
+		}
+		pending := 0
+		for _, sub := range s.clients.getSubs(clientName) {
+			sub.RLock()
+			pending += len(sub.acksPending)
+			sub.RUnlock()
+		}
+		if pending != 7 {
+			return fmt.Errorf("expected 7 pending messages, got %v", pending)
+		}
+		return nil
+	})
+	_, bytes := msgStoreState(t, c.store.Msgs)
+	msgSize := bytes / 10
+
+	getLagz := func(path string) *Lagz {
+		resp, body := getBody(t, LagPath+path, expectedJSON)
+		defer resp.Body.Close()
+		lz := &Lagz{}
+		if err := json.Unmarshal(body, lz); err != nil {
+			t.Fatalf("Got an error unmarshalling the body: %v", err)
+		}
+		return lz
+	}
+	lz := getLagz("")
+	if lz.Sort != "seq_lag" || lz.Total != 3 || lz.Count != 3 {
+		t.Fatalf("Unexpected lagz: %+v", lz)
+	}
+	expectedLags := []uint64{8, 5, 0}
+	for i, sl := range lz.Subscriptions {
+		if sl.Channel != "foo" || sl.LastSeq != 10 {
+			t.Fatalf("Unexpected entry: %+v", sl)
+		}
+		if sl.SeqLag != expectedLags[i] || sl.ByteLag != expectedLags[i]*msgSize {
+			t.Fatalf("Entry %v: expected lag %v, got %+v", i, expectedLags[i], sl)
+		}
+		if sl.SeqLag > 0 && sl.OldestPendingAge == "" {
+			t.Fatalf("Entry %v: expected oldest pending age to be set", i)
+		}
+	}
+	if q := lz.Subscriptions[1]; q.QueueName != "group" || q.QueueMembers != 1 || q.PendingCount != 5 {
+		t.Fatalf("Unexpected queue group entry: %+v", q)
+	}
+
+	lz = getLagz("?sort=pending_count&limit=1")
+	if lz.Count != 1 || lz.Total != 3 || lz.Subscriptions[0].QueueName != "group" {
+		t.Fatalf("Unexpected lagz: %+v", lz)
+	}
+	lz = getLagz("?channel=foo&sort=pending_age&offset=2")
+	if lz.Count != 1 || lz.Subscriptions[0].SeqLag != 0 {
+		t.Fatalf("Unexpected lagz: %+v", lz)
+	}
+
+	// Lag should also be reported with the subscriptions
+	resp, body := getBody(t, ChannelsPath+"?channel=foo&subs=1", expectedJSON)
+	defer resp.Body.Close()
+	cz := Channelz{}
+	if err := json.Unmarshal(body, &cz); err != nil {
+		t.Fatalf("Got an error unmarshalling the body: %v", err)
+	}
+	lags := map[uint64]bool{}
+	for _, subz := range cz.Subscriptions {
+		lags[subz.SeqLag] = true
+	}
+	if !lags[8] || !lags[5] || !lags[0] {
+		t.Fatalf("Unexpected subscriptions lag: %v", lags)
+	}
+
+	monitorExpectStatus(t, LagPath+"?sort=foo", http.StatusBadRequest)
+	monitorExpectStatus(t, LagPath+"?channel=bar", http.StatusNotFound)
+}
+
+func TestMonitorPauseResumeDisabled(t *testing.T) {
+	resetPreviousHTTPConnections()
+	s := runMonitorServer(t, GetDefaultOptions())
+	defer s.Shutdown()
+
+	for _, path := range []string{PausePath, ResumePath} {
+		url := fmt.Sprintf("http://%s:%d%s?channel=foo&queue=group", monitorHost, monitorPort, path)
+		resp, err := http.Post(url, "", nil)
+		if err != nil {
+			t.Fatalf("Expected no error: Got %v\n", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected a %d response for %s, got %d", http.StatusNotFound, path, resp.StatusCode)
+		}
+	}
+}
+
+func TestMonitorPauseResume(t *testing.T) {
+	resetPreviousHTTPConnections()
+	opts := GetDefaultOptions()
+	opts.MonitorAdmin = true
+	s := runMonitorServer(t, opts)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	if _, err := sc.Subscribe("foo", func(_ *stan.Msg) {}, stan.DurableName("dur")); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if _, err := sc.QueueSubscribe("foo", "group", func(_ *stan.Msg) {}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	waitForNumSubs(t, s, clientName, 2)
+
+	post := func(endpoint string, expectedStatus int) *SubscriptionPausez {
+		url := fmt.Sprintf("http://%s:%d%s", monitorHost, monitorPort, endpoint)
+		resp, err := http.Post(url, "", nil)
+		if err != nil {
+			stackFatalf(t, "Expected no error: Got %v\n", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != expectedStatus {
+			stackFatalf(t, "Expected a %d response, got %d\n", expectedStatus, resp.StatusCode)
+		}
+		if expectedStatus != http.StatusOK {
+			return nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			stackFatalf(t, "Got an error reading the body: %v\n", err)
+		}
+		pz := &SubscriptionPausez{}
+		if err := json.Unmarshal(body, pz); err != nil {
+			stackFatalf(t, "Got an error unmarshalling the body: %v", err)
+		}
+		return pz
+	}
+	isPaused := func() map[string]bool {
+		resp, body := getBody(t, ChannelsPath+"?channel=foo&subs=1", expectedJSON)
+		defer resp.Body.Close()
+		cz := Channelz{}
+		if err := json.Unmarshal(body, &cz); err != nil {
+			t.Fatalf("Got an error unmarshalling the body: %v", err)
+		}
+		res := map[string]bool{}
+		for _, subz := range cz.Subscriptions {
+			res[subz.DurableName+subz.QueueName] = subz.IsPaused
+		}
+		return res
+	}
+
+	// Only POST is accepted
+	monitorExpectStatus(t, PausePath+"?channel=foo&queue=group", http.StatusMethodNotAllowed)
+	post(PausePath+"?queue=group", http.StatusBadRequest)
+	post(PausePath+"?channel=foo&client="+clientName, http.StatusBadRequest)
+	post(PausePath+"?channel=foo&queue=group&durable=dur", http.StatusBadRequest)
+	post(PausePath+"?channel=bar&queue=group", http.StatusNotFound)
+	post(PausePath+"?channel=foo&queue=unknown", http.StatusNotFound)
+
+	pz := post(PausePath+"?channel=foo&client="+clientName+"&durable=dur", http.StatusOK)
+	if !pz.IsPaused || pz.Channel != "foo" || pz.DurableName != "dur" {
+		t.Fatalf("Unexpected response: %+v", pz)
+	}
+	post(PausePath+"?channel=foo&queue=group", http.StatusOK)
+	if p := isPaused(); !p["dur"] || !p["group"] {
+		t.Fatalf("Subscriptions should be paused: %v", p)
+	}
+	pz = post(ResumePath+"?channel=foo&queue=group", http.StatusOK)
+	if pz.IsPaused || pz.QueueName != "group" {
+		t.Fatalf("Unexpected response: %+v", pz)
+	}
+	if p := isPaused(); !p["dur"] || p["group"] {
+		t.Fatalf("Only the durable should be paused: %v", p)
+	}
+}
+
 func TestMonitorDurableSubs(t *testing.T) {
 	resetPreviousHTTPConnections()
 	s := runMonitorServer(t, GetDefaultOptions())
@@ -0,0 +1,155 @@
+// Copyright 2017-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+)
+
+// Routes for pausing and resuming subscriptions
+const (
+	PausePath  = RootPath + "/pause"
+	ResumePath = RootPath + "/resume"
+)
+
+// SubscriptionPausez is returned by the pause and resume endpoints.
+type SubscriptionPausez struct {
+	Channel     string `json:"channel"`
+	ClientID    string `json:"client_id,omitempty"`
+	DurableName string `json:"durable_name,omitempty"`
+	QueueName   string `json:"queue_name,omitempty"`
+	IsPaused    bool   `json:"is_paused"`
+}
+
+// PauseDurable stops the delivery of new messages to the durable subscription
+// `durableName` created by `clientID` on `channel`. Messages are still stored
+// and unacknowledged messages are still redelivered. The durable remains
+// paused if its client goes offline and comes back.
+// The paused state is neither persisted nor replicated, so it is lost on
+// restart or, in clustering mode, on leader change.
+func (s *StanServer) PauseDurable(channel, clientID, durableName string) error {
+	return s.setDurablePaused(channel, clientID, durableName, true)
+}
+
+// ResumeDurable restarts the delivery of messages to a durable subscription
+// paused with PauseDurable, starting after the last sent message.
+func (s *StanServer) ResumeDurable(channel, clientID, durableName string) error {
+	return s.setDurablePaused(channel, clientID, durableName, false)
+}
+
+// PauseQueueGroup stops the delivery of new messages to the members of the
+// queue group `group` on `channel`. For a durable queue group, `group` is
+// the durable name followed by ':' and the queue name. See PauseDurable.
+func (s *StanServer) PauseQueueGroup(channel, group string) error {
+	return s.setQueueGroupPaused(channel, group, true)
+}
+
+// ResumeQueueGroup restarts the delivery of messages to a queue group paused
+// with PauseQueueGroup.
+func (s *StanServer) ResumeQueueGroup(channel, group string) error {
+	return s.setQueueGroupPaused(channel, group, false)
+}
+
+func (s *StanServer) setDurablePaused(channel, clientID, durableName string, paused bool) error {
+	c := s.channels.get(channel)
+	if c == nil {
+		return ErrUnknownChannel
+	}
+	ss := c.ss
+	sr := &pb.SubscriptionRequest{ClientID: clientID, Subject: channel, DurableName: durableName}
+	ss.RLock()
+	sub := ss.durables[durableKey(sr)]
+	ss.RUnlock()
+	if sub == nil {
+		return ErrUnknownSub
+	}
+	sub.Lock()
+	wasPaused := sub.paused
+	sub.paused = paused
+	sub.Unlock()
+	if wasPaused && !paused {
+		s.sendAvailableMessages(c, sub)
+	}
+	return nil
+}
+
+func (s *StanServer) setQueueGroupPaused(channel, group string, paused bool) error {
+	c := s.channels.get(channel)
+	if c == nil {
+		return ErrUnknownChannel
+	}
+	ss := c.ss
+	ss.RLock()
+	qs := ss.qsubs[group]
+	ss.RUnlock()
+	if qs == nil {
+		return ErrUnknownSub
+	}
+	qs.Lock()
+	wasPaused := qs.paused
+	qs.paused = paused
+	qs.Unlock()
+	if wasPaused && !paused {
+		s.sendAvailableMessagesToQueue(c, qs)
+	}
+	return nil
+}
+
+// HandlePausez pauses the subscription identified by the `channel`
+// parameter and either the `client` and `durable` parameters, or the
+// `queue` parameter. Only POST requests are accepted.
+func (s *StanServer) HandlePausez(w http.ResponseWriter, r *http.Request) {
+	s.handlePauseResume(w, r, true)
+}
+
+// HandleResumez resumes a subscription paused with HandlePausez.
+func (s *StanServer) HandleResumez(w http.ResponseWriter, r *http.Request) {
+	s.handlePauseResume(w, r, false)
+}
+
+func (s *StanServer) handlePauseResume(w http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	pz := &SubscriptionPausez{
+		Channel:     q.Get("channel"),
+		ClientID:    q.Get("client"),
+		DurableName: q.Get("durable"),
+		QueueName:   q.Get("queue"),
+		IsPaused:    paused,
+	}
+	var err error
+	switch {
+	case pz.Channel == "":
+		http.Error(w, "Missing channel parameter", http.StatusBadRequest)
+		return
+	case pz.QueueName != "" && pz.DurableName == "" && pz.ClientID == "":
+		err = s.setQueueGroupPaused(pz.Channel, pz.QueueName, paused)
+	case pz.QueueName == "" && pz.DurableName != "" && pz.ClientID != "":
+		err = s.setDurablePaused(pz.Channel, pz.ClientID, pz.DurableName, paused)
+	default:
+		http.Error(w, "Specify either client and durable, or queue", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.sendResponse(w, r, pz)
+}
@@ -0,0 +1,167 @@
+// Copyright 2017-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kubemq-io/broker/server/stan/stores"
+)
+
+// pubRateLimiter enforces publish rate limits (messages and bytes per second)
+// using token buckets that are refilled continuously and can hold up to one
+// second worth of tokens.
+type pubRateLimiter struct {
+	sync.Mutex
+	limits   stores.PubRateLimits
+	msgs     float64
+	bytes    float64
+	last     time.Time
+	rejected uint64
+}
+
+// newPubRateLimiter returns a limiter for the given limits, or nil if
+// the limits are not set (unlimited).
+func newPubRateLimiter(limits *stores.PubRateLimits) *pubRateLimiter {
+	if limits == nil || (limits.MaxPubMsgsRate <= 0 && limits.MaxPubBytesRate <= 0) {
+		return nil
+	}
+	return &pubRateLimiter{
+		limits: *limits,
+		msgs:   float64(limits.MaxPubMsgsRate),
+		bytes:  float64(limits.MaxPubBytesRate),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens accumulated since the last call.
+// Lock held on entry.
+func (rl *pubRateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(rl.last).Seconds()
+	rl.last = now
+	if max := float64(rl.limits.MaxPubMsgsRate); max > 0 {
+		rl.msgs += elapsed * max
+		if rl.msgs > max {
+			rl.msgs = max
+		}
+	}
+	if max := float64(rl.limits.MaxPubBytesRate); max > 0 {
+		rl.bytes += elapsed * max
+		if rl.bytes > max {
+			rl.bytes = max
+		}
+	}
+}
+
+// reserve consumes the tokens for a message of the given size. If the
+// tokens are not available, they are borrowed and the returned duration
+// is how long it takes for the limits to allow the message, provided that
+// it does not exceed `maxDelay`. Otherwise, nothing is consumed and false
+// is returned. A message bigger than the bytes rate needs a full bucket.
+func (rl *pubRateLimiter) reserve(size int, maxDelay time.Duration) (time.Duration, bool) {
+	rl.Lock()
+	defer rl.Unlock()
+	rl.refill(time.Now())
+	var wait float64
+	if max := float64(rl.limits.MaxPubMsgsRate); max > 0 && rl.msgs < 1 {
+		wait = (1 - rl.msgs) / max
+	}
+	if max := float64(rl.limits.MaxPubBytesRate); max > 0 {
+		needed := float64(size)
+		if needed > max {
+			needed = max
+		}
+		if w := (needed - rl.bytes) / max; w > wait {
+			wait = w
+		}
+	}
+	delay := time.Duration(wait * float64(time.Second))
+	if delay > maxDelay {
+		rl.rejected++
+		return 0, false
+	}
+	if rl.limits.MaxPubMsgsRate > 0 {
+		rl.msgs--
+	}
+	if rl.limits.MaxPubBytesRate > 0 {
+		rl.bytes -= float64(size)
+	}
+	return delay, true
+}
+
+// cancel gives back the tokens consumed by a previous successful reserve.
+func (rl *pubRateLimiter) cancel(size int) {
+	rl.Lock()
+	if rl.limits.MaxPubMsgsRate > 0 {
+		rl.msgs++
+	}
+	if rl.limits.MaxPubBytesRate > 0 {
+		rl.bytes += float64(size)
+	}
+	rl.Unlock()
+}
+
+// numRejected returns the number of publishes rejected by this limiter.
+func (rl *pubRateLimiter) numRejected() uint64 {
+	rl.Lock()
+	n := rl.rejected
+	rl.Unlock()
+	return n
+}
+
+// checkClientPubRate checks the publish rate limits of the client that
+// published the message of `iopm`. The channel limits are checked by the
+// ioLoop with checkChannelPubRate, once the channel exists.
+func (s *StanServer) checkClientPubRate(iopm *ioPendingMsg) error {
+	if s.clients.limits == nil {
+		return nil
+	}
+	c := s.clients.lookup(iopm.pm.ClientID)
+	if c == nil || c.pubRate == nil {
+		return nil
+	}
+	delay, ok := c.pubRate.reserve(len(iopm.pm.Data), s.opts.PubRateMaxDelay)
+	if !ok {
+		return ErrClientPubRate
+	}
+	iopm.crl = c.pubRate
+	if delay > 0 {
+		iopm.ackAt = time.Now().Add(delay)
+	}
+	return nil
+}
+
+// checkChannelPubRate checks the publish rate limits of channel `c` for
+// the message of `iopm`. This is called from the ioLoop after the channel
+// has been looked up or created, so that the limits also apply to the
+// message that creates the channel. If the message is rejected, the tokens
+// consumed from the client limits are given back.
+func (s *StanServer) checkChannelPubRate(c *channel, iopm *ioPendingMsg) error {
+	if c.pubRate == nil {
+		return nil
+	}
+	size := len(iopm.pm.Data)
+	delay, ok := c.pubRate.reserve(size, s.opts.PubRateMaxDelay)
+	if !ok {
+		if iopm.crl != nil {
+			iopm.crl.cancel(size)
+		}
+		return ErrChannelPubRate
+	}
+	if ackAt := time.Now().Add(delay); delay > 0 && ackAt.After(iopm.ackAt) {
+		iopm.ackAt = ackAt
+	}
+	return nil
+}
@@ -17,6 +17,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -47,16 +49,18 @@ type raftLog struct {
 	codec    *codec.MsgpackHandle
 	closed   bool
 
+	// If set, logs are stored in segment files instead of the logs bucket.
+	segments *raftSegmentLog
+
 	// Our cache
 	hasCache  bool        // Immutable
 	cache     []*raft.Log // Simple array containing sequence based on modulo
 	cacheSize uint64      // Save size as uint64 to not have to case during store/load
 
 	// If the store is using encryption
-	encryption    bool
-	eds           *stores.EDStore
-	encryptBuf    []byte
-	encryptOffset int
+	encryption bool
+	eds        *stores.EDStore
+	encryptBuf []byte
 }
 
 func newRaftLog(log logger.Logger, fileName string, opts *Options) (*raftLog, error) {
@@ -86,21 +90,24 @@ func newRaftLog(log logger.Logger, fileName string, opts *Options) (*raftLog, er
 		r.conn.Close()
 		return nil, err
 	}
+	if err := r.openSegments(opts); err != nil {
+		r.conn.Close()
+		return nil, err
+	}
 	if opts.Encrypt {
 		lastIndex, err := r.getIndex(false)
 		if err != nil {
-			r.conn.Close()
+			r.close()
 			return nil, err
 		}
-		eds, err := stores.NewEDStore(opts.EncryptionCipher, opts.EncryptionKey, lastIndex)
+		eds, err := stores.NewEDStoreWithOldKeys(opts.EncryptionCipher, opts.EncryptionKey, opts.EncryptionOldKeys, lastIndex)
 		if err != nil {
-			r.conn.Close()
+			r.close()
 			return nil, err
 		}
 		r.eds = eds
 		r.encryption = true
 		r.encryptBuf = make([]byte, 100)
-		r.encryptOffset = eds.EncryptionOffset()
 	}
 	if cacheSize := opts.Clustering.LogCacheSize; cacheSize > 0 {
 		r.hasCache = true
@@ -130,6 +137,135 @@ func (r *raftLog) init() error {
 	return tx.Commit()
 }
 
+// openSegments opens the segment files if the logs are configured to be
+// stored in segments, moving the logs of the bolt DB, if any, to the segments.
+// If logs are not configured to be stored in segments, returns an error if
+// segment files exist since the logs would otherwise be lost.
+func (r *raftLog) openSegments(opts *Options) error {
+	dir := filepath.Join(filepath.Dir(r.fileName), raftSegmentsDir)
+	if !opts.Clustering.LogSegments {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, e := range entries {
+			if filepath.Ext(e.Name()) == raftSegmentSuffix {
+				return fmt.Errorf("raft logs are stored in segment files in %q, the log segments option must be enabled", dir)
+			}
+		}
+		return nil
+	}
+	boltLast, err := r.getIndex(false)
+	if err != nil {
+		return err
+	}
+	segments, err := newRaftSegmentLog(dir, opts.Clustering.LogSegmentSize, opts.Clustering.Sync)
+	if err != nil {
+		return err
+	}
+	if boltLast != 0 && segments.lastIndex() == 0 {
+		segments.close()
+		if err := r.migrateToSegments(dir); err != nil {
+			return err
+		}
+		segments, err = newRaftSegmentLog(dir, opts.Clustering.LogSegmentSize, opts.Clustering.Sync)
+		if err != nil {
+			return err
+		}
+	}
+	// At this point, logs in the bolt DB have been copied to the segments
+	// (a previous migration may have been interrupted before clearing them).
+	if boltLast != 0 {
+		if err := r.clearLogsBucket(); err != nil {
+			segments.close()
+			return err
+		}
+	}
+	r.segments = segments
+	return nil
+}
+
+// migrateToSegments copies the logs of the bolt DB to segment files in a
+// temporary directory that is then renamed to `dir`, so that an interrupted
+// migration is restarted from scratch.
+func (r *raftLog) migrateToSegments(dir string) error {
+	tmpDir := dir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	// Always sync, the bolt logs are cleared once the migration is complete.
+	segments, err := newRaftSegmentLog(tmpDir, 0, true)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	r.log.Noticef("Moving raft logs from %q to %q", r.fileName, dir)
+	tx, err := r.conn.Begin(false)
+	if err != nil {
+		segments.close()
+		return err
+	}
+	const batchSize = 1024
+	var (
+		logs  = make([]*raft.Log, 0, batchSize)
+		vals  = make([][]byte, 0, batchSize)
+		count int
+	)
+	flush := func() error {
+		if len(logs) == 0 {
+			return nil
+		}
+		err := segments.append(logs, vals)
+		count += len(logs)
+		logs, vals = logs[:0], vals[:0]
+		return err
+	}
+	curs := tx.Bucket(logsBucket).Cursor()
+	for k, v := curs.First(); k != nil && err == nil; k, v = curs.Next() {
+		// The value is only valid during the transaction, but
+		// is copied to the write buffer when appended.
+		logs = append(logs, &raft.Log{Index: binary.BigEndian.Uint64(k)})
+		vals = append(vals, v)
+		if len(logs) == batchSize {
+			err = flush()
+		}
+	}
+	if err == nil {
+		err = flush()
+	}
+	tx.Rollback()
+	if cerr := segments.close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		if err = os.RemoveAll(dir); err == nil {
+			err = os.Rename(tmpDir, dir)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return fmt.Errorf("unable to move raft logs to segment files: %v", err)
+	}
+	r.log.Noticef("Moved %v raft logs in %v", count, time.Since(start))
+	return nil
+}
+
+// clearLogsBucket removes all logs from the bolt DB.
+func (r *raftLog) clearLogsBucket() error {
+	tx, err := r.conn.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := tx.DeleteBucket(logsBucket); err != nil {
+		return err
+	}
+	if _, err := tx.CreateBucket(logsBucket); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (r *raftLog) encrypt(data []byte) ([]byte, error) {
 	// Here we can reuse a buffer to encrypt because we know
 	// that the underlying is going to make a copy of the
@@ -163,11 +299,7 @@ func (r *raftLog) decodeRaftLog(buf []byte, log *raft.Log) error {
 		// My understanding is that log.Data is empty at beginning of this
 		// function and dec.Decode(log) will make a copy from buffer that
 		// comes from boltdb. So we can decrypt in place since we "own" log.Data.
-		var dst []byte
-		if len(log.Data) > r.encryptOffset {
-			dst = log.Data[r.encryptOffset:]
-		}
-		dd, err := r.eds.Decrypt(dst[:0], log.Data)
+		dd, err := r.eds.DecryptInPlace(log.Data)
 		if err != nil {
 			return err
 		}
@@ -184,11 +316,22 @@ func (r *raftLog) Close() error {
 		return nil
 	}
 	r.closed = true
-	err := r.conn.Close()
+	err := r.close()
 	r.Unlock()
 	return err
 }
 
+func (r *raftLog) close() error {
+	var err error
+	if r.segments != nil {
+		err = r.segments.close()
+	}
+	if cerr := r.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 // FirstIndex implements the LogStore interface
 func (r *raftLog) FirstIndex() (uint64, error) {
 	return r.getIndex(true)
@@ -202,6 +345,12 @@ func (r *raftLog) LastIndex() (uint64, error) {
 // returns either the first (if first is true) or the last
 // index of the logs bucket.
 func (r *raftLog) getIndex(first bool) (uint64, error) {
+	if r.segments != nil {
+		if first {
+			return r.segments.firstIndex(), nil
+		}
+		return r.segments.lastIndex(), nil
+	}
 	tx, err := r.conn.Begin(false)
 	if err != nil {
 		return 0, err
@@ -234,6 +383,13 @@ func (r *raftLog) GetLog(idx uint64, log *raft.Log) error {
 			return nil
 		}
 	}
+	if r.segments != nil {
+		val, err := r.segments.get(idx)
+		if err != nil {
+			return err
+		}
+		return r.decodeRaftLog(val, log)
+	}
 	tx, err := r.conn.Begin(false)
 	if err != nil {
 		return err
@@ -258,6 +414,9 @@ func (r *raftLog) StoreLog(log *raft.Log) error {
 
 // StoreLogs implements the LogStore interface
 func (r *raftLog) StoreLogs(logs []*raft.Log) error {
+	if r.segments != nil {
+		return r.storeLogsInSegments(logs)
+	}
 	tx, err := r.conn.Begin(true)
 	if err != nil {
 		return err
@@ -282,18 +441,41 @@ func (r *raftLog) StoreLogs(logs []*raft.Log) error {
 		tx.Rollback()
 	} else {
 		err = tx.Commit()
-		if err == nil && r.hasCache {
-			r.Lock()
-			// Cache only on success
-			for _, l := range logs {
-				r.cache[l.Index%r.cacheSize] = l
-			}
-			r.Unlock()
+		if err == nil {
+			r.cacheLogs(logs)
 		}
 	}
 	return err
 }
 
+func (r *raftLog) storeLogsInSegments(logs []*raft.Log) error {
+	vals := make([][]byte, len(logs))
+	for i, log := range logs {
+		val, err := r.encodeRaftLog(log)
+		if err != nil {
+			return err
+		}
+		vals[i] = val
+	}
+	if err := r.segments.append(logs, vals); err != nil {
+		return err
+	}
+	r.cacheLogs(logs)
+	return nil
+}
+
+func (r *raftLog) cacheLogs(logs []*raft.Log) {
+	if !r.hasCache {
+		return
+	}
+	r.Lock()
+	// Cache only on success
+	for _, l := range logs {
+		r.cache[l.Index%r.cacheSize] = l
+	}
+	r.Unlock()
+}
+
 // DeleteRange implements the LogStore interface
 func (r *raftLog) DeleteRange(min, max uint64) (retErr error) {
 
@@ -321,6 +503,9 @@ func (r *raftLog) DeleteRange(min, max uint64) (retErr error) {
 // and up to max index (included).
 // Lock is held on entry
 func (r *raftLog) deleteRange(min, max uint64) error {
+	if r.segments != nil {
+		return r.segments.deleteRange(min, max)
+	}
 	var key [8]byte
 	binary.BigEndian.PutUint64(key[:], min)
 	tx, err := r.conn.Begin(true)
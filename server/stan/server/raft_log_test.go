@@ -378,6 +378,29 @@ func TestRaftLogWithEncryption(t *testing.T) {
 	}
 	store.Close()
 
+	// After a key rotation, logs can be decrypted with the old key.
+	opts.EncryptionKey = []byte("newkey")
+	opts.EncryptionOldKeys = [][]byte{[]byte("testkey")}
+	store, err = newRaftLog(testLogger, fileName, opts)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer store.Close()
+	if err := store.StoreLog(&raft.Log{Type: raft.LogCommand, Index: 4, Term: 1, Data: []byte("msg4")}); err != nil {
+		t.Fatalf("Error storing log: %v", err)
+	}
+	for i := 1; i <= 4; i++ {
+		log = &raft.Log{}
+		if err := store.GetLog(uint64(i), log); err != nil {
+			t.Fatalf("Error getting log: %v", err)
+		}
+		if string(log.Data) != fmt.Sprintf("msg%d", i) {
+			t.Fatalf("Expected %q, got %q", fmt.Sprintf("msg%d", i), log.Data)
+		}
+	}
+	store.Close()
+	opts.EncryptionOldKeys = nil
+
 	// Re-open with encryption but no key, this should fail.
 	opts.EncryptionKey = nil
 	store, err = newRaftLog(testLogger, fileName, opts)
@@ -623,3 +646,219 @@ func TestRaftLogCache(t *testing.T) {
 		t.Fatalf("Expected log 4 not to be cached, got %v", cl4)
 	}
 }
+
+func TestRaftLogSegments(t *testing.T) {
+	cleanupRaftLog(t)
+	defer cleanupRaftLog(t)
+
+	opts := GetDefaultOptions()
+	opts.Clustering.LogSegments = true
+	// Small segments so that logs span several files.
+	opts.Clustering.LogSegmentSize = 100
+	opts.Clustering.LogCacheSize = 0
+	store := createTestRaftLog(t, opts)
+	defer store.Close()
+
+	var logs []*raft.Log
+	for i := 1; i <= 20; i++ {
+		logs = append(logs, &raft.Log{
+			Index: uint64(i),
+			Term:  1,
+			Type:  raft.LogCommand,
+			Data:  []byte(fmt.Sprintf("log%d", i)),
+		})
+	}
+	// Store some logs individually and others in batches
+	for i := 0; i < 5; i++ {
+		if err := store.StoreLog(logs[i]); err != nil {
+			t.Fatalf("Error on store log: %v", err)
+		}
+	}
+	if err := store.StoreLogs(logs[5:]); err != nil {
+		t.Fatalf("Error on store logs: %v", err)
+	}
+	// A gap is rejected
+	if err := store.StoreLog(&raft.Log{Index: 22, Term: 1}); err == nil {
+		t.Fatal("Expected error storing a log that does not follow the last one")
+	}
+
+	checkLogs := func(first, last uint64) {
+		t.Helper()
+		if fi, err := store.FirstIndex(); fi != first || err != nil {
+			t.Fatalf("Expected first index to be %v, got %v - %v", first, fi, err)
+		}
+		if li, err := store.LastIndex(); li != last || err != nil {
+			t.Fatalf("Expected last index to be %v, got %v - %v", last, li, err)
+		}
+		for i := uint64(1); i <= 25; i++ {
+			log := &raft.Log{}
+			err := store.GetLog(i, log)
+			if i < first || i > last {
+				if err != raft.ErrLogNotFound {
+					t.Fatalf("Expected log %v to not be found, got %v", i, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("Error on get log %v: %v", i, err)
+			}
+			if !reflect.DeepEqual(log, logs[i-1]) {
+				t.Fatalf("Unexpected log at index %v: %v", i, log)
+			}
+		}
+	}
+	reopen := func() {
+		t.Helper()
+		store.Close()
+		store = createTestRaftLog(t, opts)
+	}
+	checkLogs(1, 20)
+	segDir := filepath.Join(defaultRaftLog, raftSegmentsDir)
+	segs, _ := filepath.Glob(filepath.Join(segDir, "*"+raftSegmentSuffix))
+	if len(segs) < 3 {
+		t.Fatalf("Expected logs to be stored in several segments, got %v", segs)
+	}
+	reopen()
+	checkLogs(1, 20)
+
+	// Delete from the head, within the first segment
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("Error on delete range: %v", err)
+	}
+	checkLogs(3, 20)
+	// and past the first segments
+	if err := store.DeleteRange(3, 8); err != nil {
+		t.Fatalf("Error on delete range: %v", err)
+	}
+	checkLogs(9, 20)
+	if s, _ := filepath.Glob(filepath.Join(segDir, "*"+raftSegmentSuffix)); len(s) >= len(segs) {
+		t.Fatalf("Expected segments to be removed, got %v", s)
+	}
+	reopen()
+	checkLogs(9, 20)
+
+	// Delete from the tail
+	if err := store.DeleteRange(15, 20); err != nil {
+		t.Fatalf("Error on delete range: %v", err)
+	}
+	checkLogs(9, 14)
+	reopen()
+	checkLogs(9, 14)
+	// The logs can be stored again
+	if err := store.StoreLogs(logs[14:]); err != nil {
+		t.Fatalf("Error on store logs: %v", err)
+	}
+	checkLogs(9, 20)
+
+	// Simulate a write interrupted by a crash
+	store.Close()
+	segs, _ = filepath.Glob(filepath.Join(segDir, "*"+raftSegmentSuffix))
+	lastSeg := segs[len(segs)-1]
+	f, err := os.OpenFile(lastSeg, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Error opening segment: %v", err)
+	}
+	f.Write([]byte{0, 0, 0, 10, 1, 2})
+	f.Close()
+	store = createTestRaftLog(t, opts)
+	checkLogs(9, 20)
+	if err := store.StoreLogs([]*raft.Log{{Index: 21, Term: 1}}); err != nil {
+		t.Fatalf("Error on store logs: %v", err)
+	}
+	logs = append(logs, &raft.Log{Index: 21, Term: 1})
+	reopen()
+	checkLogs(9, 21)
+
+	// Delete everything
+	if err := store.DeleteRange(9, 21); err != nil {
+		t.Fatalf("Error on delete range: %v", err)
+	}
+	checkLogs(0, 0)
+	reopen()
+	checkLogs(0, 0)
+}
+
+func TestRaftLogSegmentsMigration(t *testing.T) {
+	cleanupRaftLog(t)
+	defer cleanupRaftLog(t)
+
+	opts := GetDefaultOptions()
+	opts.Encrypt = true
+	opts.EncryptionKey = []byte("testkey")
+	store := createTestRaftLog(t, opts)
+	var logs []*raft.Log
+	for i := 1; i <= 10; i++ {
+		logs = append(logs, &raft.Log{
+			Index: uint64(i),
+			Term:  1,
+			Type:  raft.LogCommand,
+			Data:  []byte(fmt.Sprintf("log%d", i)),
+		})
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("Error on store logs: %v", err)
+	}
+	if err := store.DeleteRange(1, 3); err != nil {
+		t.Fatalf("Error on delete range: %v", err)
+	}
+	if err := store.SetUint64([]byte("key"), 123); err != nil {
+		t.Fatalf("Error on set: %v", err)
+	}
+	store.Close()
+
+	opts.Clustering.LogSegments = true
+	opts.Clustering.LogCacheSize = 0
+	// The key is erased when the store is opened
+	opts.EncryptionKey = []byte("testkey")
+	store = createTestRaftLog(t, opts)
+	check := func() {
+		t.Helper()
+		if fi, _ := store.FirstIndex(); fi != 4 {
+			t.Fatalf("Expected first index to be 4, got %v", fi)
+		}
+		if li, _ := store.LastIndex(); li != 10 {
+			t.Fatalf("Expected last index to be 10, got %v", li)
+		}
+		for i := 4; i <= 10; i++ {
+			log := &raft.Log{}
+			if err := store.GetLog(uint64(i), log); err != nil {
+				t.Fatalf("Error on get log %v: %v", i, err)
+			}
+			if !reflect.DeepEqual(log, logs[i-1]) {
+				t.Fatalf("Unexpected log at index %v: %v", i, log)
+			}
+		}
+		if v, err := store.GetUint64([]byte("key")); v != 123 || err != nil {
+			t.Fatalf("Unexpected value: %v - %v", v, err)
+		}
+	}
+	check()
+	// Logs have been removed from the bolt DB
+	tx, err := store.conn.Begin(false)
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	if k, _ := tx.Bucket(logsBucket).Cursor().First(); k != nil {
+		tx.Rollback()
+		t.Fatalf("Expected logs to be removed from bolt DB")
+	}
+	tx.Rollback()
+	store.Close()
+
+	// Restart, there should be no migration
+	opts.EncryptionKey = []byte("testkey")
+	store = createTestRaftLog(t, opts)
+	check()
+	store.Close()
+
+	// Logs would be lost if the option is disabled
+	opts.Clustering.LogSegments = false
+	opts.EncryptionKey = []byte("testkey")
+	fileName := filepath.Join(defaultRaftLog, raftLogFile)
+	if s, err := newRaftLog(testLogger, fileName, opts); err == nil || !strings.Contains(err.Error(), "segment") {
+		if s != nil {
+			s.Close()
+		}
+		t.Fatalf("Expected error about segments, got %v", err)
+	}
+}
@@ -0,0 +1,517 @@
+// Copyright 2017-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// Suffix of the Raft log segment files. The name of a segment is the
+	// index of its first log, zero-padded so that names sort by index.
+	raftSegmentSuffix = ".seg"
+
+	// Name of the file that holds the first index of the log when the
+	// head of the first segment has been deleted.
+	raftSegmentMetaFile = "meta"
+
+	// Version stored at the beginning of each segment file.
+	raftSegmentVersion = 1
+
+	// Size of the segment file header (the version).
+	raftSegmentHeaderSize = 4
+
+	// Size of a record header:
+	// 4 bytes for the data length, 4 bytes for the CRC-32 of the index and
+	// data, and 8 bytes for the log index.
+	raftSegmentRecHeaderSize = 4 + 4 + 8
+)
+
+var errRaftSegmentCorrupted = errors.New("corrupted record")
+
+// raftSegment is one of the files of a raftSegmentLog. It holds the logs
+// from `first` to `first+len(offsets)-1`.
+type raftSegment struct {
+	file    *os.File
+	first   uint64
+	offsets []int64 // Offset of each record in the file
+	size    int64
+}
+
+// raftSegmentLog stores encoded Raft logs in append-only segment files.
+// A new segment is started when the current one reaches the maximum size.
+// The offsets of the records are kept in memory and rebuilt when the log
+// is opened. Logs are deleted from the head by removing whole segments,
+// and from the tail by truncating the segment that contains the first
+// deleted log.
+type raftSegmentLog struct {
+	sync.RWMutex
+	dir      string
+	maxSize  int64
+	sync     bool
+	segments []*raftSegment
+	first    uint64 // 0 if there is no log
+	last     uint64
+	crcTable *crc32.Table
+	wbuf     []byte
+	closed   bool
+}
+
+// newRaftSegmentLog opens, or creates, the segment log in directory `dir`.
+// If `doSync` is true, files are sync'ed after each write.
+func newRaftSegmentLog(dir string, maxSize int64, doSync bool) (*raftSegmentLog, error) {
+	if err := os.MkdirAll(dir, os.ModeDir+os.ModePerm); err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultLogSegmentSize
+	}
+	sl := &raftSegmentLog{
+		dir:      dir,
+		maxSize:  maxSize,
+		sync:     doSync,
+		crcTable: crc32.IEEETable,
+	}
+	if err := sl.recover(); err != nil {
+		sl.close()
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (sl *raftSegmentLog) segmentName(first uint64) string {
+	return filepath.Join(sl.dir, fmt.Sprintf("%020d%s", first, raftSegmentSuffix))
+}
+
+// recover opens the existing segments and rebuilds their index. An incomplete
+// or corrupted record at the end of the last segment is the result of a
+// write interrupted by a crash, so the segment is truncated.
+func (sl *raftSegmentLog) recover() error {
+	entries, err := os.ReadDir(sl.dir)
+	if err != nil {
+		return err
+	}
+	var firsts []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, raftSegmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, raftSegmentSuffix), 10, 64)
+		if err != nil {
+			return fmt.Errorf("raft log segment has an invalid name: %v", name)
+		}
+		firsts = append(firsts, first)
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+	for i, first := range firsts {
+		seg, err := sl.openSegment(first, i == len(firsts)-1)
+		if err != nil {
+			return err
+		}
+		if len(sl.segments) > 0 {
+			prev := sl.segments[len(sl.segments)-1]
+			if prev.first+uint64(len(prev.offsets)) != first {
+				seg.file.Close()
+				return fmt.Errorf("raft log segment %q does not follow the previous segment", sl.segmentName(first))
+			}
+		}
+		sl.segments = append(sl.segments, seg)
+	}
+	// An empty segment may be left if a crash occurred right after its creation.
+	if n := len(sl.segments); n > 0 && len(sl.segments[n-1].offsets) == 0 {
+		if err := sl.removeSegment(n - 1); err != nil {
+			return err
+		}
+	}
+	if len(sl.segments) == 0 {
+		return nil
+	}
+	sl.first = sl.segments[0].first
+	lastSeg := sl.segments[len(sl.segments)-1]
+	sl.last = lastSeg.first + uint64(len(lastSeg.offsets)) - 1
+	// Apply a previous deletion of the head of the first segment.
+	content, err := os.ReadFile(filepath.Join(sl.dir, raftSegmentMetaFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(content) == 8 {
+		if first := binary.BigEndian.Uint64(content); first > sl.first {
+			if first > sl.last {
+				return sl.deleteAll()
+			}
+			sl.first = first
+		}
+	}
+	return nil
+}
+
+// openSegment opens the segment starting at index `first` and builds its
+// index. If `isLast` is true, a bad record at the end of the file causes
+// the file to be truncated instead of failing.
+func (sl *raftSegmentLog) openSegment(first uint64, isLast bool) (*raftSegment, error) {
+	name := sl.segmentName(first)
+	f, err := os.OpenFile(name, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	seg := &raftSegment{file: f, first: first}
+	var hdr [raftSegmentHeaderSize]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to read header of raft log segment %q: %v", name, err)
+	}
+	if v := binary.BigEndian.Uint32(hdr[:]); v != raftSegmentVersion {
+		f.Close()
+		return nil, fmt.Errorf("unsupported raft log segment version %v in %q", v, name)
+	}
+	seg.size = raftSegmentHeaderSize
+	r := &offsetReader{r: f, off: seg.size}
+	var buf []byte
+	for {
+		var idx uint64
+		idx, buf, err = sl.readRecord(r, buf)
+		if err == nil && idx != first+uint64(len(seg.offsets)) {
+			err = errRaftSegmentCorrupted
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			if !isLast {
+				f.Close()
+				return nil, fmt.Errorf("error reading raft log segment %q at offset %v: %v", name, seg.size, err)
+			}
+			if terr := f.Truncate(seg.size); terr != nil {
+				f.Close()
+				return nil, terr
+			}
+			break
+		}
+		seg.offsets = append(seg.offsets, seg.size)
+		seg.size = r.off
+	}
+	return seg, nil
+}
+
+// offsetReader reads sequentially from a file using ReadAt.
+type offsetReader struct {
+	r   io.ReaderAt
+	off int64
+}
+
+func (or *offsetReader) Read(p []byte) (int, error) {
+	n, err := or.r.ReadAt(p, or.off)
+	or.off += int64(n)
+	return n, err
+}
+
+// readRecord reads a record from `r`. Returns io.EOF if there is no more
+// record, and io.ErrUnexpectedEOF if the record is incomplete.
+func (sl *raftSegmentLog) readRecord(r io.Reader, buf []byte) (uint64, []byte, error) {
+	var hdr [raftSegmentRecHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, buf, err
+	}
+	size := int(binary.BigEndian.Uint32(hdr[:4]))
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, buf, err
+	}
+	crc := crc32.Update(crc32.Checksum(hdr[8:], sl.crcTable), sl.crcTable, buf)
+	if crc != binary.BigEndian.Uint32(hdr[4:8]) {
+		return 0, buf, errRaftSegmentCorrupted
+	}
+	return binary.BigEndian.Uint64(hdr[8:]), buf, nil
+}
+
+// createSegment creates a new segment whose first log will be `first`.
+func (sl *raftSegmentLog) createSegment(first uint64) (*raftSegment, error) {
+	f, err := os.OpenFile(sl.segmentName(first), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	var hdr [raftSegmentHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[:], raftSegmentVersion)
+	if _, err := f.Write(hdr[:]); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &raftSegment{file: f, first: first, size: raftSegmentHeaderSize}, nil
+}
+
+// removeSegment closes and removes the segment at position `i`.
+func (sl *raftSegmentLog) removeSegment(i int) error {
+	seg := sl.segments[i]
+	seg.file.Close()
+	if err := os.Remove(seg.file.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sl.segments = append(sl.segments[:i], sl.segments[i+1:]...)
+	return nil
+}
+
+// writeMeta persists the first index of the log.
+func (sl *raftSegmentLog) writeMeta(first uint64) error {
+	name := filepath.Join(sl.dir, raftSegmentMetaFile)
+	tmpName := name + ".tmp"
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], first)
+	f, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf[:])
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, name)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+func (sl *raftSegmentLog) firstIndex() uint64 {
+	sl.RLock()
+	defer sl.RUnlock()
+	return sl.first
+}
+
+func (sl *raftSegmentLog) lastIndex() uint64 {
+	sl.RLock()
+	defer sl.RUnlock()
+	return sl.last
+}
+
+// get returns the encoded log at index `idx`.
+func (sl *raftSegmentLog) get(idx uint64) ([]byte, error) {
+	sl.RLock()
+	defer sl.RUnlock()
+	if sl.closed {
+		return nil, raft.ErrLogNotFound
+	}
+	if sl.first == 0 || idx < sl.first || idx > sl.last {
+		return nil, raft.ErrLogNotFound
+	}
+	i := sort.Search(len(sl.segments), func(i int) bool { return sl.segments[i].first > idx }) - 1
+	seg := sl.segments[i]
+	r := &offsetReader{r: seg.file, off: seg.offsets[idx-seg.first]}
+	ridx, data, err := sl.readRecord(r, nil)
+	if err == nil && ridx != idx {
+		err = errRaftSegmentCorrupted
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading raft log %v from %q: %v", idx, seg.file.Name(), err)
+	}
+	return data, nil
+}
+
+// append adds the encoded logs `vals` whose indexes are in `logs`. Indexes
+// must follow the last index of the log.
+func (sl *raftSegmentLog) append(logs []*raft.Log, vals [][]byte) error {
+	sl.Lock()
+	defer sl.Unlock()
+	if sl.closed {
+		return errors.New("raft log closed")
+	}
+	for i, log := range logs {
+		if (i == 0 && sl.first != 0 && log.Index != sl.last+1) || (i > 0 && log.Index != logs[i-1].Index+1) {
+			return fmt.Errorf("raft log index %v does not follow last index %v", log.Index, sl.last)
+		}
+	}
+	var (
+		seg     *raftSegment
+		offsets []int64
+		err     error
+	)
+	if n := len(sl.segments); n > 0 {
+		seg = sl.segments[n-1]
+	}
+	prevFirst := sl.first
+	sl.wbuf = sl.wbuf[:0]
+	for i, log := range logs {
+		if seg == nil || seg.size+int64(len(sl.wbuf)) >= sl.maxSize {
+			// Write what we have for the current segment and start a new one.
+			if err = sl.flushSegment(seg, offsets); err == nil {
+				seg, err = sl.createSegment(log.Index)
+			}
+			if err != nil {
+				sl.resetIndexes(prevFirst)
+				return err
+			}
+			offsets = nil
+			sl.segments = append(sl.segments, seg)
+		}
+		offsets = append(offsets, seg.size+int64(len(sl.wbuf)))
+		var hdr [raftSegmentRecHeaderSize]byte
+		binary.BigEndian.PutUint32(hdr[:4], uint32(len(vals[i])))
+		binary.BigEndian.PutUint64(hdr[8:], log.Index)
+		crc := crc32.Update(crc32.Checksum(hdr[8:], sl.crcTable), sl.crcTable, vals[i])
+		binary.BigEndian.PutUint32(hdr[4:8], crc)
+		sl.wbuf = append(sl.wbuf, hdr[:]...)
+		sl.wbuf = append(sl.wbuf, vals[i]...)
+		if sl.first == 0 {
+			sl.first = log.Index
+		}
+		sl.last = log.Index
+	}
+	if err = sl.flushSegment(seg, offsets); err != nil {
+		sl.resetIndexes(prevFirst)
+	}
+	return err
+}
+
+// flushSegment writes the pending records to the segment and records their
+// offsets. Lock held on entry.
+func (sl *raftSegmentLog) flushSegment(seg *raftSegment, offsets []int64) error {
+	if seg == nil || len(sl.wbuf) == 0 {
+		return nil
+	}
+	if _, err := seg.file.WriteAt(sl.wbuf, seg.size); err != nil {
+		return err
+	}
+	if sl.sync {
+		if err := seg.file.Sync(); err != nil {
+			return err
+		}
+	}
+	seg.size += int64(len(sl.wbuf))
+	seg.offsets = append(seg.offsets, offsets...)
+	sl.wbuf = sl.wbuf[:0]
+	return nil
+}
+
+// resetIndexes sets the last index of the log based on the records that
+// were actually written, after a failed write. `first` is the first index
+// before the write. Lock held on entry.
+func (sl *raftSegmentLog) resetIndexes(first uint64) {
+	sl.first, sl.last = 0, 0
+	for len(sl.segments) > 0 {
+		seg := sl.segments[len(sl.segments)-1]
+		if len(seg.offsets) > 0 {
+			sl.first = first
+			if first == 0 {
+				sl.first = sl.segments[0].first
+			}
+			sl.last = seg.first + uint64(len(seg.offsets)) - 1
+			break
+		}
+		sl.removeSegment(len(sl.segments) - 1)
+	}
+}
+
+// deleteRange deletes the logs from `min` to `max` (included). Raft only
+// deletes from the head, when compacting the log, or from the tail, when
+// resolving conflicts with the leader.
+func (sl *raftSegmentLog) deleteRange(min, max uint64) error {
+	sl.Lock()
+	defer sl.Unlock()
+	if sl.first == 0 || max < sl.first || min > sl.last {
+		return nil
+	}
+	switch {
+	case min <= sl.first && max >= sl.last:
+		return sl.deleteAll()
+	case min <= sl.first:
+		// Persist the new first index before removing the segments.
+		if err := sl.writeMeta(max + 1); err != nil {
+			return err
+		}
+		for len(sl.segments) > 1 && sl.segments[1].first <= max+1 {
+			if err := sl.removeSegment(0); err != nil {
+				return err
+			}
+		}
+		sl.first = max + 1
+		return nil
+	case max >= sl.last:
+		for n := len(sl.segments); n > 0 && sl.segments[n-1].first >= min; n-- {
+			if err := sl.removeSegment(n - 1); err != nil {
+				return err
+			}
+		}
+		seg := sl.segments[len(sl.segments)-1]
+		keep := min - seg.first
+		if keep < uint64(len(seg.offsets)) {
+			size := seg.offsets[keep]
+			if err := seg.file.Truncate(size); err != nil {
+				return err
+			}
+			if err := seg.file.Sync(); err != nil {
+				return err
+			}
+			seg.offsets = seg.offsets[:keep]
+			seg.size = size
+		}
+		sl.last = min - 1
+		return nil
+	}
+	return fmt.Errorf("unable to delete raft logs from %v to %v, only head or tail can be deleted", min, max)
+}
+
+// deleteAll removes all segments. Lock held on entry.
+func (sl *raftSegmentLog) deleteAll() error {
+	for len(sl.segments) > 0 {
+		if err := sl.removeSegment(len(sl.segments) - 1); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(sl.dir, raftSegmentMetaFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sl.first, sl.last = 0, 0
+	return nil
+}
+
+// close closes the segment files.
+func (sl *raftSegmentLog) close() error {
+	sl.Lock()
+	defer sl.Unlock()
+	if sl.closed {
+		return nil
+	}
+	sl.closed = true
+	var err error
+	for _, seg := range sl.segments {
+		if cerr := seg.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -17,6 +17,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"net/url"
 	"os"
@@ -87,6 +88,10 @@ const (
 	// to reduce disk IO.
 	DefaultLogCacheSize = 512
 
+	// DefaultLogSegmentSize is the size at which a new Raft log segment file
+	// is started when ClusteringOptions.LogSegments is enabled.
+	DefaultLogSegmentSize = int64(64 * 1024 * 1024)
+
 	// DefaultLogSnapshots is the number of Raft log snapshots to retain.
 	DefaultLogSnapshots = 2
 
@@ -107,6 +112,9 @@ const (
 	// Name of the file to store Raft log.
 	raftLogFile = "raft.log"
 
+	// Name of the directory of the Raft log segment files.
+	raftSegmentsDir = "raft_segments"
+
 	// In partitioning mode, when a client connects, the connect request
 	// may reach several servers, but the first response the client gets
 	// allows it to proceed with either publish or subscribe.
@@ -174,6 +182,12 @@ var (
 	ErrNoChannel          = errors.New("stan: no configured channel")
 	ErrClusteredRestart   = errors.New("stan: cannot restart server in clustered mode if it was not previously clustered")
 	ErrChanDelInProgress  = errors.New("stan: channel is being deleted")
+	ErrInvalidReplaySpeed = errors.New("stan: invalid replay speed, should be >= 0")
+	ErrClientPubRate      = errors.New("stan: client publish rate limit exceeded")
+	ErrChannelPubRate     = errors.New("stan: channel publish rate limit exceeded")
+	ErrUnknownSub         = errors.New("stan: unknown subscription")
+	ErrInvalidFilter      = errors.New("stan: invalid subscription filter")
+	ErrQueueFilter        = errors.New("stan: filter does not match the one of the queue group")
 )
 
 // Shared regular expression to check clientID validity.
@@ -223,6 +237,13 @@ type ioPendingMsg struct {
 	c  *channel
 	dc bool // if true, this is a request to delete this channel.
 
+	// Publish rate limiting: limiter of the publishing client (if any),
+	// time at which the publisher can be acked when the message was
+	// accepted with a delay, and whether the channel limits rejected it.
+	crl      *pubRateLimiter
+	ackAt    time.Time
+	rejected bool
+
 	// Use for synchronization between ioLoop and other routines
 	sc  chan struct{}
 	sdc chan struct{}
@@ -386,6 +407,7 @@ func (cs *channelStore) create(s *StanServer, name string, sc *stores.Channel) (
 	if cl.MaxInactivity > 0 {
 		c.activity = &channelActivity{maxInactivity: cl.MaxInactivity}
 	}
+	c.pubRate = newPubRateLimiter(&cl.PubRateLimits)
 	return c, nil
 }
 
@@ -474,6 +496,7 @@ type channel struct {
 	stan         *StanServer
 	activity     *channelActivity
 	nextSubID    uint64
+	pubRate      *pubRateLimiter
 
 	// Used in cluster mode. This is to know if the message store
 	// last sequence should be checked before storing a message in
@@ -718,6 +741,15 @@ type StanServer struct {
 
 	// Store
 	store stores.Store
+	// Set when StoreMetrics is enabled, wraps the store implementation.
+	storeMetrics *stores.MetricsStore
+	// Set when the FileStore (used by FILE and HYBRID store types) recovers
+	// the channels lazily, to report the progress.
+	lazyFileStore *stores.FileStore
+
+	// Last backup started through the monitoring endpoint.
+	backupMu sync.Mutex
+	backup   *Backupz
 
 	// IO Channel
 	ioChannel     chan *ioPendingMsg
@@ -824,6 +856,10 @@ type queueState struct {
 	shadow          *subState // For durable case, when last member leaves and group is not closed.
 	stalledSubCount int       // number of stalled members
 	newOnHold       bool
+	replay          *replayState // Pacing of stored messages, nil once caught up.
+	replaySpeed     float64      // Replay speed of the group, stored with each member.
+	paused          bool         // No new message is delivered to the group while set.
+	filter          *msgFilter   // Messages that do not match are not delivered.
 }
 
 // When doing message redelivery due to ack expiration, the function
@@ -852,12 +888,25 @@ type subState struct {
 
 	rdlvCount map[uint64]uint32 // Used only when not a queue sub, otherwise queueState's rldvCount is used.
 
+	replay *replayState // Used only when not a queue sub, otherwise queueState's replay is used.
+
 	// So far, compacting these booleans into a byte flag would not save space.
 	// May change if we need to add more.
 	initialized bool // false until the subscription response has been sent to prevent data to be sent too early.
 	stalled     bool
-	newOnHold   bool // Prevents delivery of new msgs until old are redelivered (on restart)
-	hasFailedHB bool // This is set when server sends heartbeat to this subscriber's client.
+	newOnHold   bool       // Prevents delivery of new msgs until old are redelivered (on restart)
+	hasFailedHB bool       // This is set when server sends heartbeat to this subscriber's client.
+	paused      bool       // No new message is delivered while set. Not used for queue subs.
+	filter      *msgFilter // Messages that do not match are not delivered. Not used for queue subs.
+}
+
+// Holds the state of a subscription (or queue group) that replays stored
+// messages at their original inter-arrival pacing, scaled by speed.
+type replayState struct {
+	speed      float64
+	lastTs     int64     // timestamp of the last message delivered in replay mode
+	lastSentAt time.Time // time at which that message was delivered
+	timer      *time.Timer
 }
 
 type subSentAndAck struct {
@@ -1130,6 +1179,8 @@ func (ss *subStore) Remove(c *channel, sub *subState, unsubscribe bool) {
 	subid := sub.ID
 	store := sub.store
 	sub.stopAckSub()
+	sub.replay.stop()
+	sub.replay = nil
 	inbox := sub.Inbox
 	sub.Unlock()
 
@@ -1175,6 +1226,8 @@ func (ss *subStore) Remove(c *channel, sub *subState, unsubscribe bool) {
 		qs.subs, _ = sub.deleteFromList(qs.subs)
 		if len(qs.subs) == 0 {
 			queueGroupIsEmpty = true
+			qs.replay.stop()
+			qs.replay = nil
 			// If it was the last being removed, also remove the
 			// queue group from the subStore map, but only if
 			// non durable or explicit unsubscribe.
@@ -1357,6 +1410,8 @@ type Options struct {
 	FilestoreDir       string
 	FileStoreOpts      stores.FileStoreOptions
 	SQLStoreOpts       stores.SQLStoreOptions
+	BoltStoreOpts      stores.BoltStoreOptions
+	HybridMemBudget    int64         // Memory used by the most recent messages of all channels with the HYBRID store type.
 	stores.StoreLimits               // Store limits (MaxChannels, etc..)
 	EnableLogging      bool          // Enables logging
 	CustomLogger       logger.Logger // Server will start with the provided logger
@@ -1386,23 +1441,48 @@ type Options struct {
 	Encrypt            bool          // Specify if server should encrypt messages payload when storing them
 	EncryptionCipher   string        // Cipher used for encryption. Supported are "AES" and "CHACHA". If none is specified, defaults to AES on platforms with Intel processors, CHACHA otherwise.
 	EncryptionKey      []byte        // Encryption key. The environment NATS_STREAMING_ENCRYPTION_KEY takes precedence and is the preferred way to provide the key.
+	EncryptionKeysDir  string        // Directory of the keys of channels configured with a dedicated key. Deleting a channel deletes its key.
+	Compression        string        // Codec used to compress messages payload when storing them. Supported is "DEFLATE". Empty or "NONE" disables compression.
+	BackupDir          string        // Directory in which backups requested through the monitoring endpoint (see MonitorAdmin) are created.
+	RestoreFrom        string        // Backup directory to restore the state from when starting with an empty store. Not supported in clustering mode.
+	MonitorAdmin       bool          // Enable the administrative endpoints of the monitoring server (pause and resume of subscriptions, backup, export and import of messages). Off by default since these endpoints are not authenticated.
+	PubRateMaxDelay    time.Duration // If set, a message exceeding the publish rate limits is accepted and its ack delayed until the limits allow it, up to this duration. Otherwise (or beyond it), the message is rejected.
 	Clustering         ClusteringOptions
 	NATSClientOpts     []nats.Option
 	ReplaceDurable     bool // If true, the subscription request for a durable subscription will replace the current durable instead of failing with duplicate durable error.
+	StoreMetrics       bool // Record latency histograms of the store operations, reported in /streaming/storez.
+	// If set, and StoreMetrics is enabled, invoked after each store operation.
+	StoreOpHook stores.StoreOpHook
+	// If set, the store implementation is wrapped in a FaultStore that
+	// injects the failures configured in this FaultInjector. For testing only.
+	StoreFaultInjector *stores.FaultInjector
+	// Codec per channel (wildcards allowed), overriding Compression.
+	ChannelCompression map[string]string
+	// Keys that are no longer used to encrypt, but still needed to decrypt
+	// payloads stored before the key was rotated. The environment
+	// NATS_STREAMING_ENCRYPTION_OLD_KEYS takes precedence.
+	EncryptionOldKeys [][]byte
 }
 
 // Clone returns a deep copy of the Options object.
 func (o *Options) Clone() *Options {
 	// A simple copy covers pretty much everything
 	clone := *o
-	// But we have the problem of the PerChannel map that needs
-	// to be copied.
+	// But we have the problem of the PerChannel and PerClient maps
+	// that need to be copied.
 	clone.PerChannel = (&o.StoreLimits).ClonePerChannelMap()
+	clone.PerClient = (&o.StoreLimits).ClonePerClientMap()
 	// Make a copy of the clustering peers
 	if len(o.Clustering.Peers) > 0 {
 		clone.Clustering.Peers = make([]string, 0, len(o.Clustering.Peers))
 		clone.Clustering.Peers = append(clone.Clustering.Peers, o.Clustering.Peers...)
 	}
+	if o.ChannelCompression != nil {
+		clone.ChannelCompression = make(map[string]string, len(o.ChannelCompression))
+		for k, v := range o.ChannelCompression {
+			clone.ChannelCompression[k] = v
+		}
+	}
 	return &clone
 }
 
@@ -1412,6 +1492,7 @@ var defaultOptions = Options{
 	DiscoverPrefix:    DefaultDiscoverPrefix,
 	StoreType:         DefaultStoreType,
 	FileStoreOpts:     stores.DefaultFileStoreOptions,
+	BoltStoreOpts:     *stores.DefaultBoltStoreOptions(),
 	IOBatchSize:       DefaultIOBatchSize,
 	IOSleepTime:       DefaultIOSleepTime,
 	ClientHBInterval:  DefaultHeartBeatInterval,
@@ -1675,6 +1756,83 @@ func RunServer(ID string) (*StanServer, error) {
 	return RunServerWithOpts(sOpts, &nOpts)
 }
 
+// NewStore creates the store configured in `opts`, with encryption and
+// compression if enabled, the same way the server does when not clustered.
+// This is meant for tools that work on a store while the server is stopped.
+// The returned store has not been recovered.
+func NewStore(log logger.Logger, opts *Options) (stores.Store, error) {
+	store, err := newStore(log, opts, &opts.StoreLimits)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := wrapStore(store, opts, false)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return wrapped, nil
+}
+
+// newStore creates the store for the store type in `opts`.
+func newStore(log logger.Logger, opts *Options, limits *stores.StoreLimits) (stores.Store, error) {
+	// Ensure store type option is in upper-case
+	opts.StoreType = strings.ToUpper(opts.StoreType)
+
+	switch opts.StoreType {
+	case stores.TypeFile:
+		return stores.NewFileStore(log, opts.FilestoreDir, limits,
+			stores.AllOptions(&opts.FileStoreOpts))
+	case stores.TypeSQL:
+		return stores.NewSQLStore(log, opts.SQLStoreOpts.Driver, opts.SQLStoreOpts.Source,
+			limits, stores.SQLAllOptions(&opts.SQLStoreOpts))
+	case stores.TypeBolt:
+		return stores.NewBoltStore(log, opts.FilestoreDir, limits,
+			stores.BoltAllOptions(&opts.BoltStoreOpts))
+	case stores.TypeHybrid:
+		return stores.NewHybridStore(log, opts.FilestoreDir, limits, opts.HybridMemBudget,
+			stores.AllOptions(&opts.FileStoreOpts))
+	case stores.TypeMemory:
+		return stores.NewMemoryStore(log, limits)
+	}
+	return nil, fmt.Errorf("unsupported store type: %v", opts.StoreType)
+}
+
+// wrapStore wraps `store` with a CryptoStore if configured in `opts`, and
+// with a CompressedStore. The latter is always used so that payloads that
+// have been compressed remain readable after compression is disabled (it
+// does nothing if compression has never been enabled). The encryption keys
+// are erased unless `keepKey` is true.
+func wrapStore(store stores.Store, opts *Options, keepKey bool) (stores.Store, error) {
+	var err error
+	if opts.Encrypt || len(opts.EncryptionKey) > 0 {
+		var key []byte
+		oldKeys := opts.EncryptionOldKeys
+		if keepKey && len(opts.EncryptionKey) > 0 {
+			key = append(key, opts.EncryptionKey...)
+		} else {
+			key = opts.EncryptionKey
+		}
+		if keepKey && len(oldKeys) > 0 {
+			oldKeys = make([][]byte, len(opts.EncryptionOldKeys))
+			for i, k := range opts.EncryptionOldKeys {
+				oldKeys[i] = append([]byte(nil), k...)
+			}
+		}
+		store, err = stores.NewCryptoStoreWithOldKeys(store, opts.EncryptionCipher, key, oldKeys,
+			stores.ChannelKeysDir(opts.EncryptionKeysDir))
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Wrap after encryption so that payloads are compressed first,
+	// encrypted data does not compress.
+	cs, err := stores.NewCompressedStore(store, opts.Compression, opts.ChannelCompression)
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
 // RunServerWithOpts allows you to run a NATS Streaming Server with full control
 // on the Streaming and NATS Server configuration.
 func RunServerWithOpts(stanOpts *Options, natsOpts *server.Options) (newServer *StanServer, returnedError error) {
@@ -1703,6 +1861,7 @@ func RunServerWithOpts(stanOpts *Options, natsOpts *server.Options) (newServer *
 		}
 		// Override store sync configuration with cluster sync.
 		sOpts.FileStoreOpts.DoSync = sOpts.Clustering.Sync
+		sOpts.BoltStoreOpts.NoSync = !sOpts.Clustering.Sync
 
 		// Remove cluster's node ID (if present) from the list of peers.
 		if len(sOpts.Clustering.Peers) > 0 && sOpts.Clustering.NodeID != "" {
@@ -1788,25 +1947,27 @@ func RunServerWithOpts(stanOpts *Options, natsOpts *server.Options) (newServer *
 		store stores.Store
 	)
 
-	// Ensure store type option is in upper-case
-	sOpts.StoreType = strings.ToUpper(sOpts.StoreType)
-
-	// Create the store.
-	switch sOpts.StoreType {
-	case stores.TypeFile:
-		store, err = stores.NewFileStore(s.log, sOpts.FilestoreDir, storeLimits,
-			stores.AllOptions(&sOpts.FileStoreOpts))
-	case stores.TypeSQL:
-		store, err = stores.NewSQLStore(s.log, sOpts.SQLStoreOpts.Driver, sOpts.SQLStoreOpts.Source,
-			storeLimits, stores.SQLAllOptions(&sOpts.SQLStoreOpts))
-	case stores.TypeMemory:
-		store, err = stores.NewMemoryStore(s.log, storeLimits)
-	default:
-		err = fmt.Errorf("unsupported store type: %v", sOpts.StoreType)
-	}
+	store, err = newStore(s.log, sOpts, storeLimits)
 	if err != nil {
 		return nil, err
 	}
+	if sOpts.FileStoreOpts.LazyRecovery {
+		switch st := store.(type) {
+		case *stores.FileStore:
+			s.lazyFileStore = st
+		case *stores.HybridStore:
+			s.lazyFileStore, _ = st.Store.(*stores.FileStore)
+		}
+	}
+	if sOpts.StoreFaultInjector != nil {
+		store = stores.NewFaultStore(store, sOpts.StoreFaultInjector)
+	}
+	if sOpts.StoreMetrics {
+		// Wrap the store implementation directly so that the time spent
+		// encrypting or compressing is not counted.
+		s.storeMetrics = stores.NewMetricsStore(store, sOpts.StoreOpHook)
+		store = s.storeMetrics
+	}
 	// StanServer.store (s.store here) is of type stores.Store, which is an
 	// interface. If we assign s.store in the call of the constructor and there
 	// is an error, although the call returns "nil" for the store, we can no
@@ -1821,19 +1982,11 @@ func RunServerWithOpts(stanOpts *Options, natsOpts *server.Options) (newServer *
 		// raft logs.
 		store = stores.NewRaftStore(s.log, store, storeLimits)
 	}
-	if sOpts.Encrypt || len(sOpts.EncryptionKey) > 0 {
-		// In clustering mode, RAFT is using its own logs (not the one above),
-		// so we need to keep the key intact until we call newRaftLog().
-		var key []byte
-		if s.isClustered && len(sOpts.EncryptionKey) > 0 {
-			key = append(key, sOpts.EncryptionKey...)
-		} else {
-			key = sOpts.EncryptionKey
-		}
-		store, err = stores.NewCryptoStore(store, sOpts.EncryptionCipher, key)
-		if err != nil {
-			return nil, err
-		}
+	// In clustering mode, RAFT is using its own logs (not the one above),
+	// so we need to keep the encryption key intact until we call newRaftLog().
+	store, err = wrapStore(store, sOpts, s.isClustered)
+	if err != nil {
+		return nil, err
 	}
 	s.store = store
 
@@ -1843,6 +1996,9 @@ func RunServerWithOpts(stanOpts *Options, natsOpts *server.Options) (newServer *
 	s.startIOLoop()
 
 	s.clients = newClientStore(s.store)
+	if len(s.opts.PerClient) > 0 {
+		s.clients.limits = s.opts.StoreLimits.Clone()
+	}
 	s.channels = newChannelStore(&s, s.store)
 
 	// If no NATS server url is provided, it means that we embed the NATS Server
@@ -2033,6 +2189,9 @@ RECOVER:
 			return fmt.Errorf("cluster ID %q does not match recovered value of %q",
 				s.opts.ID, s.info.ClusterID)
 		}
+		if isIncompleteRestore(recoveredState) {
+			return ErrIncompleteRestore
+		}
 		// Check to see if SubClose subject is present or not.
 		// If not, it means we recovered from an older server, so
 		// need to update.
@@ -2099,6 +2258,24 @@ RECOVER:
 			goto RECOVER
 		}
 	}
+	if recoveredState == nil && s.opts.RestoreFrom != "" {
+		if s.isClustered {
+			return fmt.Errorf("restoring from a backup is not supported in clustering mode")
+		}
+		s.log.Noticef("Restoring the state from backup %q...", s.opts.RestoreFrom)
+		recoveredState, err = s.restoreFromBackup(s.opts.RestoreFrom)
+		if err != nil {
+			return fmt.Errorf("unable to restore from backup %q: %v", s.opts.RestoreFrom, err)
+		}
+		s.processRecoveredClients(recoveredState.Clients)
+		recoveredSubs, err = s.processRecoveredChannels(recoveredState.Channels)
+		if err != nil {
+			return err
+		}
+		s.log.Noticef("Restored %v channel(s)", len(recoveredState.Channels))
+	} else if s.opts.RestoreFrom != "" {
+		s.log.Noticef("Store is not empty, not restoring from backup %q", s.opts.RestoreFrom)
+	}
 
 	// We don't do the check if we are running FT and/or if
 	// static channels (partitioning) is in play.
@@ -2664,6 +2841,12 @@ func (s *StanServer) recoverOneSub(c *channel, recSub *spb.SubState, pendingAcks
 		} else {
 			// Add this subscription to subStore.
 			c.ss.updateState(sub)
+			// The filter has been validated when the subscription was created.
+			filter, err := newMsgFilter(sub.Filter)
+			if err != nil {
+				s.log.Errorf("Unable to restore filter %q of subscription %v on %q: %v", sub.Filter, sub.ID, c.name, err)
+			}
+			sub.setDeliveryOptions(sub.ReplaySpeed, filter)
 			// Add to the array, unless this is the shadow durable queue sub that
 			// was left in the store in order to maintain the group's state.
 			if !sub.isShadowQueueDurable() {
@@ -3450,6 +3633,14 @@ func (s *StanServer) processClientPublish(m *nats.Msg) {
 		return
 	}
 
+	if err := s.checkClientPubRate(iopm); err != nil {
+		if s.debug {
+			s.log.Debugf("[Client:%s] Rejected message subj=%s guid=%s: %v", pm.ClientID, pm.Subject, pm.Guid, err)
+		}
+		s.sendPublishErr(m.Reply, pm.Guid, err)
+		return
+	}
+
 	s.ioChannel <- iopm
 }
 
@@ -3628,6 +3819,19 @@ func (a byExpire) Less(i, j int) bool {
 	return a[i].expire < a[j].expire
 }
 
+// Returns the lowest sequence of the messages pending acknowledgment,
+// or 0 if there is none.
+// Sub lock held on entry.
+func (sub *subState) getOldestPendingSeq() uint64 {
+	var oldest uint64
+	for seq := range sub.acksPending {
+		if oldest == 0 || seq < oldest {
+			oldest = seq
+		}
+	}
+	return oldest
+}
+
 // Returns an array of pendingMsg ordered by expiration date, unless
 // the expiration date in the pendingMsgs map is not set (0), which
 // happens after a server restart. In this case, the array is ordered
@@ -4199,6 +4403,28 @@ func (s *StanServer) sendMsgToSub(sub *subState, m *pb.MsgProto, force bool) (bo
 		return false, false
 	}
 
+	// Messages that do not match the filter are skipped as if they had been
+	// delivered and acknowledged. Redeliveries have already been matched.
+	if !force && !m.Redelivered && !sub.getFilter().matches(m) {
+		if m.Sequence > sub.LastSent {
+			sub.LastSent = m.Sequence
+		}
+		return true, true
+	}
+
+	// In replay mode, hold the message until it is due. Redeliveries are
+	// never paced. Note that the sub is not marked as stalled, the replay
+	// timer will resume delivery.
+	var rs *replayState
+	if !force && !m.Redelivered {
+		if rs = sub.getReplayState(); rs != nil {
+			if wait := rs.delay(m.Timestamp); wait > 0 {
+				s.scheduleReplay(sub, rs, wait)
+				return false, false
+			}
+		}
+	}
+
 	if s.trace {
 		var action string
 		if m.Redelivered {
@@ -4228,6 +4454,11 @@ func (s *StanServer) sendMsgToSub(sub *subState, m *pb.MsgProto, force bool) (bo
 	atomic.AddInt64(&s.stats.outMsgs, 1)
 	atomic.AddInt64(&s.stats.outBytes, int64(len(b)))
 
+	if rs != nil {
+		rs.lastTs = m.Timestamp
+		rs.lastSentAt = time.Now()
+	}
+
 	// Setup the ackTimer as needed now. I don't want to use defer in this
 	// function, and want to make sure that if we exit before the end, the
 	// timer is set. It will be adjusted/stopped as needed.
@@ -4284,6 +4515,102 @@ func (s *StanServer) sendMsgToSub(sub *subState, m *pb.MsgProto, force bool) (bo
 	return true, true
 }
 
+// Returns the replay state of this subscription, or the one of its queue group.
+// sub's lock is held on entry, and if it is a queue sub, qstate's lock is held too.
+func (sub *subState) getReplayState() *replayState {
+	if sub.qstate != nil {
+		return sub.qstate.replay
+	}
+	return sub.replay
+}
+
+// Returns the filter of this subscription, or the one of its queue group.
+// sub's lock is held on entry, and if it is a queue sub, qstate's lock is held too.
+func (sub *subState) getFilter() *msgFilter {
+	if sub.qstate != nil {
+		return sub.qstate.filter
+	}
+	return sub.filter
+}
+
+// Sets the replay speed and filter of this subscription. For a queue
+// subscription, these are properties of the group and are set only by its
+// first member. A speed of 0 disables replay mode.
+func (sub *subState) setDeliveryOptions(speed float64, filter *msgFilter) {
+	sub.Lock()
+	qs := sub.qstate
+	if qs == nil {
+		sub.replay.stop()
+		sub.replay = newReplayState(speed)
+		sub.filter = filter
+		sub.Unlock()
+		return
+	}
+	sub.Unlock()
+	qs.Lock()
+	if len(qs.subs) == 1 {
+		qs.replay.stop()
+		qs.replay = newReplayState(speed)
+		qs.replaySpeed = speed
+		qs.filter = filter
+	}
+	qs.Unlock()
+}
+
+// Returns a replayState for the given speed, or nil if speed is not positive.
+func newReplayState(speed float64) *replayState {
+	if speed <= 0 {
+		return nil
+	}
+	return &replayState{speed: speed}
+}
+
+// Returns how long to wait before a message with the given timestamp is due.
+// The first message of a replay is always due immediately.
+func (rs *replayState) delay(ts int64) time.Duration {
+	if rs.lastSentAt.IsZero() || ts <= rs.lastTs {
+		return 0
+	}
+	gap := time.Duration(float64(ts-rs.lastTs) / rs.speed)
+	return time.Until(rs.lastSentAt.Add(gap))
+}
+
+// Stops the replay timer, if any. Lock protecting rs is held on entry.
+func (rs *replayState) stop() {
+	if rs != nil && rs.timer != nil {
+		rs.timer.Stop()
+		rs.timer = nil
+	}
+}
+
+// Schedules delivery of available messages once the next replayed message
+// is due. Does nothing if a timer is already pending.
+// sub's lock is held on entry, and if it is a queue sub, qstate's lock is held too.
+func (s *StanServer) scheduleReplay(sub *subState, rs *replayState, wait time.Duration) {
+	if rs.timer != nil {
+		return
+	}
+	subject, qs := sub.subject, sub.qstate
+	rs.timer = time.AfterFunc(wait, func() {
+		c := s.channels.get(subject)
+		if qs != nil {
+			qs.Lock()
+			rs.timer = nil
+			qs.Unlock()
+			if c != nil {
+				s.sendAvailableMessagesToQueue(c, qs)
+			}
+			return
+		}
+		sub.Lock()
+		rs.timer = nil
+		sub.Unlock()
+		if c != nil {
+			s.sendAvailableMessages(c, sub)
+		}
+	})
+}
+
 // Will set the redelivery count for this message that is ready to be redelivered.
 // sub's lock is held on entry, and if it is a queue sub, qstate's lock is held too.
 func (sub *subState) updateRedeliveryCount(m *pb.MsgProto) {
@@ -4347,7 +4674,9 @@ func (s *StanServer) ioLoop(ready *sync.WaitGroup) {
 			// was applied, so we can fail all published messages.
 			if err != nil {
 				for _, iopm := range iopms {
-					s.logErrAndSendPublishErr(iopm, err)
+					if !iopm.rejected {
+						s.logErrAndSendPublishErr(iopm, err)
+					}
 				}
 			} else {
 				for c, f := range futuresMap {
@@ -4379,6 +4708,9 @@ func (s *StanServer) ioLoop(ready *sync.WaitGroup) {
 				// (same for all iopms of the same channel) we fail the
 				// corresponding publishers.
 				for _, iopm := range iopms {
+					if iopm.rejected {
+						continue
+					}
 					// We can call Error() again, this is not a problem.
 					if err := futuresMap[iopm.c].Error(); err != nil {
 						s.logErrAndSendPublishErr(iopm, err)
@@ -4392,6 +4724,10 @@ func (s *StanServer) ioLoop(ready *sync.WaitGroup) {
 				pm := &iopm.pm
 				c, err := s.lookupOrCreateChannel(pm.Subject)
 				if err == nil {
+					if err = s.checkChannelPubRate(c, iopm); err != nil {
+						s.sendPublishErr(iopm.m.Reply, pm.Guid, err)
+						continue
+					}
 					msg := c.pubMsgToMsgProto(pm, c.nextSequence)
 					_, err = c.store.Msgs.Store(msg)
 				}
@@ -4553,6 +4889,11 @@ func (s *StanServer) replicate(iopms []*ioPendingMsg) (map[*channel]raft.Future,
 		if err != nil {
 			return nil, err
 		}
+		if err := s.checkChannelPubRate(c, iopm); err != nil {
+			iopm.rejected = true
+			s.sendPublishErr(iopm.m.Reply, pm.Guid, err)
+			continue
+		}
 		msg := c.pubMsgToMsgProto(pm, c.nextSequence)
 		batch := batches[c]
 		if batch == nil {
@@ -4589,6 +4930,15 @@ func (s *StanServer) ackPublisher(iopm *ioPendingMsg) {
 		pm := &iopm.pm
 		s.log.Tracef("[Client:%s] Acking Publisher subj=%s guid=%s", pm.ClientID, pm.Subject, pm.Guid)
 	}
+	// If the message was accepted over the publish rate limits, hold
+	// the ack until the limits allow it. Since tmpBuf is reused, the
+	// timer needs its own copy of the ack.
+	if delay := time.Until(iopm.ackAt); delay > 0 {
+		ack := append([]byte(nil), s.tmpBuf[:n]...)
+		reply := iopm.m.Reply
+		time.AfterFunc(delay, func() { s.ncs.Publish(reply, ack) })
+		return
+	}
 	s.ncs.Publish(iopm.m.Reply, s.tmpBuf[:n])
 }
 
@@ -5050,6 +5400,19 @@ func (s *StanServer) processSub(c *channel, sr *pb.SubscriptionRequest, ackInbox
 				sub = qs.shadow
 				qs.shadow = nil
 				qs.subs = append(qs.subs, sub)
+			} else if len(qs.subs) > 0 {
+				// The filter is a property of the group, so all members
+				// must use the same.
+				if qs.filter.expression() != strings.TrimSpace(sr.Filter) {
+					qs.Unlock()
+					ss.Unlock()
+					s.log.Errorf("[Client:%s] Filter %q does not match the one of queue group %q (%q) from %s",
+						sr.ClientID, sr.Filter, sr.QGroup, qs.filter.expression(), sr.Subject)
+					return nil, ErrQueueFilter
+				}
+				// Store the replay speed of the group with this member,
+				// so that it is restored whichever member is recovered first.
+				sr.ReplaySpeed = qs.replaySpeed
 			}
 			qs.Unlock()
 			setStartPos = false
@@ -5085,6 +5448,8 @@ func (s *StanServer) processSub(c *channel, sr *pb.SubscriptionRequest, ackInbox
 		// Use some of the new options, but ignore the ones regarding start position
 		sub.MaxInFlight = sr.MaxInFlight
 		sub.AckWaitInSecs = sr.AckWaitInSecs
+		sub.ReplaySpeed = sr.ReplaySpeed
+		sub.Filter = sr.Filter
 		sub.ackWait = computeAckWait(sr.AckWaitInSecs)
 		sub.stalled = false
 		if len(sub.acksPending) > 0 {
@@ -5128,6 +5493,8 @@ func (s *StanServer) processSub(c *channel, sr *pb.SubscriptionRequest, ackInbox
 				AckWaitInSecs: sr.AckWaitInSecs,
 				DurableName:   sr.DurableName,
 				IsDurable:     isDurable,
+				ReplaySpeed:   sr.ReplaySpeed,
+				Filter:        sr.Filter,
 			},
 			subject:     sr.Subject,
 			ackWait:     computeAckWait(sr.AckWaitInSecs),
@@ -5159,6 +5526,11 @@ func (s *StanServer) processSub(c *channel, sr *pb.SubscriptionRequest, ackInbox
 		}
 	}
 	ss.Unlock()
+	if err == nil {
+		// The filter has been validated with the request.
+		filter, _ := newMsgFilter(sr.Filter)
+		sub.setDeliveryOptions(sr.ReplaySpeed, filter)
+	}
 	if err == nil && (!s.isClustered || s.isLeader()) {
 		err = sub.startAckSub(s.nca, s.processAckMsg)
 		if err == nil {
@@ -5219,6 +5591,22 @@ func (s *StanServer) processSubscriptionRequest(m *nats.Msg) {
 		return
 	}
 
+	// ReplaySpeed must be >= 0 (0 means no pacing)
+	if sr.ReplaySpeed < 0 || math.IsNaN(sr.ReplaySpeed) || math.IsInf(sr.ReplaySpeed, 0) {
+		s.log.Errorf("[Client:%s] Invalid ReplaySpeed (%v) in subscription request from %s",
+			sr.ClientID, sr.ReplaySpeed, m.Subject)
+		s.sendSubscriptionResponseErr(m.Reply, ErrInvalidReplaySpeed)
+		return
+	}
+
+	// Filter, if any, must compile
+	if _, err := newMsgFilter(sr.Filter); err != nil {
+		s.log.Errorf("[Client:%s] %v in subscription request from %s",
+			sr.ClientID, err, m.Subject)
+		s.sendSubscriptionResponseErr(m.Reply, ErrInvalidFilter)
+		return
+	}
+
 	// StartPosition between StartPosition_NewOnly and StartPosition_First
 	if sr.StartPosition < pb.StartPosition_NewOnly || sr.StartPosition > pb.StartPosition_First {
 		s.log.Errorf("[Client:%s] Invalid StartPosition (%v) in subscription request from %s",
@@ -5608,14 +5996,20 @@ func (s *StanServer) sendAvailableMessagesToQueue(c *channel, qs *queueState) {
 		qs.Unlock()
 		return
 	}
-	// If redelivery at startup in progress, don't attempt to deliver new messages
-	if qs.newOnHold {
+	// If redelivery at startup in progress or the group is paused,
+	// don't attempt to deliver new messages
+	if qs.newOnHold || qs.paused {
 		qs.Unlock()
 		return
 	}
 	for nextSeq := qs.lastSent + 1; qs.stalledSubCount < len(qs.subs); nextSeq++ {
 		nextMsg := s.getNextMsg(c, &nextSeq, &qs.lastSent)
 		if nextMsg == nil {
+			// Caught up with the channel, switch to live delivery.
+			if qs.replay != nil {
+				qs.replay.stop()
+				qs.replay = nil
+			}
 			break
 		}
 		if _, sent := s.sendMsgToQueueGroup(qs, nextMsg, honorMaxInFlight); !sent {
@@ -5628,9 +6022,14 @@ func (s *StanServer) sendAvailableMessagesToQueue(c *channel, qs *queueState) {
 // Send any messages that are ready to be sent that have been queued.
 func (s *StanServer) sendAvailableMessages(c *channel, sub *subState) {
 	sub.Lock()
-	for nextSeq := sub.LastSent + 1; !sub.stalled; nextSeq++ {
+	for nextSeq := sub.LastSent + 1; !sub.stalled && !sub.paused; nextSeq++ {
 		nextMsg := s.getNextMsg(c, &nextSeq, &sub.LastSent)
 		if nextMsg == nil {
+			// Caught up with the channel, switch to live delivery.
+			if sub.replay != nil {
+				sub.replay.stop()
+				sub.replay = nil
+			}
 			break
 		}
 		if sent, sendMore := s.sendMsgToSub(sub, nextMsg, honorMaxInFlight); !sent || !sendMore {
@@ -5742,8 +6141,15 @@ func (s *StanServer) setSubStartSequence(c *channel, sr *pb.SubscriptionRequest)
 		}
 		// StartSequence is an uint64, so can't be lower than 0.
 		if sr.StartSequence < firstSeq {
-			// That translates to sending the first message available.
+			// That translates to sending the first message available,
+			// unless the store can still look up older messages (for
+			// instance from archived file slices).
 			lastSent = firstSeq - 1
+			if sr.StartSequence > 0 {
+				if m, _ := c.store.Msgs.Lookup(sr.StartSequence); m != nil {
+					lastSent = sr.StartSequence - 1
+				}
+			}
 		} else if sr.StartSequence > lastSeq {
 			// That translates to "new only"
 			lastSent = lastSeq
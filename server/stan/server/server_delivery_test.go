@@ -15,6 +15,7 @@ package server
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -126,6 +127,337 @@ func TestStalledDurableDelivery(t *testing.T) {
 	testStalledDelivery(t, "durable")
 }
 
+func testReplayDelivery(t *testing.T, typeSub string) {
+	s := runServer(t, clusterName)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	// Store messages spaced by gap.
+	gap := 300 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			time.Sleep(gap)
+		}
+		if err := sc.Publish("foo", []byte("hello")); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	var times []time.Time
+	ch := make(chan bool, 1)
+	cb := func(m *stan.Msg) {
+		mu.Lock()
+		times = append(times, time.Now())
+		n := len(times)
+		mu.Unlock()
+		if n == 3 || n == 4 {
+			ch <- true
+		}
+	}
+	// Replay at twice the original pace.
+	opts := []stan.SubscriptionOption{stan.DeliverAllAvailable(), stan.ReplaySpeed(2)}
+	var err error
+	if typeSub == "queue" {
+		_, err = sc.QueueSubscribe("foo", "group", cb, opts...)
+	} else {
+		_, err = sc.Subscribe("foo", cb, opts...)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	if err := Wait(ch); err != nil {
+		t.Fatal("Did not get our messages")
+	}
+	mu.Lock()
+	elapsed := times[2].Sub(times[0])
+	mu.Unlock()
+	// Two gaps replayed at twice the speed.
+	expected := gap
+	if elapsed < expected-20*time.Millisecond || elapsed > expected+gap {
+		t.Fatalf("Replay should have taken about %v, took %v", expected, elapsed)
+	}
+
+	// Now that the subscription is caught up, a new message should
+	// be delivered right away.
+	start := time.Now()
+	if err := sc.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error on publish: %v", err)
+	}
+	if err := Wait(ch); err != nil {
+		t.Fatal("Did not get our live message")
+	}
+	if dur := time.Since(start); dur > gap/2 {
+		t.Fatalf("Live message should not be paced, took %v", dur)
+	}
+}
+
+func TestReplayDelivery(t *testing.T) {
+	testReplayDelivery(t, "sub")
+}
+
+func TestReplayQueueDelivery(t *testing.T) {
+	testReplayDelivery(t, "queue")
+}
+
+func testFilterDelivery(t *testing.T, typeSub string) {
+	s := runServer(t, clusterName)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	ch := make(chan *stan.Msg, 10)
+	cb := func(m *stan.Msg) {
+		ch <- m
+	}
+	// With a MaxInflight of 1 and no ack, the subscription would stall
+	// if filtered out messages were considered pending.
+	opts := []stan.SubscriptionOption{
+		stan.DeliverAllAvailable(),
+		stan.SetManualAckMode(),
+		stan.MaxInflight(1),
+		stan.Filter(`data.type == "order" && data.total >= 100`),
+	}
+	var err error
+	if typeSub == "queue" {
+		_, err = sc.QueueSubscribe("foo", "group", cb, opts...)
+	} else {
+		_, err = sc.Subscribe("foo", cb, opts...)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	payloads := []string{
+		`{"type":"order","total":10}`,
+		`not json`,
+		`{"type":"refund","total":500}`,
+		`{"type":"order","total":150}`,
+	}
+	for _, p := range payloads {
+		if err := sc.Publish("foo", []byte(p)); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+	select {
+	case m := <-ch:
+		if m.Sequence != 4 {
+			t.Fatalf("Expected message 4, got %v: %s", m.Sequence, m.Data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not get our message")
+	}
+	select {
+	case m := <-ch:
+		t.Fatalf("Unexpected message %v: %s", m.Sequence, m.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+	subs := s.clients.getSubs(clientName)
+	if len(subs) != 1 {
+		t.Fatalf("Expected 1 sub, got %v", len(subs))
+	}
+	sub := subs[0]
+	sub.RLock()
+	lastSent, pending := sub.LastSent, len(sub.acksPending)
+	sub.RUnlock()
+	if lastSent != 4 || pending != 1 {
+		t.Fatalf("Expected last sent 4 and 1 pending, got %v and %v", lastSent, pending)
+	}
+}
+
+func TestFilterDelivery(t *testing.T) {
+	testFilterDelivery(t, "sub")
+}
+
+func TestFilterQueueDelivery(t *testing.T) {
+	testFilterDelivery(t, "queue")
+}
+
+func TestFilterQueueMismatch(t *testing.T) {
+	s := runServer(t, clusterName)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	cb := func(_ *stan.Msg) {}
+	expr := `data.type == "order"`
+	if _, err := sc.QueueSubscribe("foo", "group", cb, stan.Filter(expr), stan.ReplaySpeed(2)); err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	for _, f := range []string{"", `data.type == "refund"`} {
+		if _, err := sc.QueueSubscribe("foo", "group", cb, stan.Filter(f)); err == nil || err.Error() != ErrQueueFilter.Error() {
+			t.Fatalf("Expected error %v for filter %q, got %v", ErrQueueFilter, f, err)
+		}
+	}
+	if _, err := sc.QueueSubscribe("foo", "group", cb, stan.Filter(" "+expr+" ")); err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	// Members store the replay speed of the group.
+	for _, sub := range s.clients.getSubs(clientName) {
+		sub.RLock()
+		speed, filter := sub.ReplaySpeed, sub.Filter
+		sub.RUnlock()
+		if speed != 2 || strings.TrimSpace(filter) != expr {
+			t.Fatalf("Unexpected replay speed %v and filter %q", speed, filter)
+		}
+	}
+}
+
+func TestPersistentStoreFilterRecovered(t *testing.T) {
+	cleanupDatastore(t)
+	defer cleanupDatastore(t)
+
+	opts := getTestDefaultOptsForPersistentStore()
+	s := runServerWithOpts(t, opts, nil)
+	defer shutdownRestartedServerOnTestExit(&s)
+
+	sc, nc := createConnectionWithNatsOpts(t, clientName, nats.ReconnectWait(50*time.Millisecond))
+	defer nc.Close()
+	defer sc.Close()
+
+	ch := make(chan *stan.Msg, 10)
+	cb := func(m *stan.Msg) {
+		ch <- m
+	}
+	expr := `data.type == "order"`
+	if _, err := sc.Subscribe("foo", cb, stan.DurableName("dur"), stan.Filter(expr), stan.ReplaySpeed(2)); err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	if _, err := sc.QueueSubscribe("foo", "group", cb, stan.Filter(expr), stan.ReplaySpeed(2)); err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+
+	s.Shutdown()
+	s = runServerWithOpts(t, opts, nil)
+
+	c := s.channels.get("foo")
+	c.ss.RLock()
+	qs := c.ss.qsubs["group"]
+	c.ss.RUnlock()
+	qs.RLock()
+	speed, filter := qs.replaySpeed, qs.filter.expression()
+	qs.RUnlock()
+	if speed != 2 || filter != expr {
+		t.Fatalf("Expected queue group replay speed 2 and filter %q, got %v and %q", expr, speed, filter)
+	}
+
+	// Only the matching message should be delivered, once to each subscription.
+	for _, p := range []string{`{"type":"refund"}`, `{"type":"order"}`} {
+		if err := sc.Publish("foo", []byte(p)); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-ch:
+			if m.Sequence != 2 {
+				t.Fatalf("Expected message 2, got %v: %s", m.Sequence, m.Data)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Did not get our message")
+		}
+	}
+	select {
+	case m := <-ch:
+		t.Fatalf("Unexpected message %v: %s", m.Sequence, m.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func testPauseDelivery(t *testing.T, typeSub string) {
+	s := runServer(t, clusterName)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	ch := make(chan bool, 10)
+	count := int32(0)
+	cb := func(_ *stan.Msg) {
+		atomic.AddInt32(&count, 1)
+		ch <- true
+	}
+	var err error
+	if typeSub == "queue" {
+		_, err = sc.QueueSubscribe("foo", "group", cb, stan.DurableName("dur"))
+	} else {
+		_, err = sc.Subscribe("foo", cb, stan.DurableName("dur"))
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	pause := func(paused bool) error {
+		if typeSub == "queue" {
+			if paused {
+				return s.PauseQueueGroup("foo", "dur:group")
+			}
+			return s.ResumeQueueGroup("foo", "dur:group")
+		}
+		if paused {
+			return s.PauseDurable("foo", clientName, "dur")
+		}
+		return s.ResumeDurable("foo", clientName, "dur")
+	}
+	if err := pause(true); err != nil {
+		t.Fatalf("Error on pause: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := sc.Publish("foo", []byte("hello")); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+	// Messages are stored but not delivered.
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&count); n != 0 {
+		t.Fatalf("Should not have received messages, got %v", n)
+	}
+	if n, _ := msgStoreState(t, s.channels.get("foo").store.Msgs); n != 3 {
+		t.Fatalf("Expected 3 messages stored, got %v", n)
+	}
+	if err := pause(false); err != nil {
+		t.Fatalf("Error on resume: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := Wait(ch); err != nil {
+			t.Fatal("Did not get our messages")
+		}
+	}
+	if n := atomic.LoadInt32(&count); n != 3 {
+		t.Fatalf("Expected 3 messages, got %v", n)
+	}
+}
+
+func TestPauseDurableDelivery(t *testing.T) {
+	testPauseDelivery(t, "durable")
+}
+
+func TestPauseQueueDelivery(t *testing.T) {
+	testPauseDelivery(t, "queue")
+}
+
+func TestPauseUnknownSubscription(t *testing.T) {
+	s := runServer(t, clusterName)
+	defer s.Shutdown()
+
+	if err := s.PauseDurable("foo", clientName, "dur"); err != ErrUnknownChannel {
+		t.Fatalf("Expected error %v, got %v", ErrUnknownChannel, err)
+	}
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+	if _, err := sc.Subscribe("foo", func(_ *stan.Msg) {}); err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	if err := s.PauseDurable("foo", clientName, "dur"); err != ErrUnknownSub {
+		t.Fatalf("Expected error %v, got %v", ErrUnknownSub, err)
+	}
+	if err := s.ResumeQueueGroup("foo", "group"); err != ErrUnknownSub {
+		t.Fatalf("Expected error %v, got %v", ErrUnknownSub, err)
+	}
+}
+
 func TestPersistentStoreAutomaticDeliveryOnRestart(t *testing.T) {
 	cleanupDatastore(t)
 	defer cleanupDatastore(t)
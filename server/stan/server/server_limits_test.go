@@ -805,3 +805,111 @@ func TestMaxInactivityOnConnectionClose(t *testing.T) {
 		return nil
 	})
 }
+
+func TestPubRateLimits(t *testing.T) {
+	sOpts := GetDefaultOptions()
+	sOpts.ID = clusterName
+	sOpts.AddPerChannel("foo", &stores.ChannelLimits{PubRateLimits: stores.PubRateLimits{MaxPubMsgsRate: 2}})
+	sOpts.AddPerClient("limited*", &stores.PubRateLimits{MaxPubMsgsRate: 1})
+	s := runServerWithOpts(t, sOpts, nil)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	// The channel allows a burst of 2 messages, including the
+	// one that creates the channel.
+	for i := 0; i < 2; i++ {
+		if err := sc.Publish("foo", []byte("hello")); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+	if err := sc.Publish("foo", []byte("hello")); err == nil || err.Error() != ErrChannelPubRate.Error() {
+		t.Fatalf("Expected error %q, got %v", ErrChannelPubRate, err)
+	}
+	// Other channels are not affected.
+	for i := 0; i < 5; i++ {
+		if err := sc.Publish("bar", []byte("hello")); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+	// After a second, the bucket should be full again.
+	time.Sleep(time.Second)
+	if err := sc.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error on publish: %v", err)
+	}
+
+	lsc, err := stan.Connect(clusterName, "limited1")
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer lsc.Close()
+	if err := lsc.Publish("bar", []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error on publish: %v", err)
+	}
+	if err := lsc.Publish("bar", []byte("hello")); err == nil || err.Error() != ErrClientPubRate.Error() {
+		t.Fatalf("Expected error %q, got %v", ErrClientPubRate, err)
+	}
+	// Check that the messages rejected for rate limit were not stored.
+	if n, _, _ := s.channels.msgsState("foo"); n != 3 {
+		t.Fatalf("Expected 3 messages in foo, got %v", n)
+	}
+	if n, _, _ := s.channels.msgsState("bar"); n != 6 {
+		t.Fatalf("Expected 6 messages in bar, got %v", n)
+	}
+}
+
+func TestPubRateLimitsMaxDelay(t *testing.T) {
+	sOpts := GetDefaultOptions()
+	sOpts.ID = clusterName
+	sOpts.AddPerChannel("foo", &stores.ChannelLimits{PubRateLimits: stores.PubRateLimits{MaxPubMsgsRate: 2}})
+	sOpts.PubRateMaxDelay = 700 * time.Millisecond
+	s := runServerWithOpts(t, sOpts, nil)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	// The first 2 messages are acked right away, the next ones
+	// only when the limits allow them.
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := sc.Publish("foo", []byte("hello")); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+	if dur := time.Since(start); dur < 900*time.Millisecond {
+		t.Fatalf("Expected acks to be delayed, took %v", dur)
+	}
+
+	// Messages that would need to wait more than the max delay
+	// are rejected.
+	errCh := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		if _, err := sc.PublishAsync("foo", []byte("hello"), func(_ string, err error) {
+			errCh <- err
+		}); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+	rejected := 0
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				if err.Error() != ErrChannelPubRate.Error() {
+					t.Fatalf("Expected error %q, got %v", ErrChannelPubRate, err)
+				}
+				rejected++
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Did not get our acks")
+		}
+	}
+	if rejected == 0 {
+		t.Fatal("Expected some messages to be rejected")
+	}
+	if n, _, _ := s.channels.msgsState("foo"); n != 8-rejected {
+		t.Fatalf("Expected %v messages in foo, got %v", 8-rejected, n)
+	}
+}
@@ -659,6 +659,8 @@ func TestPersistentStoreMultipleShadowQSubs(t *testing.T) {
 		store, err = stores.NewFileStore(testLogger, defaultDataStore, &limits)
 	case stores.TypeSQL:
 		store, err = stores.NewSQLStore(testLogger, testSQLDriver, testSQLSource, &limits)
+	case stores.TypeBolt:
+		store, err = stores.NewBoltStore(testLogger, defaultDataStore, &limits)
 	}
 	if err != nil {
 		t.Fatalf("Error creating store: %v", err)
@@ -15,6 +15,7 @@ package server
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"testing"
 	"time"
@@ -173,8 +174,26 @@ func TestInvalidSubRequest(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 
-	// Test invalid StartPosition values
+	// Test invalid ReplaySpeed values
 	req.MaxInFlight = 1
+	req.ReplaySpeed = -1
+	if err := sendInvalidSubRequest(s, nc, req, ErrInvalidReplaySpeed); err != nil {
+		t.Fatalf("%v", err)
+	}
+	req.ReplaySpeed = math.Inf(1)
+	if err := sendInvalidSubRequest(s, nc, req, ErrInvalidReplaySpeed); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Test invalid filter
+	req.ReplaySpeed = 0
+	req.Filter = "data.foo =="
+	if err := sendInvalidSubRequest(s, nc, req, ErrInvalidFilter); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Test invalid StartPosition values
+	req.Filter = ""
 	req.StartPosition = pb.StartPosition_NewOnly - 1
 	if err := sendInvalidSubRequest(s, nc, req, ErrInvalidStart); err != nil {
 		t.Fatalf("%v", err)
@@ -865,3 +865,45 @@ func TestSQLStoreServerStateMissing(t *testing.T) {
 		t.Fatal("Did not get warning about non recovered server state")
 	}
 }
+
+func TestStoreFaultInjector(t *testing.T) {
+	fi := stores.NewFaultInjector()
+	opts := GetDefaultOptions()
+	opts.StoreFaultInjector = fi
+	s := runServerWithOpts(t, opts, nil)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	if err := sc.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Unable to publish: %v", err)
+	}
+
+	id, err := fi.Inject(stores.Fault{Op: "Store", Channel: "foo", DiskFull: true})
+	if err != nil {
+		t.Fatalf("Error injecting fault: %v", err)
+	}
+	if err := sc.Publish("foo", []byte("hello")); err == nil || !strings.Contains(err.Error(), "no space") {
+		t.Fatalf("Expected disk full error, got %v", err)
+	}
+	// Other channels are not affected
+	if err := sc.Publish("bar", []byte("hello")); err != nil {
+		t.Fatalf("Unable to publish: %v", err)
+	}
+	fi.Remove(id)
+	if err := sc.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Unable to publish: %v", err)
+	}
+
+	fi.Inject(stores.Fault{Op: "CreateSub", Count: 1, Err: errOnPurpose})
+	if _, err := sc.Subscribe("foo", func(_ *stan.Msg) {}); err == nil || !strings.Contains(err.Error(), errOnPurpose.Error()) {
+		t.Fatalf("Expected error %v, got %v", errOnPurpose, err)
+	}
+	if _, err := sc.Subscribe("foo", func(_ *stan.Msg) {}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if n := fi.Fired(); n != 2 {
+		t.Fatalf("Expected faults to fire 2 times, got %v", n)
+	}
+}
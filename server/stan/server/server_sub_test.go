@@ -15,6 +15,7 @@ package server
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -354,6 +355,56 @@ func TestSubStartPositionSequenceStart(t *testing.T) {
 	sub.Unsubscribe()
 }
 
+func TestSubStartPositionArchivedSequence(t *testing.T) {
+	cleanupDatastore(t)
+	defer cleanupDatastore(t)
+	archiveDir := defaultDataStore + "_archive"
+	os.RemoveAll(archiveDir)
+	defer os.RemoveAll(archiveDir)
+
+	opts := GetDefaultOptions()
+	opts.StoreType = stores.TypeFile
+	opts.FilestoreDir = defaultDataStore
+	opts.FileStoreOpts.SliceMaxMsgs = 2
+	opts.FileStoreOpts.SliceArchiveDir = archiveDir
+	opts.MaxMsgs = 3
+	s := runServerWithOpts(t, opts, nil)
+	defer s.Shutdown()
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+
+	total := 10
+	for i := 0; i < total; i++ {
+		if err := sc.Publish("foo", []byte("hello")); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+	if firstSeq, _ := msgStoreFirstAndLastSequence(t, channelsGet(t, s.channels, "foo").store.Msgs); firstSeq != 8 {
+		t.Fatalf("Expected first sequence to be 8, got %v", firstSeq)
+	}
+
+	// Messages older than the first one are delivered from the archive.
+	ch := make(chan uint64, total)
+	sub, err := sc.Subscribe("foo", func(m *stan.Msg) {
+		ch <- m.Sequence
+	}, stan.StartAtSequence(2))
+	if err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	for expected := uint64(2); expected <= uint64(total); expected++ {
+		select {
+		case seq := <-ch:
+			if seq != expected {
+				t.Fatalf("Expected sequence %v, got %v", expected, seq)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Did not get message %v", expected)
+		}
+	}
+}
+
 func TestSubStartPositionTimeDelta(t *testing.T) {
 	s := runServer(t, clusterName)
 	defer s.Shutdown()
@@ -765,6 +816,8 @@ func TestPersistentStoreNonDurableSubRemovedOnConnClose(t *testing.T) {
 		store, err = stores.NewFileStore(testLogger, defaultDataStore, &limits)
 	case stores.TypeSQL:
 		store, err = stores.NewSQLStore(testLogger, testSQLDriver, testSQLSource, &limits)
+	case stores.TypeBolt:
+		store, err = stores.NewBoltStore(testLogger, defaultDataStore, &limits)
 	}
 	if err != nil {
 		t.Fatalf("Error opening file: %v", err)
@@ -41,6 +41,7 @@ import (
 	_ "github.com/go-sql-driver/mysql"                             // mysql driver
 	_ "github.com/kubemq-io/broker/server/stan/stores/pqdeadlines" // wrapper for postgres that gives read/write deadlines
 	_ "github.com/lib/pq"                                          // postgres driver
+	_ "github.com/mattn/go-sqlite3"                                // sqlite driver
 )
 
 const (
@@ -76,6 +77,8 @@ const (
 
 	testDefaultPostgresSource      = "sslmode=disable dbname=" + testDefaultDatabaseName
 	testDefaultPostgresSourceAdmin = "sslmode=disable"
+
+	testDefaultSQLiteSource = testDefaultDatabaseName
 )
 
 var (
@@ -119,7 +122,7 @@ func TestMain(m *testing.M) {
 	switch pst {
 	case "":
 	// use default
-	case stores.TypeFile, stores.TypeSQL:
+	case stores.TypeFile, stores.TypeSQL, stores.TypeBolt:
 		persistentStoreType = pst
 	default:
 		fmt.Printf("Unknown or unsupported store %q for persistent store server tests\n", pst)
@@ -134,6 +137,7 @@ func TestMain(m *testing.M) {
 		defaultSources := make(map[string][]string)
 		defaultSources[test.DriverMySQL] = []string{testDefaultMySQLSource, testDefaultMySQLSourceAdmin}
 		defaultSources[test.DriverPostgres] = []string{testDefaultPostgresSource, testDefaultPostgresSourceAdmin}
+		defaultSources[test.DriverSQLite] = []string{testDefaultSQLiteSource, ""}
 		if err := test.ProcessSQLFlags(flag.CommandLine, defaultSources); err != nil {
 			fmt.Println(err.Error())
 			os.Exit(2)
@@ -438,7 +442,7 @@ func NewDefaultConnection(t tLogger) stan.Conn {
 
 func cleanupDatastore(t tLogger) {
 	switch persistentStoreType {
-	case stores.TypeFile:
+	case stores.TypeFile, stores.TypeBolt:
 		if err := os.RemoveAll(defaultDataStore); err != nil {
 			stackFatalf(t, "Error cleaning up datastore: %v", err)
 		}
@@ -463,6 +467,8 @@ func getTestDefaultOptsForPersistentStore() *Options {
 	case stores.TypeSQL:
 		opts.SQLStoreOpts.Driver = testSQLDriver
 		opts.SQLStoreOpts.Source = testSQLSource
+	case stores.TypeBolt:
+		opts.FilestoreDir = defaultDataStore
 	default:
 		panic(fmt.Sprintf("Need to specify configuration for store: %q", persistentStoreType))
 	}
@@ -1848,3 +1854,46 @@ func TestChannelNameRejectedIfAlreadyExistsWithDifferentCase(t *testing.T) {
 		})
 	}
 }
+
+func TestHybridStoreRestart(t *testing.T) {
+	if err := os.RemoveAll(defaultDataStore); err != nil {
+		t.Fatalf("Error cleaning up datastore: %v", err)
+	}
+	defer os.RemoveAll(defaultDataStore)
+
+	opts := GetDefaultOptions()
+	opts.StoreType = stores.TypeHybrid
+	opts.FilestoreDir = defaultDataStore
+	// Small enough that some messages are moved to disk.
+	opts.HybridMemBudget = 1024
+	s := runServerWithOpts(t, opts, nil)
+	defer shutdownRestartedServerOnTestExit(&s)
+
+	if name := s.store.Name(); name != stores.TypeHybrid {
+		t.Fatalf("Expected store to be %v, got %v", stores.TypeHybrid, name)
+	}
+
+	sc := NewDefaultConnection(t)
+	defer sc.Close()
+	for i := 0; i < 20; i++ {
+		if err := sc.Publish("foo", make([]byte, 100)); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	sc.Close()
+
+	s.Shutdown()
+	s = runServerWithOpts(t, opts, nil)
+
+	c := s.channels.get("foo")
+	if c == nil {
+		t.Fatal("Channel foo should have been recovered")
+	}
+	first, last, err := c.store.Msgs.FirstAndLastSequence()
+	if err != nil {
+		t.Fatalf("Error getting sequences: %v", err)
+	}
+	if first != 1 || last != 20 {
+		t.Fatalf("Expected first/last to be 1/20, got %v/%v", first, last)
+	}
+}
@@ -112,16 +112,47 @@ func (s *serverSnapshot) Persist(sink raft.SnapshotSink) (err error) {
 		break
 	}
 
+	if err := writeSnapshot(sink, b); err != nil {
+		return err
+	}
+
+	return sink.Close()
+}
+
+// writeSnapshot writes the size of the encoded snapshot `b`, followed by `b`.
+func writeSnapshot(w io.Writer, b []byte) error {
 	var sizeBuf [4]byte
 	util.ByteOrder.PutUint32(sizeBuf[:], uint32(len(b)))
-	if _, err := sink.Write(sizeBuf[:]); err != nil {
+	if _, err := w.Write(sizeBuf[:]); err != nil {
 		return err
 	}
-	if _, err := sink.Write(b); err != nil {
-		return err
+	_, err := w.Write(b)
+	return err
+}
+
+// readSnapshot reads a snapshot written with writeSnapshot. Returns nil if
+// there is nothing to read.
+func readSnapshot(r io.Reader) (*spb.RaftSnapshot, error) {
+	sizeBuf := make([]byte, 4)
+	// Read the snapshot size.
+	if _, err := io.ReadFull(r, sizeBuf); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// Read the snapshot.
+	size := util.ByteOrder.Uint32(sizeBuf)
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
 	}
 
-	return sink.Close()
+	serverSnap := &spb.RaftSnapshot{}
+	if err := serverSnap.Unmarshal(buf); err != nil {
+		return nil, fmt.Errorf("error decoding snapshot record: %v", err)
+	}
+	return serverSnap, nil
 }
 
 func (s *serverSnapshot) snapshotClients(snap *spb.RaftSnapshot, sink raft.SnapshotSink) {
@@ -309,29 +340,13 @@ func (r *raftFSM) Restore(snapshot io.ReadCloser) (retErr error) {
 		}
 	}
 
-	sizeBuf := make([]byte, 4)
-	// Read the snapshot size.
-	if _, err := io.ReadFull(snapshot, sizeBuf); err != nil {
-		if err == io.EOF {
-			return nil
-		}
+	serverSnap, err := readSnapshot(snapshot)
+	if serverSnap == nil || err != nil {
 		return err
 	}
-	// Read the snapshot.
-	size := util.ByteOrder.Uint32(sizeBuf)
-	buf := make([]byte, size)
-	if _, err := io.ReadFull(snapshot, buf); err != nil {
-		return err
-	}
-
-	serverSnap := &spb.RaftSnapshot{}
-	if err := serverSnap.Unmarshal(buf); err != nil {
-		return fmt.Errorf("error decoding snapshot record: %v", err)
-	}
 	if err := r.restoreClientsFromSnapshot(serverSnap); err != nil {
 		return err
 	}
-	var err error
 	shouldSnapshot, err = r.restoreChannelsFromSnapshot(serverSnap, r.restoreFromInit)
 	return err
 }
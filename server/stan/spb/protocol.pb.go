@@ -4,6 +4,7 @@
 package spb
 
 import (
+	encoding_binary "encoding/binary"
 	fmt "fmt"
 	_ "github.com/gogo/protobuf/gogoproto"
 	proto "github.com/gogo/protobuf/proto"
@@ -103,17 +104,19 @@ func (RaftOperation_Type) EnumDescriptor() ([]byte, []int) {
 
 // SubState represents the state of a Subscription
 type SubState struct {
-	ID            uint64 `protobuf:"varint,1,opt,name=ID,proto3" json:"ID,omitempty"`
-	ClientID      string `protobuf:"bytes,2,opt,name=clientID,proto3" json:"clientID,omitempty"`
-	QGroup        string `protobuf:"bytes,3,opt,name=qGroup,proto3" json:"qGroup,omitempty"`
-	Inbox         string `protobuf:"bytes,4,opt,name=inbox,proto3" json:"inbox,omitempty"`
-	AckInbox      string `protobuf:"bytes,5,opt,name=ackInbox,proto3" json:"ackInbox,omitempty"`
-	MaxInFlight   int32  `protobuf:"varint,6,opt,name=maxInFlight,proto3" json:"maxInFlight,omitempty"`
-	AckWaitInSecs int32  `protobuf:"varint,7,opt,name=ackWaitInSecs,proto3" json:"ackWaitInSecs,omitempty"`
-	DurableName   string `protobuf:"bytes,8,opt,name=durableName,proto3" json:"durableName,omitempty"`
-	LastSent      uint64 `protobuf:"varint,9,opt,name=lastSent,proto3" json:"lastSent,omitempty"`
-	IsDurable     bool   `protobuf:"varint,10,opt,name=isDurable,proto3" json:"isDurable,omitempty"`
-	IsClosed      bool   `protobuf:"varint,11,opt,name=isClosed,proto3" json:"isClosed,omitempty"`
+	ID            uint64  `protobuf:"varint,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	ClientID      string  `protobuf:"bytes,2,opt,name=clientID,proto3" json:"clientID,omitempty"`
+	QGroup        string  `protobuf:"bytes,3,opt,name=qGroup,proto3" json:"qGroup,omitempty"`
+	Inbox         string  `protobuf:"bytes,4,opt,name=inbox,proto3" json:"inbox,omitempty"`
+	AckInbox      string  `protobuf:"bytes,5,opt,name=ackInbox,proto3" json:"ackInbox,omitempty"`
+	MaxInFlight   int32   `protobuf:"varint,6,opt,name=maxInFlight,proto3" json:"maxInFlight,omitempty"`
+	AckWaitInSecs int32   `protobuf:"varint,7,opt,name=ackWaitInSecs,proto3" json:"ackWaitInSecs,omitempty"`
+	DurableName   string  `protobuf:"bytes,8,opt,name=durableName,proto3" json:"durableName,omitempty"`
+	LastSent      uint64  `protobuf:"varint,9,opt,name=lastSent,proto3" json:"lastSent,omitempty"`
+	IsDurable     bool    `protobuf:"varint,10,opt,name=isDurable,proto3" json:"isDurable,omitempty"`
+	IsClosed      bool    `protobuf:"varint,11,opt,name=isClosed,proto3" json:"isClosed,omitempty"`
+	ReplaySpeed   float64 `protobuf:"fixed64,12,opt,name=replaySpeed,proto3" json:"replaySpeed,omitempty"`
+	Filter        string  `protobuf:"bytes,13,opt,name=filter,proto3" json:"filter,omitempty"`
 }
 
 func (m *SubState) Reset()         { *m = SubState{} }
@@ -820,86 +823,88 @@ func init() {
 func init() { proto.RegisterFile("protocol.proto", fileDescriptor_2bc2336598a3f7e0) }
 
 var fileDescriptor_2bc2336598a3f7e0 = []byte{
-	// 1261 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x56, 0xc1, 0x6e, 0xdb, 0x46,
-	0x10, 0x35, 0x25, 0xca, 0x92, 0x46, 0x92, 0xcd, 0x2c, 0xdc, 0x84, 0x0d, 0x02, 0x41, 0x60, 0x8b,
-	0x42, 0x45, 0x13, 0xb9, 0x51, 0x8b, 0x9e, 0x0a, 0x14, 0x8e, 0x95, 0x34, 0x2c, 0xea, 0xc4, 0x58,
-	0x25, 0x28, 0x50, 0xa0, 0x87, 0x25, 0xb5, 0x96, 0x09, 0xcb, 0x4b, 0x86, 0xbb, 0x34, 0x9c, 0x0f,
-	0x28, 0xd0, 0x63, 0xfb, 0x23, 0xfd, 0x80, 0x9e, 0x7a, 0xcc, 0x31, 0xe8, 0x29, 0xc7, 0x36, 0xf9,
-	0x91, 0x62, 0x67, 0x97, 0x14, 0x65, 0x07, 0xb9, 0xed, 0x9b, 0x99, 0x5d, 0xce, 0xbe, 0x37, 0x33,
-	0x4b, 0xd8, 0xc9, 0xf2, 0x54, 0xa5, 0x71, 0xba, 0x9a, 0xe0, 0x82, 0x34, 0x65, 0x16, 0xdd, 0xbe,
-	0xb7, 0x4c, 0xd4, 0x69, 0x11, 0x4d, 0xe2, 0xf4, 0x7c, 0x7f, 0x99, 0x2e, 0xd3, 0x7d, 0xf4, 0x45,
-	0xc5, 0x09, 0x22, 0x04, 0xb8, 0x32, 0x7b, 0x6e, 0xdf, 0xad, 0x85, 0x0b, 0xa6, 0xe4, 0xbd, 0x24,
-	0xdd, 0x97, 0x8a, 0x89, 0x89, 0xde, 0x19, 0xed, 0x6f, 0x7e, 0x21, 0xf8, 0xbb, 0x01, 0x9d, 0x79,
-	0x11, 0xcd, 0x15, 0x53, 0x9c, 0xec, 0x40, 0x23, 0x9c, 0xf9, 0xce, 0xc8, 0x19, 0xbb, 0xb4, 0x11,
-	0xce, 0xc8, 0x6d, 0xe8, 0xc4, 0xab, 0x84, 0x0b, 0x15, 0xce, 0xfc, 0xc6, 0xc8, 0x19, 0x77, 0x69,
-	0x85, 0xc9, 0x4d, 0xd8, 0x7e, 0xf1, 0x7d, 0x9e, 0x16, 0x99, 0xdf, 0x44, 0x8f, 0x45, 0x64, 0x0f,
-	0x5a, 0x89, 0x88, 0xd2, 0x4b, 0xdf, 0x45, 0xb3, 0x01, 0xfa, 0x24, 0x16, 0x9f, 0x85, 0xe8, 0x68,
-	0x99, 0x93, 0x4a, 0x4c, 0x46, 0xd0, 0x3b, 0x67, 0x97, 0xa1, 0x78, 0xb4, 0x4a, 0x96, 0xa7, 0xca,
-	0xdf, 0x1e, 0x39, 0xe3, 0x16, 0xad, 0x9b, 0xc8, 0xa7, 0x30, 0x60, 0xf1, 0xd9, 0x4f, 0x2c, 0x51,
-	0xa1, 0x98, 0xf3, 0x58, 0xfa, 0x6d, 0x8c, 0xd9, 0x34, 0xea, 0x73, 0x16, 0x45, 0xce, 0xa2, 0x15,
-	0x7f, 0xc2, 0xce, 0xb9, 0xdf, 0xc1, 0xcf, 0xd4, 0x4d, 0x3a, 0x8b, 0x15, 0x93, 0x6a, 0xce, 0x85,
-	0xf2, 0xbb, 0x78, 0xcb, 0x0a, 0x93, 0x3b, 0xd0, 0x4d, 0xe4, 0xcc, 0x04, 0xfb, 0x30, 0x72, 0xc6,
-	0x1d, 0xba, 0x36, 0xe8, 0x9d, 0x89, 0x3c, 0x5c, 0xa5, 0x92, 0x2f, 0xfc, 0x1e, 0x3a, 0x2b, 0x1c,
-	0x8c, 0x60, 0xa7, 0x64, 0x70, 0xc6, 0x57, 0xfc, 0x3a, 0x8f, 0xc1, 0x37, 0xeb, 0x88, 0xe7, 0xd9,
-	0xe2, 0x7d, 0x4c, 0xef, 0x41, 0x4b, 0xf2, 0x17, 0x22, 0x45, 0x9a, 0x5d, 0x6a, 0x40, 0xf0, 0x5b,
-	0x03, 0x60, 0xce, 0xf3, 0x0b, 0x9e, 0x87, 0xe2, 0x24, 0xd5, 0x29, 0x1e, 0xae, 0x0a, 0xa9, 0x78,
-	0x6e, 0xf7, 0x76, 0xe9, 0xda, 0xa0, 0xbd, 0xb3, 0x44, 0xc6, 0xe9, 0x05, 0xcf, 0x5f, 0x5a, 0xb5,
-	0xd6, 0x06, 0xe2, 0x43, 0xfb, 0xb8, 0x88, 0x56, 0x89, 0x3c, 0xb5, 0x7a, 0x95, 0x50, 0xef, 0x9b,
-	0x17, 0x91, 0x8c, 0xf3, 0x24, 0xe2, 0x56, 0xb4, 0xb5, 0x41, 0x93, 0xfa, 0x5c, 0xc8, 0xca, 0x6f,
-	0xb4, 0xab, 0x9b, 0x74, 0xea, 0x48, 0x04, 0x0a, 0xd7, 0xa5, 0x06, 0x68, 0xc2, 0xe6, 0x45, 0x64,
-	0x1c, 0x6d, 0x23, 0x78, 0x89, 0xb5, 0xef, 0x20, 0x3e, 0x93, 0xfa, 0x23, 0x56, 0xa5, 0x0a, 0xeb,
-	0xb2, 0x7a, 0x92, 0x2e, 0x78, 0x38, 0x43, 0x81, 0xba, 0xd4, 0xa2, 0xe0, 0x4f, 0x07, 0xe0, 0xd0,
-	0xd4, 0x9e, 0xa6, 0x62, 0xcd, 0x5f, 0x17, 0xf9, 0xf3, 0xa1, 0xfd, 0x38, 0x32, 0xe5, 0x65, 0xae,
-	0x5e, 0x42, 0x7d, 0xe0, 0x61, 0x2a, 0x44, 0x38, 0xc3, 0x7b, 0xf7, 0xa9, 0x45, 0x3a, 0x89, 0x63,
-	0xdb, 0x0a, 0x78, 0xeb, 0x16, 0xad, 0x30, 0x09, 0xa0, 0x7f, 0x9c, 0x88, 0x65, 0x28, 0x14, 0xcf,
-	0x2f, 0xd8, 0x0a, 0x6f, 0xdd, 0xa2, 0x1b, 0x36, 0x32, 0x04, 0xd0, 0xf8, 0x88, 0x5d, 0x3e, 0x2d,
-	0xca, 0xa2, 0xad, 0x59, 0x82, 0x21, 0xf4, 0x4d, 0xbe, 0xd7, 0x6a, 0x02, 0x33, 0x0e, 0xde, 0x38,
-	0xd0, 0x3e, 0x54, 0xf9, 0xea, 0x48, 0x2e, 0xc9, 0x17, 0xd0, 0x3e, 0x92, 0xcb, 0x67, 0x2f, 0x33,
-	0x8e, 0x01, 0x3b, 0xd3, 0x1b, 0x13, 0x99, 0x45, 0x13, 0xeb, 0x9e, 0x68, 0x07, 0x2d, 0x23, 0x90,
-	0x59, 0x53, 0x13, 0x55, 0x53, 0x96, 0x98, 0x10, 0x70, 0x67, 0x4c, 0x31, 0x7b, 0x55, 0x5c, 0x6b,
-	0x7d, 0x28, 0x3f, 0x09, 0x67, 0x65, 0x43, 0x22, 0x08, 0x7e, 0x06, 0x17, 0x4f, 0x23, 0x58, 0x9a,
-	0x35, 0x3d, 0xbd, 0x2d, 0xd2, 0x5f, 0x6b, 0xe7, 0x39, 0x64, 0x00, 0x5d, 0x4d, 0x99, 0x81, 0x0d,
-	0xb2, 0x0b, 0xbd, 0x47, 0xcf, 0x1e, 0x73, 0x96, 0xab, 0x88, 0x33, 0xe5, 0x35, 0x89, 0x07, 0xfd,
-	0x63, 0x96, 0xab, 0x44, 0x25, 0xa9, 0x48, 0xc4, 0xd2, 0x73, 0x83, 0x87, 0xb0, 0x4b, 0xd9, 0x89,
-	0xfa, 0x21, 0x4d, 0x04, 0xe5, 0x2f, 0x0a, 0x2e, 0x55, 0x4d, 0x56, 0xa7, 0x2e, 0xab, 0xbe, 0x8c,
-	0x5e, 0x1d, 0x2c, 0x16, 0x79, 0x79, 0x99, 0x12, 0x07, 0x63, 0xf0, 0xd6, 0xc7, 0xc8, 0x2c, 0x15,
-	0x12, 0x8b, 0xed, 0x61, 0x9e, 0xa7, 0xb9, 0x3d, 0xc6, 0x80, 0xe0, 0x2f, 0x17, 0x06, 0x3a, 0xf4,
-	0x69, 0xc6, 0x73, 0xa6, 0xf3, 0x20, 0xfb, 0xb0, 0xfd, 0x34, 0xab, 0x11, 0x7a, 0x0b, 0x09, 0xdd,
-	0x88, 0x31, 0xb4, 0xda, 0x30, 0x32, 0x81, 0xbe, 0x6d, 0x88, 0x07, 0x4c, 0xc5, 0xa7, 0x98, 0x4c,
-	0x6f, 0x0a, 0xb8, 0x0d, 0x2d, 0x74, 0xc3, 0x4f, 0x3e, 0x83, 0xe6, 0xbc, 0x88, 0x90, 0xe8, 0xde,
-	0x74, 0x0f, 0xc3, 0x0e, 0x16, 0x0b, 0xdb, 0x37, 0x99, 0x3e, 0x9f, 0xea, 0x00, 0x72, 0x17, 0x5a,
-	0x48, 0x2e, 0xb2, 0xdf, 0x9b, 0xde, 0x9c, 0x64, 0xd1, 0xa4, 0xc6, 0xb6, 0xe5, 0x87, 0x9a, 0x20,
-	0x32, 0x05, 0xd0, 0x83, 0x82, 0x0b, 0x75, 0x10, 0x9f, 0x61, 0xd9, 0xf5, 0xa6, 0x04, 0x0f, 0x2f,
-	0xcd, 0x62, 0x71, 0x10, 0x9f, 0xd1, 0x5a, 0x14, 0xf9, 0x1a, 0x06, 0xa6, 0xd0, 0xb4, 0x4a, 0x3c,
-	0x56, 0xd8, 0x6e, 0xbd, 0xe9, 0x4e, 0x99, 0x93, 0x71, 0xd2, 0xcd, 0x20, 0xf2, 0x2d, 0x78, 0xb6,
-	0x3c, 0xf5, 0x88, 0x30, 0x1b, 0x3b, 0xb8, 0xd1, 0xd3, 0x29, 0xa2, 0xda, 0x65, 0x72, 0xd7, 0x22,
-	0x75, 0xbb, 0x1d, 0x9e, 0x32, 0x21, 0xf8, 0xca, 0xb6, 0x69, 0x09, 0x71, 0x46, 0x99, 0x65, 0x38,
-	0xc3, 0x31, 0xea, 0xd2, 0xb5, 0x21, 0xf8, 0xc3, 0xb1, 0x65, 0xd7, 0xab, 0xc6, 0x91, 0xb7, 0xa5,
-	0x2b, 0xac, 0x1a, 0x38, 0x9e, 0x43, 0x6e, 0x02, 0xa1, 0xfc, 0x3c, 0xbd, 0xe0, 0x75, 0x36, 0xbd,
-	0x06, 0xf9, 0x08, 0x6e, 0x60, 0x5a, 0x1b, 0xe6, 0x26, 0xd9, 0xd1, 0x33, 0x52, 0x2c, 0x0c, 0x33,
-	0x9e, 0xab, 0x8f, 0xb6, 0x97, 0xf4, 0xb6, 0xb5, 0x73, 0x9d, 0xb6, 0xd7, 0x26, 0x37, 0x60, 0x60,
-	0xfa, 0xd1, 0xe6, 0xe4, 0x75, 0x82, 0xfb, 0xd0, 0x32, 0x92, 0x8e, 0xa1, 0x73, 0xc4, 0xa5, 0x64,
-	0x4b, 0x2e, 0x7d, 0x67, 0xd4, 0x1c, 0xf7, 0xa6, 0x7d, 0x4d, 0xc5, 0x91, 0x5c, 0xe2, 0x60, 0xa0,
-	0x95, 0x37, 0xc8, 0x60, 0xf7, 0x8a, 0xd8, 0xe4, 0x3e, 0xb4, 0x2d, 0x5d, 0x58, 0x71, 0xbd, 0xe9,
-	0xad, 0x89, 0x51, 0x6d, 0x5d, 0x0f, 0x96, 0xcd, 0x32, 0xce, 0x8e, 0xc1, 0xfa, 0xd0, 0xaa, 0xb0,
-	0x9d, 0x16, 0xcd, 0xea, 0x05, 0x39, 0x83, 0xc1, 0x46, 0x05, 0xd4, 0x15, 0x70, 0x36, 0x15, 0xf8,
-	0xd0, 0xb1, 0x04, 0x5c, 0x7c, 0xfc, 0x9a, 0xa3, 0xe6, 0xd8, 0xa5, 0xb8, 0x26, 0x1e, 0x34, 0x75,
-	0xb1, 0xb9, 0x68, 0xd2, 0xcb, 0x60, 0x0e, 0xdd, 0xaa, 0x6e, 0xc8, 0xdd, 0xab, 0x17, 0x23, 0x58,
-	0x1f, 0x86, 0xd1, 0x6b, 0x77, 0xf2, 0x75, 0xf4, 0x49, 0xce, 0xa5, 0xe9, 0xa0, 0x0e, 0x2d, 0x61,
-	0xf0, 0xab, 0x03, 0x7d, 0xdd, 0x7f, 0x73, 0xc1, 0x32, 0x79, 0x9a, 0x2a, 0xf2, 0x39, 0xb4, 0xcd,
-	0x27, 0x4a, 0xb6, 0x77, 0xcd, 0xd0, 0xab, 0x86, 0x3c, 0x2d, 0xfd, 0xe4, 0x4b, 0xe8, 0xd8, 0xdb,
-	0x49, 0xbf, 0x81, 0xb1, 0xa6, 0xe3, 0xac, 0xb1, 0x3c, 0x92, 0x56, 0x51, 0xf8, 0xdc, 0xb1, 0xc5,
-	0x22, 0x11, 0x4b, 0x3b, 0x0b, 0x4b, 0x18, 0xfc, 0xe3, 0xc0, 0xee, 0x95, 0x7d, 0x1f, 0x20, 0x73,
-	0x0f, 0x5a, 0x8f, 0x92, 0x5c, 0xaa, 0xf2, 0x5d, 0x46, 0xa0, 0x69, 0xfc, 0x91, 0x49, 0x65, 0xf5,
-	0xc1, 0x35, 0xf9, 0x0e, 0x15, 0xaa, 0xd4, 0x96, 0x48, 0x68, 0x6f, 0xfa, 0x71, 0xd9, 0xbd, 0x95,
-	0xa7, 0xca, 0x76, 0x33, 0x5e, 0x77, 0xce, 0x13, 0x7e, 0xa9, 0xe6, 0x45, 0x14, 0xce, 0xb0, 0xf5,
-	0x5d, 0xba, 0x36, 0x6c, 0xf6, 0xd5, 0xf6, 0xd5, 0xbe, 0xfa, 0x05, 0xf6, 0xde, 0xf7, 0x09, 0xf2,
-	0x09, 0xb4, 0xf0, 0xaf, 0xc3, 0x4a, 0x37, 0xa8, 0x46, 0x89, 0x36, 0x52, 0xe3, 0xd3, 0x4f, 0xbc,
-	0x7e, 0x7e, 0x8f, 0xb9, 0x40, 0xbe, 0x1a, 0x58, 0x08, 0x75, 0xd3, 0x83, 0x3b, 0xaf, 0xfe, 0x1b,
-	0x6e, 0xbd, 0x7a, 0x3b, 0x74, 0x5e, 0xbf, 0x1d, 0x3a, 0xff, 0xbe, 0x1d, 0x3a, 0xbf, 0xbf, 0x1b,
-	0x6e, 0xbd, 0x7e, 0x37, 0xdc, 0x7a, 0xf3, 0x6e, 0xb8, 0x15, 0x6d, 0xe3, 0x9f, 0xe4, 0x57, 0xff,
-	0x07, 0x00, 0x00, 0xff, 0xff, 0x36, 0xd2, 0xc8, 0x4e, 0xbd, 0x0a, 0x00, 0x00,
+	// 1288 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x56, 0xcd, 0x6e, 0xdb, 0x46,
+	0x10, 0x36, 0xf5, 0x63, 0x49, 0x23, 0xc9, 0x66, 0x16, 0x6e, 0xc2, 0x06, 0x81, 0x20, 0xb0, 0x45,
+	0xa1, 0xa2, 0x89, 0xdc, 0xa8, 0x45, 0x4f, 0x05, 0x0a, 0xc7, 0x4a, 0x1a, 0x15, 0x75, 0x62, 0xac,
+	0x12, 0x14, 0x28, 0xd0, 0xc3, 0x92, 0x5c, 0xcb, 0x84, 0xe9, 0x25, 0xc3, 0x5d, 0x1a, 0xce, 0x03,
+	0x14, 0xe8, 0xb1, 0x7d, 0x91, 0x3e, 0x40, 0x9f, 0x20, 0xc7, 0xa0, 0xa7, 0x1c, 0xdb, 0xe4, 0x2d,
+	0x7a, 0x2a, 0x76, 0x76, 0x49, 0x51, 0x76, 0x90, 0xdb, 0x7e, 0x33, 0xb3, 0xcb, 0xd9, 0xef, 0x9b,
+	0x99, 0x25, 0xec, 0x64, 0x79, 0xaa, 0xd2, 0x30, 0x4d, 0xa6, 0xb8, 0x20, 0x4d, 0x99, 0x05, 0xb7,
+	0xef, 0xad, 0x62, 0x75, 0x5a, 0x04, 0xd3, 0x30, 0x3d, 0xdf, 0x5f, 0xa5, 0xab, 0x74, 0x1f, 0x7d,
+	0x41, 0x71, 0x82, 0x08, 0x01, 0xae, 0xcc, 0x9e, 0xdb, 0x77, 0x6b, 0xe1, 0x82, 0x29, 0x79, 0x2f,
+	0x4e, 0xf7, 0xa5, 0x62, 0x62, 0xaa, 0x77, 0x06, 0xfb, 0x9b, 0x5f, 0xf0, 0xff, 0x6b, 0x40, 0x77,
+	0x59, 0x04, 0x4b, 0xc5, 0x14, 0x27, 0x3b, 0xd0, 0x58, 0xcc, 0x3d, 0x67, 0xec, 0x4c, 0x5a, 0xb4,
+	0xb1, 0x98, 0x93, 0xdb, 0xd0, 0x0d, 0x93, 0x98, 0x0b, 0xb5, 0x98, 0x7b, 0x8d, 0xb1, 0x33, 0xe9,
+	0xd1, 0x0a, 0x93, 0x9b, 0xb0, 0xfd, 0xe2, 0xfb, 0x3c, 0x2d, 0x32, 0xaf, 0x89, 0x1e, 0x8b, 0xc8,
+	0x1e, 0xb4, 0x63, 0x11, 0xa4, 0x97, 0x5e, 0x0b, 0xcd, 0x06, 0xe8, 0x93, 0x58, 0x78, 0xb6, 0x40,
+	0x47, 0xdb, 0x9c, 0x54, 0x62, 0x32, 0x86, 0xfe, 0x39, 0xbb, 0x5c, 0x88, 0x47, 0x49, 0xbc, 0x3a,
+	0x55, 0xde, 0xf6, 0xd8, 0x99, 0xb4, 0x69, 0xdd, 0x44, 0x3e, 0x85, 0x21, 0x0b, 0xcf, 0x7e, 0x62,
+	0xb1, 0x5a, 0x88, 0x25, 0x0f, 0xa5, 0xd7, 0xc1, 0x98, 0x4d, 0xa3, 0x3e, 0x27, 0x2a, 0x72, 0x16,
+	0x24, 0xfc, 0x09, 0x3b, 0xe7, 0x5e, 0x17, 0x3f, 0x53, 0x37, 0xe9, 0x2c, 0x12, 0x26, 0xd5, 0x92,
+	0x0b, 0xe5, 0xf5, 0xf0, 0x96, 0x15, 0x26, 0x77, 0xa0, 0x17, 0xcb, 0xb9, 0x09, 0xf6, 0x60, 0xec,
+	0x4c, 0xba, 0x74, 0x6d, 0xd0, 0x3b, 0x63, 0x79, 0x98, 0xa4, 0x92, 0x47, 0x5e, 0x1f, 0x9d, 0x15,
+	0xd6, 0xdf, 0xcd, 0x79, 0x96, 0xb0, 0x97, 0xcb, 0x8c, 0xf3, 0xc8, 0x1b, 0x8c, 0x9d, 0x89, 0x43,
+	0xeb, 0x26, 0xcd, 0xd5, 0x49, 0x9c, 0x28, 0x9e, 0x7b, 0x43, 0xc3, 0x95, 0x41, 0xfe, 0x18, 0x76,
+	0x4a, 0xee, 0xe7, 0x3c, 0xe1, 0xd7, 0x15, 0xf0, 0xbf, 0x59, 0x47, 0x3c, 0xcf, 0xa2, 0xf7, 0x69,
+	0xb4, 0x07, 0x6d, 0xc9, 0x5f, 0x88, 0x14, 0x05, 0x6a, 0x51, 0x03, 0xfc, 0xdf, 0x1a, 0x00, 0x4b,
+	0x9e, 0x5f, 0xf0, 0x7c, 0x21, 0x4e, 0x52, 0x7d, 0xb9, 0xc3, 0xa4, 0x90, 0x8a, 0xe7, 0x76, 0x6f,
+	0x8f, 0xae, 0x0d, 0xda, 0x3b, 0x8f, 0x65, 0x98, 0x5e, 0xf0, 0xfc, 0xa5, 0xd5, 0x79, 0x6d, 0x20,
+	0x1e, 0x74, 0x8e, 0x8b, 0x20, 0x89, 0xe5, 0xa9, 0x55, 0xba, 0x84, 0x7a, 0xdf, 0xb2, 0x08, 0x64,
+	0x98, 0xc7, 0x01, 0xb7, 0x72, 0xaf, 0x0d, 0x9a, 0x96, 0xe7, 0x42, 0x56, 0x7e, 0xa3, 0x7a, 0xdd,
+	0xa4, 0x53, 0x47, 0x0a, 0x51, 0xf2, 0x1e, 0x35, 0x40, 0x53, 0xbd, 0x2c, 0x02, 0xe3, 0xe8, 0x98,
+	0x52, 0x29, 0xb1, 0xf6, 0x1d, 0x84, 0x67, 0x52, 0x7f, 0xc4, 0xea, 0x5b, 0x61, 0x4d, 0xf2, 0x93,
+	0x34, 0xe2, 0x8b, 0x39, 0x4a, 0xdb, 0xa3, 0x16, 0xf9, 0x7f, 0x3a, 0x00, 0x87, 0xa6, 0x6a, 0x35,
+	0x15, 0x6b, 0xfe, 0x7a, 0xc8, 0x9f, 0x07, 0x9d, 0xc7, 0x81, 0x29, 0x4c, 0x73, 0xf5, 0x12, 0xea,
+	0x03, 0x0f, 0x53, 0x21, 0x16, 0x73, 0xbc, 0xf7, 0x80, 0x5a, 0xa4, 0x93, 0x38, 0xb6, 0x4d, 0x84,
+	0xb7, 0x6e, 0xd3, 0x0a, 0x13, 0x1f, 0x06, 0xc7, 0xb1, 0x58, 0x2d, 0x84, 0xe2, 0xf9, 0x05, 0x4b,
+	0xf0, 0xd6, 0x6d, 0xba, 0x61, 0x23, 0x23, 0x00, 0x8d, 0x8f, 0xd8, 0xe5, 0xd3, 0xa2, 0x2c, 0xf7,
+	0x9a, 0xc5, 0x1f, 0xc1, 0xc0, 0xe4, 0x7b, 0xad, 0x26, 0x30, 0x63, 0xff, 0x8d, 0x03, 0x9d, 0x43,
+	0x95, 0x27, 0x47, 0x72, 0x45, 0xbe, 0x80, 0xce, 0x91, 0x5c, 0x3d, 0x7b, 0x99, 0x71, 0x0c, 0xd8,
+	0x99, 0xdd, 0x98, 0xca, 0x2c, 0x98, 0x5a, 0xf7, 0x54, 0x3b, 0x68, 0x19, 0x81, 0xcc, 0x9a, 0x9a,
+	0xa8, 0xda, 0xb9, 0xc4, 0x84, 0x40, 0x6b, 0xce, 0x14, 0xb3, 0x57, 0xc5, 0xb5, 0xd6, 0x87, 0xf2,
+	0x93, 0xc5, 0xbc, 0x6c, 0x65, 0x04, 0xfe, 0xcf, 0xd0, 0xc2, 0xd3, 0x08, 0x96, 0x66, 0x4d, 0x4f,
+	0x77, 0x8b, 0x0c, 0xd6, 0xda, 0xb9, 0x0e, 0x19, 0x42, 0x4f, 0x53, 0x66, 0x60, 0x83, 0xec, 0x42,
+	0xff, 0xd1, 0xb3, 0xc7, 0x9c, 0xe5, 0x2a, 0xe0, 0x4c, 0xb9, 0x4d, 0xe2, 0xc2, 0xe0, 0x98, 0xe5,
+	0x2a, 0x56, 0x71, 0x2a, 0x62, 0xb1, 0x72, 0x5b, 0xfe, 0x43, 0xd8, 0xa5, 0xec, 0x44, 0xfd, 0x90,
+	0xc6, 0x82, 0xf2, 0x17, 0x05, 0x97, 0xaa, 0x26, 0xab, 0x53, 0x97, 0x55, 0x5f, 0x46, 0xaf, 0x0e,
+	0xa2, 0x28, 0x2f, 0x2f, 0x53, 0x62, 0x7f, 0x02, 0xee, 0xfa, 0x18, 0x99, 0xa5, 0x42, 0x62, 0xb1,
+	0x3d, 0xcc, 0xf3, 0x34, 0xb7, 0xc7, 0x18, 0xe0, 0xff, 0xd5, 0x82, 0xa1, 0x0e, 0x7d, 0x9a, 0xf1,
+	0x9c, 0xe9, 0x3c, 0xc8, 0x3e, 0x6c, 0x3f, 0xcd, 0x6a, 0x84, 0xde, 0x42, 0x42, 0x37, 0x62, 0x0c,
+	0xad, 0x36, 0x8c, 0x4c, 0x61, 0x60, 0x1b, 0xe2, 0x01, 0x53, 0xe1, 0x29, 0x26, 0xd3, 0x9f, 0x01,
+	0x6e, 0x43, 0x0b, 0xdd, 0xf0, 0x93, 0xcf, 0xa0, 0xb9, 0x2c, 0x02, 0x24, 0xba, 0x3f, 0xdb, 0xc3,
+	0xb0, 0x83, 0x28, 0xb2, 0x7d, 0x93, 0xe9, 0xf3, 0xa9, 0x0e, 0x20, 0x77, 0xa1, 0x8d, 0xe4, 0x22,
+	0xfb, 0xfd, 0xd9, 0xcd, 0x69, 0x16, 0x4c, 0x6b, 0x6c, 0x5b, 0x7e, 0xa8, 0x09, 0x22, 0x33, 0x00,
+	0x3d, 0x28, 0xb8, 0x50, 0x07, 0xe1, 0x19, 0x96, 0x5d, 0x7f, 0x46, 0xf0, 0xf0, 0xd2, 0x2c, 0xa2,
+	0x83, 0xf0, 0x8c, 0xd6, 0xa2, 0xc8, 0xd7, 0x30, 0x34, 0x85, 0xa6, 0x55, 0xe2, 0xa1, 0xc2, 0x76,
+	0xeb, 0xcf, 0x76, 0xca, 0x9c, 0x8c, 0x93, 0x6e, 0x06, 0x91, 0x6f, 0xc1, 0xb5, 0xe5, 0xa9, 0x47,
+	0x84, 0xd9, 0xd8, 0xc5, 0x8d, 0xae, 0x4e, 0x11, 0xd5, 0x2e, 0x93, 0xbb, 0x16, 0xa9, 0xdb, 0xed,
+	0xf0, 0x94, 0x09, 0xc1, 0x13, 0xdb, 0xa6, 0x25, 0xc4, 0x19, 0x65, 0x96, 0x8b, 0x39, 0x0e, 0xe0,
+	0x16, 0x5d, 0x1b, 0xfc, 0x3f, 0x1c, 0x5b, 0x76, 0xfd, 0x6a, 0x1c, 0xb9, 0x5b, 0xba, 0xc2, 0xaa,
+	0x81, 0xe3, 0x3a, 0xe4, 0x26, 0x10, 0xca, 0xcf, 0xd3, 0x0b, 0x5e, 0x67, 0xd3, 0x6d, 0x90, 0x8f,
+	0xe0, 0x06, 0xa6, 0xb5, 0x61, 0x6e, 0x92, 0x1d, 0x3d, 0x23, 0x45, 0x64, 0x98, 0x71, 0x5b, 0xfa,
+	0x68, 0x7b, 0x49, 0x77, 0x5b, 0x3b, 0xd7, 0x69, 0xbb, 0x1d, 0x72, 0x03, 0x86, 0xa6, 0x1f, 0x6d,
+	0x4e, 0x6e, 0xd7, 0xbf, 0x0f, 0x6d, 0x23, 0xe9, 0x04, 0xba, 0x47, 0x5c, 0x4a, 0xb6, 0xe2, 0xd2,
+	0x73, 0xc6, 0xcd, 0x49, 0x7f, 0x36, 0xd0, 0x54, 0x1c, 0xc9, 0x15, 0x0e, 0x06, 0x5a, 0x79, 0xfd,
+	0x0c, 0x76, 0xaf, 0x88, 0x4d, 0xee, 0x43, 0xc7, 0xd2, 0x85, 0x15, 0xd7, 0x9f, 0xdd, 0x9a, 0x1a,
+	0xd5, 0xd6, 0xf5, 0x60, 0xd9, 0x2c, 0xe3, 0xec, 0x18, 0xac, 0x0f, 0xad, 0x0a, 0xdb, 0x69, 0xd1,
+	0xac, 0x5e, 0x90, 0x33, 0x18, 0x6e, 0x54, 0x40, 0x5d, 0x01, 0x67, 0x53, 0x81, 0x0f, 0x1d, 0x4b,
+	0xa0, 0x85, 0xcf, 0x66, 0x73, 0xdc, 0x9c, 0xb4, 0x28, 0xae, 0x89, 0x0b, 0x4d, 0x5d, 0x6c, 0x2d,
+	0x34, 0xe9, 0xa5, 0xbf, 0x84, 0x5e, 0x55, 0x37, 0xe4, 0xee, 0xd5, 0x8b, 0x11, 0xac, 0x0f, 0xc3,
+	0xe8, 0xb5, 0x3b, 0x79, 0x3a, 0xfa, 0x24, 0xe7, 0xd2, 0x74, 0x50, 0x97, 0x96, 0xd0, 0xff, 0xd5,
+	0x81, 0x81, 0xee, 0xbf, 0xa5, 0x60, 0x99, 0x3c, 0x4d, 0x15, 0xf9, 0x1c, 0x3a, 0xe6, 0x13, 0x25,
+	0xdb, 0xbb, 0x66, 0xe8, 0x55, 0x43, 0x9e, 0x96, 0x7e, 0xf2, 0x25, 0x74, 0xed, 0xed, 0xa4, 0xd7,
+	0x18, 0x37, 0xab, 0x8e, 0xb3, 0xc6, 0xf2, 0x48, 0x5a, 0x45, 0xe1, 0x73, 0xc7, 0xa2, 0x28, 0x16,
+	0x2b, 0x3b, 0x0b, 0x4b, 0xe8, 0xff, 0xed, 0xc0, 0xee, 0x95, 0x7d, 0x1f, 0x20, 0x73, 0x0f, 0xda,
+	0x8f, 0xe2, 0x5c, 0xaa, 0xf2, 0x5d, 0x46, 0xa0, 0x69, 0xfc, 0x91, 0x49, 0x65, 0xf5, 0xc1, 0x35,
+	0xf9, 0x0e, 0x86, 0x75, 0xb5, 0x25, 0x12, 0xda, 0x9f, 0x7d, 0x5c, 0x76, 0x6f, 0xe5, 0xa9, 0xb2,
+	0xdd, 0x8c, 0xd7, 0x9d, 0xf3, 0x84, 0x5f, 0xaa, 0x65, 0x11, 0x2c, 0xe6, 0xd8, 0xfa, 0x2d, 0xba,
+	0x36, 0x6c, 0xf6, 0xd5, 0xf6, 0xd5, 0xbe, 0xfa, 0x05, 0xf6, 0xde, 0xf7, 0x09, 0xf2, 0x09, 0xb4,
+	0xf1, 0xaf, 0xc3, 0x4a, 0x37, 0xac, 0x46, 0x89, 0x36, 0x52, 0xe3, 0xd3, 0x4f, 0xbc, 0x7e, 0x7e,
+	0x8f, 0xb9, 0x40, 0xbe, 0x1a, 0x58, 0x08, 0x75, 0xd3, 0x83, 0x3b, 0xaf, 0xfe, 0x1d, 0x6d, 0xbd,
+	0x7a, 0x3b, 0x72, 0x5e, 0xbf, 0x1d, 0x39, 0xff, 0xbc, 0x1d, 0x39, 0xbf, 0xbf, 0x1b, 0x6d, 0xbd,
+	0x7e, 0x37, 0xda, 0x7a, 0xf3, 0x6e, 0xb4, 0x15, 0x6c, 0xe3, 0x3f, 0xe8, 0x57, 0xff, 0x0f, 0x00,
+	0xce, 0x46, 0x3f, 0x27, 0xf7, 0x0a, 0x00, 0x00,
 }
 
 func (m *SubState) Marshal() (dAtA []byte, err error) {
@@ -922,6 +927,19 @@ func (m *SubState) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Filter) > 0 {
+		i -= len(m.Filter)
+		copy(dAtA[i:], m.Filter)
+		i = encodeVarintProtocol(dAtA, i, uint64(len(m.Filter)))
+		i--
+		dAtA[i] = 0x6a
+	}
+	if m.ReplaySpeed != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.ReplaySpeed))))
+		i--
+		dAtA[i] = 0x61
+	}
 	if m.IsClosed {
 		i--
 		if m.IsClosed {
@@ -1896,6 +1914,13 @@ func (m *SubState) Size() (n int) {
 	if m.IsClosed {
 		n += 2
 	}
+	if m.ReplaySpeed != 0 {
+		n += 9
+	}
+	l = len(m.Filter)
+	if l > 0 {
+		n += 1 + l + sovProtocol(uint64(l))
+	}
 	return n
 }
 
@@ -2580,6 +2605,49 @@ func (m *SubState) Unmarshal(dAtA []byte) error {
 				}
 			}
 			m.IsClosed = bool(v != 0)
+		case 12:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReplaySpeed", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.ReplaySpeed = float64(math.Float64frombits(v))
+		case 13:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Filter", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowProtocol
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthProtocol
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthProtocol
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Filter = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipProtocol(dAtA[iNdEx:])
@@ -0,0 +1,12 @@
+-- The server creates and migrates these tables on startup, unless the SQL option no_migration is set.
+CREATE TABLE IF NOT EXISTS ServerInfo (uniquerow INT DEFAULT 1, id VARCHAR(1024), proto BLOB, version INTEGER, PRIMARY KEY (uniquerow));
+CREATE TABLE IF NOT EXISTS Clients (id VARCHAR(1024), hbinbox TEXT, proto BLOB, PRIMARY KEY (id));
+CREATE TABLE IF NOT EXISTS Channels (id INTEGER, name VARCHAR(1024) NOT NULL, maxseq BIGINT DEFAULT 0, maxmsgs INTEGER DEFAULT 0, maxbytes BIGINT DEFAULT 0, maxage BIGINT DEFAULT 0, deleted BOOL DEFAULT FALSE, PRIMARY KEY (id));
+CREATE INDEX IF NOT EXISTS Idx_ChannelsName ON Channels (name);
+CREATE TABLE IF NOT EXISTS Messages (id INTEGER, seq BIGINT, timestamp BIGINT, size INTEGER, data BLOB, CONSTRAINT PK_MsgKey PRIMARY KEY(id, seq));
+CREATE INDEX IF NOT EXISTS Idx_MsgsTimestamp ON Messages (timestamp);
+CREATE TABLE IF NOT EXISTS Subscriptions (id INTEGER, subid BIGINT, lastsent BIGINT DEFAULT 0, proto BLOB, deleted BOOL DEFAULT FALSE, CONSTRAINT PK_SubKey PRIMARY KEY(id, subid));
+CREATE TABLE IF NOT EXISTS SubsPending (subid BIGINT, `row` BIGINT, seq BIGINT DEFAULT 0, lastsent BIGINT DEFAULT 0, pending BLOB, acks BLOB, CONSTRAINT PK_MsgPendingKey PRIMARY KEY(subid, `row`));
+CREATE INDEX IF NOT EXISTS Idx_SubsPendingSeq ON SubsPending (seq);
+CREATE TABLE IF NOT EXISTS StoreLock (id VARCHAR(30), tick BIGINT DEFAULT 0);
+CREATE TABLE IF NOT EXISTS MsgsPartitions (id INTEGER, PRIMARY KEY (id));
@@ -0,0 +1,181 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Archiver is used by the FileStore to offload the file slices that are
+// removed due to channel limits, and to read them back when a message
+// older than the first message of the channel is looked up.
+type Archiver interface {
+	// Archive stores the data and index files of a slice of `channel`
+	// holding messages `first` to `last`. The files must be copied or
+	// moved, the FileStore removes them if they are still present once
+	// this call returns without error.
+	// This is invoked from a go routine, not from the store's hot path.
+	Archive(channel string, first, last uint64, datFile, idxFile string) error
+
+	// Open returns the archived slice of `channel` that contains the
+	// message `seq`, or nil if there is none. The FileStore closes the
+	// returned slice when done with it.
+	Open(channel string, seq uint64) (*ArchivedSlice, error)
+
+	// Remove removes all archived slices of `channel`. This is invoked
+	// when the channel is deleted or its messages are purged, since
+	// message sequences would then start again from 1.
+	Remove(channel string) error
+}
+
+// ArchivedFile is an archived data or index file.
+type ArchivedFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// ArchivedSlice gives read access to the files of an archived slice.
+type ArchivedSlice struct {
+	First uint64
+	Last  uint64
+	Data  ArchivedFile
+	Index ArchivedFile
+}
+
+// Close closes the data and index files.
+func (as *ArchivedSlice) Close() error {
+	err := as.Data.Close()
+	if ierr := as.Index.Close(); err == nil {
+		err = ierr
+	}
+	return err
+}
+
+// LocalArchiver is an Archiver that keeps archived slices in a local
+// directory, which is typically on a cheaper and larger volume than
+// the one used by the FileStore. Slices of a channel are stored in a
+// directory named after the channel, and can be pruned externally.
+type LocalArchiver struct {
+	dir string
+}
+
+// Name of archived slice files, without suffix, from the sequence of the
+// first and last message.
+const archivedSliceFmt = msgFilesPrefix + "%020d-%020d"
+
+// NewLocalArchiver returns an Archiver that stores slices in `dir`,
+// creating the directory if needed.
+func NewLocalArchiver(dir string) (*LocalArchiver, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("archive directory must be specified")
+	}
+	if err := os.MkdirAll(dir, os.ModeDir+os.ModePerm); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("unable to create the archive directory [%s]: %v", dir, err)
+	}
+	return &LocalArchiver{dir: dir}, nil
+}
+
+// Archive implements the Archiver interface
+func (la *LocalArchiver) Archive(channel string, first, last uint64, datFile, idxFile string) error {
+	chanDir := filepath.Join(la.dir, channel)
+	if err := os.MkdirAll(chanDir, os.ModeDir+os.ModePerm); err != nil && !os.IsExist(err) {
+		return err
+	}
+	base := filepath.Join(chanDir, fmt.Sprintf(archivedSliceFmt, first, last))
+	// Move the index file last: a slice is considered archived only
+	// when both files are present.
+	if err := moveFile(datFile, base+datSuffix); err != nil {
+		return err
+	}
+	if err := moveFile(idxFile, base+idxSuffix); err != nil {
+		os.Remove(base + datSuffix)
+		return err
+	}
+	return nil
+}
+
+// Open implements the Archiver interface
+func (la *LocalArchiver) Open(channel string, seq uint64) (*ArchivedSlice, error) {
+	chanDir := filepath.Join(la.dir, channel)
+	idxFiles, err := filepath.Glob(filepath.Join(chanDir, msgFilesPrefix+"*"+idxSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, idxName := range idxFiles {
+		var first, last uint64
+		name := strings.TrimSuffix(filepath.Base(idxName), idxSuffix)
+		if _, err := fmt.Sscanf(name, msgFilesPrefix+"%d-%d", &first, &last); err != nil {
+			continue
+		}
+		if seq < first || seq > last {
+			continue
+		}
+		idx, err := os.Open(idxName)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Pruned in the meantime.
+				return nil, nil
+			}
+			return nil, err
+		}
+		dat, err := os.Open(strings.TrimSuffix(idxName, idxSuffix) + datSuffix)
+		if err != nil {
+			idx.Close()
+			return nil, err
+		}
+		return &ArchivedSlice{First: first, Last: last, Data: dat, Index: idx}, nil
+	}
+	return nil, nil
+}
+
+// Remove implements the Archiver interface
+func (la *LocalArchiver) Remove(channel string) error {
+	return os.RemoveAll(filepath.Join(la.dir, channel))
+}
+
+// moveFile renames `src` to `dst`, or copies it if they are not on the
+// same volume.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,1082 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+	"github.com/kubemq-io/broker/server/stan/logger"
+	"github.com/kubemq-io/broker/server/stan/spb"
+	"github.com/kubemq-io/broker/server/stan/util"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Name of the bolt database file in the store's root directory.
+	boltFileName = "streaming.db"
+
+	// Version of the layout of the bolt database.
+	boltVersion = 1
+
+	// If expiration of messages fails, retry after this interval.
+	boltExpirationIntervalOnError = 5 * time.Second
+)
+
+// The database is organized as follow:
+//
+//	server/   info -> ServerInfo, version -> boltVersion
+//	clients/  <client ID> -> ClientInfo
+//	channels/ <channel name>/
+//	              lastSeq  -> sequence of the last stored message
+//	              maxSubID -> highest subscription ID ever assigned
+//	              msgs/    <seq> -> MsgProto
+//	              subs/    <sub ID>/
+//	                           state    -> SubState
+//	                           lastSent -> highest sequence added as pending
+//	                           pending/ <seq> -> empty
+//
+// Sequences and IDs are encoded as 8 bytes big endian so that keys
+// are ordered.
+var (
+	boltServerBucket   = []byte("server")
+	boltClientsBucket  = []byte("clients")
+	boltChannelsBucket = []byte("channels")
+	boltMsgsBucket     = []byte("msgs")
+	boltSubsBucket     = []byte("subs")
+	boltPendingBucket  = []byte("pending")
+	boltInfoKey        = []byte("info")
+	boltVersionKey     = []byte("version")
+	boltLastSeqKey     = []byte("lastSeq")
+	boltMaxSubIDKey    = []byte("maxSubID")
+	boltSubStateKey    = []byte("state")
+	boltLastSentKey    = []byte("lastSent")
+
+	errBoltStoreClosed = errors.New("bolt: store is closed")
+)
+
+// BoltStoreOptions are used to configure the Bolt Store.
+type BoltStoreOptions struct {
+	// If true, the database file is not synced after each write
+	// transaction, but only when MsgStore.Flush() or SubStore.Flush()
+	// are called. This is faster but data may be lost on a crash.
+	NoSync bool
+
+	// How long to wait to open the database file if it is locked by
+	// another process. If 0, waits indefinitely.
+	OpenTimeout time.Duration
+}
+
+// DefaultBoltStoreOptions returns default store options for a Bolt Store
+func DefaultBoltStoreOptions() *BoltStoreOptions {
+	return &BoltStoreOptions{
+		OpenTimeout: 5 * time.Second,
+	}
+}
+
+// BoltStoreOption is a function on the options for a Bolt Store
+type BoltStoreOption func(*BoltStoreOptions) error
+
+// BoltNoSync sets the NoSync option
+func BoltNoSync(noSync bool) BoltStoreOption {
+	return func(o *BoltStoreOptions) error {
+		o.NoSync = noSync
+		return nil
+	}
+}
+
+// BoltOpenTimeout sets the OpenTimeout option
+func BoltOpenTimeout(timeout time.Duration) BoltStoreOption {
+	return func(o *BoltStoreOptions) error {
+		if timeout < 0 {
+			return fmt.Errorf("bolt: open timeout cannot be negative")
+		}
+		o.OpenTimeout = timeout
+		return nil
+	}
+}
+
+// BoltAllOptions is a convenient option to pass all options from a BoltStoreOptions
+// structure to the constructor.
+func BoltAllOptions(opts *BoltStoreOptions) BoltStoreOption {
+	return func(o *BoltStoreOptions) error {
+		if err := BoltOpenTimeout(opts.OpenTimeout)(o); err != nil {
+			return err
+		}
+		o.NoSync = opts.NoSync
+		return nil
+	}
+}
+
+// BoltStore is a factory for message and subscription stores held in
+// a single bolt database file.
+type BoltStore struct {
+	genericStore
+	opts     *BoltStoreOptions
+	rootDir  string
+	db       *bolt.DB
+	lockFile util.LockFile
+}
+
+// BoltSubStore is a subscription store backed by a bolt database.
+type BoltSubStore struct {
+	genericSubStore
+	db      *bolt.DB
+	channel []byte
+	noSync  bool
+}
+
+// BoltMsgStore is a per channel message store backed by a bolt database.
+type BoltMsgStore struct {
+	genericMsgStore
+	db          *bolt.DB
+	channel     []byte
+	noSync      bool
+	fTimestamp  int64
+	expireTimer *time.Timer
+	wg          sync.WaitGroup
+}
+
+func boltKey(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func boltUint64(b []byte) uint64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// Returns the bucket of the given channel, or an error if it does not exist.
+func boltChannelBucket(tx *bolt.Tx, channel []byte) (*bolt.Bucket, error) {
+	cb := tx.Bucket(boltChannelsBucket).Bucket(channel)
+	if cb == nil {
+		return nil, fmt.Errorf("bolt: channel %q not found", channel)
+	}
+	return cb, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// BoltStore methods
+////////////////////////////////////////////////////////////////////////////
+
+// NewBoltStore returns a factory for stores held in a bolt database
+// created in the given root directory.
+// If not limits are provided, the store will be created with
+// DefaultStoreLimits.
+// The database file is opened on first use, so that a standby server
+// in FT mode does not hold it before getting the exclusive lock.
+func NewBoltStore(log logger.Logger, rootDir string, limits *StoreLimits, options ...BoltStoreOption) (*BoltStore, error) {
+	if rootDir == "" {
+		return nil, fmt.Errorf("for %v stores, root directory must be specified", TypeBolt)
+	}
+	opts := DefaultBoltStoreOptions()
+	for _, opt := range options {
+		if err := opt(opts); err != nil {
+			return nil, err
+		}
+	}
+	bs := &BoltStore{opts: opts, rootDir: rootDir}
+	if err := bs.init(TypeBolt, log, limits); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(rootDir, os.ModeDir+os.ModePerm); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("unable to create the root directory [%s]: %v", rootDir, err)
+	}
+	return bs, nil
+}
+
+// openDB opens the database if not already done.
+// Store lock held on entry.
+func (bs *BoltStore) openDB() error {
+	if bs.closed {
+		return errBoltStoreClosed
+	}
+	if bs.db != nil {
+		return nil
+	}
+	db, err := bolt.Open(filepath.Join(bs.rootDir, boltFileName), 0600,
+		&bolt.Options{Timeout: bs.opts.OpenTimeout})
+	if err != nil {
+		return fmt.Errorf("bolt: unable to open database: %v", err)
+	}
+	db.NoSync = bs.opts.NoSync
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltServerBucket, boltClientsBucket, boltChannelsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	bs.db = db
+	return nil
+}
+
+// GetExclusiveLock implements the Store interface
+func (bs *BoltStore) GetExclusiveLock() (bool, error) {
+	bs.Lock()
+	defer bs.Unlock()
+	if bs.closed {
+		return false, nil
+	}
+	if bs.lockFile != nil {
+		return true, nil
+	}
+	f, err := util.CreateLockFile(filepath.Join(bs.rootDir, lockFileName))
+	if err != nil {
+		if err == util.ErrUnableToLockNow {
+			return false, nil
+		}
+		return false, err
+	}
+	// Keep a reference to the file, otherwise the lock is released
+	// when `f` is garbage collected.
+	bs.lockFile = f
+	return true, nil
+}
+
+// Init is used to persist server's information after the first start
+func (bs *BoltStore) Init(info *spb.ServerInfo) error {
+	bs.Lock()
+	defer bs.Unlock()
+	if err := bs.openDB(); err != nil {
+		return err
+	}
+	infoBytes, _ := info.Marshal()
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltServerBucket)
+		if err := b.Put(boltVersionKey, boltKey(boltVersion)); err != nil {
+			return err
+		}
+		return b.Put(boltInfoKey, infoBytes)
+	})
+}
+
+// Recover implements the Store interface
+func (bs *BoltStore) Recover() (*RecoveredState, error) {
+	bs.Lock()
+	defer bs.Unlock()
+	if err := bs.openDB(); err != nil {
+		return nil, err
+	}
+	var rs *RecoveredState
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		sb := tx.Bucket(boltServerBucket)
+		infoBytes := sb.Get(boltInfoKey)
+		if infoBytes == nil {
+			if k, _ := tx.Bucket(boltChannelsBucket).Cursor().First(); k != nil {
+				return ErrNoSrvButChannels
+			}
+			return nil
+		}
+		if v := boltUint64(sb.Get(boltVersionKey)); v != boltVersion {
+			return fmt.Errorf("bolt: unsupported version: %v (supports [1..%v])", v, boltVersion)
+		}
+		info := &spb.ServerInfo{}
+		if err := info.Unmarshal(infoBytes); err != nil {
+			return err
+		}
+		rs = &RecoveredState{Info: info}
+
+		err := tx.Bucket(boltClientsBucket).ForEach(func(_, v []byte) error {
+			client := &Client{}
+			if err := client.ClientInfo.Unmarshal(v); err != nil {
+				return err
+			}
+			rs.Clients = append(rs.Clients, client)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(boltChannelsBucket).ForEach(func(k, _ []byte) error {
+			name := string(k)
+			rc, err := bs.recoverChannel(tx, name)
+			if err != nil {
+				return fmt.Errorf("bolt: unable to recover channel %q: %v", name, err)
+			}
+			if rs.Channels == nil {
+				rs.Channels = make(map[string]*RecoveredChannel)
+			}
+			rs.Channels[name] = rc
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rs != nil {
+		for name, rc := range rs.Channels {
+			bs.channels[name] = rc.Channel
+			ms := rc.Channel.Msgs.(*BoltMsgStore)
+			ms.Lock()
+			if ms.limits.MaxAge > 0 && ms.totalCount > 0 {
+				ms.createExpireTimer()
+			}
+			ms.Unlock()
+		}
+	}
+	return rs, nil
+}
+
+// recoverChannel recovers the messages and subscriptions of a channel,
+// applying the current limits to the stored messages.
+// Store lock held on entry.
+func (bs *BoltStore) recoverChannel(tx *bolt.Tx, name string) (*RecoveredChannel, error) {
+	channelLimits := bs.genericStore.getChannelLimits(name)
+	cb, err := boltChannelBucket(tx, []byte(name))
+	if err != nil {
+		return nil, err
+	}
+
+	ms := bs.newBoltMsgStore(name, &channelLimits.MsgStoreLimits)
+	msgs := cb.Bucket(boltMsgsBucket)
+	if err := msgs.ForEach(func(k, v []byte) error {
+		if ms.totalCount == 0 {
+			ms.first = boltUint64(k)
+		}
+		ms.last = boltUint64(k)
+		ms.totalCount++
+		ms.totalBytes += uint64(len(v))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if ms.limits.MaxAge > 0 {
+		if err := ms.removeExpired(msgs, time.Now().UnixNano()); err != nil {
+			return nil, err
+		}
+	}
+	if err := ms.enforceCountLimits(msgs); err != nil {
+		return nil, err
+	}
+	if ms.totalCount > 0 {
+		_, v := msgs.Cursor().First()
+		m := &pb.MsgProto{}
+		if err := m.Unmarshal(v); err != nil {
+			return nil, err
+		}
+		ms.fTimestamp = m.Timestamp
+	}
+	maxSeq := boltUint64(cb.Get(boltLastSeqKey))
+
+	ss := bs.newBoltSubStore(name, &channelLimits.SubStoreLimits)
+	ss.maxSubID = boltUint64(cb.Get(boltMaxSubIDKey))
+	var subscriptions []*RecoveredSubscription
+	subs := cb.Bucket(boltSubsBucket)
+	err = subs.ForEach(func(k, _ []byte) error {
+		sb := subs.Bucket(k)
+		if sb == nil {
+			return nil
+		}
+		sub := &spb.SubState{}
+		if err := sub.Unmarshal(sb.Get(boltSubStateKey)); err != nil {
+			return err
+		}
+		if lastSent := boltUint64(sb.Get(boltLastSentKey)); lastSent > sub.LastSent {
+			sub.LastSent = lastSent
+		}
+		pending := make(PendingAcks)
+		if pendingBucket := sb.Bucket(boltPendingBucket); pendingBucket != nil {
+			pendingBucket.ForEach(func(seq, _ []byte) error {
+				pending[boltUint64(seq)] = struct{}{}
+				return nil
+			})
+		}
+		if sub.ID > ss.maxSubID {
+			ss.maxSubID = sub.ID
+		}
+		if sub.LastSent > maxSeq {
+			maxSeq = sub.LastSent
+		}
+		ss.subs[sub.ID] = emptySub
+		subscriptions = append(subscriptions, &RecoveredSubscription{Sub: sub, Pending: pending})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Messages may have been removed due to limits, so make sure that
+	// the sequence is not reset.
+	if maxSeq > ms.last {
+		ms.first = maxSeq + 1
+		ms.last = maxSeq
+	}
+	return &RecoveredChannel{
+		Channel: &Channel{
+			Msgs: ms,
+			Subs: ss,
+		},
+		Subscriptions: subscriptions,
+	}, nil
+}
+
+func (bs *BoltStore) newBoltMsgStore(channel string, limits *MsgStoreLimits) *BoltMsgStore {
+	ms := &BoltMsgStore{
+		db:      bs.db,
+		channel: []byte(channel),
+		noSync:  bs.opts.NoSync,
+	}
+	ms.init(channel, bs.log, limits)
+	return ms
+}
+
+func (bs *BoltStore) newBoltSubStore(channel string, limits *SubStoreLimits) *BoltSubStore {
+	ss := &BoltSubStore{
+		db:      bs.db,
+		channel: []byte(channel),
+		noSync:  bs.opts.NoSync,
+	}
+	ss.init(bs.log, limits)
+	return ss
+}
+
+// CreateChannel implements the Store interface
+func (bs *BoltStore) CreateChannel(channel string) (*Channel, error) {
+	bs.Lock()
+	defer bs.Unlock()
+
+	if err := bs.openDB(); err != nil {
+		return nil, err
+	}
+	// Verify that it does not already exist or that we did not hit the limits
+	if err := bs.canAddChannel(channel); err != nil {
+		return nil, err
+	}
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		// A bucket of that name could be left from a deleted channel
+		// if the deletion failed, so start from scratch.
+		channels := tx.Bucket(boltChannelsBucket)
+		if channels.Bucket([]byte(channel)) != nil {
+			if err := channels.DeleteBucket([]byte(channel)); err != nil {
+				return err
+			}
+		}
+		cb, err := channels.CreateBucket([]byte(channel))
+		if err != nil {
+			return err
+		}
+		if _, err := cb.CreateBucket(boltMsgsBucket); err != nil {
+			return err
+		}
+		_, err = cb.CreateBucket(boltSubsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	channelLimits := bs.genericStore.getChannelLimits(channel)
+	c := &Channel{
+		Subs: bs.newBoltSubStore(channel, &channelLimits.SubStoreLimits),
+		Msgs: bs.newBoltMsgStore(channel, &channelLimits.MsgStoreLimits),
+	}
+	bs.channels[channel] = c
+	return c, nil
+}
+
+// DeleteChannel implements the Store interface
+func (bs *BoltStore) DeleteChannel(channel string) error {
+	bs.Lock()
+	defer bs.Unlock()
+	if err := bs.deleteChannel(channel); err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltChannelsBucket).DeleteBucket([]byte(channel))
+	})
+}
+
+// AddClient implements the Store interface
+func (bs *BoltStore) AddClient(info *spb.ClientInfo) (*Client, error) {
+	bs.Lock()
+	defer bs.Unlock()
+	if err := bs.openDB(); err != nil {
+		return nil, err
+	}
+	infoBytes, err := info.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltClientsBucket).Put([]byte(info.ID), infoBytes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Client{*info}, nil
+}
+
+// DeleteClient implements the Store interface
+func (bs *BoltStore) DeleteClient(clientID string) error {
+	bs.Lock()
+	defer bs.Unlock()
+	if err := bs.openDB(); err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltClientsBucket).Delete([]byte(clientID))
+	})
+}
+
+// Close implements the Store interface
+func (bs *BoltStore) Close() error {
+	bs.Lock()
+	defer bs.Unlock()
+	if bs.closed {
+		return nil
+	}
+	bs.closed = true
+	// This will cause MsgStore's and SubStore's to be closed.
+	err := bs.close()
+	if bs.db != nil {
+		if lerr := bs.db.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	if bs.lockFile != nil {
+		if lerr := bs.lockFile.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////
+// BoltMsgStore methods
+////////////////////////////////////////////////////////////////////////////
+
+// Store implements the MsgStore interface
+func (ms *BoltMsgStore) Store(m *pb.MsgProto) (uint64, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	if m.Sequence <= ms.last {
+		// We've already seen this message.
+		return m.Sequence, nil
+	}
+	if ms.closed {
+		return 0, errBoltStoreClosed
+	}
+
+	seq := m.Sequence
+	msgBytes, _ := m.Marshal()
+
+	// Work on a copy of the counters so that they are not modified if
+	// the transaction fails.
+	saved := ms.saveCounters()
+	err := ms.db.Update(func(tx *bolt.Tx) error {
+		cb, err := boltChannelBucket(tx, ms.channel)
+		if err != nil {
+			return err
+		}
+		msgs := cb.Bucket(boltMsgsBucket)
+		if err := msgs.Put(boltKey(seq), msgBytes); err != nil {
+			return err
+		}
+		if err := cb.Put(boltLastSeqKey, boltKey(seq)); err != nil {
+			return err
+		}
+		if ms.totalCount == 0 {
+			ms.first = seq
+			ms.fTimestamp = m.Timestamp
+		}
+		ms.last = seq
+		ms.totalCount++
+		ms.totalBytes += uint64(len(msgBytes))
+		return ms.enforceCountLimits(msgs)
+	})
+	if err != nil {
+		ms.restoreCounters(saved)
+		return 0, err
+	}
+
+	if ms.limits.MaxAge > 0 && ms.expireTimer == nil {
+		ms.createExpireTimer()
+	}
+	return seq, nil
+}
+
+// boltMsgCounters holds the state that a write transaction may modify,
+// so that it can be restored if the transaction fails.
+type boltMsgCounters struct {
+	first, last uint64
+	totalCount  int
+	totalBytes  uint64
+	hitLimit    bool
+	fTimestamp  int64
+}
+
+func (ms *BoltMsgStore) saveCounters() boltMsgCounters {
+	return boltMsgCounters{
+		first:      ms.first,
+		last:       ms.last,
+		totalCount: ms.totalCount,
+		totalBytes: ms.totalBytes,
+		hitLimit:   ms.hitLimit,
+		fTimestamp: ms.fTimestamp,
+	}
+}
+
+func (ms *BoltMsgStore) restoreCounters(c boltMsgCounters) {
+	ms.first, ms.last = c.first, c.last
+	ms.totalCount, ms.totalBytes = c.totalCount, c.totalBytes
+	ms.hitLimit = c.hitLimit
+	ms.fTimestamp = c.fTimestamp
+}
+
+// enforceCountLimits removes the oldest messages until the count and size
+// limits are satisfied, but leaves at least one message.
+// Lock held on entry.
+func (ms *BoltMsgStore) enforceCountLimits(msgs *bolt.Bucket) error {
+	maxMsgs := ms.limits.MaxMsgs
+	maxBytes := ms.limits.MaxBytes
+	if maxMsgs <= 0 && maxBytes <= 0 {
+		return nil
+	}
+	c := msgs.Cursor()
+	for ms.totalCount > 1 &&
+		((maxMsgs > 0 && ms.totalCount > maxMsgs) ||
+			(maxBytes > 0 && ms.totalBytes > uint64(maxBytes))) {
+		k, v := c.First()
+		if k == nil {
+			break
+		}
+		size := uint64(len(v))
+		if err := c.Delete(); err != nil {
+			return err
+		}
+		ms.totalCount--
+		ms.totalBytes -= size
+		if k, _ = c.First(); k != nil {
+			ms.first = boltUint64(k)
+		}
+		if !ms.hitLimit {
+			ms.hitLimit = true
+			ms.log.Warnf(droppingMsgsFmt, ms.subject, ms.totalCount, ms.limits.MaxMsgs,
+				util.FriendlyBytes(int64(ms.totalBytes)), util.FriendlyBytes(ms.limits.MaxBytes))
+		}
+	}
+	return nil
+}
+
+// removeExpired removes the messages that are older than MaxAge and
+// records the timestamp of the first remaining message.
+// Lock held on entry.
+func (ms *BoltMsgStore) removeExpired(msgs *bolt.Bucket, now int64) error {
+	maxAge := int64(ms.limits.MaxAge)
+	ms.fTimestamp = 0
+	c := msgs.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.First() {
+		m := &pb.MsgProto{}
+		if err := m.Unmarshal(v); err != nil {
+			return err
+		}
+		if now-m.Timestamp < maxAge {
+			ms.first = boltUint64(k)
+			ms.fTimestamp = m.Timestamp
+			return nil
+		}
+		if err := c.Delete(); err != nil {
+			return err
+		}
+		ms.totalCount--
+		ms.totalBytes -= uint64(len(v))
+	}
+	// Nothing left
+	if ms.last > 0 {
+		ms.first = ms.last + 1
+	}
+	return nil
+}
+
+func (ms *BoltMsgStore) createExpireTimer() {
+	ms.wg.Add(1)
+	ms.expireTimer = time.AfterFunc(ms.msgExpireIn(ms.fTimestamp), ms.expireMsgs)
+}
+
+// expireMsgs removes all messages that have expired in this channel.
+func (ms *BoltMsgStore) expireMsgs() {
+	ms.Lock()
+	defer ms.Unlock()
+
+	if ms.closed {
+		ms.wg.Done()
+		return
+	}
+
+	now := time.Now().UnixNano()
+	saved := ms.saveCounters()
+	err := ms.db.Update(func(tx *bolt.Tx) error {
+		cb, err := boltChannelBucket(tx, ms.channel)
+		if err != nil {
+			return err
+		}
+		return ms.removeExpired(cb.Bucket(boltMsgsBucket), now)
+	})
+	if err != nil {
+		ms.restoreCounters(saved)
+		ms.log.Errorf("Unable to perform expiration for channel %q: %v", ms.subject, err)
+		ms.expireTimer.Reset(boltExpirationIntervalOnError)
+		return
+	}
+	// No message left, the timer will be recreated when a new message
+	// is added to the channel.
+	if ms.fTimestamp == 0 {
+		ms.expireTimer = nil
+		ms.wg.Done()
+		return
+	}
+	ms.expireTimer.Reset(ms.msgExpireIn(ms.fTimestamp))
+}
+
+// Lookup implements the MsgStore interface
+func (ms *BoltMsgStore) Lookup(seq uint64) (*pb.MsgProto, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	if seq < ms.first || seq > ms.last {
+		return nil, nil
+	}
+	return ms.lookup(func(c *bolt.Cursor) ([]byte, []byte) {
+		k, v := c.Seek(boltKey(seq))
+		if k == nil || boltUint64(k) != seq {
+			return nil, nil
+		}
+		return k, v
+	})
+}
+
+// lookup returns the message at the position selected by `position`,
+// or nil if there is none.
+// Lock held on entry.
+func (ms *BoltMsgStore) lookup(position func(c *bolt.Cursor) ([]byte, []byte)) (*pb.MsgProto, error) {
+	if ms.closed {
+		return nil, errBoltStoreClosed
+	}
+	var msg *pb.MsgProto
+	err := ms.db.View(func(tx *bolt.Tx) error {
+		cb, err := boltChannelBucket(tx, ms.channel)
+		if err != nil {
+			return err
+		}
+		_, v := position(cb.Bucket(boltMsgsBucket).Cursor())
+		if v == nil {
+			return nil
+		}
+		// Unmarshal copies the data, so the message remains valid
+		// after the transaction is closed.
+		msg = &pb.MsgProto{}
+		return msg.Unmarshal(v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// FirstMsg implements the MsgStore interface
+func (ms *BoltMsgStore) FirstMsg() (*pb.MsgProto, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	return ms.lookup(func(c *bolt.Cursor) ([]byte, []byte) { return c.First() })
+}
+
+// LastMsg implements the MsgStore interface
+func (ms *BoltMsgStore) LastMsg() (*pb.MsgProto, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	return ms.lookup(func(c *bolt.Cursor) ([]byte, []byte) { return c.Last() })
+}
+
+// GetSequenceFromTimestamp implements the MsgStore interface
+func (ms *BoltMsgStore) GetSequenceFromTimestamp(timestamp int64) (uint64, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	// No message ever stored
+	if ms.first == 0 {
+		return 0, nil
+	}
+	// All messages have expired
+	if ms.first > ms.last {
+		return ms.last + 1, nil
+	}
+	if ms.closed {
+		return 0, errBoltStoreClosed
+	}
+	seq := ms.last + 1
+	err := ms.db.View(func(tx *bolt.Tx) error {
+		cb, err := boltChannelBucket(tx, ms.channel)
+		if err != nil {
+			return err
+		}
+		c := cb.Bucket(boltMsgsBucket).Cursor()
+		// Timestamps are increasing with the sequence, but there may be
+		// gaps in the sequence. A lookup at `mid` returns the first
+		// message at or after `mid`.
+		lo, hi := ms.first, ms.last+1
+		for lo < hi {
+			mid := lo + (hi-lo)/2
+			k, v := c.Seek(boltKey(mid))
+			if k == nil {
+				hi = mid
+				continue
+			}
+			m := &pb.MsgProto{}
+			if err := m.Unmarshal(v); err != nil {
+				return err
+			}
+			if m.Timestamp >= timestamp {
+				hi = mid
+			} else {
+				lo = boltUint64(k) + 1
+			}
+		}
+		if k, _ := c.Seek(boltKey(lo)); k != nil {
+			seq = boltUint64(k)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// Empty implements the MsgStore interface
+func (ms *BoltMsgStore) Empty() error {
+	ms.Lock()
+	defer ms.Unlock()
+	if ms.closed {
+		return errBoltStoreClosed
+	}
+	err := ms.db.Update(func(tx *bolt.Tx) error {
+		cb, err := boltChannelBucket(tx, ms.channel)
+		if err != nil {
+			return err
+		}
+		if err := cb.DeleteBucket(boltMsgsBucket); err != nil {
+			return err
+		}
+		if _, err := cb.CreateBucket(boltMsgsBucket); err != nil {
+			return err
+		}
+		return cb.Delete(boltLastSeqKey)
+	})
+	if err != nil {
+		return err
+	}
+	ms.empty()
+	ms.fTimestamp = 0
+	if ms.expireTimer != nil {
+		if ms.expireTimer.Stop() {
+			ms.wg.Done()
+		}
+		ms.expireTimer = nil
+	}
+	return nil
+}
+
+// Flush implements the MsgStore interface
+func (ms *BoltMsgStore) Flush() error {
+	ms.RLock()
+	defer ms.RUnlock()
+	if !ms.noSync || ms.closed {
+		return nil
+	}
+	return ms.db.Sync()
+}
+
+// Close implements the MsgStore interface
+func (ms *BoltMsgStore) Close() error {
+	ms.Lock()
+	if ms.closed {
+		ms.Unlock()
+		return nil
+	}
+	ms.closed = true
+	if ms.expireTimer != nil {
+		if ms.expireTimer.Stop() {
+			ms.wg.Done()
+		}
+	}
+	ms.Unlock()
+
+	ms.wg.Wait()
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// BoltSubStore methods
+////////////////////////////////////////////////////////////////////////////
+
+// update runs `f` in a write transaction with the bucket holding the
+// subscriptions of this channel.
+// Lock held on entry.
+func (ss *BoltSubStore) update(f func(cb, subs *bolt.Bucket) error) error {
+	if ss.closed {
+		return errBoltStoreClosed
+	}
+	return ss.db.Update(func(tx *bolt.Tx) error {
+		cb, err := boltChannelBucket(tx, ss.channel)
+		if err != nil {
+			return err
+		}
+		return f(cb, cb.Bucket(boltSubsBucket))
+	})
+}
+
+// CreateSub implements the SubStore interface
+func (ss *BoltSubStore) CreateSub(sub *spb.SubState) error {
+	ss.Lock()
+	defer ss.Unlock()
+	if err := ss.createSub(sub); err != nil {
+		return err
+	}
+	subBytes, _ := sub.Marshal()
+	err := ss.update(func(cb, subs *bolt.Bucket) error {
+		if err := cb.Put(boltMaxSubIDKey, boltKey(ss.maxSubID)); err != nil {
+			return err
+		}
+		return putBoltSub(subs, sub.ID, subBytes)
+	})
+	if err != nil {
+		delete(ss.subs, sub.ID)
+		sub.ID = 0
+		return err
+	}
+	return nil
+}
+
+// putBoltSub creates, if needed, the bucket of the given subscription
+// and stores its state.
+func putBoltSub(subs *bolt.Bucket, subid uint64, subBytes []byte) error {
+	sb, err := subs.CreateBucketIfNotExists(boltKey(subid))
+	if err != nil {
+		return err
+	}
+	if _, err := sb.CreateBucketIfNotExists(boltPendingBucket); err != nil {
+		return err
+	}
+	return sb.Put(boltSubStateKey, subBytes)
+}
+
+// UpdateSub implements the SubStore interface
+func (ss *BoltSubStore) UpdateSub(sub *spb.SubState) error {
+	ss.Lock()
+	defer ss.Unlock()
+	subBytes, _ := sub.Marshal()
+	err := ss.update(func(cb, subs *bolt.Bucket) error {
+		if sub.ID > ss.maxSubID {
+			if err := cb.Put(boltMaxSubIDKey, boltKey(sub.ID)); err != nil {
+				return err
+			}
+		}
+		return putBoltSub(subs, sub.ID, subBytes)
+	})
+	if err != nil {
+		return err
+	}
+	// Like other stores, support updating a subscription for which
+	// there was no CreateSub.
+	ss.subs[sub.ID] = emptySub
+	if sub.ID > ss.maxSubID {
+		ss.maxSubID = sub.ID
+	}
+	return nil
+}
+
+// DeleteSub implements the SubStore interface
+func (ss *BoltSubStore) DeleteSub(subid uint64) error {
+	ss.Lock()
+	defer ss.Unlock()
+	err := ss.update(func(_, subs *bolt.Bucket) error {
+		if err := subs.DeleteBucket(boltKey(subid)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	delete(ss.subs, subid)
+	return nil
+}
+
+// AddSeqPending implements the SubStore interface
+func (ss *BoltSubStore) AddSeqPending(subid, seqno uint64) error {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.update(func(_, subs *bolt.Bucket) error {
+		// Ignore updates for a subscription that has been deleted.
+		sb := subs.Bucket(boltKey(subid))
+		if sb == nil {
+			return nil
+		}
+		key := boltKey(seqno)
+		if err := sb.Bucket(boltPendingBucket).Put(key, nil); err != nil {
+			return err
+		}
+		if seqno > boltUint64(sb.Get(boltLastSentKey)) {
+			return sb.Put(boltLastSentKey, key)
+		}
+		return nil
+	})
+}
+
+// AckSeqPending implements the SubStore interface
+func (ss *BoltSubStore) AckSeqPending(subid, seqno uint64) error {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.update(func(_, subs *bolt.Bucket) error {
+		sb := subs.Bucket(boltKey(subid))
+		if sb == nil {
+			return nil
+		}
+		return sb.Bucket(boltPendingBucket).Delete(boltKey(seqno))
+	})
+}
+
+// Flush implements the SubStore interface
+func (ss *BoltSubStore) Flush() error {
+	ss.RLock()
+	defer ss.RUnlock()
+	if !ss.noSync || ss.closed {
+		return nil
+	}
+	return ss.db.Sync()
+}
+
+// Close implements the SubStore interface
+func (ss *BoltSubStore) Close() error {
+	ss.Lock()
+	ss.closed = true
+	ss.Unlock()
+	return nil
+}
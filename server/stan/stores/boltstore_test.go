@@ -0,0 +1,196 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+var testBoltDefaultDatastore string
+
+func init() {
+	tmpDir, err := os.MkdirTemp(".", "bolt_data_stores_")
+	if err != nil {
+		panic("Could not create tmp dir")
+	}
+	if err := os.Remove(tmpDir); err != nil {
+		panic(fmt.Errorf("Error removing temp directory: %v", err))
+	}
+	testBoltDefaultDatastore = tmpDir
+}
+
+func cleanupBoltDatastore(t tLogger) {
+	if err := os.RemoveAll(testBoltDefaultDatastore); err != nil {
+		stackFatalf(t, "Error cleaning up datastore: %v", err)
+	}
+}
+
+func newBoltStore(t tLogger, dataStore string, limits *StoreLimits, options ...BoltStoreOption) (*BoltStore, *RecoveredState) {
+	bs, err := NewBoltStore(testLogger, dataStore, limits, options...)
+	if err != nil {
+		stackFatalf(t, "Error creating bolt store: %v", err)
+	}
+	state, err := bs.Recover()
+	if err != nil {
+		bs.Close()
+		stackFatalf(t, "Error recovering bolt store: %v", err)
+	}
+	return bs, state
+}
+
+func createDefaultBoltStore(t tLogger, options ...BoltStoreOption) *BoltStore {
+	limits := testDefaultStoreLimits
+	bs, state := newBoltStore(t, testBoltDefaultDatastore, &limits, options...)
+	if state == nil {
+		info := testDefaultServerInfo
+		if err := bs.Init(&info); err != nil {
+			stackFatalf(t, "Unexpected error during Init: %v", err)
+		}
+	}
+	return bs
+}
+
+func openDefaultBoltStoreWithLimits(t tLogger, limits *StoreLimits, options ...BoltStoreOption) (*BoltStore, *RecoveredState) {
+	if limits == nil {
+		l := testDefaultStoreLimits
+		limits = &l
+	}
+	return newBoltStore(t, testBoltDefaultDatastore, limits, options...)
+}
+
+func TestBoltOptions(t *testing.T) {
+	if _, err := NewBoltStore(testLogger, "", nil); err == nil {
+		t.Fatal("Expected error with empty root directory")
+	}
+	if _, err := NewBoltStore(testLogger, testBoltDefaultDatastore, nil, BoltOpenTimeout(-1)); err == nil {
+		t.Fatal("Expected error with negative open timeout")
+	}
+
+	cleanupBoltDatastore(t)
+	defer cleanupBoltDatastore(t)
+
+	expected := BoltStoreOptions{NoSync: true, OpenTimeout: time.Second}
+	bs, err := NewBoltStore(testLogger, testBoltDefaultDatastore, nil, BoltAllOptions(&expected))
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer bs.Close()
+	if *bs.opts != expected {
+		t.Fatalf("Expected options to be %v, got %v", expected, *bs.opts)
+	}
+}
+
+func TestBoltGetExclusiveLock(t *testing.T) {
+	cleanupBoltDatastore(t)
+	defer cleanupBoltDatastore(t)
+
+	limits := testDefaultStoreLimits
+	bs, err := NewBoltStore(testLogger, testBoltDefaultDatastore, &limits)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer bs.Close()
+	for i := 0; i < 2; i++ {
+		// GetExclusiveLock should return true even if called more than once
+		locked, err := bs.GetExclusiveLock()
+		if err != nil {
+			t.Fatalf("Error getting exclusive lock: %v", err)
+		}
+		if !locked {
+			t.Fatal("Should have been locked")
+		}
+	}
+	bs.RLock()
+	db := bs.db
+	lockFile := bs.lockFile
+	bs.RUnlock()
+	// The database should not be opened until it is used, so that a
+	// standby does not hold it.
+	if db != nil {
+		t.Fatal("Database should not have been opened")
+	}
+	bs.Close()
+	if !lockFile.IsClosed() {
+		t.Fatal("LockFile should have been closed")
+	}
+	if locked, err := bs.GetExclusiveLock(); locked || err != nil {
+		t.Fatalf("Expected lock to fail without error on closed store, got %v - %v", locked, err)
+	}
+}
+
+func TestBoltNoSync(t *testing.T) {
+	cleanupBoltDatastore(t)
+	defer cleanupBoltDatastore(t)
+
+	bs := createDefaultBoltStore(t, BoltNoSync(true))
+	defer bs.Close()
+
+	cs := storeCreateChannel(t, bs, "foo")
+	storeMsg(t, cs, "foo", 1, []byte("hello"))
+	subID := storeSub(t, cs, "foo")
+	storeSubPending(t, cs, "foo", subID, 1)
+	if err := cs.Msgs.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	if err := cs.Subs.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	bs.Close()
+
+	bs, state := openDefaultBoltStoreWithLimits(t, nil)
+	defer bs.Close()
+	rc := getRecoveredChannel(t, state, "foo")
+	if n, _ := msgStoreState(t, rc.Msgs); n != 1 {
+		t.Fatalf("Expected 1 message, got %v", n)
+	}
+	subs := getRecoveredSubs(t, state, "foo", 1)
+	if len(subs[0].Pending) != 1 {
+		t.Fatalf("Expected 1 pending message, got %v", subs[0].Pending)
+	}
+}
+
+func TestBoltKeepLastSentOnRecovery(t *testing.T) {
+	cleanupBoltDatastore(t)
+	defer cleanupBoltDatastore(t)
+
+	bs := createDefaultBoltStore(t)
+	defer bs.Close()
+
+	cs := storeCreateChannel(t, bs, "foo")
+	subID := storeSub(t, cs, "foo")
+	for seq := uint64(1); seq <= 3; seq++ {
+		storeMsg(t, cs, "foo", seq, []byte("hello"))
+		storeSubPending(t, cs, "foo", subID, seq)
+	}
+	if err := cs.Msgs.Empty(); err != nil {
+		t.Fatalf("Error emptying store: %v", err)
+	}
+	bs.Close()
+
+	bs, state := openDefaultBoltStoreWithLimits(t, nil)
+	defer bs.Close()
+	rc := getRecoveredChannel(t, state, "foo")
+	// Even though the messages are gone, the sequence should not go back
+	// below what was sent to the subscription.
+	if first, last := msgStoreFirstAndLastSequence(t, rc.Msgs); first != 4 || last != 3 {
+		t.Fatalf("Expected first/last to be 4/3, got %v/%v", first, last)
+	}
+	subs := getRecoveredSubs(t, state, "foo", 1)
+	if subs[0].Sub.LastSent != 3 {
+		t.Fatalf("Expected LastSent to be 3, got %v", subs[0].Sub.LastSent)
+	}
+}
@@ -14,6 +14,7 @@
 package stores
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -56,6 +57,12 @@ type genericSubStore struct {
 	maxSubID uint64
 }
 
+// msgStoreWrapper is implemented by the stores wrapping a MsgStore
+// implementation, so that the wrapped store can be retrieved.
+type msgStoreWrapper interface {
+	unwrapMsgStore() MsgStore
+}
+
 // genericMsgStore is the generic store implementation that manages messages
 // for a given channel.
 type genericMsgStore struct {
@@ -88,6 +95,24 @@ func (gs *genericStore) init(name string, log logger.Logger, limits *StoreLimits
 	return nil
 }
 
+// lockStore acquires the exclusive lock of store `s`, described by `name`
+// in the returned error, and fails if the lock is held by another process.
+// Stores that don't support the lock can't be shared, so this is not an
+// error.
+func lockStore(s Store, name string) error {
+	locked, err := s.GetExclusiveLock()
+	if err == ErrNotSupported {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to acquire the lock of the %s store: %v", name, err)
+	}
+	if !locked {
+		return fmt.Errorf("%s store is locked by another process", name)
+	}
+	return nil
+}
+
 // GetExclusiveLock implements the Store interface.
 func (gs *genericStore) GetExclusiveLock() (bool, error) {
 	// Need to be implementation specific.
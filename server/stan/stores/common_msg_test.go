@@ -25,7 +25,7 @@ import (
 func getCryptoOverhead(s MsgStore) uint64 {
 	if cms, ok := s.(*CryptoMsgStore); ok {
 		cms.Lock()
-		overhead := 1 + cms.eds.nonceSize + cms.eds.cryptoOverhead
+		overhead := cms.eds.EncryptionOffset() + cms.eds.cryptoOverhead
 		cms.Unlock()
 		return uint64(overhead)
 	}
@@ -614,7 +614,8 @@ func TestCSGetSeqFromStartTime(t *testing.T) {
 
 				for i := 0; i < len(times); i++ {
 					s.Close()
-					s, state := testReOpenStore(t, st, &limits)
+					var state *RecoveredState
+					s, state = testReOpenStore(t, st, &limits)
 					defer s.Close()
 
 					cs := getRecoveredChannel(t, state, "foo")
@@ -688,7 +689,7 @@ func TestCSFirstAndLastMsg(t *testing.T) {
 					firstMsg = ms.firstMsg
 					lastMsg = ms.lastMsg
 					ms.RUnlock()
-				case TypeSQL:
+				case TypeSQL, TypeBolt, TypeHybrid:
 					// Not applicable since this store does not store
 					// the first and last message.
 					firstMsg = msgStoreFirstMsg(t, cs.Msgs)
@@ -41,9 +41,12 @@ var testDefaultStoreLimits = StoreLimits{
 		SubStoreLimits{
 			MaxSubscriptions: 1000,
 		},
+		PubRateLimits{},
 		0,
+		false,
 	},
 	nil,
+	nil,
 }
 
 var testDefaultServerInfo = spb.ServerInfo{
@@ -67,6 +70,8 @@ var (
 		{TypeFile, true},
 		{TypeSQL, true},
 		{TypeRaft, false},
+		{TypeBolt, true},
+		{TypeHybrid, true},
 	}
 	testTimestampMu   sync.Mutex
 	testLastTimestamp int64
@@ -319,6 +324,12 @@ func startTest(t tLogger, ts *testStore) Store {
 	case TypeRaft:
 		cleanupRaftDatastore(t)
 		s = createDefaultRaftStore(t)
+	case TypeBolt:
+		cleanupBoltDatastore(t)
+		s = createDefaultBoltStore(t)
+	case TypeHybrid:
+		cleanupHybridDatastore(t)
+		s = createDefaultHybridStore(t)
 	default:
 		// This is used with testStores table. If a store type has been
 		// added there, it needs to be added here.
@@ -345,6 +356,10 @@ func endTest(t tLogger, ts *testStore) {
 		cleanupSQLDatastore(t)
 	case TypeRaft:
 		cleanupRaftDatastore(t)
+	case TypeBolt:
+		cleanupBoltDatastore(t)
+	case TypeHybrid:
+		cleanupHybridDatastore(t)
 	}
 }
 
@@ -357,6 +372,10 @@ func testReOpenStore(t tLogger, ts *testStore, limits *StoreLimits) (Store, *Rec
 		return openDefaultFileStoreWithLimits(t, limits)
 	case TypeSQL:
 		return openDefaultSQLStoreWithLimits(t, limits)
+	case TypeBolt:
+		return openDefaultBoltStoreWithLimits(t, limits)
+	case TypeHybrid:
+		return openDefaultHybridStoreWithLimits(t, limits)
 	default:
 		// This is used with testStores table. If a recoverable
 		// store type has been added there, it needs to be added here.
@@ -376,6 +395,8 @@ func isStorageBasedOnFile(s Store) bool {
 		return true
 	case *RaftStore:
 		return true
+	case *HybridStore:
+		return true
 	default:
 		return false
 	}
@@ -403,6 +424,7 @@ func TestMain(m *testing.M) {
 		defaultSources := make(map[string][]string)
 		defaultSources[test.DriverMySQL] = []string{testDefaultMySQLSource, testDefaultMySQLSourceAdmin}
 		defaultSources[test.DriverPostgres] = []string{testDefaultPostgresSource, testDefaultPostgresSourceAdmin}
+		defaultSources[test.DriverSQLite] = []string{testDefaultSQLiteSource, ""}
 		if err := test.ProcessSQLFlags(flag.CommandLine, defaultSources); err != nil {
 			fmt.Println(err.Error())
 			os.Exit(2)
@@ -536,6 +558,10 @@ func TestCSInit(t *testing.T) {
 				if err == nil {
 					s = NewRaftStore(testLogger, s, nil)
 				}
+			case TypeBolt:
+				s, err = NewBoltStore(testLogger, testBoltDefaultDatastore, nil)
+			case TypeHybrid:
+				s, err = NewHybridStore(testLogger, testHybridDefaultDatastore, nil, 0)
 			default:
 				panic(fmt.Errorf("Add store type %q in this test", st.name))
 			}
@@ -1008,7 +1034,9 @@ func TestCSPerChannelLimits(t *testing.T) {
 				SubStoreLimits{
 					MaxSubscriptions: 1,
 				},
+				PubRateLimits{},
 				0,
+				false,
 			}
 			barLimits := ChannelLimits{
 				MsgStoreLimits{
@@ -1018,7 +1046,9 @@ func TestCSPerChannelLimits(t *testing.T) {
 				SubStoreLimits{
 					MaxSubscriptions: 2,
 				},
+				PubRateLimits{},
 				0,
+				false,
 			}
 			noSubsOverrideLimits := ChannelLimits{
 				MsgStoreLimits{
@@ -1026,14 +1056,18 @@ func TestCSPerChannelLimits(t *testing.T) {
 					MaxBytes: 6 * 1024,
 				},
 				SubStoreLimits{},
+				PubRateLimits{},
 				0,
+				false,
 			}
 			noMaxMsgOverrideLimits := ChannelLimits{
 				MsgStoreLimits{
 					MaxBytes: 7 * 1024,
 				},
 				SubStoreLimits{},
+				PubRateLimits{},
 				0,
+				false,
 			}
 			if testUseEncryption {
 				noMaxMsgOverrideLimits.MaxBytes += int64(100 * getCryptoOverhead(oc.Msgs))
@@ -1043,7 +1077,9 @@ func TestCSPerChannelLimits(t *testing.T) {
 					MaxMsgs: 10,
 				},
 				SubStoreLimits{},
+				PubRateLimits{},
 				0,
+				false,
 			}
 
 			storeLimits.AddPerChannel("foo", &fooLimits)
@@ -0,0 +1,467 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+	"github.com/kubemq-io/broker/server/stan/spb"
+	"github.com/kubemq-io/broker/server/stan/util"
+)
+
+// CompressedStore specific errors
+var (
+	ErrCompressionCodecNotSupported = errors.New("compression codec not supported")
+)
+
+const (
+	// CompressionNone can be used in the per-channel map to disable
+	// compression for some channels.
+	CompressionNone = "NONE"
+
+	// CompressionDeflate is the name of the DEFLATE codec
+	CompressionDeflate = "DEFLATE"
+)
+
+// Code of the DEFLATE codec. Code 0 is reserved to mark payloads that are
+// stored as-is even though they start with the compression header.
+const compressCodeDeflate = byte(1)
+
+// Payloads smaller than this are not worth compressing.
+const compressMinSize = 64
+
+// Compressed payloads start with this header, followed by the codec code.
+// Payloads stored before compression was enabled are returned as-is, based
+// on the compression marker, not on this header (see compressMarkerClientID).
+var compressMagic = []byte{0, 'N', 'S', 'Z'}
+
+// compressMarkerClientID is the ID of the client record in which the
+// CompressedStore persists, when compression is enabled for the first
+// time, the last sequence of each channel. Messages up to this sequence
+// were stored before compression was enabled, and their payloads are
+// returned as-is, whatever they start with. Channels created afterwards
+// are not in the record. Once this record exists, payloads keep being
+// stored in the compression format, even if compression is disabled.
+// The record is removed from the recovered state.
+const compressMarkerClientID = "_STAN.compression"
+
+const compressHeaderSize = 5
+
+// CompressionCodec compresses and decompresses message payloads.
+type CompressionCodec interface {
+	// Name is the name used to select this codec in the configuration.
+	Name() string
+	// Code identifies the codec in stored payloads, so it must never
+	// change once payloads have been stored with it.
+	Code() byte
+	// Compress appends the compressed `data` to `dst`.
+	Compress(dst, data []byte) ([]byte, error)
+	// Decompress returns the decompressed `data`.
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	codecsMu     sync.RWMutex
+	codecsByCode = map[byte]CompressionCodec{}
+	codecsByName = map[string]CompressionCodec{}
+)
+
+func init() {
+	RegisterCompressionCodec(&deflateCodec{})
+}
+
+// RegisterCompressionCodec makes a codec available to the CompressedStore.
+// A codec must be registered before the store is recovered if some
+// payloads have been compressed with it.
+func RegisterCompressionCodec(c CompressionCodec) error {
+	name := strings.ToUpper(c.Name())
+	if c.Code() == 0 || name == "" || name == CompressionNone {
+		return fmt.Errorf("invalid compression codec %q (code %v)", c.Name(), c.Code())
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, exists := codecsByCode[c.Code()]; exists {
+		return fmt.Errorf("compression codec code %v already registered", c.Code())
+	}
+	if _, exists := codecsByName[name]; exists {
+		return fmt.Errorf("compression codec %q already registered", name)
+	}
+	codecsByCode[c.Code()] = c
+	codecsByName[name] = c
+	return nil
+}
+
+// lookupCodec returns the codec registered under this name, or nil for
+// CompressionNone.
+func lookupCodec(name string) (CompressionCodec, error) {
+	name = strings.ToUpper(name)
+	if name == CompressionNone {
+		return nil, nil
+	}
+	codecsMu.RLock()
+	c := codecsByName[name]
+	codecsMu.RUnlock()
+	if c == nil {
+		return nil, ErrCompressionCodecNotSupported
+	}
+	return c, nil
+}
+
+type deflateCodec struct {
+	writers sync.Pool
+}
+
+func (dc *deflateCodec) Name() string { return CompressionDeflate }
+func (dc *deflateCodec) Code() byte   { return compressCodeDeflate }
+
+func (dc *deflateCodec) Compress(dst, data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, _ := dc.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(buf, flate.BestSpeed); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(buf)
+	}
+	_, err := w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	dc.writers.Put(w)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (dc *deflateCodec) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// CompressionStats reports how well the payloads of a channel compress.
+// They cover the messages stored since the server started.
+type CompressionStats struct {
+	Codec             string  `json:"codec"`
+	UncompressedBytes uint64  `json:"uncompressed_bytes"`
+	CompressedBytes   uint64  `json:"compressed_bytes"`
+	Ratio             float64 `json:"ratio"`
+}
+
+// CompressedStore is a store wrapping a store implementation
+// and adds payload compression support. When used with a CryptoStore,
+// it must wrap the CryptoStore so that payloads are compressed before
+// being encrypted.
+type CompressedStore struct {
+	sync.Mutex
+	Store
+	codec   CompressionCodec
+	sublist *util.Sublist
+	enabled bool
+	// Last sequence of the channels stored before compression was enabled,
+	// nil if compression has never been enabled for this store.
+	legacy      map[string]uint64
+	savePending bool
+}
+
+// CompressedMsgStore is a store wrapping a MsgStore implementation
+// and adds compression support.
+type CompressedMsgStore struct {
+	MsgStore
+	codec CompressionCodec
+	// Messages up to this sequence were stored before compression
+	// was enabled.
+	legacyLastSeq uint64
+	// Payload bytes before and after compression.
+	inBytes  uint64
+	outBytes uint64
+}
+
+// NewCompressedStore returns a CompressedStore instance with given
+// underlying store. Payloads are compressed with the codec named `codec`,
+// unless overridden for a channel in `perChannel`, whose keys can contain
+// wildcards. Use CompressionNone to disable compression. If compression
+// is disabled and has never been enabled for the underlying store, the
+// CompressedStore does not wrap the message stores.
+func NewCompressedStore(s Store, codec string, perChannel map[string]string) (*CompressedStore, error) {
+	if codec == "" {
+		codec = CompressionNone
+	}
+	c, err := lookupCodec(codec)
+	if err != nil {
+		return nil, err
+	}
+	cs := &CompressedStore{Store: s, codec: c, sublist: util.NewSublist(), enabled: c != nil || len(perChannel) > 0}
+	if cs.enabled {
+		// Until Recover says otherwise, there is no legacy message, and the
+		// marker is saved when the first channel is created.
+		cs.legacy = make(map[string]uint64)
+		cs.savePending = true
+	}
+	for channel, name := range perChannel {
+		if !util.IsChannelNameValid(channel, true) {
+			return nil, fmt.Errorf("invalid channel name %q in compression settings", channel)
+		}
+		if _, err := lookupCodec(name); err != nil {
+			return nil, fmt.Errorf("channel %q: %v", channel, err)
+		}
+		// Insert the name, the sublist does not accept nil elements.
+		cs.sublist.Insert(channel, name)
+	}
+	return cs, nil
+}
+
+// Returns the codec for this channel, or nil if it should not be compressed.
+func (cs *CompressedStore) channelCodec(channel string) CompressionCodec {
+	r := cs.sublist.Match(channel)
+	if len(r) == 0 {
+		return cs.codec
+	}
+	// Like for limits, the last element is the narrowest match. Names
+	// have been validated when the store was created.
+	c, _ := lookupCodec(r[len(r)-1].(string))
+	return c
+}
+
+// Enabled returns true if compression is enabled for at least some channels.
+func (cs *CompressedStore) Enabled() bool {
+	return cs.enabled
+}
+
+// Recover implements the Store interface
+func (cs *CompressedStore) Recover() (*RecoveredState, error) {
+	cs.Lock()
+	defer cs.Unlock()
+	rs, err := cs.Store.Recover()
+	if err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		return nil, nil
+	}
+	found := false
+	for i, c := range rs.Clients {
+		if c.ID != compressMarkerClientID {
+			continue
+		}
+		legacy := make(map[string]uint64)
+		if err := json.Unmarshal([]byte(c.HbInbox), &legacy); err != nil {
+			return nil, fmt.Errorf("unable to decode compression marker: %v", err)
+		}
+		cs.legacy, cs.savePending, found = legacy, false, true
+		rs.Clients = append(rs.Clients[:i], rs.Clients[i+1:]...)
+		break
+	}
+	if !found {
+		if !cs.enabled {
+			// Payloads have never been compressed.
+			return rs, nil
+		}
+		// Compression is enabled for the first time, existing messages are
+		// stored as-is.
+		for cn, rc := range rs.Channels {
+			last, err := rc.Channel.Msgs.LastSequence()
+			if err != nil {
+				return nil, err
+			}
+			if last > 0 {
+				cs.legacy[cn] = last
+			}
+		}
+		if err := cs.saveMarker(); err != nil {
+			return nil, err
+		}
+	}
+	for cn, rc := range rs.Channels {
+		rc.Channel.Msgs = &CompressedMsgStore{MsgStore: rc.Channel.Msgs, codec: cs.channelCodec(cn), legacyLastSeq: cs.legacy[cn]}
+	}
+	return rs, nil
+}
+
+// saveMarker persists the compression marker.
+// Lock is held on entry.
+func (cs *CompressedStore) saveMarker() error {
+	data, err := json.Marshal(cs.legacy)
+	if err != nil {
+		return err
+	}
+	if _, err := cs.Store.AddClient(&spb.ClientInfo{ID: compressMarkerClientID, HbInbox: string(data)}); err != nil {
+		return fmt.Errorf("unable to save compression marker: %v", err)
+	}
+	cs.savePending = false
+	return nil
+}
+
+// CreateChannel implements the Store interface
+func (cs *CompressedStore) CreateChannel(channel string) (*Channel, error) {
+	cs.Lock()
+	defer cs.Unlock()
+
+	c, err := cs.Store.CreateChannel(channel)
+	if err != nil {
+		return nil, err
+	}
+	if cs.legacy == nil {
+		return c, nil
+	}
+	if cs.savePending {
+		if err := cs.saveMarker(); err != nil {
+			return nil, err
+		}
+	}
+	c.Msgs = &CompressedMsgStore{MsgStore: c.Msgs, codec: cs.channelCodec(channel)}
+	return c, nil
+}
+
+// DeleteChannel implements the Store interface
+func (cs *CompressedStore) DeleteChannel(channel string) error {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if err := cs.Store.DeleteChannel(channel); err != nil {
+		return err
+	}
+	// A channel recreated with the same name has no legacy messages.
+	if _, ok := cs.legacy[channel]; ok {
+		delete(cs.legacy, channel)
+		return cs.saveMarker()
+	}
+	return nil
+}
+
+// Stats returns the compression statistics of this channel.
+func (cms *CompressedMsgStore) Stats() *CompressionStats {
+	stats := &CompressionStats{
+		Codec:             CompressionNone,
+		UncompressedBytes: atomic.LoadUint64(&cms.inBytes),
+		CompressedBytes:   atomic.LoadUint64(&cms.outBytes),
+	}
+	if cms.codec != nil {
+		stats.Codec = cms.codec.Name()
+	}
+	if stats.CompressedBytes > 0 {
+		stats.Ratio = float64(stats.UncompressedBytes) / float64(stats.CompressedBytes)
+	}
+	return stats
+}
+
+// unwrapMsgStore implements the msgStoreWrapper interface
+func (cms *CompressedMsgStore) unwrapMsgStore() MsgStore {
+	return cms.MsgStore
+}
+
+// Store implements the MsgStore interface
+func (cms *CompressedMsgStore) Store(msg *pb.MsgProto) (uint64, error) {
+	if len(msg.Data) == 0 {
+		return cms.MsgStore.Store(msg)
+	}
+	cd, err := cms.compress(msg.Data)
+	if err != nil {
+		return 0, err
+	}
+	atomic.AddUint64(&cms.inBytes, uint64(len(msg.Data)))
+	atomic.AddUint64(&cms.outBytes, uint64(len(cd)))
+	msg.Data = cd
+	return cms.MsgStore.Store(msg)
+}
+
+func (cms *CompressedMsgStore) compress(data []byte) ([]byte, error) {
+	hasMagic := bytes.HasPrefix(data, compressMagic)
+	if cms.codec != nil && len(data) >= compressMinSize {
+		buf := make([]byte, compressHeaderSize, compressHeaderSize+len(data))
+		copy(buf, compressMagic)
+		buf[len(compressMagic)] = cms.codec.Code()
+		cd, err := cms.codec.Compress(buf, data)
+		if err != nil {
+			return nil, err
+		}
+		// Keep the compressed payload only if it is actually smaller.
+		if len(cd) < len(data) {
+			return cd, nil
+		}
+	}
+	if !hasMagic {
+		return data, nil
+	}
+	// Payload would be mistaken for a compressed one, so add a header
+	// indicating that it is stored as-is.
+	buf := make([]byte, compressHeaderSize+len(data))
+	copy(buf, compressMagic)
+	copy(buf[compressHeaderSize:], data)
+	return buf, nil
+}
+
+func (cms *CompressedMsgStore) decompressedMsg(m *pb.MsgProto) (*pb.MsgProto, error) {
+	if m.Sequence <= cms.legacyLastSeq || !bytes.HasPrefix(m.Data, compressMagic) || len(m.Data) < compressHeaderSize {
+		return m, nil
+	}
+	var dd []byte
+	if code := m.Data[len(compressMagic)]; code == 0 {
+		dd = m.Data[compressHeaderSize:]
+	} else {
+		codecsMu.RLock()
+		c := codecsByCode[code]
+		codecsMu.RUnlock()
+		if c == nil {
+			return nil, fmt.Errorf("unable to decompress message %v, unknown codec %v", m.Sequence, code)
+		}
+		var err error
+		if dd, err = c.Decompress(m.Data[compressHeaderSize:]); err != nil {
+			return nil, fmt.Errorf("unable to decompress message %v: %v", m.Sequence, err)
+		}
+	}
+	// Store owns the message, so make a copy before returning
+	retMsg := *m
+	retMsg.Data = dd
+	return &retMsg, nil
+}
+
+// Lookup implements the MsgStore interface
+func (cms *CompressedMsgStore) Lookup(seq uint64) (*pb.MsgProto, error) {
+	m, err := cms.MsgStore.Lookup(seq)
+	if m == nil || m.Data == nil || err != nil {
+		return m, err
+	}
+	return cms.decompressedMsg(m)
+}
+
+// FirstMsg implements the MsgStore interface
+func (cms *CompressedMsgStore) FirstMsg() (*pb.MsgProto, error) {
+	m, err := cms.MsgStore.FirstMsg()
+	if m == nil || m.Data == nil || err != nil {
+		return m, err
+	}
+	return cms.decompressedMsg(m)
+}
+
+// LastMsg implements the MsgStore interface
+func (cms *CompressedMsgStore) LastMsg() (*pb.MsgProto, error) {
+	m, err := cms.MsgStore.LastMsg()
+	if m == nil || m.Data == nil || err != nil {
+		return m, err
+	}
+	return cms.decompressedMsg(m)
+}
@@ -0,0 +1,295 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var testCompressiblePayload = []byte(strings.Repeat(`{"type":"order","total":150,"items":["a","b"]}`, 20))
+
+func TestCompressedStoreOptions(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	s := createDefaultFileStore(t)
+	defer s.Close()
+
+	if _, err := NewCompressedStore(s, "unknown", nil); err != ErrCompressionCodecNotSupported {
+		t.Fatalf("Expected error %q, got %v", ErrCompressionCodecNotSupported, err)
+	}
+	if _, err := NewCompressedStore(s, CompressionDeflate, map[string]string{"foo": "unknown"}); err == nil {
+		t.Fatal("Expected error for unknown per-channel codec")
+	}
+	if _, err := NewCompressedStore(s, CompressionDeflate, map[string]string{"foo..bar": CompressionNone}); err == nil {
+		t.Fatal("Expected error for invalid channel name")
+	}
+	if err := RegisterCompressionCodec(&deflateCodec{}); err == nil {
+		t.Fatal("Expected error registering codec twice")
+	}
+}
+
+func TestCompressedStore(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	s := createDefaultFileStore(t)
+	defer s.Close()
+
+	// Store some messages before compression is enabled, including one
+	// that looks like a compressed payload.
+	legacy := [][]byte{
+		testCompressiblePayload,
+		append(append([]byte(nil), compressMagic...), compressCodeDeflate, 'x', 'y'),
+	}
+	c := storeCreateChannel(t, s, "foo")
+	for i, p := range legacy {
+		storeMsg(t, c, "foo", uint64(i+1), p)
+	}
+	s.Close()
+
+	s, _ = openDefaultFileStore(t)
+	cs, err := NewCompressedStore(s, strings.ToLower(CompressionDeflate), map[string]string{"bar.>": CompressionNone})
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer cs.Close()
+	rs, err := cs.Recover()
+	if err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	if len(rs.Clients) != 0 {
+		t.Fatalf("Compression marker should not be recovered as a client: %v", rs.Clients)
+	}
+	c = getRecoveredChannel(t, rs, "foo")
+	// Payloads that start with the compression header, or are too small,
+	// must be returned as-is too.
+	payloads := append(legacy,
+		testCompressiblePayload,
+		append(append([]byte(nil), compressMagic...), 1, 2, 3),
+		[]byte("small"),
+	)
+	for i := len(legacy); i < len(payloads); i++ {
+		storeMsg(t, c, "foo", uint64(i+1), payloads[i])
+	}
+	bc := storeCreateChannel(t, cs, "bar.baz")
+	storeMsg(t, bc, "bar.baz", 1, testCompressiblePayload)
+
+	check := func(ms MsgStore) {
+		t.Helper()
+		for i, p := range payloads {
+			if m := msgStoreLookup(t, ms, uint64(i+1)); !bytes.Equal(m.Data, p) {
+				t.Fatalf("Unexpected payload for message %v: %q", i+1, m.Data)
+			}
+		}
+		if m := msgStoreFirstMsg(t, ms); !bytes.Equal(m.Data, payloads[0]) {
+			t.Fatalf("Unexpected first message payload: %q", m.Data)
+		}
+		if m := msgStoreLastMsg(t, ms); !bytes.Equal(m.Data, payloads[len(payloads)-1]) {
+			t.Fatalf("Unexpected last message payload: %q", m.Data)
+		}
+	}
+	check(c.Msgs)
+
+	stats := c.Msgs.(*CompressedMsgStore).Stats()
+	if stats.Codec != CompressionDeflate || stats.Ratio <= 1 || stats.CompressedBytes >= stats.UncompressedBytes {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	stats = bc.Msgs.(*CompressedMsgStore).Stats()
+	if stats.Codec != CompressionNone || stats.CompressedBytes != stats.UncompressedBytes {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	cs.Close()
+
+	// Check what is actually stored.
+	s, rs = openDefaultFileStore(t)
+	raw := getRecoveredChannel(t, rs, "foo").Msgs
+	for i, p := range legacy {
+		if m := msgStoreLookup(t, raw, uint64(i+1)); !bytes.Equal(m.Data, p) {
+			t.Fatalf("Legacy payload should not have been modified: %q", m.Data)
+		}
+	}
+	if m := msgStoreLookup(t, raw, 3); !bytes.HasPrefix(m.Data, compressMagic) || len(m.Data) >= len(testCompressiblePayload) {
+		t.Fatalf("Payload should have been compressed: %q", m.Data)
+	}
+	if m := msgStoreLookup(t, raw, 4); len(m.Data) != len(payloads[3])+compressHeaderSize || m.Data[len(compressMagic)] != 0 {
+		t.Fatalf("Payload should have been stored with header: %q", m.Data)
+	}
+	if m := msgStoreLookup(t, getRecoveredChannel(t, rs, "bar.baz").Msgs, 1); !bytes.Equal(m.Data, testCompressiblePayload) {
+		t.Fatalf("Payload should not have been compressed: %q", m.Data)
+	}
+	s.Close()
+
+	// Reopen with compression disabled, previously compressed payloads
+	// must still be readable.
+	s, _ = openDefaultFileStore(t)
+	cs, err = NewCompressedStore(s, CompressionNone, nil)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer cs.Close()
+	if rs, err = cs.Recover(); err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	check(getRecoveredChannel(t, rs, "foo").Msgs)
+	// Payloads are still stored in the compression format.
+	c = getRecoveredChannel(t, rs, "foo")
+	magic := append(append([]byte(nil), compressMagic...), compressCodeDeflate)
+	storeMsg(t, c, "foo", uint64(len(payloads)+1), magic)
+	if m := msgStoreLookup(t, c.Msgs, uint64(len(payloads)+1)); !bytes.Equal(m.Data, magic) {
+		t.Fatalf("Unexpected payload: %q", m.Data)
+	}
+}
+
+func TestCompressedStoreNotEnabled(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	s := createDefaultFileStore(t)
+	defer s.Close()
+
+	cs, err := NewCompressedStore(s, CompressionNone, nil)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer cs.Close()
+	if cs.Enabled() {
+		t.Fatal("Compression should not be enabled")
+	}
+	if _, err := cs.Recover(); err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	// Message stores are not wrapped and payloads are stored as-is.
+	c := storeCreateChannel(t, cs, "foo")
+	if _, ok := c.Msgs.(*CompressedMsgStore); ok {
+		t.Fatal("Message store should not have been wrapped")
+	}
+	magic := append(append([]byte(nil), compressMagic...), compressCodeDeflate, 'x')
+	storeMsg(t, c, "foo", 1, magic)
+	cs.Close()
+
+	// Once enabled, the message stored before is returned as-is.
+	s, _ = openDefaultFileStore(t)
+	cs, err = NewCompressedStore(s, CompressionDeflate, nil)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer cs.Close()
+	rs, err := cs.Recover()
+	if err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	if m := msgStoreLookup(t, getRecoveredChannel(t, rs, "foo").Msgs, 1); !bytes.Equal(m.Data, magic) {
+		t.Fatalf("Unexpected payload: %q", m.Data)
+	}
+	// A channel recreated with the same name has no legacy message.
+	if err := cs.DeleteChannel("foo"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+	c = storeCreateChannel(t, cs, "foo")
+	storeMsg(t, c, "foo", 1, testCompressiblePayload)
+	cs.Close()
+
+	s, _ = openDefaultFileStore(t)
+	cs, err = NewCompressedStore(s, CompressionNone, nil)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer cs.Close()
+	if rs, err = cs.Recover(); err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	if m := msgStoreLookup(t, getRecoveredChannel(t, rs, "foo").Msgs, 1); !bytes.Equal(m.Data, testCompressiblePayload) {
+		t.Fatalf("Unexpected payload: %q", m.Data)
+	}
+}
+
+func TestCompressedStoreWithRaftStore(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	s := createDefaultFileStore(t)
+	defer s.Close()
+	c := storeCreateChannel(t, s, "foo")
+	magic := append(append([]byte(nil), compressMagic...), compressCodeDeflate, 'x')
+	storeMsg(t, c, "foo", 1, magic)
+	s.Close()
+
+	// The RaftStore does not store clients, but must keep the marker.
+	for i := 0; i < 2; i++ {
+		s, _ = openDefaultFileStore(t)
+		cs, err := NewCompressedStore(NewRaftStore(testLogger, s, nil), CompressionDeflate, nil)
+		if err != nil {
+			t.Fatalf("Error creating store: %v", err)
+		}
+		rs, err := cs.Recover()
+		if err != nil {
+			t.Fatalf("Error recovering store: %v", err)
+		}
+		if len(rs.Clients) != 0 {
+			t.Fatalf("Compression marker should not be recovered as a client: %v", rs.Clients)
+		}
+		if m := msgStoreLookup(t, getRecoveredChannel(t, rs, "foo").Msgs, 1); !bytes.Equal(m.Data, magic) {
+			t.Fatalf("Unexpected payload: %q", m.Data)
+		}
+		cs.Close()
+	}
+}
+
+func TestCompressedStoreWithCryptoStore(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	s := createDefaultFileStore(t)
+	defer s.Close()
+
+	cryptoStore, err := NewCryptoStore(s, CryptoCipherAES, []byte("testkey"))
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	cs, err := NewCompressedStore(cryptoStore, CompressionDeflate, nil)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer cs.Close()
+
+	c := storeCreateChannel(t, cs, "foo")
+	for i := 0; i < 5; i++ {
+		p := []byte(fmt.Sprintf("%d:%s", i, testCompressiblePayload))
+		if m := storeMsg(t, c, "foo", uint64(i+1), p); !bytes.Equal(m.Data, p) {
+			t.Fatalf("Unexpected payload: %q", m.Data)
+		}
+	}
+	cs.Close()
+
+	// Payloads are compressed before being encrypted, so once decrypted,
+	// they should start with the compression header.
+	s, _ = openDefaultFileStore(t)
+	cryptoStore, err = NewCryptoStore(s, CryptoCipherAES, []byte("testkey"))
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer cryptoStore.Close()
+	rs, err := cryptoStore.Recover()
+	if err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	m := msgStoreLookup(t, getRecoveredChannel(t, rs, "foo").Msgs, 1)
+	if !bytes.HasPrefix(m.Data, compressMagic) || len(m.Data) >= len(testCompressiblePayload) {
+		t.Fatalf("Payload should have been compressed: %q", m.Data)
+	}
+}
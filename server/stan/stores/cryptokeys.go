@@ -0,0 +1,133 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Channel keys are random keys, stored in a file per channel wrapped with
+// (that is, encrypted with) a key derived from the master key. Deleting the
+// file of a channel makes its payloads unrecoverable, wherever they have
+// been copied.
+const (
+	channelKeySize   = 32
+	channelKeySuffix = ".key"
+)
+
+func channelKeyFile(dir, channel string) string {
+	return filepath.Join(dir, channel+channelKeySuffix)
+}
+
+// newKeyWrapper returns the EDStore used to wrap the key of `channel`.
+func newKeyWrapper(code byte, mkhs []*cryptoKey, channel string) (*EDStore, error) {
+	// A channel name can't contain a space, so this can't be
+	// the name of another channel.
+	return newChannelEDStore(code, mkhs, nil, channel+" key", 0)
+}
+
+// loadChannelKey returns the key of `channel` stored in `dir`, or nil if
+// the channel does not have one.
+func loadChannelKey(dir string, code byte, mkhs []*cryptoKey, channel string) (*cryptoKey, error) {
+	wrapped, err := os.ReadFile(channelKeyFile(dir, channel))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	w, err := newKeyWrapper(code, mkhs, channel)
+	if err != nil {
+		return nil, err
+	}
+	key, err := w.Decrypt(nil, wrapped)
+	if err == nil && len(key) != channelKeySize {
+		err = fmt.Errorf("invalid key size %v", len(key))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap key of channel %q: %v", channel, err)
+	}
+	ck := newCryptoKey(key)
+	eraseKeys(key, nil)
+	return ck, nil
+}
+
+// createChannelKey generates a key for `channel` and stores it in `dir`.
+func createChannelKey(dir string, code byte, mkhs []*cryptoKey, channel string) (*cryptoKey, error) {
+	key := make([]byte, channelKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	defer eraseKeys(key, nil)
+	if err := writeChannelKey(dir, code, mkhs, channel, key); err != nil {
+		return nil, err
+	}
+	return newCryptoKey(key), nil
+}
+
+// writeChannelKey stores `key` in `dir`, wrapped with the current master key.
+func writeChannelKey(dir string, code byte, mkhs []*cryptoKey, channel string, key []byte) error {
+	w, err := newKeyWrapper(code, mkhs, channel)
+	if err != nil {
+		return err
+	}
+	wrapped, err := w.Encrypt(nil, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModeDir+os.ModePerm); err != nil {
+		return err
+	}
+	if err := writeFileAtomically(channelKeyFile(dir, channel), wrapped); err != nil {
+		return fmt.Errorf("unable to store key of channel %q: %v", channel, err)
+	}
+	return nil
+}
+
+// rewrapChannelKey wraps the key of `channel` with the current master key
+// if it was wrapped with an old one. Returns true if it has been rewrapped.
+func rewrapChannelKey(dir string, code byte, mkhs []*cryptoKey, channel string) (bool, error) {
+	wrapped, err := os.ReadFile(channelKeyFile(dir, channel))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	w, err := newKeyWrapper(code, mkhs, channel)
+	if err != nil {
+		return false, err
+	}
+	if !w.needsReEncryption(wrapped) {
+		return false, nil
+	}
+	key, err := w.Decrypt(nil, wrapped)
+	if err != nil {
+		return false, fmt.Errorf("unable to unwrap key of channel %q: %v", channel, err)
+	}
+	defer eraseKeys(key, nil)
+	return true, writeChannelKey(dir, code, mkhs, channel, key)
+}
+
+// eraseChannelKey removes the key of `channel`, if any.
+func eraseChannelKey(dir, channel string) error {
+	if err := os.Remove(channelKeyFile(dir, channel)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
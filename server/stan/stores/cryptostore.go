@@ -35,6 +35,10 @@ import (
 var (
 	ErrCryptoStoreRequiresKey = errors.New("encryption key required")
 	ErrCipherNotSupported     = errors.New("encryption cipher not supported")
+	// ErrUnknownEncryptionKey is returned when decrypting data that has been
+	// encrypted with a key that is not known, for instance the key of a
+	// channel that has been deleted.
+	ErrUnknownEncryptionKey = errors.New("message authentication failed: data encrypted with unknown key")
 )
 
 const (
@@ -43,6 +47,13 @@ const (
 	// a parameter.
 	CryptoStoreEnvKeyName = "NATS_STREAMING_ENCRYPTION_KEY"
 
+	// CryptoStoreEnvOldKeysName is the environment variable name
+	// that the CryptoStore looks up for a comma separated list of
+	// keys that are no longer used to encrypt, but still needed to
+	// decrypt data. If set, it takes precedence over the old keys
+	// passed as a parameter.
+	CryptoStoreEnvOldKeysName = "NATS_STREAMING_ENCRYPTION_OLD_KEYS"
+
 	// CryptoCipherAES is the name of the AES cipher to use for encryption
 	CryptoCipherAES = "AES"
 
@@ -60,13 +71,48 @@ const (
 	CryptoCodeChaCha = byte(2)
 )
 
+// Data encrypted with these codes has the ID of the encryption key
+// after the code, which allows to decrypt it after a key rotation.
+const (
+	CryptoCodeAESKeyID    = byte(CryptoCodeAES | cryptoKeyIDFlag)
+	CryptoCodeChaChaKeyID = byte(CryptoCodeChaCha | cryptoKeyIDFlag)
+)
+
+const (
+	cryptoKeyIDFlag = byte(0x80)
+	cryptoKeyIDSize = 4
+)
+
 // CryptoStore is a store wrapping a store implementation
 // and adds encryption support.
 type CryptoStore struct {
 	sync.Mutex
 	Store
 	code byte
-	mkh  []byte
+	mkhs []*cryptoKey // Master key hashes, the current one first
+	opts CryptoStoreOptions
+}
+
+// CryptoStoreOptions can be used to customize a CryptoStore
+type CryptoStoreOptions struct {
+	// ChannelKeysDir is the directory where the keys of the channels that
+	// have a dedicated key (see ChannelLimits) are stored, wrapped with the
+	// master key. Deleting the key of a channel, which is done when the
+	// channel is deleted, makes its payloads unrecoverable, including in
+	// backups and archived slices. If empty, dedicated keys are disabled.
+	ChannelKeysDir string
+}
+
+// CryptoStoreOption is a function on the options for a CryptoStore
+type CryptoStoreOption func(*CryptoStoreOptions) error
+
+// ChannelKeysDir is a CryptoStore option that sets the directory where the
+// keys of the channels are stored.
+func ChannelKeysDir(dir string) CryptoStoreOption {
+	return func(o *CryptoStoreOptions) error {
+		o.ChannelKeysDir = dir
+		return nil
+	}
 }
 
 // CryptoMsgStore is a store wrappeing a SubStore implementation
@@ -81,33 +127,64 @@ type CryptoMsgStore struct {
 type EDStore struct {
 	code           byte
 	gcm            cipher.AEAD // Use this one to encrypt
-	aesgcm         cipher.AEAD // This is to decrypt data encrypted with this AES cipher
-	chachagcm      cipher.AEAD // This is to decrypt data encrypted with this Chacha cipher
+	keyID          uint32      // ID of the key used to encrypt
+	keys           []*edKey    // Keys to decrypt, the current one first
 	cryptoOverhead int
 	nonceMu        sync.Mutex
 	nonce          []byte
 	nonceSize      int
 }
 
+// A key hash and the ID of the master key it derives from.
+type cryptoKey struct {
+	id   uint32
+	hash []byte
+}
+
+// The ciphers to decrypt data encrypted with a given key.
+type edKey struct {
+	id        uint32
+	aesgcm    cipher.AEAD // This is to decrypt data encrypted with this AES cipher
+	chachagcm cipher.AEAD // This is to decrypt data encrypted with this Chacha cipher
+}
+
 // NewEDStore returns an instance of EDStore that adds Encrypt/Decrypt
 // capabilities.
 func NewEDStore(encryptionCipher string, encryptionKey []byte, idx uint64) (*EDStore, error) {
-	code, keyHash, err := createMasterKeyHash(encryptionCipher, encryptionKey)
+	return NewEDStoreWithOldKeys(encryptionCipher, encryptionKey, nil, idx)
+}
+
+// NewEDStoreWithOldKeys returns an instance of EDStore that encrypts with
+// `encryptionKey` and is able to decrypt data encrypted with this key or
+// any of the `oldKeys`.
+func NewEDStoreWithOldKeys(encryptionCipher string, encryptionKey []byte, oldKeys [][]byte, idx uint64) (*EDStore, error) {
+	code, mkhs, err := createMasterKeyHashes(encryptionCipher, encryptionKey, oldKeys)
 	if err != nil {
 		return nil, err
 	}
-	s, err := newEDStore(code, keyHash, idx)
+	s, err := newEDStore(code, mkhs, idx)
 	if err != nil {
 		return nil, err
 	}
-	// On success, erase the key
+	// On success, erase the keys
+	eraseKeys(encryptionKey, oldKeys)
+	return s, nil
+}
+
+func eraseKeys(encryptionKey []byte, oldKeys [][]byte) {
 	for i := 0; i < len(encryptionKey); i++ {
 		encryptionKey[i] = 'x'
 	}
-	return s, nil
+	for _, key := range oldKeys {
+		for i := 0; i < len(key); i++ {
+			key[i] = 'x'
+		}
+	}
 }
 
-func createMasterKeyHash(encryptionCipher string, encryptionKey []byte) (byte, []byte, error) {
+// createMasterKeyHashes returns the code of the cipher and the hashes of
+// the current key, followed by the ones of the old keys.
+func createMasterKeyHashes(encryptionCipher string, encryptionKey []byte, oldKeys [][]byte) (byte, []*cryptoKey, error) {
 	var code byte
 	if encryptionCipher != CryptoCipherAutoSelect {
 		switch strings.ToUpper(encryptionCipher) {
@@ -136,45 +213,94 @@ func createMasterKeyHash(encryptionCipher string, encryptionKey []byte) (byte, [
 			return 0, nil, ErrCryptoStoreRequiresKey
 		}
 	}
+	if env := os.Getenv(CryptoStoreEnvOldKeysName); env != "" {
+		oldKeys = nil
+		for _, k := range strings.Split(env, ",") {
+			oldKeys = append(oldKeys, []byte(k))
+		}
+	}
+	mkhs := []*cryptoKey{newCryptoKey(key)}
+	for _, k := range oldKeys {
+		if len(k) == 0 {
+			continue
+		}
+		mkh := newCryptoKey(k)
+		dup := false
+		for _, ck := range mkhs {
+			dup = dup || ck.id == mkh.id
+		}
+		if !dup {
+			mkhs = append(mkhs, mkh)
+		}
+	}
+	return code, mkhs, nil
+}
+
+// newCryptoKey returns the hash of the master key `key`. Its ID is
+// derived from the hash, so that it does not reveal anything about it.
+func newCryptoKey(key []byte) *cryptoKey {
+	h := sha256.Sum256(key)
+	id := sha256.Sum256(h[:])
+	return &cryptoKey{id: util.ByteOrder.Uint32(id[:cryptoKeyIDSize]), hash: h[:]}
+}
+
+// channelKey returns the key of `channel` derived from the master key `mkh`.
+func channelKey(mkh *cryptoKey, channel string) *cryptoKey {
+	// Construct a key based of the master key hash and
+	// the channel name, then get the hash for that.
+	key := make([]byte, len(mkh.hash)+1+len(channel))
+	key = append(key, mkh.hash...)
+	key = append(key, '.')
+	key = append(key, channel...)
 	h := sha256.New()
 	h.Write(key)
-	return code, h.Sum(nil), nil
+	keyHash := h.Sum(nil)
+	for i := 0; i < len(key); i++ {
+		key[i] = 'x'
+	}
+	return &cryptoKey{id: mkh.id, hash: keyHash}
 }
 
-func newEDStore(cipherCode byte, keyHash []byte, idx uint64) (*EDStore, error) {
-	s := &EDStore{code: cipherCode}
+// newEDStore returns an EDStore that encrypts with the first of `keys`,
+// and decrypts with any of them.
+func newEDStore(cipherCode byte, keys []*cryptoKey, idx uint64) (*EDStore, error) {
+	s := &EDStore{code: cipherCode | cryptoKeyIDFlag, keyID: keys[0].id}
 
-	block, err := aes.NewCipher(keyHash)
-	if err != nil {
-		return nil, err
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-	s.aesgcm = gcm
+	for _, k := range keys {
+		ek := &edKey{id: k.id}
+		block, err := aes.NewCipher(k.hash)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		ek.aesgcm = gcm
 
-	s.nonceSize = gcm.NonceSize()
-	if s.nonceSize < 8 {
-		return nil, fmt.Errorf("nonce size too small: %v", s.nonceSize)
-	}
-	s.cryptoOverhead = gcm.Overhead()
+		s.nonceSize = gcm.NonceSize()
+		if s.nonceSize < 8 {
+			return nil, fmt.Errorf("nonce size too small: %v", s.nonceSize)
+		}
+		s.cryptoOverhead = gcm.Overhead()
 
-	gcm, err = chacha20poly1305.New(keyHash)
-	if err != nil {
-		return nil, err
-	}
-	s.chachagcm = gcm
-	if gcm.NonceSize() != s.nonceSize {
-		return nil, fmt.Errorf("chacha nonce size different than aes (%v vs %v)",
-			gcm.NonceSize(), s.nonceSize)
+		gcm, err = chacha20poly1305.New(k.hash)
+		if err != nil {
+			return nil, err
+		}
+		ek.chachagcm = gcm
+		if gcm.NonceSize() != s.nonceSize {
+			return nil, fmt.Errorf("chacha nonce size different than aes (%v vs %v)",
+				gcm.NonceSize(), s.nonceSize)
+		}
+		s.keys = append(s.keys, ek)
 	}
 
-	switch s.code {
+	switch cipherCode {
 	case CryptoCodeAES:
-		s.gcm = s.aesgcm
+		s.gcm = s.keys[0].aesgcm
 	case CryptoCodeChaCha:
-		s.gcm = s.chachagcm
+		s.gcm = s.keys[0].chachagcm
 	}
 
 	s.nonceMu.Lock()
@@ -198,7 +324,7 @@ func newEDStore(cipherCode byte, keyHash []byte, idx uint64) (*EDStore, error) {
 // EncryptionOffset returns the encrypted data actually starts in
 // an encrypted buffer.
 func (s *EDStore) EncryptionOffset() int {
-	return 1 + s.nonceSize
+	return 1 + cryptoKeyIDSize + s.nonceSize
 }
 
 // Encrypt returns the encrypted data or an error
@@ -208,17 +334,19 @@ func (s *EDStore) Encrypt(pbuf *[]byte, data []byte) ([]byte, error) {
 	if pbuf != nil {
 		buf = *pbuf
 	}
+	offset := s.EncryptionOffset()
 	// Make sure size is ok, expand if necessary
-	buf = util.EnsureBufBigEnough(buf, 1+s.nonceSize+s.cryptoOverhead+len(data))
+	buf = util.EnsureBufBigEnough(buf, offset+s.cryptoOverhead+len(data))
 	// If buffer was passed, update the reference
 	if pbuf != nil {
 		*pbuf = buf
 	}
 	buf[0] = s.code
+	util.ByteOrder.PutUint32(buf[1:], s.keyID)
 	s.nonceMu.Lock()
-	copy(buf[1:], s.nonce)
-	copy(buf[1+s.nonceSize:], data)
-	dst := buf[1+s.nonceSize : 1+s.nonceSize+len(data)]
+	copy(buf[1+cryptoKeyIDSize:], s.nonce)
+	copy(buf[offset:], data)
+	dst := buf[offset : offset+len(data)]
 	ed := s.gcm.Seal(dst[:0], s.nonce, dst, nil)
 	for i := s.nonceSize - 1; i >= 0; i-- {
 		s.nonce[i]++
@@ -227,70 +355,170 @@ func (s *EDStore) Encrypt(pbuf *[]byte, data []byte) ([]byte, error) {
 		}
 	}
 	s.nonceMu.Unlock()
-	return buf[:1+s.nonceSize+len(ed)], nil
+	return buf[:offset+len(ed)], nil
+}
+
+// cipherText returns the offset of the nonce in `cipherText`, and the keys
+// that may have been used to encrypt it. Data encrypted before key IDs
+// were recorded may have been encrypted with any of the keys. If the data
+// is not encrypted, the returned keys are nil.
+func (s *EDStore) cipherText(cipherText []byte) (int, []*edKey, error) {
+	if len(cipherText) == 0 {
+		return 0, nil, fmt.Errorf("trying to decrypt data that is not (len=0)")
+	}
+	switch cipherText[0] {
+	case CryptoCodeAES, CryptoCodeChaCha:
+		return 1, s.keys, nil
+	case CryptoCodeAESKeyID, CryptoCodeChaChaKeyID:
+		if len(cipherText) <= 1+cryptoKeyIDSize {
+			return 0, nil, fmt.Errorf("trying to decrypt data that is not (len=%v)", len(cipherText))
+		}
+		id := util.ByteOrder.Uint32(cipherText[1:])
+		for _, k := range s.keys {
+			if k.id == id {
+				return 1 + cryptoKeyIDSize, []*edKey{k}, nil
+			}
+		}
+		return 0, nil, fmt.Errorf("%w ID %08x", ErrUnknownEncryptionKey, id)
+	}
+	// Anything else, assume no algo or something we don't know how to decrypt.
+	return 0, nil, nil
 }
 
 // Decrypt returns the decrypted data or an error
 func (s *EDStore) Decrypt(dst []byte, cipherText []byte) ([]byte, error) {
-	var gcm cipher.AEAD
-	if len(cipherText) > 0 {
-		switch cipherText[0] {
-		case CryptoCodeAES:
-			gcm = s.aesgcm
-		case CryptoCodeChaCha:
-			gcm = s.chachagcm
-		default:
-			// Anything else, assume no algo or something we don't know how to decrypt.
-			return cipherText, nil
-		}
+	offset, keys, err := s.cipherText(cipherText)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return cipherText, nil
 	}
-	if len(cipherText) <= 1+s.nonceSize {
+	if len(cipherText) <= offset+s.nonceSize {
 		return nil, fmt.Errorf("trying to decrypt data that is not (len=%v)", len(cipherText))
 	}
-	dd, err := gcm.Open(dst, cipherText[1:1+s.nonceSize], cipherText[1+s.nonceSize:], nil)
+	nonce := cipherText[offset : offset+s.nonceSize]
+	data := cipherText[offset+s.nonceSize:]
+	// A failed attempt clears the destination, which could be the
+	// cipher text itself, so use a new buffer if several keys are tried.
+	if len(keys) > 1 {
+		dst = nil
+	}
+	for _, k := range keys {
+		gcm := k.aesgcm
+		if cipherText[0]&^cryptoKeyIDFlag == CryptoCodeChaCha {
+			gcm = k.chachagcm
+		}
+		var dd []byte
+		if dd, err = gcm.Open(dst, nonce, data, nil); err == nil {
+			return dd, nil
+		}
+	}
+	return nil, err
+}
+
+// DecryptInPlace returns the decrypted data or an error. The data is
+// decrypted in the buffer of `cipherText`, so it must not be used
+// by the caller after this call.
+func (s *EDStore) DecryptInPlace(cipherText []byte) ([]byte, error) {
+	offset, _, err := s.cipherText(cipherText)
 	if err != nil {
 		return nil, err
 	}
-	return dd, nil
+	var dst []byte
+	if len(cipherText) > offset+s.nonceSize {
+		dst = cipherText[offset+s.nonceSize:]
+	}
+	return s.Decrypt(dst[:0], cipherText)
+}
+
+// needsReEncryption returns true if `data` is encrypted, but not with the
+// current key.
+func (s *EDStore) needsReEncryption(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	switch data[0] {
+	case CryptoCodeAES, CryptoCodeChaCha:
+		return true
+	case CryptoCodeAESKeyID, CryptoCodeChaChaKeyID:
+		return len(data) > 1+cryptoKeyIDSize && util.ByteOrder.Uint32(data[1:]) != s.keyID
+	}
+	return false
 }
 
 // NewCryptoStore returns a CryptoStore instance with
 // given underlying store.
 func NewCryptoStore(s Store, encryptionCipher string, encryptionKey []byte) (*CryptoStore, error) {
-	code, mkh, err := createMasterKeyHash(encryptionCipher, encryptionKey)
+	return NewCryptoStoreWithOldKeys(s, encryptionCipher, encryptionKey, nil)
+}
+
+// NewCryptoStoreWithOldKeys returns a CryptoStore instance with given
+// underlying store. Payloads are encrypted with `encryptionKey`, while
+// the `oldKeys` are used only to decrypt payloads that were encrypted
+// before the key was rotated. Once all payloads have been re-encrypted
+// with the new key, see ReEncryptFileStore and ReEncryptSQLStore, the
+// old keys are no longer needed.
+func NewCryptoStoreWithOldKeys(s Store, encryptionCipher string, encryptionKey []byte, oldKeys [][]byte, options ...CryptoStoreOption) (*CryptoStore, error) {
+	var opts CryptoStoreOptions
+	for _, opt := range options {
+		if err := opt(&opts); err != nil {
+			return nil, err
+		}
+	}
+	code, mkhs, err := createMasterKeyHashes(encryptionCipher, encryptionKey, oldKeys)
 	if err != nil {
 		return nil, err
 	}
 	cs := &CryptoStore{
 		Store: s,
 		code:  code,
-		mkh:   mkh,
-	}
-	// On success, erase the key
-	for i := 0; i < len(encryptionKey); i++ {
-		encryptionKey[i] = 'x'
+		mkhs:  mkhs,
+		opts:  opts,
 	}
+	// On success, erase the keys
+	eraseKeys(encryptionKey, oldKeys)
 	return cs, nil
 }
 
+// WrapStore returns a CryptoStore wrapping `s` that uses the same keys and
+// options than this store. This is used to keep payloads encrypted when
+// they are copied into another store.
+func (cs *CryptoStore) WrapStore(s Store) *CryptoStore {
+	return &CryptoStore{Store: s, code: cs.code, mkhs: cs.mkhs, opts: cs.opts}
+}
+
+// newChannelEDStore returns the EDStore of `channel`. It encrypts with
+// `dataKey` if not nil, otherwise with a key derived from the master key.
+// Keys derived from all master keys are used to decrypt.
+func newChannelEDStore(code byte, mkhs []*cryptoKey, dataKey *cryptoKey, channel string, idx uint64) (*EDStore, error) {
+	keys := make([]*cryptoKey, 0, len(mkhs)+1)
+	if dataKey != nil {
+		keys = append(keys, dataKey)
+	}
+	for _, mkh := range mkhs {
+		keys = append(keys, channelKey(mkh, channel))
+	}
+	return newEDStore(code, keys, idx)
+}
+
 func (cs *CryptoStore) newCryptoMsgStore(channel string, ms MsgStore) (*CryptoMsgStore, error) {
 	idx, err := ms.LastSequence()
 	if err != nil {
 		return nil, err
 	}
-	// Construct a key based of the master key hash and
-	// the channel name, then get the hash for that.
-	key := make([]byte, len(cs.mkh)+1+len(channel))
-	key = append(key, cs.mkh...)
-	key = append(key, '.')
-	key = append(key, channel...)
-	h := sha256.New()
-	h.Write(key)
-	keyHash := h.Sum(nil)
-	for i := 0; i < len(key); i++ {
-		key[i] = 'x'
+	var dataKey *cryptoKey
+	if dir := cs.opts.ChannelKeysDir; dir != "" {
+		if dataKey, err = loadChannelKey(dir, cs.code, cs.mkhs, channel); err != nil {
+			return nil, err
+		}
+		if cl := cs.Store.GetChannelLimits(channel); dataKey == nil && cl != nil && cl.DedicatedKey {
+			if dataKey, err = createChannelKey(dir, cs.code, cs.mkhs, channel); err != nil {
+				return nil, err
+			}
+		}
 	}
-	eds, err := newEDStore(cs.code, keyHash, idx)
+	eds, err := newChannelEDStore(cs.code, cs.mkhs, dataKey, channel, idx)
 	if err != nil {
 		return nil, err
 	}
@@ -332,6 +560,26 @@ func (cs *CryptoStore) CreateChannel(channel string) (*Channel, error) {
 	return c, nil
 }
 
+// DeleteChannel implements the Store interface. The key of the channel, if
+// it has one, is deleted too.
+func (cs *CryptoStore) DeleteChannel(channel string) error {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if err := cs.Store.DeleteChannel(channel); err != nil {
+		return err
+	}
+	if dir := cs.opts.ChannelKeysDir; dir != "" {
+		return eraseChannelKey(dir, channel)
+	}
+	return nil
+}
+
+// unwrapMsgStore implements the msgStoreWrapper interface
+func (cms *CryptoMsgStore) unwrapMsgStore() MsgStore {
+	return cms.MsgStore
+}
+
 // Store implements the MsgStore interface
 func (cms *CryptoMsgStore) Store(msg *pb.MsgProto) (uint64, error) {
 	if len(msg.Data) == 0 {
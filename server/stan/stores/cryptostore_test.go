@@ -15,8 +15,10 @@ package stores
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -515,3 +517,307 @@ func TestCryptoFileAutoSync(t *testing.T) {
 
 	// Server should not have panic'ed.
 }
+
+// legacyEncrypt encrypts `data` the way it was done before key IDs
+// were recorded in front of the encrypted data.
+func legacyEncrypt(t *testing.T, key, channel string, data []byte) []byte {
+	t.Helper()
+	eds, err := newChannelEDStore(CryptoCodeAES, []*cryptoKey{newCryptoKey([]byte(key))}, nil, channel, 0)
+	if err != nil {
+		t.Fatalf("Error creating EDStore: %v", err)
+	}
+	ed := append([]byte{CryptoCodeAES}, eds.nonce...)
+	return eds.keys[0].aesgcm.Seal(ed, eds.nonce, data, nil)
+}
+
+func TestCryptoStoreKeyRotation(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	os.Unsetenv(CryptoStoreEnvOldKeysName)
+	defer os.Unsetenv(CryptoStoreEnvOldKeysName)
+
+	s, err := NewFileStore(testLogger, testFSDefaultDatastore, nil)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+	if err := s.Init(&testDefaultServerInfo); err != nil {
+		t.Fatalf("Error on init: %v", err)
+	}
+	c := storeCreateChannel(t, s, "foo")
+	storeMsg(t, c, "foo", 1, legacyEncrypt(t, "key1", "foo", []byte("msg1")))
+	s.Close()
+
+	open := func(key string, oldKeys ...string) (*CryptoStore, *Channel) {
+		t.Helper()
+		s, err := NewFileStore(testLogger, testFSDefaultDatastore, nil)
+		if err != nil {
+			t.Fatalf("Error opening store: %v", err)
+		}
+		var ok [][]byte
+		for _, k := range oldKeys {
+			ok = append(ok, []byte(k))
+		}
+		cs, err := NewCryptoStoreWithOldKeys(s, CryptoCipherAES, []byte(key), ok)
+		if err != nil {
+			t.Fatalf("Error creating crypto store: %v", err)
+		}
+		state, err := cs.Recover()
+		if err != nil {
+			t.Fatalf("Error recovering store: %v", err)
+		}
+		return cs, getRecoveredChannel(t, state, "foo")
+	}
+	checkMsgs := func(c *Channel, count int) {
+		t.Helper()
+		for seq := uint64(1); seq <= uint64(count); seq++ {
+			if m := msgStoreLookup(t, c.Msgs, seq); string(m.Data) != fmt.Sprintf("msg%d", seq) {
+				t.Fatalf("Unexpected message %v: %q", seq, m.Data)
+			}
+		}
+	}
+	checkFails := func(c *Channel, seq uint64) {
+		t.Helper()
+		if m, err := c.Msgs.Lookup(seq); err == nil {
+			t.Fatalf("Expected lookup of message %v to fail, got %q", seq, m.Data)
+		}
+	}
+
+	// Data encrypted before key IDs were recorded can still be decrypted.
+	cs, c := open("key1")
+	checkMsgs(c, 1)
+	storeMsg(t, c, "foo", 2, []byte("msg2"))
+	cs.Close()
+
+	// The key has been rotated, old data can't be decrypted without the old key.
+	cs, c = open("key2")
+	checkFails(c, 1)
+	checkFails(c, 2)
+	cs.Close()
+
+	cs, c = open("key2", "key0", "key1")
+	checkMsgs(c, 2)
+	storeMsg(t, c, "foo", 3, []byte("msg3"))
+	checkMsgs(c, 3)
+	cs.Close()
+
+	// The environment variable takes precedence.
+	if err := os.Setenv(CryptoStoreEnvOldKeysName, "key0,key1"); err != nil {
+		t.Fatalf("Unable to set environment variable: %v", err)
+	}
+	cs, c = open("key2", "wrongkey")
+	checkMsgs(c, 3)
+	cs.Close()
+	os.Unsetenv(CryptoStoreEnvOldKeysName)
+
+	// Re-encrypt with the new key, after which the old key is no longer needed.
+	report, err := ReEncryptFileStore(testFSDefaultDatastore, &ReEncryptOptions{Cipher: CryptoCipherAES, Key: []byte("key2"), OldKeys: [][]byte{[]byte("key1")}, Log: testLogger})
+	if err != nil {
+		t.Fatalf("Error re-encrypting: %v", err)
+	}
+	if report.Channels != 1 || report.Msgs != 3 || report.ReEncrypted != 2 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	report, err = ReEncryptFileStore(testFSDefaultDatastore, &ReEncryptOptions{Cipher: CryptoCipherAES, Key: []byte("key2")})
+	if err != nil {
+		t.Fatalf("Error re-encrypting: %v", err)
+	}
+	if report.Msgs != 3 || report.ReEncrypted != 0 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if vr, err := VerifyFileStore(testFSDefaultDatastore, nil); err != nil || len(vr.Problems) != 0 {
+		t.Fatalf("Unexpected verify result: %v - %v", vr, err)
+	}
+	cs, c = open("key2")
+	checkMsgs(c, 3)
+	storeMsg(t, c, "foo", 4, []byte("msg4"))
+	checkMsgs(c, 4)
+	cs.Close()
+
+	// Without the old key, the pass fails.
+	if _, err := ReEncryptFileStore(testFSDefaultDatastore, &ReEncryptOptions{Cipher: CryptoCipherAES, Key: []byte("key3")}); err == nil {
+		t.Fatal("Expected re-encryption to fail without the old key")
+	}
+}
+
+func TestCryptoStoreReEncryptSQL(t *testing.T) {
+	if !doSQL {
+		t.SkipNow()
+	}
+	cleanupSQLDatastore(t)
+	defer cleanupSQLDatastore(t)
+
+	s, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+	if err := s.Init(&testDefaultServerInfo); err != nil {
+		t.Fatalf("Error on init: %v", err)
+	}
+	cs, err := NewCryptoStore(s, CryptoCipherAES, []byte("key1"))
+	if err != nil {
+		t.Fatalf("Error creating crypto store: %v", err)
+	}
+	c := storeCreateChannel(t, cs, "foo")
+	storeMsg(t, c, "foo", 1, []byte("msg1"))
+	storeMsg(t, c, "foo", 2, []byte("msg2"))
+	cs.Close()
+
+	report, err := ReEncryptSQLStore(testSQLDriver, testSQLSource, &ReEncryptOptions{Cipher: CryptoCipherAES, Key: []byte("key2"), OldKeys: [][]byte{[]byte("key1")}})
+	if err != nil {
+		t.Fatalf("Error re-encrypting: %v", err)
+	}
+	if report.Channels != 1 || report.Msgs != 2 || report.ReEncrypted != 2 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+
+	s, err = NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil)
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	defer s.Close()
+	cs, err = NewCryptoStore(s, CryptoCipherAES, []byte("key2"))
+	if err != nil {
+		t.Fatalf("Error creating crypto store: %v", err)
+	}
+	defer cs.Close()
+	state, err := cs.Recover()
+	if err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	c = getRecoveredChannel(t, state, "foo")
+	for seq := uint64(1); seq <= 2; seq++ {
+		if m := msgStoreLookup(t, c.Msgs, seq); string(m.Data) != fmt.Sprintf("msg%d", seq) {
+			t.Fatalf("Unexpected message %v: %q", seq, m.Data)
+		}
+	}
+}
+
+func TestCryptoStoreReEncryptSQLLocked(t *testing.T) {
+	if !doSQL {
+		t.SkipNow()
+	}
+	cleanupSQLDatastore(t)
+	defer cleanupSQLDatastore(t)
+
+	sqlLockUpdateInterval = 250 * time.Millisecond
+	sqlLockLostCount = 2
+	defer func() {
+		sqlLockUpdateInterval = sqlDefaultLockUpdateInterval
+		sqlLockLostCount = sqlDefaultLockLostCount
+	}()
+
+	s := createDefaultSQLStore(t)
+	defer s.Close()
+	if locked, err := s.GetExclusiveLock(); !locked || err != nil {
+		t.Fatalf("Error getting lock: %v", err)
+	}
+	opts := &ReEncryptOptions{Cipher: CryptoCipherAES, Key: []byte("key2"), OldKeys: [][]byte{[]byte("key1")}}
+	if _, err := ReEncryptSQLStore(testSQLDriver, testSQLSource, opts); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Fatalf("Expected error about locked store, got %v", err)
+	}
+	s.Close()
+	if _, err := ReEncryptSQLStore(testSQLDriver, testSQLSource, opts); err != nil {
+		t.Fatalf("Error re-encrypting: %v", err)
+	}
+}
+
+func TestCryptoStoreDedicatedKey(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+	keysDir, err := os.MkdirTemp("", "channel_keys")
+	if err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	defer os.RemoveAll(keysDir)
+
+	limits := DefaultStoreLimits
+	limits.AddPerChannel("tenant.>", &ChannelLimits{DedicatedKey: true})
+	open := func(key string, oldKeys ...string) (*CryptoStore, *RecoveredState) {
+		t.Helper()
+		s, err := NewFileStore(testLogger, testFSDefaultDatastore, &limits)
+		if err != nil {
+			t.Fatalf("Error opening store: %v", err)
+		}
+		var ok [][]byte
+		for _, k := range oldKeys {
+			ok = append(ok, []byte(k))
+		}
+		cs, err := NewCryptoStoreWithOldKeys(s, CryptoCipherAES, []byte(key), ok, ChannelKeysDir(keysDir))
+		if err != nil {
+			t.Fatalf("Error creating crypto store: %v", err)
+		}
+		state, err := cs.Recover()
+		if err != nil {
+			t.Fatalf("Error recovering store: %v", err)
+		}
+		if state == nil {
+			if err := cs.Init(&testDefaultServerInfo); err != nil {
+				t.Fatalf("Error on init: %v", err)
+			}
+		}
+		return cs, state
+	}
+	checkMsg := func(c *Channel, data string) {
+		t.Helper()
+		if m := msgStoreLookup(t, c.Msgs, 1); string(m.Data) != data {
+			t.Fatalf("Expected %q, got %q", data, m.Data)
+		}
+	}
+	keyFile := filepath.Join(keysDir, "tenant.a"+channelKeySuffix)
+
+	cs, _ := open("key1")
+	storeMsg(t, storeCreateChannel(t, cs, "tenant.a"), "tenant.a", 1, []byte("a"))
+	storeMsg(t, storeCreateChannel(t, cs, "other"), "other", 1, []byte("other"))
+	cs.Close()
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Fatalf("Expected key file for channel: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(keysDir, "other"+channelKeySuffix)); !os.IsNotExist(err) {
+		t.Fatalf("Channel should not have a key, got %v", err)
+	}
+
+	// The master key is rotated, the channel key is wrapped again.
+	report, err := ReEncryptFileStore(testFSDefaultDatastore, &ReEncryptOptions{
+		Cipher: CryptoCipherAES, Key: []byte("key2"), OldKeys: [][]byte{[]byte("key1")}, ChannelKeysDir: keysDir})
+	if err != nil {
+		t.Fatalf("Error re-encrypting: %v", err)
+	}
+	if report.Rewrapped != 1 || report.ReEncrypted != 1 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	cs, state := open("key2")
+	checkMsg(getRecoveredChannel(t, state, "tenant.a"), "a")
+	checkMsg(getRecoveredChannel(t, state, "other"), "other")
+
+	// Keep a copy of the channel, as if it was in a backup or an archive.
+	copyDir := filepath.Join(testFSDefaultDatastore, "..", "tenant_copy")
+	os.RemoveAll(copyDir)
+	defer os.RemoveAll(copyDir)
+	if err := os.Rename(filepath.Join(testFSDefaultDatastore, "tenant.a"), copyDir); err != nil {
+		t.Fatalf("Error copying channel: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(testFSDefaultDatastore, "tenant.a"), os.ModeDir+os.ModePerm); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	if err := cs.DeleteChannel("tenant.a"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+	cs.Close()
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Fatalf("Key of deleted channel should have been removed, got %v", err)
+	}
+
+	// Put the copy back, it can no longer be decrypted.
+	if err := os.Rename(copyDir, filepath.Join(testFSDefaultDatastore, "tenant.a")); err != nil {
+		t.Fatalf("Error restoring channel: %v", err)
+	}
+	cs, state = open("key2")
+	defer cs.Close()
+	if _, err := getRecoveredChannel(t, state, "tenant.a").Msgs.Lookup(1); !errors.Is(err, ErrUnknownEncryptionKey) {
+		t.Fatalf("Expected error %v, got %v", ErrUnknownEncryptionKey, err)
+	}
+	checkMsg(getRecoveredChannel(t, state, "other"), "other")
+}
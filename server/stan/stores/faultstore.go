@@ -0,0 +1,426 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+	"github.com/kubemq-io/broker/server/stan/spb"
+)
+
+// ErrInjectedFault is a convenience error that can be used for Fault.Err.
+var ErrInjectedFault = errors.New("injected fault")
+
+// Fault describes a failure injected by a FaultStore in the operations
+// that match its Op and Channel.
+type Fault struct {
+	// Name of the operation, as reported by the MetricsStore ("Store",
+	// "Lookup", "CreateSub", etc..). Empty matches all operations.
+	Op string
+	// Name of the channel. Empty matches all channels, as well as the
+	// operations that are not specific to a channel (AddClient, etc..).
+	Channel string
+	// The fault fires on the Nth matching call (starting at 1) and the
+	// following ones. A value of 0 is the same as 1.
+	Nth int
+	// Number of times the fault fires. 0 means no limit.
+	Count int
+	// Latency added to the operation before it is executed.
+	Latency time.Duration
+	// Error returned by the operation. If nil, and DiskFull and Panic are
+	// not set, the operation is only delayed by Latency.
+	Err error
+	// If set, the operation fails with an error whose underlying cause is
+	// syscall.ENOSPC, as it would when the disk is full.
+	DiskFull bool
+	// If set, the operation is executed before the error is returned, so
+	// the caller sees a failure for something that was (partly) persisted.
+	// Messages are stored with only the first half of their payload.
+	Partial bool
+	// If set, the operation panics instead of returning an error.
+	Panic bool
+}
+
+// injectedFault is a Fault registered with a FaultInjector.
+type injectedFault struct {
+	Fault
+	id    int
+	op    int // -1 for all operations
+	calls int
+	fired int
+}
+
+// FaultInjector holds the faults applied by the FaultStores created with it.
+// Faults can be added and removed at any time, they apply to the next
+// operations.
+type FaultInjector struct {
+	sync.Mutex
+	faults []*injectedFault
+	nextID int
+	fired  uint64
+}
+
+// FaultStore is a store wrapping a store implementation
+// and injects the failures configured in its FaultInjector.
+type FaultStore struct {
+	sync.Mutex
+	Store
+	fi *FaultInjector
+}
+
+// FaultMsgStore is a store wrapping a MsgStore implementation
+// and injects failures in its operations.
+type FaultMsgStore struct {
+	MsgStore
+	channel string
+	fi      *FaultInjector
+}
+
+// FaultSubStore is a store wrapping a SubStore implementation
+// and injects failures in its operations.
+type FaultSubStore struct {
+	SubStore
+	channel string
+	fi      *FaultInjector
+}
+
+// NewFaultInjector returns a FaultInjector with no fault.
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{}
+}
+
+// NewFaultStore returns a FaultStore instance with given underlying store,
+// whose failures are controlled by `fi`. The same FaultInjector can be
+// used for several stores.
+func NewFaultStore(s Store, fi *FaultInjector) *FaultStore {
+	return &FaultStore{Store: s, fi: fi}
+}
+
+// Inject adds the fault and returns its ID, to be used with Remove.
+// Returns an error if the operation name is not known.
+func (fi *FaultInjector) Inject(f Fault) (int, error) {
+	op := -1
+	if f.Op != "" {
+		for i, name := range storeOpNames {
+			if name == f.Op {
+				op = i
+				break
+			}
+		}
+		if op == -1 {
+			return 0, fmt.Errorf("unknown store operation %q", f.Op)
+		}
+	}
+	fi.Lock()
+	defer fi.Unlock()
+	fi.nextID++
+	fi.faults = append(fi.faults, &injectedFault{Fault: f, id: fi.nextID, op: op})
+	return fi.nextID, nil
+}
+
+// Remove removes the fault with the given ID. Returns false if there
+// is no such fault.
+func (fi *FaultInjector) Remove(id int) bool {
+	fi.Lock()
+	defer fi.Unlock()
+	for i, f := range fi.faults {
+		if f.id == id {
+			fi.faults = append(fi.faults[:i], fi.faults[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Clear removes all faults.
+func (fi *FaultInjector) Clear() {
+	fi.Lock()
+	fi.faults = nil
+	fi.Unlock()
+}
+
+// Fired returns the number of times a fault has been applied since
+// the creation of this FaultInjector.
+func (fi *FaultInjector) Fired() uint64 {
+	fi.Lock()
+	defer fi.Unlock()
+	return fi.fired
+}
+
+// match returns the fault that fires for this invocation of the operation
+// `op` on `channel`, or nil. All matching faults count the invocation, but
+// only the first one that fires is applied. Faults that have fired Count
+// times are removed.
+func (fi *FaultInjector) match(channel string, op int) *Fault {
+	fi.Lock()
+	defer fi.Unlock()
+	var res *Fault
+	for i := 0; i < len(fi.faults); i++ {
+		f := fi.faults[i]
+		if (f.op != -1 && f.op != op) || (f.Channel != "" && f.Channel != channel) {
+			continue
+		}
+		f.calls++
+		if res != nil || f.calls < f.Nth {
+			continue
+		}
+		f.fired++
+		fi.fired++
+		fault := f.Fault
+		res = &fault
+		if f.Count > 0 && f.fired >= f.Count {
+			fi.faults = append(fi.faults[:i], fi.faults[i+1:]...)
+			i--
+		}
+	}
+	return res
+}
+
+// inject executes `call`, which performs the operation `op` on `channel`,
+// subject to the fault that fires for this invocation, if any.
+// The `partial` parameter of `call` is true if the operation is executed
+// although an error is returned.
+func (fi *FaultInjector) inject(channel string, op int, call func(partial bool) error) error {
+	f := fi.match(channel, op)
+	if f == nil {
+		return call(false)
+	}
+	if f.Latency > 0 {
+		time.Sleep(f.Latency)
+	}
+	if f.Panic {
+		panic(fmt.Sprintf("injected panic in %s operation on channel %q", storeOpNames[op], channel))
+	}
+	err := f.Err
+	if f.DiskFull {
+		err = &os.PathError{Op: storeOpNames[op], Path: channel, Err: syscall.ENOSPC}
+	}
+	if err == nil {
+		return call(false)
+	}
+	if f.Partial {
+		call(true)
+	}
+	return err
+}
+
+// Wraps the stores of the channel.
+func (fs *FaultStore) wrapChannel(name string, c *Channel) {
+	c.Msgs = &FaultMsgStore{MsgStore: c.Msgs, channel: name, fi: fs.fi}
+	c.Subs = &FaultSubStore{SubStore: c.Subs, channel: name, fi: fs.fi}
+}
+
+// Recover implements the Store interface
+func (fs *FaultStore) Recover() (*RecoveredState, error) {
+	fs.Lock()
+	defer fs.Unlock()
+	rs, err := fs.Store.Recover()
+	if rs == nil || err != nil {
+		return rs, err
+	}
+	for cn, rc := range rs.Channels {
+		fs.wrapChannel(cn, rc.Channel)
+	}
+	return rs, nil
+}
+
+// CreateChannel implements the Store interface
+func (fs *FaultStore) CreateChannel(channel string) (*Channel, error) {
+	fs.Lock()
+	defer fs.Unlock()
+	var c *Channel
+	err := fs.fi.inject(channel, opCreateChannel, func(bool) error {
+		var err error
+		c, err = fs.Store.CreateChannel(channel)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	fs.wrapChannel(channel, c)
+	return c, nil
+}
+
+// DeleteChannel implements the Store interface
+func (fs *FaultStore) DeleteChannel(channel string) error {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.fi.inject(channel, opDeleteChannel, func(bool) error {
+		return fs.Store.DeleteChannel(channel)
+	})
+}
+
+// AddClient implements the Store interface
+func (fs *FaultStore) AddClient(info *spb.ClientInfo) (*Client, error) {
+	var c *Client
+	err := fs.fi.inject("", opAddClient, func(bool) error {
+		var err error
+		c, err = fs.Store.AddClient(info)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DeleteClient implements the Store interface
+func (fs *FaultStore) DeleteClient(clientID string) error {
+	return fs.fi.inject("", opDeleteClient, func(bool) error {
+		return fs.Store.DeleteClient(clientID)
+	})
+}
+
+// unwrapMsgStore implements the msgStoreWrapper interface
+func (fms *FaultMsgStore) unwrapMsgStore() MsgStore {
+	return fms.MsgStore
+}
+
+// Store implements the MsgStore interface
+func (fms *FaultMsgStore) Store(msg *pb.MsgProto) (uint64, error) {
+	var seq uint64
+	err := fms.fi.inject(fms.channel, opStore, func(partial bool) error {
+		m := msg
+		if partial {
+			pm := *msg
+			pm.Data = msg.Data[:len(msg.Data)/2]
+			m = &pm
+		}
+		var err error
+		seq, err = fms.MsgStore.Store(m)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// Lookup implements the MsgStore interface
+func (fms *FaultMsgStore) Lookup(seq uint64) (*pb.MsgProto, error) {
+	var m *pb.MsgProto
+	err := fms.fi.inject(fms.channel, opLookup, func(bool) error {
+		var err error
+		m, err = fms.MsgStore.Lookup(seq)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetSequenceFromTimestamp implements the MsgStore interface
+func (fms *FaultMsgStore) GetSequenceFromTimestamp(timestamp int64) (uint64, error) {
+	var seq uint64
+	err := fms.fi.inject(fms.channel, opGetSequenceFromTimestamp, func(bool) error {
+		var err error
+		seq, err = fms.MsgStore.GetSequenceFromTimestamp(timestamp)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// FirstMsg implements the MsgStore interface
+func (fms *FaultMsgStore) FirstMsg() (*pb.MsgProto, error) {
+	var m *pb.MsgProto
+	err := fms.fi.inject(fms.channel, opFirstMsg, func(bool) error {
+		var err error
+		m, err = fms.MsgStore.FirstMsg()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LastMsg implements the MsgStore interface
+func (fms *FaultMsgStore) LastMsg() (*pb.MsgProto, error) {
+	var m *pb.MsgProto
+	err := fms.fi.inject(fms.channel, opLastMsg, func(bool) error {
+		var err error
+		m, err = fms.MsgStore.LastMsg()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Flush implements the MsgStore interface
+func (fms *FaultMsgStore) Flush() error {
+	return fms.fi.inject(fms.channel, opMsgsFlush, func(bool) error {
+		return fms.MsgStore.Flush()
+	})
+}
+
+// Empty implements the MsgStore interface
+func (fms *FaultMsgStore) Empty() error {
+	return fms.fi.inject(fms.channel, opEmpty, func(bool) error {
+		return fms.MsgStore.Empty()
+	})
+}
+
+// CreateSub implements the SubStore interface
+func (fss *FaultSubStore) CreateSub(sub *spb.SubState) error {
+	return fss.fi.inject(fss.channel, opCreateSub, func(bool) error {
+		return fss.SubStore.CreateSub(sub)
+	})
+}
+
+// UpdateSub implements the SubStore interface
+func (fss *FaultSubStore) UpdateSub(sub *spb.SubState) error {
+	return fss.fi.inject(fss.channel, opUpdateSub, func(bool) error {
+		return fss.SubStore.UpdateSub(sub)
+	})
+}
+
+// DeleteSub implements the SubStore interface
+func (fss *FaultSubStore) DeleteSub(subid uint64) error {
+	return fss.fi.inject(fss.channel, opDeleteSub, func(bool) error {
+		return fss.SubStore.DeleteSub(subid)
+	})
+}
+
+// AddSeqPending implements the SubStore interface
+func (fss *FaultSubStore) AddSeqPending(subid, seqno uint64) error {
+	return fss.fi.inject(fss.channel, opAddSeqPending, func(bool) error {
+		return fss.SubStore.AddSeqPending(subid, seqno)
+	})
+}
+
+// AckSeqPending implements the SubStore interface
+func (fss *FaultSubStore) AckSeqPending(subid, seqno uint64) error {
+	return fss.fi.inject(fss.channel, opAckSeqPending, func(bool) error {
+		return fss.SubStore.AckSeqPending(subid, seqno)
+	})
+}
+
+// Flush implements the SubStore interface
+func (fss *FaultSubStore) Flush() error {
+	return fss.fi.inject(fss.channel, opSubsFlush, func(bool) error {
+		return fss.SubStore.Flush()
+	})
+}
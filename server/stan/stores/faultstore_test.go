@@ -0,0 +1,126 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+	"github.com/kubemq-io/broker/server/stan/spb"
+)
+
+func TestFaultStore(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	s := createDefaultFileStore(t)
+	defer s.Close()
+	c := storeCreateChannel(t, s, "foo")
+	storeMsg(t, c, "foo", 1, []byte("msg"))
+	s.Close()
+
+	fi := NewFaultInjector()
+	if _, err := fi.Inject(Fault{Op: "Unknown"}); err == nil {
+		t.Fatal("Expected error for unknown operation")
+	}
+
+	fs, _ := openDefaultFileStore(t)
+	ftStore := NewFaultStore(fs, fi)
+	defer ftStore.Close()
+	rs, err := ftStore.Recover()
+	if err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	// Recovered channels are wrapped too.
+	foo := getRecoveredChannel(t, rs, "foo")
+	bar := storeCreateChannel(t, ftStore, "bar")
+
+	// Fail only the 3rd Store on "foo"
+	if _, err := fi.Inject(Fault{Op: "Store", Channel: "foo", Nth: 3, Count: 1, Err: ErrInjectedFault}); err != nil {
+		t.Fatalf("Error injecting fault: %v", err)
+	}
+	storeMsg(t, foo, "foo", 2, []byte("msg"))
+	storeMsg(t, bar, "bar", 1, []byte("msg"))
+	storeMsg(t, foo, "foo", 3, []byte("msg"))
+	if _, err := foo.Msgs.Store(&pb.MsgProto{Sequence: 4, Subject: "foo", Data: []byte("msg")}); err != ErrInjectedFault {
+		t.Fatalf("Expected error %v, got %v", ErrInjectedFault, err)
+	}
+	storeMsg(t, foo, "foo", 4, []byte("msg"))
+	if n := fi.Fired(); n != 1 {
+		t.Fatalf("Expected fault to fire once, got %v", n)
+	}
+
+	// Disk full on all operations of "bar", until removed.
+	id, _ := fi.Inject(Fault{Channel: "bar", DiskFull: true})
+	for i := 0; i < 2; i++ {
+		_, err := bar.Msgs.Store(&pb.MsgProto{Sequence: 2, Subject: "bar", Data: []byte("msg")})
+		if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.ENOSPC {
+			t.Fatalf("Expected disk full error, got %v", err)
+		}
+	}
+	if err := bar.Subs.CreateSub(&spb.SubState{ClientID: "me", Inbox: "inbox", AckInbox: "ackInbox"}); err == nil {
+		t.Fatal("Expected error creating sub")
+	}
+	// Operations on other channels are not affected
+	storeSub(t, foo, "foo")
+	if !fi.Remove(id) {
+		t.Fatal("Fault should have been removed")
+	}
+	if fi.Remove(id) {
+		t.Fatal("Fault should have already been removed")
+	}
+	storeMsg(t, bar, "bar", 2, []byte("msg"))
+
+	// Partial write: the message is stored, with half of its payload,
+	// but the caller gets an error.
+	fi.Inject(Fault{Op: "Store", Count: 1, Partial: true, Err: ErrInjectedFault})
+	if _, err := bar.Msgs.Store(&pb.MsgProto{Sequence: 3, Subject: "bar", Data: []byte("abcd")}); err != ErrInjectedFault {
+		t.Fatalf("Expected error %v, got %v", ErrInjectedFault, err)
+	}
+	if m := msgStoreLookup(t, bar.Msgs, 3); m == nil || string(m.Data) != "ab" {
+		t.Fatalf("Unexpected message: %v", m)
+	}
+
+	// Latency only
+	fi.Inject(Fault{Op: "Lookup", Count: 1, Latency: 50 * time.Millisecond})
+	start := time.Now()
+	if m := msgStoreLookup(t, foo.Msgs, 1); m == nil {
+		t.Fatal("Expected message")
+	}
+	if dur := time.Since(start); dur < 50*time.Millisecond {
+		t.Fatalf("Lookup should have been delayed, took %v", dur)
+	}
+
+	// Store level operations
+	fi.Inject(Fault{Op: "CreateChannel", Err: ErrInjectedFault})
+	if c, err := ftStore.CreateChannel("baz"); c != nil || err != ErrInjectedFault {
+		t.Fatalf("Expected error %v, got %v - %v", ErrInjectedFault, c, err)
+	}
+	fi.Clear()
+	storeCreateChannel(t, ftStore, "baz")
+
+	// Panics
+	fi.Inject(Fault{Op: "MsgsFlush", Panic: true})
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("Expected panic")
+			}
+		}()
+		foo.Msgs.Flush()
+	}()
+}
@@ -15,13 +15,16 @@ package stores
 
 import (
 	"bufio"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"math/bits"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -53,12 +56,32 @@ const (
 	// Name of the subscriptions file.
 	subsFileName = "subs" + datSuffix
 
+	// When SubCheckpointRecords is set, the subscriptions file is a journal
+	// that is renamed "subs.<generation>.jrn" when a checkpoint is started.
+	// The checkpoint, which includes the records of this journal and of the
+	// previous ones, is then written in "subs.<generation>.ckp".
+	subsFilesPrefix      = "subs."
+	subsJournalSuffix    = ".jrn"
+	subsCheckpointSuffix = ".ckp"
+
 	// Name of the clients file.
 	clientsFileName = "clients" + datSuffix
 
 	// Name of the server file.
 	serverFileName = "server" + datSuffix
 
+	// Name of the file in which the state of a channel's message store is
+	// saved on close when LazyRecovery is enabled.
+	msgsStateFileName = msgFilesPrefix + "state"
+
+	// Version of the content of the msgsStateFileName file.
+	msgsStateVersion = 1
+
+	// Size of the content of the msgsStateFileName file.
+	// Version - First - Last - Count - Bytes - First timestamp - First slice
+	// - Last slice - Size of last data file - CRC
+	msgsStateSize = 4 + 8*8 + crcSize
+
 	// Number of bytes required to store a CRC-32 checksum
 	crcSize = crc32.Size
 
@@ -80,6 +103,10 @@ const (
 	// channels and/or file slice limits.
 	msgRecordOverhead = recordHeaderSize + msgIndexRecSize
 
+	// A timestamp index record is added for every tixInterval messages
+	// stored in a file slice.
+	tixInterval = 128
+
 	// Percentage of buffer usage to decide if the buffer should shrink
 	bufShrinkThreshold = 50
 
@@ -170,6 +197,17 @@ type FileStoreOptions struct {
 	// those files.
 	SliceArchiveScript string
 
+	// SliceArchiveDir, if set, causes file slices that are removed to be
+	// moved to this directory, from where messages can still be looked up.
+	// See Archiver. Cannot be used with SliceArchiveScript.
+	SliceArchiveDir string
+
+	// Archiver, if set, receives the file slices that are removed and is
+	// used to look up messages older than the first message of a channel.
+	// It takes precedence over SliceArchiveDir. Cannot be used with
+	// SliceArchiveScript.
+	Archiver Archiver
+
 	// FileDescriptorsLimit is a soft limit hinting at FileStore to try to
 	// limit the number of concurrent opened files to that limit.
 	FileDescriptorsLimit int64
@@ -195,6 +233,27 @@ type FileStoreOptions struct {
 	// by setting DoSync to false.
 	// Setting AutoSync to any value <= 0 will disable auto sync.
 	AutoSync time.Duration
+
+	// SubCheckpointRecords, if non zero, replaces the compaction settings
+	// for the subscriptions files. Once this number of records has been
+	// appended to a subscriptions file, this file is rotated and a
+	// checkpoint is written in the background, with a record per
+	// subscription holding its state, its lowest pending sequence and a
+	// sparse bitmap of its pending messages. The rotated files are removed
+	// once included in a checkpoint. Recovery reads the last checkpoint and
+	// only the records written since it was started.
+	// This does not apply to the SQL store, whose rows of pending messages
+	// are deleted once acknowledged, so there is no journal to replay.
+	SubCheckpointRecords int
+
+	// LazyRecovery, if true, allows the message store of a channel to be
+	// opened from the state saved when the store was last closed, without
+	// reading its file slices. The slices are recovered the first time the
+	// messages of the channel are accessed, or in the background after
+	// Recover returns, with up to ParallelRecovery channels at a time.
+	// Subscriptions are always recovered on startup since the server needs
+	// them, see SubCheckpointRecords to bound their recovery time.
+	LazyRecovery bool
 }
 
 // This is an internal error to detect situations where we do
@@ -331,6 +390,27 @@ func AutoSync(dur time.Duration) FileStoreOption {
 	}
 }
 
+// SubCheckpointRecords is a FileStore option that defines the number of
+// subscription records after which the state of the subscriptions is
+// checkpointed in the background, see FileStoreOptions.
+// Any value <= 0 will disable it and use the compaction settings instead.
+func SubCheckpointRecords(records int) FileStoreOption {
+	return func(o *FileStoreOptions) error {
+		o.SubCheckpointRecords = records
+		return nil
+	}
+}
+
+// LazyRecovery is a FileStore option that defines if the message stores
+// of the channels can be opened from their saved state and recovered on
+// first access or in the background.
+func LazyRecovery(lazy bool) FileStoreOption {
+	return func(o *FileStoreOptions) error {
+		o.LazyRecovery = lazy
+		return nil
+	}
+}
+
 // SliceConfig is a FileStore option that allows the configuration of
 // file slice limits and optional archive script file name.
 func SliceConfig(maxMsgs int, maxBytes int64, maxAge time.Duration, script string) FileStoreOption {
@@ -346,6 +426,24 @@ func SliceConfig(maxMsgs int, maxBytes int64, maxAge time.Duration, script strin
 	}
 }
 
+// SliceArchiveDir is a FileStore option that sets the directory where the
+// LocalArchiver moves the file slices that are removed.
+func SliceArchiveDir(dir string) FileStoreOption {
+	return func(o *FileStoreOptions) error {
+		o.SliceArchiveDir = dir
+		return nil
+	}
+}
+
+// SliceArchiver is a FileStore option that sets the Archiver receiving
+// the file slices that are removed.
+func SliceArchiver(archiver Archiver) FileStoreOption {
+	return func(o *FileStoreOptions) error {
+		o.Archiver = archiver
+		return nil
+	}
+}
+
 // FileDescriptorsLimit is a soft limit hinting at FileStore to try to
 // limit the number of concurrent opened files to that limit.
 func FileDescriptorsLimit(limit int64) FileStoreOption {
@@ -418,10 +516,14 @@ func AllOptions(opts *FileStoreOptions) FileStoreOption {
 		if err := AutoSync(opts.AutoSync)(o); err != nil {
 			return err
 		}
+		o.SliceArchiveDir = opts.SliceArchiveDir
+		o.Archiver = opts.Archiver
 		o.CompactEnabled = opts.CompactEnabled
 		o.DoCRC = opts.DoCRC
 		o.DoSync = opts.DoSync
 		o.TruncateUnexpectedEOF = opts.TruncateUnexpectedEOF
+		o.SubCheckpointRecords = opts.SubCheckpointRecords
+		o.LazyRecovery = opts.LazyRecovery
 		return nil
 	}
 }
@@ -449,6 +551,7 @@ const (
 	subRecDel
 	subRecAck
 	subRecMsg
+	subRecCheckpoint
 )
 
 // Record types for client store
@@ -509,13 +612,62 @@ type FileStore struct {
 	cliCompactTS  time.Time
 	crcTable      *crc32.Table
 	lockFile      util.LockFile
+	archiver      Archiver
+	recovery      *RecoveryProgress // set if some message stores are recovered lazily
+	recoveryQuit  chan struct{}
+	recoveryWG    sync.WaitGroup
+}
+
+// RecoveryProgress reports the progress of the recovery of the message
+// stores that have been opened from their saved state (see LazyRecovery).
+type RecoveryProgress struct {
+	Channels  int64 `json:"channels"`  // Number of channels opened from their saved state
+	Recovered int64 `json:"recovered"` // Number of those that have been recovered
+	Failed    int64 `json:"failed"`    // Number of those that failed to recover
+}
+
+// msgsState is the content of the msgsStateFileName file.
+type msgsState struct {
+	first          uint64
+	last           uint64
+	count          uint64
+	bytes          uint64
+	firstTimestamp uint64
+	firstFSlSeq    uint64
+	lastFSlSeq     uint64
+	lastDatSize    uint64
 }
 
 type subscription struct {
-	sub    *spb.SubState
-	seqnos map[uint64]struct{}
+	sub     *spb.SubState
+	pending *seqBitmap
 }
 
+// seqBitmap is a sparse bitmap of message sequences, used to keep track of
+// the messages pending acknowledgment of a subscription. Only words that
+// have at least one bit set are kept.
+type seqBitmap struct {
+	words map[uint64]uint64 // indexed by seq/64
+	count int
+}
+
+// subCheckpoint is the record written for a subscription when the state
+// of the subscriptions is checkpointed. It contains the subscription and
+// (part of) its pending messages, encoded as the lowest pending sequence
+// followed by the non empty words of the bitmap.
+type subCheckpoint struct {
+	sub     *spb.SubState
+	lwm     uint64
+	indexes []uint64 // sorted indexes of the words to encode
+	pending *seqBitmap
+}
+
+// Maximum number of bitmap words in a checkpoint record. Subscriptions with
+// more pending messages are checkpointed with several records.
+const subCheckpointMaxWords = 64 * 1024
+
+var errBadSubCheckpoint = errors.New("invalid subscription checkpoint record")
+
 type bufferedWriter struct {
 	buf           *bufio.Writer
 	bufSize       int  // current buffer size
@@ -537,8 +689,11 @@ type FileSubStore struct {
 	opts        *FileStoreOptions // points to options from FileStore
 	compactItvl time.Duration
 	fileSize    int64
-	numRecs     int // Number of records (sub and msgs)
-	delRecs     int // Number of delete (or ack) records
+	numRecs     int    // Number of records (sub and msgs)
+	delRecs     int    // Number of delete (or ack) records
+	journalRecs int    // Number of records written since the last checkpoint
+	ckpGen      uint64 // Generation of the last checkpoint started
+	ckpInFlight bool   // A checkpoint is being written in the background
 	compactTS   time.Time
 	crcTable    *crc32.Table // reference to the one from FileStore
 	activity    bool         // was there any write between two flush calls
@@ -561,6 +716,18 @@ type fileSlice struct {
 	msgsSize   uint64
 	firstWrite int64 // Time the first message was added to this slice (used for slice age limit)
 	lastUsed   int64
+	tix        []tixEntry // Sparse timestamp index
+}
+
+// tixEntry is a record of the sparse timestamp index of a file slice.
+// There is one entry for every tixInterval messages stored in the slice,
+// starting with the first one, which allows GetSequenceFromTimestamp to
+// find the range of messages to look at without reading whole index files.
+// The index is not persisted on its own: recovery reads every record of
+// the slice's index file anyway, and rebuilds it from their timestamps.
+type tixEntry struct {
+	seq       uint64
+	timestamp int64
 }
 
 // msgIndex contains the message's offset in the data file, its timestamp
@@ -609,6 +776,7 @@ type FileMsgStore struct {
 	// to run with 32bit processes.
 	checkSlices int64 // used with atomic operations
 	timeTick    int64 // time captured in background tasks go routine
+	lazy        int32 // 1 if the file slices have not been recovered yet, used with atomic operations
 
 	tmpMsgBuf    []byte
 	fm           *filesManager // shortcut to ms.fstore.fm
@@ -636,8 +804,23 @@ type FileMsgStore struct {
 	bkgTasksWake chan bool // signal the background tasks go routine to get out of a sleep
 	allDone      sync.WaitGroup
 	readBufSize  int
-	needSync     bool  // this required to reduce sync'ing when DoSync==false, but AutoSync>0
-	synced       int64 // number of times the file is actually sync'ed
+	needSync     bool           // this required to reduce sync'ing when DoSync==false, but AutoSync>0
+	synced       int64          // number of times the file is actually sync'ed
+	archSlice    *ArchivedSlice // archived slice used by the last archived lookup
+	archMu       sync.Mutex     // protects archiving
+	archiving    []*archivingSlice
+	archWG       sync.WaitGroup
+	lazyErr      error // error of the lazy recovery of the file slices
+	saveState    bool  // save the state on close, see LazyRecovery
+}
+
+// archivingSlice is a removed file slice being handed to the Archiver.
+// Until this is done, messages are looked up from its backup files.
+type archivingSlice struct {
+	first   uint64
+	last    uint64
+	datFile string
+	idxFile string
 }
 
 type bufferPool struct {
@@ -1273,6 +1456,19 @@ func NewFileStore(log logger.Logger, rootDir string, limits *StoreLimits, option
 			return nil, err
 		}
 	}
+	fs.archiver = fs.opts.Archiver
+	if fs.archiver != nil || fs.opts.SliceArchiveDir != "" {
+		if fs.opts.SliceArchiveScript != "" {
+			return nil, fmt.Errorf("slice archive script cannot be used with an archiver")
+		}
+		if fs.archiver == nil {
+			la, err := NewLocalArchiver(fs.opts.SliceArchiveDir)
+			if err != nil {
+				return nil, err
+			}
+			fs.archiver = la
+		}
+	}
 	// Create filesManager based on options' FD limit
 	fs.fm = createFilesManager(rootDir, fs.opts.FileDescriptorsLimit)
 	// Convert the compact interval in time.Duration
@@ -1392,6 +1588,9 @@ func (fs *FileStore) Recover() (*RecoveredState, error) {
 	if cerr != nil {
 		return nil, err
 	}
+	if fs.opts.LazyRecovery {
+		fs.recovery = &RecoveryProgress{}
+	}
 	if len(channels) > 0 {
 		wg, poolCh, errCh, recoverCh := initParalleRecovery(fs.opts.ParallelRecovery, len(channels))
 		ctx := &channelRecoveryCtx{wg: wg, poolCh: poolCh, errCh: errCh, recoverCh: recoverCh}
@@ -1433,6 +1632,9 @@ func (fs *FileStore) Recover() (*RecoveredState, error) {
 			return nil, err
 		default:
 		}
+		if fs.recovery != nil && fs.recovery.Channels > 0 {
+			fs.startBackgroundRecovery(recoveredChannels)
+		}
 	}
 	// Create the recovered state to return
 	recoveredState = &RecoveredState{
@@ -1496,6 +1698,9 @@ func (fs *FileStore) recoverOneChannel(dir, name string, limits *ChannelLimits,
 	if err != nil {
 		return
 	}
+	if atomic.LoadInt32(&msgStore.lazy) == 1 {
+		atomic.AddInt64(&fs.recovery.Channels, 1)
+	}
 	subStore, err = fs.newFileSubStore(name, &limits.SubStoreLimits, true)
 	if err != nil {
 		msgStore.Close()
@@ -1526,12 +1731,13 @@ func (fs *FileStore) recoverOneChannel(dir, name string, limits *ChannelLimits,
 			Pending: make(PendingAcks),
 		}
 		// If we recovered any seqno...
-		if len(sub.seqnos) > 0 {
+		if sub.pending.len() > 0 {
 			// Lookup messages, and if we find those, update the
 			// Pending map.
-			for seq := range sub.seqnos {
+			sub.pending.forEach(func(seq uint64) error {
 				rs.Pending[seq] = struct{}{}
-			}
+				return nil
+			})
 		}
 		// Add to the array of recovered subscriptions
 		recoveredChannel.rc.Subscriptions = append(recoveredChannel.rc.Subscriptions, rs)
@@ -1540,6 +1746,57 @@ func (fs *FileStore) recoverOneChannel(dir, name string, limits *ChannelLimits,
 	ctx.recoverCh <- recoveredChannel
 }
 
+// startBackgroundRecovery starts the go routines recovering the file slices
+// of the message stores that have been opened from their saved state, with
+// up to ParallelRecovery channels at a time.
+// Lock held on entry.
+func (fs *FileStore) startBackgroundRecovery(channels map[string]*RecoveredChannel) {
+	lazyStores := make(chan *FileMsgStore, len(channels))
+	for _, rc := range channels {
+		if ms := rc.Channel.Msgs.(*FileMsgStore); atomic.LoadInt32(&ms.lazy) == 1 {
+			lazyStores <- ms
+		}
+	}
+	close(lazyStores)
+	fs.recoveryQuit = make(chan struct{})
+	for i := 0; i < fs.opts.ParallelRecovery; i++ {
+		fs.recoveryWG.Add(1)
+		go fs.backgroundRecovery(lazyStores)
+	}
+}
+
+// backgroundRecovery recovers the message stores from `lazyStores` until
+// there are no more or the store is closed.
+func (fs *FileStore) backgroundRecovery(lazyStores chan *FileMsgStore) {
+	defer fs.recoveryWG.Done()
+	for ms := range lazyStores {
+		select {
+		case <-fs.recoveryQuit:
+			return
+		default:
+		}
+		// Errors are logged and reported by the store itself.
+		ms.ensureRecovered()
+	}
+}
+
+// RecoveryProgress returns the progress of the recovery of the message
+// stores that have been opened from their saved state, or nil if there
+// are none (see LazyRecovery).
+func (fs *FileStore) RecoveryProgress() *RecoveryProgress {
+	fs.RLock()
+	rp := fs.recovery
+	fs.RUnlock()
+	if rp == nil || atomic.LoadInt64(&rp.Channels) == 0 {
+		return nil
+	}
+	return &RecoveryProgress{
+		Channels:  atomic.LoadInt64(&rp.Channels),
+		Recovered: atomic.LoadInt64(&rp.Recovered),
+		Failed:    atomic.LoadInt64(&rp.Failed),
+	}
+}
+
 // GetExclusiveLock implements the Store interface
 func (fs *FileStore) GetExclusiveLock() (bool, error) {
 	fs.Lock()
@@ -1743,6 +2000,11 @@ func (fs *FileStore) DeleteChannel(channel string) error {
 	if err != nil {
 		return err
 	}
+	if fs.archiver != nil {
+		if err := fs.archiver.Remove(channel); err != nil {
+			return err
+		}
+	}
 	return os.RemoveAll(filepath.Join(fs.fm.rootDir, channel))
 }
 
@@ -1896,6 +2158,12 @@ func (fs *FileStore) Close() error {
 	}
 	fs.closed = true
 
+	// Stop the background recovery of the message stores, if running.
+	if fs.recoveryQuit != nil {
+		close(fs.recoveryQuit)
+		fs.recoveryWG.Wait()
+	}
+
 	err := fs.genericStore.close()
 
 	fm := fs.fm
@@ -2053,105 +2321,21 @@ func (fs *FileStore) newFileMsgStore(channelDirName, channel string, limits *Msg
 
 	// Recovery case
 	if doRecover {
-		var dirEntries []os.DirEntry
-		var fseq int64
-		var datFile, idxFile *file
-		var useIdxFile bool
-
-		dirEntries, err = os.ReadDir(channelDirName)
-		for _, entry := range dirEntries {
-			if entry.IsDir() {
-				continue
-			}
-			file, ierr := entry.Info()
-			if ierr != nil {
-				err = ierr
-				break
-			}
-			fileName := file.Name()
-			if !strings.HasPrefix(fileName, msgFilesPrefix) || !strings.HasSuffix(fileName, datSuffix) {
-				continue
-			}
-			// Remove suffix
-			fileNameWithoutSuffix := strings.TrimSuffix(fileName, datSuffix)
-			// Remove prefix
-			fileNameWithoutPrefixAndSuffix := strings.TrimPrefix(fileNameWithoutSuffix, msgFilesPrefix)
-			// Get the file sequence number
-			fseq, err = strconv.ParseInt(fileNameWithoutPrefixAndSuffix, 10, 64)
-			if err != nil {
-				err = fmt.Errorf("message log has an invalid name: %v", fileName)
-				break
-			}
-			idxFName := fmt.Sprintf("%s%v%s", msgFilesPrefix, fseq, idxSuffix)
-			useIdxFile = false
-			if s, statErr := os.Stat(filepath.Join(channelDirName, idxFName)); s != nil && statErr == nil {
-				useIdxFile = true
-			}
-			fname = filepath.Join(channel, fileName)
-			datFile, err = ms.fm.createFile(fname, defaultFileFlags, nil)
-			if err != nil {
-				break
-			}
-			fname = filepath.Join(channel, idxFName)
-			idxFile, err = ms.fm.createFile(fname, defaultFileFlags, nil)
-			if err != nil {
-				ms.fm.unlockFile(datFile)
-				break
-			}
-			// Create the slice
-			fslice := &fileSlice{file: datFile, idxFile: idxFile, lastUsed: time.Now().UnixNano()}
-			// Recover the file slice
-			err = ms.recoverOneMsgFile(fslice, int(fseq), useIdxFile)
-			if err != nil {
-				break
-			}
-		}
-		if err == nil && ms.lastFSlSeq > 0 {
-			// Now that all file slices have been recovered, we know which
-			// one is the last, so use it as the write slice.
-			ms.writeSlice = ms.files[ms.lastFSlSeq]
-			// Need to set the writer, etc..
-			ms.fm.lockFile(ms.writeSlice.file)
-			err = ms.setFile(ms.writeSlice, -1)
-			ms.fm.unlockFile(ms.writeSlice.file)
-			if err == nil {
-				// Set the beforeFileClose callback to the slices now that
-				// we are done recovering.
-				for _, fslice := range ms.files {
-					ms.fm.setBeforeCloseCb(fslice.file, ms.beforeDataFileCloseCb(fslice))
-					ms.fm.setBeforeCloseCb(fslice.idxFile, ms.beforeIndexFileCloseCb(fslice))
-				}
-				ms.checkSlices = 1
+		if fs.opts.LazyRecovery {
+			if state := readMsgsState(channelDirName); state != nil && ms.stateWithinLimits(state) {
+				// The file slices will be recovered on first access or by
+				// the background recovery (see FileStore.Recover).
+				ms.first, ms.last = state.first, state.last
+				ms.totalCount, ms.totalBytes = int(state.count), state.bytes
+				ms.lazy = 1
+				return ms, nil
 			}
 		}
-		if err == nil {
-			// Apply message limits (no need to check if there are limits
-			// defined, the call won't do anything if they aren't).
-			err = ms.enforceLimits(false, true)
-		}
+		fname, err = ms.recoverSlices(channelDirName)
 	}
 	if err == nil {
 		ms.Lock()
-		ms.allDone.Add(1)
-		// Capture the time here first, it will then be captured
-		// in the go routine we are about to start.
-		ms.timeTick = time.Now().UnixNano()
-		// On recovery, if there is age limit set and at least one message...
-		if doRecover {
-			if ms.limits.MaxAge > 0 && ms.totalCount > 0 {
-				// Force the execution of the expireMsgs method.
-				// This will take care of expiring messages that should have
-				// expired while the server was stopped.
-				ms.expireMsgs(ms.timeTick, int64(ms.limits.MaxAge))
-			}
-			// Now that we are done with recovery, close the write slice
-			if ms.writeSlice != nil {
-				ms.fm.closeFileIfOpened(ms.writeSlice.file)
-				ms.fm.closeFileIfOpened(ms.writeSlice.idxFile)
-			}
-		}
-		// Start the background tasks go routine
-		go ms.backgroundTasks()
+		ms.start(doRecover)
 		ms.Unlock()
 	}
 	// Cleanup on error
@@ -2173,6 +2357,295 @@ func (fs *FileStore) newFileMsgStore(channelDirName, channel string, limits *Msg
 	return ms, nil
 }
 
+// recoverSlices recovers the file slices of the store from the channel
+// directory `dir`. It returns the name of the file being recovered in
+// case of error.
+func (ms *FileMsgStore) recoverSlices(dir string) (string, error) {
+	var (
+		err        error
+		fname      string
+		dirEntries []os.DirEntry
+		fseq       int64
+		datFile    *file
+		idxFile    *file
+		useIdxFile bool
+	)
+	// The saved state is no longer valid once the store is modified.
+	if err := removeMsgsState(dir); err != nil {
+		return filepath.Join(ms.channelName, msgsStateFileName), err
+	}
+	dirEntries, err = os.ReadDir(dir)
+	for _, entry := range dirEntries {
+		if entry.IsDir() {
+			continue
+		}
+		// Only the name is needed: with lazy recovery, this runs while the
+		// rotated subscriptions journals may be removed by a checkpoint.
+		fileName := entry.Name()
+		if !strings.HasPrefix(fileName, msgFilesPrefix) || !strings.HasSuffix(fileName, datSuffix) {
+			continue
+		}
+		// Remove suffix
+		fileNameWithoutSuffix := strings.TrimSuffix(fileName, datSuffix)
+		// Remove prefix
+		fileNameWithoutPrefixAndSuffix := strings.TrimPrefix(fileNameWithoutSuffix, msgFilesPrefix)
+		// Get the file sequence number
+		fseq, err = strconv.ParseInt(fileNameWithoutPrefixAndSuffix, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("message log has an invalid name: %v", fileName)
+			break
+		}
+		idxFName := fmt.Sprintf("%s%v%s", msgFilesPrefix, fseq, idxSuffix)
+		useIdxFile = false
+		if s, statErr := os.Stat(filepath.Join(dir, idxFName)); s != nil && statErr == nil {
+			useIdxFile = true
+		}
+		fname = filepath.Join(ms.channelName, fileName)
+		datFile, err = ms.fm.createFile(fname, defaultFileFlags, nil)
+		if err != nil {
+			break
+		}
+		fname = filepath.Join(ms.channelName, idxFName)
+		idxFile, err = ms.fm.createFile(fname, defaultFileFlags, nil)
+		if err != nil {
+			ms.fm.unlockFile(datFile)
+			break
+		}
+		// Create the slice
+		fslice := &fileSlice{file: datFile, idxFile: idxFile, lastUsed: time.Now().UnixNano()}
+		// Recover the file slice
+		err = ms.recoverOneMsgFile(fslice, int(fseq), useIdxFile)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && ms.lastFSlSeq > 0 {
+		// Now that all file slices have been recovered, we know which
+		// one is the last, so use it as the write slice.
+		ms.writeSlice = ms.files[ms.lastFSlSeq]
+		// Need to set the writer, etc..
+		ms.fm.lockFile(ms.writeSlice.file)
+		err = ms.setFile(ms.writeSlice, -1)
+		ms.fm.unlockFile(ms.writeSlice.file)
+		if err == nil {
+			// Set the beforeFileClose callback to the slices now that
+			// we are done recovering.
+			for _, fslice := range ms.files {
+				ms.fm.setBeforeCloseCb(fslice.file, ms.beforeDataFileCloseCb(fslice))
+				ms.fm.setBeforeCloseCb(fslice.idxFile, ms.beforeIndexFileCloseCb(fslice))
+			}
+			ms.checkSlices = 1
+		}
+	}
+	if err == nil {
+		// Apply message limits (no need to check if there are limits
+		// defined, the call won't do anything if they aren't).
+		err = ms.enforceLimits(false, true)
+	}
+	return fname, err
+}
+
+// start starts the background tasks of the store. If `recovered` is true,
+// the messages that have expired while the server was stopped are removed.
+// Lock held on entry.
+func (ms *FileMsgStore) start(recovered bool) {
+	ms.allDone.Add(1)
+	// Capture the time here first, it will then be captured
+	// in the go routine we are about to start.
+	ms.timeTick = time.Now().UnixNano()
+	// On recovery, if there is age limit set and at least one message...
+	if recovered {
+		if ms.limits.MaxAge > 0 && ms.totalCount > 0 {
+			// Force the execution of the expireMsgs method.
+			// This will take care of expiring messages that should have
+			// expired while the server was stopped.
+			ms.expireMsgs(ms.timeTick, int64(ms.limits.MaxAge))
+		}
+		// Now that we are done with recovery, close the write slice
+		if ms.writeSlice != nil {
+			ms.fm.closeFileIfOpened(ms.writeSlice.file)
+			ms.fm.closeFileIfOpened(ms.writeSlice.idxFile)
+		}
+	}
+	// Start the background tasks go routine
+	go ms.backgroundTasks()
+	ms.saveState = ms.fstore.opts.LazyRecovery
+}
+
+// ensureRecovered recovers the file slices of the store if it has been
+// opened from its saved state and this has not been done yet.
+func (ms *FileMsgStore) ensureRecovered() error {
+	if atomic.LoadInt32(&ms.lazy) == 0 {
+		return nil
+	}
+	ms.Lock()
+	err := ms.recoverLazily()
+	ms.Unlock()
+	return err
+}
+
+// recoverLazily recovers the file slices of a store opened from its saved
+// state. If that fails, the error is returned by all further calls.
+// Lock held on entry.
+func (ms *FileMsgStore) recoverLazily() error {
+	if atomic.LoadInt32(&ms.lazy) == 0 || ms.lazyErr != nil {
+		return ms.lazyErr
+	}
+	progress := ms.fstore.recovery
+	if ms.closed {
+		// Nothing to recover, and the saved state is still valid.
+		atomic.StoreInt32(&ms.lazy, 0)
+		atomic.AddInt64(&progress.Recovered, 1)
+		return nil
+	}
+	first, last, count, bytes := ms.first, ms.last, ms.totalCount, ms.totalBytes
+	ms.first, ms.last, ms.totalCount, ms.totalBytes = 0, 0, 0, 0
+	fname, err := ms.recoverSlices(filepath.Join(ms.fm.rootDir, ms.channelName))
+	if err != nil {
+		ms.closeSlices(nil)
+		ms.files = make(map[int]*fileSlice)
+		ms.writeSlice = nil
+		ms.first, ms.last, ms.totalCount, ms.totalBytes = first, last, count, bytes
+		ms.lazyErr = fmt.Errorf("unable to recover message store for [%s](file: %q): %v", ms.subject, fname, err)
+		ms.log.Errorf("%v", ms.lazyErr)
+		atomic.AddInt64(&progress.Failed, 1)
+		return ms.lazyErr
+	}
+	ms.start(true)
+	atomic.StoreInt32(&ms.lazy, 0)
+	atomic.AddInt64(&progress.Recovered, 1)
+	return nil
+}
+
+// stateWithinLimits returns true if the messages described by the saved
+// `state` are within the limits of the store. If not, some would have to
+// be removed, so the file slices are recovered right away.
+func (ms *FileMsgStore) stateWithinLimits(state *msgsState) bool {
+	if state.count == 0 {
+		return true
+	}
+	if ms.limits.MaxMsgs > 0 && state.count > uint64(ms.limits.MaxMsgs) {
+		return false
+	}
+	if ms.limits.MaxBytes > 0 && state.bytes > uint64(ms.limits.MaxBytes) {
+		return false
+	}
+	if ms.limits.MaxAge > 0 && time.Now().UnixNano()-int64(state.firstTimestamp) >= int64(ms.limits.MaxAge) {
+		return false
+	}
+	return true
+}
+
+// firstMsgTimestamp returns the timestamp of the first message, or 0 if
+// there is none. Lock held on entry.
+func (ms *FileMsgStore) firstMsgTimestamp() (int64, error) {
+	if ms.firstMsg != nil {
+		return ms.firstMsg.Timestamp, nil
+	}
+	if ms.first == 0 || ms.first > ms.last {
+		return 0, nil
+	}
+	slice := ms.getFileSliceForSeq(ms.first)
+	if slice == nil {
+		return 0, nil
+	}
+	if err := ms.lockIndexFile(slice); err != nil {
+		return 0, err
+	}
+	mindex, err := ms.getMsgIndex(slice, ms.first)
+	ms.unlockIndexFile(slice)
+	if err != nil || mindex == nil {
+		return 0, err
+	}
+	return mindex.timestamp, nil
+}
+
+// saveMsgsState saves the state of the store in the channel directory, so
+// that the store can be opened without recovering its file slices. It must
+// be called before the file slices are closed.
+// Lock held on entry.
+func (ms *FileMsgStore) saveMsgsState() error {
+	dir := filepath.Join(ms.fm.rootDir, ms.channelName)
+	firstTimestamp, err := ms.firstMsgTimestamp()
+	if err != nil {
+		return err
+	}
+	state := &msgsState{
+		first:          ms.first,
+		last:           ms.last,
+		count:          uint64(ms.totalCount),
+		bytes:          ms.totalBytes,
+		firstTimestamp: uint64(firstTimestamp),
+		firstFSlSeq:    uint64(ms.firstFSlSeq),
+		lastFSlSeq:     uint64(ms.lastFSlSeq),
+	}
+	if ms.lastFSlSeq > 0 {
+		fi, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%s%v%s", msgFilesPrefix, ms.lastFSlSeq, datSuffix)))
+		if err != nil {
+			return err
+		}
+		state.lastDatSize = uint64(fi.Size())
+	}
+	buf := make([]byte, msgsStateSize)
+	util.ByteOrder.PutUint32(buf, msgsStateVersion)
+	b := buf[4:]
+	for _, v := range []uint64{state.first, state.last, state.count, state.bytes,
+		state.firstTimestamp, state.firstFSlSeq, state.lastFSlSeq, state.lastDatSize} {
+		util.ByteOrder.PutUint64(b, v)
+		b = b[8:]
+	}
+	util.ByteOrder.PutUint32(b, crc32.ChecksumIEEE(buf[:msgsStateSize-crcSize]))
+	return writeFileAtomically(filepath.Join(dir, msgsStateFileName), buf)
+}
+
+// readMsgsState returns the state saved in the channel directory `dir`,
+// or nil if there is none or if it does not match the file slices.
+func readMsgsState(dir string) *msgsState {
+	buf, err := os.ReadFile(filepath.Join(dir, msgsStateFileName))
+	if err != nil || len(buf) != msgsStateSize {
+		return nil
+	}
+	if util.ByteOrder.Uint32(buf) != msgsStateVersion ||
+		util.ByteOrder.Uint32(buf[msgsStateSize-crcSize:]) != crc32.ChecksumIEEE(buf[:msgsStateSize-crcSize]) {
+		return nil
+	}
+	state := &msgsState{}
+	b := buf[4:]
+	for _, v := range []*uint64{&state.first, &state.last, &state.count, &state.bytes,
+		&state.firstTimestamp, &state.firstFSlSeq, &state.lastFSlSeq, &state.lastDatSize} {
+		*v = util.ByteOrder.Uint64(b)
+		b = b[8:]
+	}
+	// The file slices may have been modified by a server or a tool that
+	// does not maintain the saved state, so check the ones we know of.
+	sliceName := func(fseq uint64) string {
+		return filepath.Join(dir, fmt.Sprintf("%s%v%s", msgFilesPrefix, fseq, datSuffix))
+	}
+	if state.firstFSlSeq > 0 {
+		if _, err := os.Stat(sliceName(state.firstFSlSeq)); err != nil {
+			return nil
+		}
+	}
+	if state.lastFSlSeq > 0 {
+		if fi, err := os.Stat(sliceName(state.lastFSlSeq)); err != nil || uint64(fi.Size()) != state.lastDatSize {
+			return nil
+		}
+	}
+	if _, err := os.Stat(sliceName(state.lastFSlSeq + 1)); err == nil {
+		return nil
+	}
+	return state
+}
+
+// removeMsgsState removes the state saved in the channel directory `dir`,
+// if any.
+func removeMsgsState(dir string) error {
+	if err := os.Remove(filepath.Join(dir, msgsStateFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // beforeDataFileCloseCb returns a beforeFileClose callback to be used
 // by FileMsgStore's files when a data file for that slice is being closed.
 // This is invoked asynchronously and should not acquire the store's lock.
@@ -2361,6 +2834,7 @@ func (ms *FileMsgStore) recoverOneMsgFile(fslice *fileSlice, fseq int, useIdxFil
 				fslice.firstSeq = seq
 			}
 			fslice.lastSeq = seq
+			fslice.addTimeIndex(seq, mindex.timestamp)
 			fslice.msgsCount++
 			// For size, add the message record size, the record header and the size
 			// required for the corresponding index record.
@@ -2403,6 +2877,7 @@ func (ms *FileMsgStore) recoverOneMsgFile(fslice *fileSlice, fseq int, useIdxFil
 			fslice.msgsCount = 0
 			fslice.msgsSize = 0
 			fslice.firstWrite = 0
+			fslice.tix = nil
 			file = fslice.file
 			err = nil
 			useIdxFile = false
@@ -2449,6 +2924,7 @@ func (ms *FileMsgStore) recoverOneMsgFile(fslice *fileSlice, fseq int, useIdxFil
 				fslice.firstSeq = msg.Sequence
 			}
 			fslice.lastSeq = msg.Sequence
+			fslice.addTimeIndex(msg.Sequence, msg.Timestamp)
 			fslice.msgsCount++
 			// For size, add the message record size, the record header and the size
 			// required for the corresponding index record.
@@ -2602,11 +3078,16 @@ func (ms *FileMsgStore) writeIndex(w io.Writer, seq uint64, offset, timestamp in
 
 // addIndex adds a message index record in the given buffer
 func (ms *FileMsgStore) addIndex(buf []byte, seq uint64, offset, timestamp int64, msgSize int) {
+	encodeIndex(buf, seq, offset, timestamp, msgSize, ms.fstore.crcTable)
+}
+
+// encodeIndex encodes a message index record in the given buffer
+func encodeIndex(buf []byte, seq uint64, offset, timestamp int64, msgSize int, crcTable *crc32.Table) {
 	util.ByteOrder.PutUint64(buf, seq)
 	util.ByteOrder.PutUint64(buf[8:], uint64(offset))
 	util.ByteOrder.PutUint64(buf[16:], uint64(timestamp))
 	util.ByteOrder.PutUint32(buf[24:], uint32(msgSize))
-	crc := crc32.Checksum(buf[:msgIndexRecSize-crcSize], ms.fstore.crcTable)
+	crc := crc32.Checksum(buf[:msgIndexRecSize-crcSize], crcTable)
 	util.ByteOrder.PutUint32(buf[msgIndexRecSize-crcSize:], crc)
 }
 
@@ -2622,6 +3103,11 @@ func (ms *FileMsgStore) readIndex(r io.Reader) (uint64, *msgIndex, error) {
 }
 
 func (ms *FileMsgStore) readIndexFromBuffer(buf []byte) (uint64, *msgIndex, error) {
+	return decodeIndex(buf, ms.fstore.crcTable, ms.fstore.opts.DoCRC)
+}
+
+// decodeIndex decodes a message index record from the given buffer
+func decodeIndex(buf []byte, crcTable *crc32.Table, checkCRC bool) (uint64, *msgIndex, error) {
 	mindex := &msgIndex{}
 	seq := util.ByteOrder.Uint64(buf)
 	mindex.offset = int64(util.ByteOrder.Uint64(buf[8:]))
@@ -2634,9 +3120,9 @@ func (ms *FileMsgStore) readIndexFromBuffer(buf []byte) (uint64, *msgIndex, erro
 			return 0, nil, errNeedRewind
 		}
 	}
-	if ms.fstore.opts.DoCRC {
+	if checkCRC {
 		storedCRC := util.ByteOrder.Uint32(buf[msgIndexRecSize-crcSize:])
-		crc := crc32.Checksum(buf[:msgIndexRecSize-crcSize], ms.fstore.crcTable)
+		crc := crc32.Checksum(buf[:msgIndexRecSize-crcSize], crcTable)
 		if storedCRC != crc {
 			return 0, nil, fmt.Errorf("corrupted data, expected crc to be 0x%08x, got 0x%08x", storedCRC, crc)
 		}
@@ -2644,11 +3130,128 @@ func (ms *FileMsgStore) readIndexFromBuffer(buf []byte) (uint64, *msgIndex, erro
 	return seq, mindex, nil
 }
 
+// addTimeIndex adds an entry to the slice's timestamp index if the
+// message with the given sequence and timestamp starts a new interval.
+// This must be called before the slice's message count is updated.
+func (sl *fileSlice) addTimeIndex(seq uint64, timestamp int64) {
+	if sl.msgsCount%tixInterval == 0 {
+		sl.tix = append(sl.tix, tixEntry{seq: seq, timestamp: timestamp})
+	}
+}
+
+// seqFromTimeIndex returns the sequence of the first message whose timestamp
+// is greater or equal to the given timestamp, using the slices' timestamp
+// indexes to locate the slice and the range of index records to read.
+// Store read lock held on entry.
+func (ms *FileMsgStore) seqFromTimeIndex(timestamp int64) (uint64, error) {
+	slices := make([]*fileSlice, 0, len(ms.files))
+	// support possible missing slices
+	for i := ms.firstFSlSeq; i <= ms.lastFSlSeq; i++ {
+		if slice := ms.files[i]; slice != nil && len(slice.tix) > 0 {
+			slices = append(slices, slice)
+		}
+	}
+	// The first entry of each slice is for its first stored message, so find
+	// the first slice that starts at or after the timestamp. The one before,
+	// if any, is where the message should be.
+	i := sort.Search(len(slices), func(i int) bool {
+		return slices[i].tix[0].timestamp >= timestamp
+	})
+	if i == 0 {
+		if len(slices) == 0 {
+			return ms.last + 1, nil
+		}
+		return slices[0].firstSeq, nil
+	}
+	slice := slices[i-1]
+	tix := slice.tix
+	j := sort.Search(len(tix), func(j int) bool {
+		return tix[j].timestamp >= timestamp
+	})
+	// Messages may have been removed from the slice due to limits, so entry
+	// j may be for a message before the first one. If so, the first message
+	// is not older than the timestamp either.
+	if j < len(tix) && tix[j].seq <= slice.firstSeq {
+		return slice.firstSeq, nil
+	}
+	// Entry j-1 is lower than timestamp, and entry j (or the end of the
+	// slice) is greater or equal, so search between those.
+	start := tix[j-1].seq + 1
+	if start < slice.firstSeq {
+		start = slice.firstSeq
+	}
+	end := slice.lastSeq
+	if j < len(tix) && tix[j].seq < end {
+		end = tix[j].seq
+	}
+	if start <= end {
+		if err := ms.lockIndexFile(slice); err != nil {
+			return 0, err
+		}
+		seq, err := ms.searchIndexRange(slice, start, end, timestamp)
+		ms.unlockIndexFile(slice)
+		if err != nil || seq != 0 {
+			return seq, err
+		}
+	}
+	// All remaining messages in this slice are older.
+	if i < len(slices) {
+		return slices[i].firstSeq, nil
+	}
+	return ms.last + 1, nil
+}
+
+// searchIndexRange returns the sequence of the first message in the range
+// [start, end] of the given slice whose timestamp is greater or equal to
+// the given timestamp, or 0 if there is none. The index records of the
+// range are read at once, unless they may not all be on disk or there are
+// gaps in the index file, in which case they are looked up one by one.
+// Index file is locked on entry.
+func (ms *FileMsgStore) searchIndexRange(slice *fileSlice, start, end uint64, timestamp int64) (uint64, error) {
+	if len(ms.bufferedMsgs) == 0 {
+		buf := make([]byte, int(end-start+1)*msgIndexRecSize)
+		offset := 4 + (int64(start-slice.firstSeq)+int64(slice.rmCount))*msgIndexRecSize
+		_, err := slice.idxFile.handle.Seek(offset, io.SeekStart)
+		if err == nil {
+			_, err = io.ReadFull(slice.idxFile.handle, buf)
+		}
+		if err == nil {
+			seq := start
+			for ; seq <= end; seq++ {
+				seqInIndexFile, mindex, err := ms.readIndexFromBuffer(buf[int(seq-start)*msgIndexRecSize:])
+				if err != nil || seqInIndexFile != seq {
+					break
+				}
+				if mindex.timestamp >= timestamp {
+					return seq, nil
+				}
+			}
+			if seq > end {
+				return 0, nil
+			}
+		}
+	}
+	for seq := start; seq <= end; seq++ {
+		mindex, err := ms.getMsgIndex(slice, seq)
+		if err != nil {
+			return 0, err
+		}
+		if mindex != nil && mindex.timestamp >= timestamp {
+			return seq, nil
+		}
+	}
+	return 0, nil
+}
+
 // Store a given message.
 func (ms *FileMsgStore) Store(m *pb.MsgProto) (uint64, error) {
 	ms.Lock()
 	defer ms.Unlock()
 
+	if err := ms.recoverLazily(); err != nil {
+		return 0, err
+	}
+
 	if m.Sequence <= ms.last {
 		// We've already seen this message.
 		return m.Sequence, nil
@@ -2820,6 +3423,7 @@ func (ms *FileMsgStore) Store(m *pb.MsgProto) (uint64, error) {
 	ms.totalBytes += size
 
 	// Stats per file slice
+	fslice.addTimeIndex(seq, m.Timestamp)
 	fslice.msgsCount++
 	fslice.msgsSize += size
 	if fslice.firstWrite == 0 {
@@ -2880,6 +3484,7 @@ func (ms *FileMsgStore) fillGaps(fslice *fileSlice, upToMsg *pb.MsgProto) error
 		size := uint64(msgSize + msgRecordOverhead)
 		ms.totalBytes += size
 		fslice.lastSeq = i
+		fslice.addTimeIndex(i, emptyMsg.Timestamp)
 		fslice.msgsCount++
 		fslice.msgsSize += size
 	}
@@ -3164,9 +3769,12 @@ func (ms *FileMsgStore) removeFirstSlice() {
 	ms.fm.remove(sl.idxFile)
 	// Assume we will remove the files
 	remove := true
-	// If there is an archive script invoke it first
+	// If there is an archiver, hand it the files, otherwise if there is
+	// an archive script invoke it first
 	script := ms.fstore.opts.SliceArchiveScript
-	if script != "" {
+	if ms.fstore.archiver != nil {
+		remove = !ms.archiveSlice(sl)
+	} else if script != "" {
 		datBak := sl.file.name + bakSuffix
 		idxBak := sl.idxFile.name + bakSuffix
 
@@ -3219,21 +3827,217 @@ func (ms *FileMsgStore) removeFirstSlice() {
 	}
 }
 
-// getFileSliceForSeq returns the file slice where the message of the
-// given sequence is stored, or nil if the message is not found in any
-// of the file slices.
-func (ms *FileMsgStore) getFileSliceForSeq(seq uint64) *fileSlice {
-	if len(ms.files) == 0 {
-		return nil
+// archiveSlice renames the files of the removed slice `sl` with the
+// backup suffix and hands them to the Archiver from a go routine.
+// Returns false if this could not be done, in which case the files
+// should simply be removed.
+// Lock held on entry.
+func (ms *FileMsgStore) archiveSlice(sl *fileSlice) bool {
+	if sl.msgsCount == 0 {
+		return false
 	}
-	// Start with write slice
-	slice := ms.writeSlice
-	if (slice.firstSeq <= seq) && (seq <= slice.lastSeq) {
-		return slice
+	first, err := ms.readFirstIndexSeq(sl.idxFile.name)
+	if err == nil {
+		err = os.Rename(sl.file.name, sl.file.name+bakSuffix)
 	}
-	// We want to support possible gaps in file slice sequence, so
-	// no dichotomy, but simple iteration of the map, which in Go is
-	// random.
+	if err == nil {
+		if err = os.Rename(sl.idxFile.name, sl.idxFile.name+bakSuffix); err != nil {
+			os.Remove(sl.file.name + bakSuffix)
+		}
+	}
+	if err != nil {
+		ms.log.Errorf("Unable to archive file slice %q for channel %q: %v", sl.file.name, ms.subject, err)
+		return false
+	}
+	as := &archivingSlice{
+		first:   first,
+		last:    sl.lastSeq,
+		datFile: sl.file.name + bakSuffix,
+		idxFile: sl.idxFile.name + bakSuffix,
+	}
+	ms.archMu.Lock()
+	ms.archiving = append(ms.archiving, as)
+	ms.archMu.Unlock()
+
+	ms.archWG.Add(1)
+	go func() {
+		defer ms.archWG.Done()
+		err := ms.fstore.archiver.Archive(ms.subject, as.first, as.last, as.datFile, as.idxFile)
+		ms.archMu.Lock()
+		for i, a := range ms.archiving {
+			if a == as {
+				ms.archiving = append(ms.archiving[:i], ms.archiving[i+1:]...)
+				break
+			}
+		}
+		ms.archMu.Unlock()
+		if err != nil {
+			ms.log.Errorf("Unable to archive messages %v to %v for channel %q, files kept as %q and %q: %v",
+				as.first, as.last, ms.subject, as.datFile, as.idxFile, err)
+			return
+		}
+		os.Remove(as.datFile)
+		os.Remove(as.idxFile)
+	}()
+	return true
+}
+
+// readFirstIndexSeq returns the sequence of the first record in the
+// given index file.
+func (ms *FileMsgStore) readFirstIndexSeq(idxFileName string) (uint64, error) {
+	f, err := os.Open(idxFileName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	// Skip the file version
+	if _, err := f.Seek(4, io.SeekStart); err != nil {
+		return 0, err
+	}
+	seq, _, err := ms.readIndex(f)
+	return seq, err
+}
+
+// lookupArchived returns the message for the given sequence from the
+// archived slice that contains it, or nil if it is not found.
+// Store write lock is assumed to be held on entry
+func (ms *FileMsgStore) lookupArchived(seq uint64) (*pb.MsgProto, error) {
+	// Messages removed from the first slice are still in its files.
+	if sl := ms.files[ms.firstFSlSeq]; sl != nil && ms.first-seq <= uint64(sl.rmCount) {
+		return ms.readRemovedMsg(sl, seq)
+	}
+	as := ms.archSlice
+	if as == nil || seq < as.First || seq > as.Last {
+		if as != nil {
+			as.Close()
+			ms.archSlice = nil
+		}
+		var err error
+		if as, err = ms.openArchivedSlice(seq); as == nil || err != nil {
+			return nil, err
+		}
+		// Keep it opened since subscriptions catching up will look
+		// up the following messages.
+		ms.archSlice = as
+	}
+	return ms.readArchivedMsg(as, seq)
+}
+
+// readRemovedMsg reads the message of the given sequence, which has been
+// removed from the slice `sl` but not yet from its files.
+// Store write lock is assumed to be held on entry
+func (ms *FileMsgStore) readRemovedMsg(sl *fileSlice, seq uint64) (*pb.MsgProto, error) {
+	if bm := ms.bufferedMsgs[seq]; bm != nil {
+		return bm.msg, nil
+	}
+	if err := ms.lockFiles(sl); err != nil {
+		return nil, err
+	}
+	defer ms.unlockFiles(sl)
+	mindex, err := ms.readMsgIndex(sl, seq)
+	if mindex == nil || err != nil {
+		return nil, err
+	}
+	if _, err := sl.file.handle.Seek(mindex.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	ms.tmpMsgBuf, err = ms.readMsgRecord(sl.file.handle, ms.tmpMsgBuf, mindex.msgSize)
+	if err != nil {
+		return nil, err
+	}
+	msg := &pb.MsgProto{}
+	if err := msg.Unmarshal(ms.tmpMsgBuf[recordHeaderSize : recordHeaderSize+mindex.msgSize]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// openArchivedSlice returns the archived slice that contains the given
+// sequence, which may still be in the process of being archived.
+func (ms *FileMsgStore) openArchivedSlice(seq uint64) (*ArchivedSlice, error) {
+	var pending *archivingSlice
+	ms.archMu.Lock()
+	for _, a := range ms.archiving {
+		if seq >= a.first && seq <= a.last {
+			pending = a
+			break
+		}
+	}
+	ms.archMu.Unlock()
+	if pending != nil {
+		// If this fails, the files have just been handed to the archiver.
+		if idx, err := os.Open(pending.idxFile); err == nil {
+			if dat, err := os.Open(pending.datFile); err == nil {
+				return &ArchivedSlice{First: pending.first, Last: pending.last, Data: dat, Index: idx}, nil
+			}
+			idx.Close()
+		}
+	}
+	return ms.fstore.archiver.Open(ms.subject, seq)
+}
+
+// readArchivedMsg reads the message of the given sequence from the
+// archived slice, or returns nil if it is not found.
+func (ms *FileMsgStore) readArchivedMsg(as *ArchivedSlice, seq uint64) (*pb.MsgProto, error) {
+	var (
+		buf    [msgIndexRecSize]byte
+		mindex *msgIndex
+	)
+	// Index records should be consecutive, but, as in backtrackIndex,
+	// go backward in case there are gaps.
+	for offset := 4 + int64(seq-as.First)*msgIndexRecSize; offset >= 4; offset -= msgIndexRecSize {
+		if _, err := as.Index.ReadAt(buf[:], offset); err != nil {
+			if err == io.EOF {
+				continue
+			}
+			return nil, err
+		}
+		seqInIndexFile, mi, err := ms.readIndexFromBuffer(buf[:])
+		if err == errNeedRewind {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if seqInIndexFile == seq {
+			mindex = mi
+			break
+		}
+		if seqInIndexFile < seq {
+			break
+		}
+	}
+	if mindex == nil {
+		return nil, nil
+	}
+	var err error
+	r := io.NewSectionReader(as.Data, mindex.offset, int64(recordHeaderSize)+int64(mindex.msgSize))
+	ms.tmpMsgBuf, err = ms.readMsgRecord(r, ms.tmpMsgBuf, mindex.msgSize)
+	if err != nil {
+		return nil, err
+	}
+	msg := &pb.MsgProto{}
+	if err := msg.Unmarshal(ms.tmpMsgBuf[recordHeaderSize : recordHeaderSize+mindex.msgSize]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// getFileSliceForSeq returns the file slice where the message of the
+// given sequence is stored, or nil if the message is not found in any
+// of the file slices.
+func (ms *FileMsgStore) getFileSliceForSeq(seq uint64) *fileSlice {
+	if len(ms.files) == 0 {
+		return nil
+	}
+	// Start with write slice
+	slice := ms.writeSlice
+	if (slice.firstSeq <= seq) && (seq <= slice.lastSeq) {
+		return slice
+	}
+	// We want to support possible gaps in file slice sequence, so
+	// no dichotomy, but simple iteration of the map, which in Go is
+	// random.
 	for _, slice := range ms.files {
 		if (slice.firstSeq <= seq) && (seq <= slice.lastSeq) {
 			return slice
@@ -3552,8 +4356,20 @@ func (ms *FileMsgStore) readAheadMsgs(fslice *fileSlice, seq uint64) (*pb.MsgPro
 
 // Lookup returns the stored message with given sequence number.
 func (ms *FileMsgStore) Lookup(seq uint64) (*pb.MsgProto, error) {
+	var (
+		msg *pb.MsgProto
+		err error
+	)
+	if err = ms.ensureRecovered(); err != nil {
+		return nil, err
+	}
 	ms.Lock()
-	msg, err := ms.lookup(seq)
+	// Messages removed due to limits may have been archived
+	if seq < ms.first && ms.fstore.archiver != nil {
+		msg, err = ms.lookupArchived(seq)
+	} else {
+		msg, err = ms.lookup(seq)
+	}
 	ms.Unlock()
 	return msg, err
 }
@@ -3561,6 +4377,9 @@ func (ms *FileMsgStore) Lookup(seq uint64) (*pb.MsgProto, error) {
 // FirstMsg returns the first message stored.
 func (ms *FileMsgStore) FirstMsg() (*pb.MsgProto, error) {
 	var err error
+	if err = ms.ensureRecovered(); err != nil {
+		return nil, err
+	}
 	ms.RLock()
 	if ms.firstMsg == nil {
 		ms.firstMsg, err = ms.lookup(ms.first)
@@ -3573,6 +4392,9 @@ func (ms *FileMsgStore) FirstMsg() (*pb.MsgProto, error) {
 // LastMsg returns the last message stored.
 func (ms *FileMsgStore) LastMsg() (*pb.MsgProto, error) {
 	var err error
+	if err = ms.ensureRecovered(); err != nil {
+		return nil, err
+	}
 	ms.RLock()
 	if ms.lastMsg == nil {
 		ms.lastMsg, err = ms.lookup(ms.last)
@@ -3585,6 +4407,9 @@ func (ms *FileMsgStore) LastMsg() (*pb.MsgProto, error) {
 // GetSequenceFromTimestamp returns the sequence of the first message whose
 // timestamp is greater or equal to given timestamp.
 func (ms *FileMsgStore) GetSequenceFromTimestamp(timestamp int64) (uint64, error) {
+	if err := ms.ensureRecovered(); err != nil {
+		return 0, err
+	}
 	ms.RLock()
 	defer ms.RUnlock()
 
@@ -3609,59 +4434,7 @@ func (ms *FileMsgStore) GetSequenceFromTimestamp(timestamp int64) (uint64, error
 		}
 	}
 	// This will require disk access.
-	for i := ms.firstFSlSeq; i <= ms.lastFSlSeq; i++ {
-		// support possible missing slices
-		slice := ms.files[i]
-		if slice == nil {
-			continue
-		}
-		if err := ms.lockIndexFile(slice); err != nil {
-			return 0, err
-		}
-		seq := slice.firstSeq
-		if firstMsgInSlice, err := ms.getMsgIndex(slice, seq); err != nil {
-			ms.unlockIndexFile(slice)
-			return 0, err
-		} else if timestamp > firstMsgInSlice.timestamp {
-			seq = slice.lastSeq
-			lastMsgInSlice, err := ms.getMsgIndex(slice, seq)
-			if err != nil {
-				ms.unlockIndexFile(slice)
-				return 0, err
-			}
-			if timestamp > lastMsgInSlice.timestamp {
-				// Not there, move to the next slice.
-				ms.unlockIndexFile(slice)
-				continue
-			}
-			// It may be equal, so search only if strictly lower
-			if timestamp < lastMsgInSlice.timestamp {
-				// We know that the timestamp is somewhere in this slice.
-
-				// Could do binary search, but will be probably more efficient
-				// to do sequential disk reads. The index records are small,
-				// so read of a record will probably bring many consecutive ones
-				// in the system's disk cache, resulting in memory-only access
-				// for the following indexes...
-				for seq = slice.firstSeq + 1; seq <= slice.lastSeq-1; seq++ {
-					mindex, err := ms.getMsgIndex(slice, seq)
-					if err != nil {
-						ms.unlockIndexFile(slice)
-						return 0, err
-					}
-					if mindex.timestamp >= timestamp {
-						break
-					}
-				}
-			}
-		}
-		ms.unlockIndexFile(slice)
-		// We are here if the timestamp is smaller than the first
-		// message in the first slice, or we have found the first
-		// sequence that is >= timestamp.
-		return seq, nil
-	}
-	return ms.last + 1, nil
+	return ms.seqFromTimeIndex(timestamp)
 }
 
 // initCache initializes the message cache
@@ -3789,16 +4562,33 @@ func (ms *FileMsgStore) Close() error {
 
 	// Wait on go routines/timers to finish
 	ms.allDone.Wait()
+	ms.archWG.Wait()
 
 	ms.Lock()
 	var err error
+	if ms.archSlice != nil {
+		err = ms.archSlice.Close()
+		ms.archSlice = nil
+	}
 	if ms.writeSlice != nil {
 		// Flush current file slice where writes happen
 		ms.lockFiles(ms.writeSlice)
 		err = ms.flush(ms.writeSlice, true)
 		ms.unlockFiles(ms.writeSlice)
 	}
-	// Remove/close all file slices
+	if ms.saveState && err == nil {
+		err = ms.saveMsgsState()
+	}
+	err = ms.closeSlices(err)
+	ms.Unlock()
+
+	return err
+}
+
+// closeSlices removes all file slices from the files manager and closes
+// them. The first error, starting with `err`, is returned.
+// Lock held on entry.
+func (ms *FileMsgStore) closeSlices(err error) error {
 	for _, slice := range ms.files {
 		ms.fm.remove(slice.file)
 		ms.fm.remove(slice.idxFile)
@@ -3809,8 +4599,6 @@ func (ms *FileMsgStore) Close() error {
 			err = util.CloseFile(err, slice.idxFile.handle)
 		}
 	}
-	ms.Unlock()
-
 	return err
 }
 
@@ -3871,10 +4659,23 @@ func (ms *FileMsgStore) autoSync() {
 
 // Empty implements the MsgStore interface
 func (ms *FileMsgStore) Empty() error {
+	if err := ms.ensureRecovered(); err != nil {
+		return err
+	}
 	ms.Lock()
 	defer ms.Unlock()
 
 	var err error
+	if ms.fstore.archiver != nil {
+		// Sequences will start again from 1, so drop the archived slices
+		// once the ones in progress are done.
+		ms.archWG.Wait()
+		if ms.archSlice != nil {
+			ms.archSlice.Close()
+			ms.archSlice = nil
+		}
+		err = ms.fstore.archiver.Remove(ms.subject)
+	}
 	// Close all file slices
 	for sliceID, slice := range ms.files {
 		ms.fm.remove(slice.file)
@@ -3915,6 +4716,265 @@ func (ms *FileMsgStore) Empty() error {
 // FileSubStore methods
 ////////////////////////////////////////////////////////////////////////////
 
+func newSeqBitmap() *seqBitmap {
+	return &seqBitmap{words: make(map[uint64]uint64)}
+}
+
+// add sets the bit of the given sequence.
+func (b *seqBitmap) add(seq uint64) {
+	idx, bit := seq/64, uint64(1)<<(seq%64)
+	if w := b.words[idx]; w&bit == 0 {
+		b.words[idx] = w | bit
+		b.count++
+	}
+}
+
+// remove clears the bit of the given sequence.
+func (b *seqBitmap) remove(seq uint64) {
+	idx, bit := seq/64, uint64(1)<<(seq%64)
+	w := b.words[idx]
+	if w&bit == 0 {
+		return
+	}
+	if w &^= bit; w == 0 {
+		delete(b.words, idx)
+	} else {
+		b.words[idx] = w
+	}
+	b.count--
+}
+
+// setWord sets the bits of `w` in the word at index `idx`.
+func (b *seqBitmap) setWord(idx, w uint64) {
+	old := b.words[idx]
+	if w |= old; w == old {
+		return
+	}
+	b.words[idx] = w
+	b.count += bits.OnesCount64(w) - bits.OnesCount64(old)
+}
+
+// len returns the number of sequences in the bitmap.
+func (b *seqBitmap) len() int {
+	return b.count
+}
+
+// clone returns a copy of the bitmap.
+func (b *seqBitmap) clone() *seqBitmap {
+	c := &seqBitmap{words: make(map[uint64]uint64, len(b.words)), count: b.count}
+	for idx, w := range b.words {
+		c.words[idx] = w
+	}
+	return c
+}
+
+// sortedIndexes returns the indexes of the words in increasing order.
+func (b *seqBitmap) sortedIndexes() []uint64 {
+	indexes := make([]uint64, 0, len(b.words))
+	for idx := range b.words {
+		indexes = append(indexes, idx)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	return indexes
+}
+
+// forEach invokes `f` for each sequence in the bitmap, in no particular
+// order, and stops at the first error.
+func (b *seqBitmap) forEach(f func(seq uint64) error) error {
+	for idx, w := range b.words {
+		for ; w != 0; w &= w - 1 {
+			if err := f(idx*64 + uint64(bits.TrailingZeros64(w))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newSubCheckpoints returns the records to write to checkpoint the given
+// subscription. There is a single record unless the subscription has a
+// very large number of pending messages.
+func newSubCheckpoints(sub *subscription) []*subCheckpoint {
+	var ckps []*subCheckpoint
+	indexes := sub.pending.sortedIndexes()
+	for {
+		n := len(indexes)
+		if n > subCheckpointMaxWords {
+			n = subCheckpointMaxWords
+		}
+		ckp := &subCheckpoint{sub: sub.sub, indexes: indexes[:n], pending: sub.pending}
+		if n > 0 {
+			first := indexes[0]
+			ckp.lwm = first*64 + uint64(bits.TrailingZeros64(sub.pending.words[first]))
+		}
+		ckps = append(ckps, ckp)
+		if indexes = indexes[n:]; len(indexes) == 0 {
+			return ckps
+		}
+	}
+}
+
+func uvarintSize(v uint64) int {
+	n := 1
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// Size implements the record interface
+func (c *subCheckpoint) Size() int {
+	subSize := c.sub.Size()
+	size := uvarintSize(uint64(subSize)) + subSize
+	size += uvarintSize(c.lwm) + uvarintSize(uint64(len(c.indexes)))
+	prev := c.lwm / 64
+	for _, idx := range c.indexes {
+		size += uvarintSize(idx-prev) + 8
+		prev = idx
+	}
+	return size
+}
+
+// MarshalTo implements the record interface
+func (c *subCheckpoint) MarshalTo(buf []byte) (int, error) {
+	n := binary.PutUvarint(buf, uint64(c.sub.Size()))
+	sn, err := c.sub.MarshalTo(buf[n:])
+	if err != nil {
+		return 0, err
+	}
+	n += sn
+	n += binary.PutUvarint(buf[n:], c.lwm)
+	n += binary.PutUvarint(buf[n:], uint64(len(c.indexes)))
+	prev := c.lwm / 64
+	for _, idx := range c.indexes {
+		n += binary.PutUvarint(buf[n:], idx-prev)
+		util.ByteOrder.PutUint64(buf[n:], c.pending.words[idx])
+		n += 8
+		prev = idx
+	}
+	return n, nil
+}
+
+// Unmarshal decodes the checkpoint record. The pending sequences are added
+// to the bitmap `c.pending`, which must be set by the caller.
+func (c *subCheckpoint) Unmarshal(buf []byte) error {
+	subSize, n := binary.Uvarint(buf)
+	if n <= 0 || subSize > uint64(len(buf)-n) {
+		return errBadSubCheckpoint
+	}
+	c.sub = &spb.SubState{}
+	if err := c.sub.Unmarshal(buf[n : n+int(subSize)]); err != nil {
+		return err
+	}
+	buf = buf[n+int(subSize):]
+	if c.lwm, n = binary.Uvarint(buf); n <= 0 {
+		return errBadSubCheckpoint
+	}
+	buf = buf[n:]
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return errBadSubCheckpoint
+	}
+	buf = buf[n:]
+	idx := c.lwm / 64
+	for i := uint64(0); i < count; i++ {
+		delta, n := binary.Uvarint(buf)
+		if n <= 0 || len(buf) < n+8 {
+			return errBadSubCheckpoint
+		}
+		idx += delta
+		c.pending.setWord(idx, util.ByteOrder.Uint64(buf[n:]))
+		buf = buf[n+8:]
+	}
+	return nil
+}
+
+// subsGenFileName returns the name of the checkpoint or rotated journal
+// (based on `suffix`) of the given generation.
+func subsGenFileName(gen uint64, suffix string) string {
+	return fmt.Sprintf("%s%d%s", subsFilesPrefix, gen, suffix)
+}
+
+// parseSubsGenFileName returns the generation and suffix of a checkpoint or
+// rotated journal file name, and false if `name` is not such a file.
+func parseSubsGenFileName(name string) (uint64, string, bool) {
+	if !strings.HasPrefix(name, subsFilesPrefix) {
+		return 0, "", false
+	}
+	for _, suffix := range []string{subsJournalSuffix, subsCheckpointSuffix} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		gen, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, subsFilesPrefix), suffix), 10, 64)
+		if err != nil || gen == 0 {
+			return 0, "", false
+		}
+		return gen, suffix, true
+	}
+	return 0, "", false
+}
+
+// subsFilesToRecover returns, in the order in which they must be read
+// before the subscriptions file, the last checkpoint found in `dir` and the
+// journals rotated after it was started. It also returns the generation of
+// this checkpoint (0 if there is none) and the highest generation found.
+func subsFilesToRecover(dir string) ([]string, uint64, uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var ckpGen, lastGen uint64
+	var jrnGens []uint64
+	for _, entry := range entries {
+		gen, suffix, ok := parseSubsGenFileName(entry.Name())
+		if !ok {
+			continue
+		}
+		if suffix == subsCheckpointSuffix {
+			if gen > ckpGen {
+				ckpGen = gen
+			}
+		} else {
+			jrnGens = append(jrnGens, gen)
+		}
+		if gen > lastGen {
+			lastGen = gen
+		}
+	}
+	sort.Slice(jrnGens, func(i, j int) bool { return jrnGens[i] < jrnGens[j] })
+	var files []string
+	if ckpGen > 0 {
+		files = append(files, filepath.Join(dir, subsGenFileName(ckpGen, subsCheckpointSuffix)))
+	}
+	for _, gen := range jrnGens {
+		// Journals up to the checkpoint generation are included in it.
+		if gen > ckpGen {
+			files = append(files, filepath.Join(dir, subsGenFileName(gen, subsJournalSuffix)))
+		}
+	}
+	return files, ckpGen, lastGen, nil
+}
+
+// removeSubsFiles removes from `dir` the rotated journals whose generation
+// is at most `maxJrnGen` and the checkpoints whose generation is at most
+// `maxCkpGen`.
+func removeSubsFiles(dir string, maxJrnGen, maxCkpGen uint64) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		gen, suffix, ok := parseSubsGenFileName(entry.Name())
+		if !ok || (suffix == subsJournalSuffix && gen > maxJrnGen) || (suffix == subsCheckpointSuffix && gen > maxCkpGen) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 // newFileSubStore returns a new instace of a file SubStore.
 func (fs *FileStore) newFileSubStore(channel string, limits *SubStoreLimits, doRecover bool) (fss *FileSubStore, retErr error) {
 	ss := &FileSubStore{
@@ -4042,6 +5102,11 @@ func (ss *FileSubStore) recoverSubscriptions() error {
 	var err error
 	var recType recordType
 
+	// Start with the last checkpoint, if any, and the journals that follow.
+	if err := ss.recoverCheckpoint(); err != nil {
+		return err
+	}
+
 	recSize := 0
 	offset := int64(4)
 
@@ -4068,93 +5133,179 @@ func (ss *FileSubStore) recoverSubscriptions() error {
 		readBytes := int64(recSize + recordHeaderSize)
 		offset += readBytes
 		ss.fileSize += readBytes
-		// Based on record type...
-		switch recType {
-		case subRecNew:
-			newSub := &spb.SubState{}
-			if err := newSub.Unmarshal(ss.tmpSubBuf[:recSize]); err != nil {
-				return err
+		ss.journalRecs++
+		if err := ss.recoverRecord(recType, ss.tmpSubBuf[:recSize]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverCheckpoint recovers the last checkpoint of the subscriptions and
+// the journals rotated after it was started. The previous checkpoints and
+// the journals included in that checkpoint are removed.
+func (ss *FileSubStore) recoverCheckpoint() error {
+	dir := filepath.Dir(ss.file.name)
+	files, ckpGen, lastGen, err := subsFilesToRecover(dir)
+	if err != nil {
+		return err
+	}
+	if ckpGen > 0 {
+		if err := removeSubsFiles(dir, ckpGen, ckpGen-1); err != nil {
+			return err
+		}
+	}
+	for _, fileName := range files {
+		if err := ss.recoverSubsFile(fileName); err != nil {
+			return fmt.Errorf("unable to recover %q: %v", fileName, err)
+		}
+	}
+	ss.ckpGen = lastGen
+	return nil
+}
+
+// recoverSubsFile recovers the records of a checkpoint or rotated journal.
+// Contrary to the subscriptions file, these files are complete: they are
+// renamed once they are no longer written to.
+func (ss *FileSubStore) recoverSubsFile(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReaderSize(f, defaultBufSize)
+	if err := checkFileVersion(br); err != nil {
+		return err
+	}
+	journal := strings.HasSuffix(fileName, subsJournalSuffix)
+	for {
+		var recSize int
+		var recType recordType
+		ss.tmpSubBuf, recSize, recType, err = readRecord(br, ss.tmpSubBuf, true, ss.crcTable, ss.opts.DoCRC, 0)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if journal {
+			ss.journalRecs++
+		}
+		if err := ss.recoverRecord(recType, ss.tmpSubBuf[:recSize]); err != nil {
+			return err
+		}
+	}
+}
+
+// recoverRecord applies the given record to the recovered subscriptions.
+func (ss *FileSubStore) recoverRecord(recType recordType, buf []byte) error {
+	// Based on record type...
+	switch recType {
+	case subRecCheckpoint:
+		ckp := subCheckpoint{pending: newSeqBitmap()}
+		if err := ckp.Unmarshal(buf); err != nil {
+			return err
+		}
+		// A subscription with a lot of pending messages is
+		// checkpointed with several records.
+		if subi, exists := ss.subs[ckp.sub.ID]; exists {
+			sub := subi.(*subscription)
+			sub.sub = ckp.sub
+			for idx, w := range ckp.pending.words {
+				sub.pending.setWord(idx, w)
 			}
+		} else {
+			ss.subs[ckp.sub.ID] = &subscription{sub: ckp.sub, pending: ckp.pending}
+		}
+		// Keep track of max subscription ID found.
+		if ckp.sub.ID > ss.maxSubID {
+			ss.maxSubID = ckp.sub.ID
+		}
+		ss.numRecs++
+	case subRecNew:
+		newSub := &spb.SubState{}
+		if err := newSub.Unmarshal(buf); err != nil {
+			return err
+		}
+		sub := &subscription{
+			sub:     newSub,
+			pending: newSeqBitmap(),
+		}
+		ss.subs[newSub.ID] = sub
+		// Keep track of max subscription ID found.
+		if newSub.ID > ss.maxSubID {
+			ss.maxSubID = newSub.ID
+		}
+		ss.numRecs++
+	case subRecUpdate:
+		modifiedSub := &spb.SubState{}
+		if err := modifiedSub.Unmarshal(buf); err != nil {
+			return err
+		}
+		// Search if the create has been recovered.
+		subi, exists := ss.subs[modifiedSub.ID]
+		if exists {
+			sub := subi.(*subscription)
+			sub.sub = modifiedSub
+			// An update means that the previous version is free space.
+			ss.delRecs++
+		} else {
 			sub := &subscription{
-				sub:    newSub,
-				seqnos: make(map[uint64]struct{}),
-			}
-			ss.subs[newSub.ID] = sub
-			// Keep track of max subscription ID found.
-			if newSub.ID > ss.maxSubID {
-				ss.maxSubID = newSub.ID
-			}
-			ss.numRecs++
-		case subRecUpdate:
-			modifiedSub := &spb.SubState{}
-			if err := modifiedSub.Unmarshal(ss.tmpSubBuf[:recSize]); err != nil {
-				return err
-			}
-			// Search if the create has been recovered.
-			subi, exists := ss.subs[modifiedSub.ID]
-			if exists {
-				sub := subi.(*subscription)
-				sub.sub = modifiedSub
-				// An update means that the previous version is free space.
-				ss.delRecs++
-			} else {
-				sub := &subscription{
-					sub:    modifiedSub,
-					seqnos: make(map[uint64]struct{}),
-				}
-				ss.subs[modifiedSub.ID] = sub
+				sub:     modifiedSub,
+				pending: newSeqBitmap(),
 			}
-			// Keep track of max subscription ID found.
-			if modifiedSub.ID > ss.maxSubID {
-				ss.maxSubID = modifiedSub.ID
+			ss.subs[modifiedSub.ID] = sub
+		}
+		// Keep track of max subscription ID found.
+		if modifiedSub.ID > ss.maxSubID {
+			ss.maxSubID = modifiedSub.ID
+		}
+		ss.numRecs++
+	case subRecDel:
+		delSub := spb.SubStateDelete{}
+		if err := delSub.Unmarshal(buf); err != nil {
+			return err
+		}
+		if si, exists := ss.subs[delSub.ID]; exists {
+			s := si.(*subscription)
+			delete(ss.subs, delSub.ID)
+			// Delete and count all non-ack'ed messages free space.
+			ss.delRecs++
+			ss.delRecs += s.pending.len()
+		}
+		// Keep track of max subscription ID found.
+		if delSub.ID > ss.maxSubID {
+			ss.maxSubID = delSub.ID
+		}
+	case subRecMsg:
+		updateSub := spb.SubStateUpdate{}
+		if err := updateSub.Unmarshal(buf); err != nil {
+			return err
+		}
+		if subi, exists := ss.subs[updateSub.ID]; exists {
+			sub := subi.(*subscription)
+			seqno := updateSub.Seqno
+			// Same seqno/ack can appear several times for the same sub.
+			// See queue subscribers redelivery.
+			if seqno > sub.sub.LastSent {
+				sub.sub.LastSent = seqno
 			}
+			sub.pending.add(seqno)
 			ss.numRecs++
-		case subRecDel:
-			delSub := spb.SubStateDelete{}
-			if err := delSub.Unmarshal(ss.tmpSubBuf[:recSize]); err != nil {
-				return err
-			}
-			if si, exists := ss.subs[delSub.ID]; exists {
-				s := si.(*subscription)
-				delete(ss.subs, delSub.ID)
-				// Delete and count all non-ack'ed messages free space.
-				ss.delRecs++
-				ss.delRecs += len(s.seqnos)
-			}
-			// Keep track of max subscription ID found.
-			if delSub.ID > ss.maxSubID {
-				ss.maxSubID = delSub.ID
-			}
-		case subRecMsg:
-			updateSub := spb.SubStateUpdate{}
-			if err := updateSub.Unmarshal(ss.tmpSubBuf[:recSize]); err != nil {
-				return err
-			}
-			if subi, exists := ss.subs[updateSub.ID]; exists {
-				sub := subi.(*subscription)
-				seqno := updateSub.Seqno
-				// Same seqno/ack can appear several times for the same sub.
-				// See queue subscribers redelivery.
-				if seqno > sub.sub.LastSent {
-					sub.sub.LastSent = seqno
-				}
-				sub.seqnos[seqno] = struct{}{}
-				ss.numRecs++
-			}
-		case subRecAck:
-			updateSub := spb.SubStateUpdate{}
-			if err := updateSub.Unmarshal(ss.tmpSubBuf[:recSize]); err != nil {
-				return err
-			}
-			if subi, exists := ss.subs[updateSub.ID]; exists {
-				sub := subi.(*subscription)
-				delete(sub.seqnos, updateSub.Seqno)
-				// A message is ack'ed
-				ss.delRecs++
-			}
-		default:
-			return fmt.Errorf("unexpected record type: %v", recType)
 		}
+	case subRecAck:
+		updateSub := spb.SubStateUpdate{}
+		if err := updateSub.Unmarshal(buf); err != nil {
+			return err
+		}
+		if subi, exists := ss.subs[updateSub.ID]; exists {
+			sub := subi.(*subscription)
+			sub.pending.remove(updateSub.Seqno)
+			// A message is ack'ed
+			ss.delRecs++
+		}
+	default:
+		return fmt.Errorf("unexpected record type: %v", recType)
 	}
 	return nil
 }
@@ -4176,8 +5327,9 @@ func (ss *FileSubStore) CreateSub(sub *spb.SubState) error {
 	// We need to get a copy of the passed sub, we can't hold a reference
 	// to it.
 	csub := *sub
-	s := &subscription{sub: &csub, seqnos: make(map[uint64]struct{})}
+	s := &subscription{sub: &csub, pending: newSeqBitmap()}
 	ss.subs[sub.ID] = s
+	ss.checkpointIfNeeded()
 	return nil
 }
 
@@ -4196,9 +5348,10 @@ func (ss *FileSubStore) UpdateSub(sub *spb.SubState) error {
 		s := si.(*subscription)
 		s.sub = &csub
 	} else {
-		s := &subscription{sub: &csub, seqnos: make(map[uint64]struct{})}
+		s := &subscription{sub: &csub, pending: newSeqBitmap()}
 		ss.subs[sub.ID] = s
 	}
+	ss.checkpointIfNeeded()
 	return nil
 }
 
@@ -4214,13 +5367,16 @@ func (ss *FileSubStore) DeleteSub(subid uint64) error {
 		delete(ss.subs, subid)
 		// writeRecord has already accounted for the count of the
 		// delete record. We add to this the number of pending messages
-		ss.delRecs += len(s.seqnos)
+		ss.delRecs += s.pending.len()
 		// Check if this triggers a need for compaction
 		if ss.shouldCompact() {
 			ss.fm.closeFileIfOpened(ss.file)
 			ss.compact(ss.file.name)
 		}
 	}
+	if err == nil {
+		ss.checkpointIfNeeded()
+	}
 	ss.Unlock()
 	return err
 }
@@ -4228,6 +5384,10 @@ func (ss *FileSubStore) DeleteSub(subid uint64) error {
 // shouldCompact returns a boolean indicating if we should compact
 // Lock is held by caller
 func (ss *FileSubStore) shouldCompact() bool {
+	// Checkpoints replace compaction
+	if ss.opts.SubCheckpointRecords > 0 {
+		return false
+	}
 	// Gobal switch
 	if !ss.opts.CompactEnabled {
 		return false
@@ -4254,6 +5414,113 @@ func (ss *FileSubStore) shouldCompact() bool {
 	return true
 }
 
+// checkpointIfNeeded starts a checkpoint of the subscriptions if enough
+// records have been written since the last one. The subscriptions file is
+// renamed as a journal, and the subscriptions, copied from memory, are
+// written in the background. The caller therefore does not pay for the
+// size of the state on disk, only for the copy of the pending bitmaps.
+// Lock is held by caller
+func (ss *FileSubStore) checkpointIfNeeded() {
+	if ss.opts.SubCheckpointRecords <= 0 || ss.journalRecs < ss.opts.SubCheckpointRecords || ss.ckpInFlight || ss.closed {
+		return
+	}
+	// This flushes the buffered records.
+	if err := ss.fm.closeFileIfOpened(ss.file); err != nil {
+		ss.log.Errorf("Unable to checkpoint subscriptions of %q: %v", ss.file.name, err)
+		return
+	}
+	dir := filepath.Dir(ss.file.name)
+	gen := ss.ckpGen + 1
+	if err := os.Rename(ss.file.name, filepath.Join(dir, subsGenFileName(gen, subsJournalSuffix))); err != nil {
+		ss.log.Errorf("Unable to checkpoint subscriptions of %q: %v", ss.file.name, err)
+		return
+	}
+	// The subscriptions file is created again on the next write.
+	ss.ckpGen = gen
+	ss.numRecs, ss.delRecs, ss.journalRecs, ss.fileSize = 0, 0, 0, 0
+
+	subs := make([]*subscription, 0, len(ss.subs))
+	for _, subi := range ss.subs {
+		sub := subi.(*subscription)
+		state := *sub.sub
+		subs = append(subs, &subscription{sub: &state, pending: sub.pending.clone()})
+	}
+	// If the subscription with the highest ID has been deleted, keep
+	// a trace of it so that its ID is not reused after a restart.
+	var deletedMaxSubID uint64
+	if _, exists := ss.subs[ss.maxSubID]; !exists {
+		deletedMaxSubID = ss.maxSubID
+	}
+	ss.ckpInFlight = true
+	ss.allDone.Add(1)
+	go ss.writeCheckpoint(dir, gen, subs, deletedMaxSubID)
+}
+
+// writeCheckpoint writes the checkpoint of generation `gen` with the given
+// copy of the subscriptions. On success, the previous checkpoint and the
+// journals included in this one are removed. On failure, they are kept so
+// that the next checkpoint, or the recovery, includes them.
+func (ss *FileSubStore) writeCheckpoint(dir string, gen uint64, subs []*subscription, deletedMaxSubID uint64) {
+	defer ss.allDone.Done()
+
+	fileName := filepath.Join(dir, subsGenFileName(gen, subsCheckpointSuffix))
+	err := ss.writeCheckpointFile(fileName, subs, deletedMaxSubID)
+	if err == nil {
+		err = removeSubsFiles(dir, gen, gen-1)
+	}
+	if err != nil {
+		ss.log.Errorf("Unable to write subscriptions checkpoint %q: %v", fileName, err)
+	}
+	ss.Lock()
+	ss.ckpInFlight = false
+	ss.Unlock()
+}
+
+// writeCheckpointFile writes the checkpoint records of the given
+// subscriptions in a temporary file, which is then renamed `fileName`.
+// This is invoked without the store's lock.
+func (ss *FileSubStore) writeCheckpointFile(fileName string, subs []*subscription, deletedMaxSubID uint64) error {
+	tmpFile, err := getTempFile(ss.fm.rootDir, "subs")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tmpFile != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+		}
+	}()
+	bw := bufio.NewWriterSize(tmpFile, defaultBufSize)
+	var buf []byte
+	for _, sub := range subs {
+		for _, ckp := range newSubCheckpoints(sub) {
+			if buf, _, err = writeRecord(bw, buf, subRecCheckpoint, ckp, ckp.Size(), ss.crcTable); err != nil {
+				return err
+			}
+		}
+	}
+	if deletedMaxSubID > 0 {
+		del := &spb.SubStateDelete{ID: deletedMaxSubID}
+		if _, _, err = writeRecord(bw, buf, subRecDel, del, del.Size(), ss.crcTable); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), fileName); err != nil {
+		return err
+	}
+	tmpFile = nil
+	return nil
+}
+
 // AddSeqPending adds the given message seqno to the given subscription.
 func (ss *FileSubStore) AddSeqPending(subid, seqno uint64) error {
 	ss.Lock()
@@ -4268,8 +5535,9 @@ func (ss *FileSubStore) AddSeqPending(subid, seqno uint64) error {
 		if seqno > s.sub.LastSent {
 			s.sub.LastSent = seqno
 		}
-		s.seqnos[seqno] = struct{}{}
+		s.pending.add(seqno)
 	}
+	ss.checkpointIfNeeded()
 	ss.Unlock()
 	return nil
 }
@@ -4286,13 +5554,14 @@ func (ss *FileSubStore) AckSeqPending(subid, seqno uint64) error {
 	si := ss.subs[subid]
 	if si != nil {
 		s := si.(*subscription)
-		delete(s.seqnos, seqno)
+		s.pending.remove(seqno)
 		// Test if we should compact
 		if ss.shouldCompact() {
 			ss.fm.closeFileIfOpened(ss.file)
 			ss.compact(ss.file.name)
 		}
 	}
+	ss.checkpointIfNeeded()
 	ss.Unlock()
 	return nil
 }
@@ -4300,7 +5569,8 @@ func (ss *FileSubStore) AckSeqPending(subid, seqno uint64) error {
 // compact rewrites all subscriptions on a temporary file, reducing the size
 // since we get rid of deleted subscriptions and message sequences that have
 // been acknowledged. On success, the subscriptions file is replaced by this
-// temporary file.
+// temporary file, or the temporary file becomes the next checkpoint if there
+// are checkpoints.
 // Lock is held by caller
 func (ss *FileSubStore) compact(orgFileName string) error {
 	tmpFile, err := getTempFile(ss.fm.rootDir, "subs")
@@ -4334,12 +5604,12 @@ func (ss *FileSubStore) compact(orgFileName string) error {
 			return err
 		}
 		ss.updateSub.ID = sub.sub.ID
-		for seqno := range sub.seqnos {
+		err = sub.pending.forEach(func(seqno uint64) error {
 			ss.updateSub.Seqno = seqno
-			err = ss.writeRecord(tmpBW, subRecMsg, &ss.updateSub)
-			if err != nil {
-				return err
-			}
+			return ss.writeRecord(tmpBW, subRecMsg, &ss.updateSub)
+		})
+		if err != nil {
+			return err
 		}
 	}
 	// Flush and sync the temporary file
@@ -4355,14 +5625,34 @@ func (ss *FileSubStore) compact(orgFileName string) error {
 	if err := tmpFile.Close(); err != nil {
 		return err
 	}
+	// If there are checkpoints (the option may have been removed), the
+	// subscriptions file can't simply be replaced, since the checkpoints
+	// would still be recovered first. Instead, it is rotated as a journal
+	// and the tmp file becomes the next checkpoint.
+	fileName := orgFileName
+	newCkp := ss.ckpGen > 0
+	if newCkp {
+		dir := filepath.Dir(orgFileName)
+		gen := ss.ckpGen + 1
+		if err := os.Rename(orgFileName, filepath.Join(dir, subsGenFileName(gen, subsJournalSuffix))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		ss.ckpGen = gen
+		fileName = filepath.Join(dir, subsGenFileName(gen, subsCheckpointSuffix))
+	}
 	// Rename the tmp file to original file name
-	if err := os.Rename(tmpFile.Name(), orgFileName); err != nil {
+	if err := os.Rename(tmpFile.Name(), fileName); err != nil {
 		return err
 	}
 	// Prevent cleanup on success
 	tmpFile = nil
 	// Update the timestamp of this last successful compact
 	ss.compactTS = time.Now()
+	// The files included in the new checkpoint are no longer needed. If
+	// they can't be removed now, they will be on recovery.
+	if newCkp {
+		removeSubsFiles(filepath.Dir(orgFileName), ss.ckpGen, ss.ckpGen-1)
+	}
 	return nil
 }
 
@@ -4375,6 +5665,8 @@ func (ss *FileSubStore) writeRecord(w io.Writer, recType recordType, rec record)
 
 	var bwBuf *bufio.Writer
 	needsUnlock := false
+	// Records written in the store's file (not during compaction)
+	journal := w == nil
 
 	if w == nil {
 		if err := ss.lockFile(); err != nil {
@@ -4429,6 +5721,9 @@ func (ss *FileSubStore) writeRecord(w io.Writer, recType recordType, rec record)
 	default:
 		panic(fmt.Errorf("record type %v unknown", recType))
 	}
+	if journal {
+		ss.journalRecs++
+	}
 	ss.fileSize += int64(totalSize)
 	if needsUnlock {
 		ss.fm.unlockFile(ss.file)
@@ -902,6 +902,139 @@ func TestFSArchiveScript(t *testing.T) {
 	}
 }
 
+func checkArchivedMsgs(t *testing.T, ms MsgStore, from, to uint64) {
+	t.Helper()
+	for seq := from; seq <= to; seq++ {
+		m, err := ms.Lookup(seq)
+		if err != nil {
+			t.Fatalf("Error looking up message %v: %v", seq, err)
+		}
+		if m == nil || m.Sequence != seq || string(m.Data) != fmt.Sprintf("msg%d", seq) {
+			t.Fatalf("Unexpected message for seq %v: %v", seq, m)
+		}
+	}
+}
+
+func TestFSSliceArchiveDir(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	archiveDir, err := os.MkdirTemp(".", "archive_")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(archiveDir)
+
+	if _, err := NewFileStore(testLogger, testFSDefaultDatastore, nil,
+		SliceConfig(0, 0, 0, "script.sh"), SliceArchiveDir(archiveDir)); err == nil {
+		t.Fatal("Expected error using both archive script and directory")
+	}
+
+	fs := createDefaultFileStore(t, SliceConfig(2, 0, 0, ""), SliceArchiveDir(archiveDir))
+	defer fs.Close()
+	limits := DefaultStoreLimits
+	limits.MaxMsgs = 3
+	fs.SetLimits(&limits)
+
+	cs := storeCreateChannel(t, fs, "foo")
+	for seq := uint64(1); seq <= 10; seq++ {
+		storeMsg(t, cs, "foo", seq, []byte(fmt.Sprintf("msg%d", seq)))
+	}
+	ms := cs.Msgs.(*FileMsgStore)
+	if first := msgStoreFirstMsg(t, ms); first.Sequence != 8 {
+		t.Fatalf("Expected first message to be 8, got %v", first.Sequence)
+	}
+	// Messages in removed slices are still available.
+	checkArchivedMsgs(t, ms, 1, 10)
+
+	ms.archWG.Wait()
+	archived, _ := filepath.Glob(filepath.Join(archiveDir, "foo", msgFilesPrefix+"*"))
+	if len(archived) != 6 {
+		t.Fatalf("Expected 3 archived slices, got %v", archived)
+	}
+	bak, _ := filepath.Glob(filepath.Join(testFSDefaultDatastore, "foo", "*"+bakSuffix))
+	if len(bak) != 0 {
+		t.Fatalf("Backup files should have been removed, got %v", bak)
+	}
+	checkArchivedMsgs(t, ms, 1, 10)
+	// Sequences never stored are not found.
+	if m := msgStoreLookup(t, ms, 11); m != nil {
+		t.Fatalf("Unexpected message: %v", m)
+	}
+
+	fs.Close()
+	fs, state := openDefaultFileStore(t, SliceArchiveDir(archiveDir))
+	defer fs.Close()
+	cs = getRecoveredChannel(t, state, "foo")
+	checkArchivedMsgs(t, cs.Msgs, 1, 10)
+
+	// Purging the channel removes the archived slices.
+	if err := cs.Msgs.Empty(); err != nil {
+		t.Fatalf("Error on empty: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(archiveDir, "foo")); !os.IsNotExist(err) {
+		t.Fatalf("Archived slices should have been removed, got %v", err)
+	}
+	if m := msgStoreLookup(t, cs.Msgs, 1); m != nil {
+		t.Fatalf("Unexpected message: %v", m)
+	}
+
+	// Same when deleting the channel.
+	for seq := uint64(1); seq <= 10; seq++ {
+		storeMsg(t, cs, "foo", seq, []byte(fmt.Sprintf("msg%d", seq)))
+	}
+	cs.Msgs.(*FileMsgStore).archWG.Wait()
+	if err := fs.DeleteChannel("foo"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(archiveDir, "foo")); !os.IsNotExist(err) {
+		t.Fatalf("Archived slices should have been removed, got %v", err)
+	}
+}
+
+type blockingArchiver struct {
+	*LocalArchiver
+	ch chan struct{}
+}
+
+func (ba *blockingArchiver) Archive(channel string, first, last uint64, datFile, idxFile string) error {
+	<-ba.ch
+	return ba.LocalArchiver.Archive(channel, first, last, datFile, idxFile)
+}
+
+func TestFSSliceArchiverLookupWhileArchiving(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	archiveDir, err := os.MkdirTemp(".", "archive_")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(archiveDir)
+	la, err := NewLocalArchiver(archiveDir)
+	if err != nil {
+		t.Fatalf("Error creating archiver: %v", err)
+	}
+	ba := &blockingArchiver{LocalArchiver: la, ch: make(chan struct{})}
+
+	fs := createDefaultFileStore(t, SliceConfig(2, 0, 0, ""), SliceArchiver(ba))
+	defer fs.Close()
+	limits := DefaultStoreLimits
+	limits.MaxMsgs = 2
+	fs.SetLimits(&limits)
+
+	cs := storeCreateChannel(t, fs, "foo")
+	for seq := uint64(1); seq <= 4; seq++ {
+		storeMsg(t, cs, "foo", seq, []byte(fmt.Sprintf("msg%d", seq)))
+	}
+	// The first slice is being archived, its messages are read from
+	// the backup files.
+	checkArchivedMsgs(t, cs.Msgs, 1, 4)
+	close(ba.ch)
+	cs.Msgs.(*FileMsgStore).archWG.Wait()
+	checkArchivedMsgs(t, cs.Msgs, 1, 4)
+}
+
 func TestFSNoSliceLimitAndNoChannelLimits(t *testing.T) {
 	cleanupFSDatastore(t)
 	defer cleanupFSDatastore(t)
@@ -1903,6 +2036,154 @@ func TestFSGetSeqFromTimestamp(t *testing.T) {
 	}
 }
 
+// removeOldestMsg removes the first message of the store.
+func removeOldestMsg(ms *FileMsgStore) error {
+	ms.Lock()
+	defer ms.Unlock()
+	return ms.removeFirstMsg(nil, true)
+}
+
+func TestFSGetSeqFromTimeIndex(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	s := createDefaultFileStore(t, SliceConfig(300, 0, 0, ""))
+	defer s.Close()
+
+	c := storeCreateChannel(t, s, "foo")
+
+	// Use timestamps 10ns apart so that expected sequences are easy to compute.
+	total := 1000
+	base := time.Now().UnixNano()
+	for i := 0; i < total; i++ {
+		if _, err := c.Msgs.Store(&pb.MsgProto{
+			Sequence:  uint64(i + 1),
+			Subject:   "foo",
+			Data:      []byte("msg"),
+			Timestamp: base + int64(i*10),
+		}); err != nil {
+			t.Fatalf("Error storing message: %v", err)
+		}
+	}
+
+	check := func(c *Channel, first uint64) {
+		t.Helper()
+		ms := getFileMsgStore(c.Msgs)
+		ms.Lock()
+		ms.firstMsg, ms.lastMsg = nil, nil
+		ms.Unlock()
+		for i := 0; i < total; i++ {
+			expected := uint64(i + 1)
+			if expected < first {
+				expected = first
+			}
+			for _, ts := range []int64{base + int64(i*10), base + int64(i*10) - 5} {
+				if seq := msgStoreGetSequenceFromTimestamp(t, c.Msgs, ts); seq != expected {
+					t.Fatalf("Expected seq %v for timestamp %v, got %v", expected, ts, seq)
+				}
+			}
+		}
+		if seq := msgStoreGetSequenceFromTimestamp(t, c.Msgs, base-1); seq != first {
+			t.Fatalf("Expected seq %v, got %v", first, seq)
+		}
+		if seq := msgStoreGetSequenceFromTimestamp(t, c.Msgs, base+int64(total*10)); seq != uint64(total+1) {
+			t.Fatalf("Expected seq %v, got %v", total+1, seq)
+		}
+	}
+	check(c, 1)
+
+	getTimeIndexes := func(c *Channel) [][]tixEntry {
+		t.Helper()
+		ms := getFileMsgStore(c.Msgs)
+		ms.RLock()
+		defer ms.RUnlock()
+		var tixes [][]tixEntry
+		for i := ms.firstFSlSeq; i <= ms.lastFSlSeq; i++ {
+			tixes = append(tixes, ms.files[i].tix)
+		}
+		return tixes
+	}
+	tixes := getTimeIndexes(c)
+	if len(tixes) != 4 {
+		t.Fatalf("Expected 4 slices, got %v", len(tixes))
+	}
+	if len(tixes[0]) != 3 || len(tixes[3]) != 1 {
+		t.Fatalf("Expected 3 and 1 timestamp index entries, got %v and %v", len(tixes[0]), len(tixes[3]))
+	}
+	s.Close()
+
+	// The timestamp indexes are rebuilt on recovery.
+	s, rs := openDefaultFileStore(t, SliceConfig(300, 0, 0, ""))
+	defer s.Close()
+	c = getRecoveredChannel(t, rs, "foo")
+	if recovered := getTimeIndexes(c); !reflect.DeepEqual(recovered, tixes) {
+		t.Fatalf("Expected timestamp indexes %v, got %v", tixes, recovered)
+	}
+	check(c, 1)
+
+	// Remove messages so that the first slice is removed and the new
+	// first slice has some messages removed.
+	ms := getFileMsgStore(c.Msgs)
+	for i := 0; i < 350; i++ {
+		if err := removeOldestMsg(ms); err != nil {
+			t.Fatalf("Error removing message: %v", err)
+		}
+	}
+	check(c, 351)
+}
+
+func TestFSGetSeqFromTimeIndexWithRemovedMsgs(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	s := createDefaultFileStore(t, SliceConfig(1000, 0, 0, ""))
+	defer s.Close()
+
+	c := storeCreateChannel(t, s, "foo")
+	base := time.Now().UnixNano()
+	for i := 0; i < 300; i++ {
+		if _, err := c.Msgs.Store(&pb.MsgProto{
+			Sequence:  uint64(i + 1),
+			Subject:   "foo",
+			Data:      []byte("msg"),
+			Timestamp: base + int64(i*10),
+		}); err != nil {
+			t.Fatalf("Error storing message: %v", err)
+		}
+	}
+	// Remove messages from the only slice, past its second timestamp
+	// index entry.
+	ms := getFileMsgStore(c.Msgs)
+	for i := 0; i < 200; i++ {
+		if err := removeOldestMsg(ms); err != nil {
+			t.Fatalf("Error removing message: %v", err)
+		}
+	}
+	ms.Lock()
+	ms.firstMsg, ms.lastMsg = nil, nil
+	rmCount := ms.files[ms.firstFSlSeq].rmCount
+	ms.Unlock()
+	if rmCount != 200 {
+		t.Fatalf("Expected 200 messages removed from the slice, got %v", rmCount)
+	}
+	for _, test := range []struct {
+		msg      int
+		expected uint64
+	}{
+		{1, 201},
+		{100, 201},
+		{150, 201},
+		{201, 201},
+		{250, 250},
+		{300, 300},
+	} {
+		ts := base + int64((test.msg-1)*10)
+		if seq := msgStoreGetSequenceFromTimestamp(t, c.Msgs, ts); seq != test.expected {
+			t.Fatalf("Expected seq %v for timestamp of msg %v, got %v", test.expected, test.msg, seq)
+		}
+	}
+}
+
 func TestFSNoPanicOnRemoveMsg(t *testing.T) {
 	cleanupFSDatastore(t)
 	defer cleanupFSDatastore(t)
@@ -2401,3 +2682,157 @@ func TestFSMsgsFileVersionError(t *testing.T) {
 		})
 	}
 }
+
+func TestFSLazyRecovery(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	stateFile := func(channel string) string {
+		return filepath.Join(testFSDefaultDatastore, channel, msgsStateFileName)
+	}
+	waitForRecovery := func(t *testing.T, s *FileStore, recovered, failed int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			rp := s.RecoveryProgress()
+			if rp != nil && rp.Recovered == recovered && rp.Failed == failed {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %v recovered and %v failed, got %+v", recovered, failed, rp)
+			}
+			time.Sleep(15 * time.Millisecond)
+		}
+	}
+	checkState := func(t *testing.T, ms MsgStore, first, last uint64, count int) {
+		t.Helper()
+		if f, l := msgStoreFirstAndLastSequence(t, ms); f != first || l != last {
+			t.Fatalf("Expected first/last to be %v/%v, got %v/%v", first, last, f, l)
+		}
+		if n, _ := msgStoreState(t, ms); n != count {
+			t.Fatalf("Expected %v messages, got %v", count, n)
+		}
+	}
+
+	limits := testDefaultStoreLimits
+	limits.MaxMsgs = 20
+	s, _ := openDefaultFileStoreWithLimits(t, &limits, SliceConfig(5, 0, 0, ""), LazyRecovery(true))
+	defer s.Close()
+	if s.RecoveryProgress() != nil {
+		t.Fatal("Recovery progress should not be reported for a new store")
+	}
+	info := testDefaultServerInfo
+	if err := s.Init(&info); err != nil {
+		t.Fatalf("Error on init: %v", err)
+	}
+	foo := storeCreateChannel(t, s, "foo")
+	for seq := uint64(1); seq <= 30; seq++ {
+		storeMsg(t, foo, "foo", seq, []byte("msg"))
+	}
+	_, fooBytes := msgStoreState(t, foo.Msgs)
+	bar := storeCreateChannel(t, s, "bar")
+	storeMsg(t, bar, "bar", 1, []byte("msg"))
+	storeCreateChannel(t, s, "baz")
+	s.Close()
+	for _, c := range []string{"foo", "bar", "baz"} {
+		if _, err := os.Stat(stateFile(c)); err != nil {
+			t.Fatalf("State of %q should have been saved: %v", c, err)
+		}
+	}
+
+	// Access the messages of "foo" while the other channels may still be
+	// recovered in the background.
+	s, rs := openDefaultFileStoreWithLimits(t, &limits, SliceConfig(5, 0, 0, ""), LazyRecovery(true))
+	defer s.Close()
+	if rp := s.RecoveryProgress(); rp == nil || rp.Channels != 3 {
+		t.Fatalf("Expected 3 channels to be recovered lazily, got %+v", rp)
+	}
+	foo = getRecoveredChannel(t, rs, "foo")
+	checkState(t, foo.Msgs, 11, 30, 20)
+	if _, b := msgStoreState(t, foo.Msgs); b != fooBytes {
+		t.Fatalf("Expected %v bytes, got %v", fooBytes, b)
+	}
+	if m := msgStoreLookup(t, foo.Msgs, 11); m == nil || m.Sequence != 11 {
+		t.Fatalf("Unexpected message: %v", m)
+	}
+	storeMsg(t, foo, "foo", 31, []byte("msg"))
+	checkState(t, foo.Msgs, 12, 31, 20)
+	waitForRecovery(t, s, 3, 0)
+	for _, c := range []string{"foo", "bar", "baz"} {
+		if _, err := os.Stat(stateFile(c)); !os.IsNotExist(err) {
+			t.Fatalf("State of %q should have been removed once recovered: %v", c, err)
+		}
+	}
+	checkState(t, getRecoveredChannel(t, rs, "bar").Msgs, 1, 1, 1)
+	checkState(t, getRecoveredChannel(t, rs, "baz").Msgs, 0, 0, 0)
+	s.Close()
+
+	// Keep the saved state and modify the store without lazy recovery:
+	// the stale state must not be used.
+	stale, err := os.ReadFile(stateFile("foo"))
+	if err != nil {
+		t.Fatalf("Error reading state: %v", err)
+	}
+	s, rs = openDefaultFileStoreWithLimits(t, &limits, SliceConfig(5, 0, 0, ""))
+	defer s.Close()
+	if s.RecoveryProgress() != nil {
+		t.Fatal("Recovery progress should not be reported")
+	}
+	if _, err := os.Stat(stateFile("foo")); !os.IsNotExist(err) {
+		t.Fatalf("State should have been removed on recovery: %v", err)
+	}
+	foo = getRecoveredChannel(t, rs, "foo")
+	storeMsg(t, foo, "foo", 32, []byte("msg"))
+	s.Close()
+	if err := os.WriteFile(stateFile("foo"), stale, 0666); err != nil {
+		t.Fatalf("Error writing state: %v", err)
+	}
+	// Corrupt the state of "bar": it has to be fully recovered too.
+	if err := os.WriteFile(stateFile("bar"), []byte("bad"), 0666); err != nil {
+		t.Fatalf("Error writing state: %v", err)
+	}
+	s, rs = openDefaultFileStoreWithLimits(t, &limits, SliceConfig(5, 0, 0, ""), LazyRecovery(true))
+	defer s.Close()
+	if rp := s.RecoveryProgress(); rp != nil {
+		t.Fatalf("No channel should have been recovered lazily, got %+v", rp)
+	}
+	checkState(t, getRecoveredChannel(t, rs, "foo").Msgs, 13, 32, 20)
+	checkState(t, getRecoveredChannel(t, rs, "bar").Msgs, 1, 1, 1)
+	s.Close()
+
+	// With lower limits, messages have to be removed, so the channel is
+	// recovered right away.
+	lowLimits := limits
+	lowLimits.MaxMsgs = 10
+	s, rs = openDefaultFileStoreWithLimits(t, &lowLimits, SliceConfig(5, 0, 0, ""), LazyRecovery(true))
+	defer s.Close()
+	if rp := s.RecoveryProgress(); rp == nil || rp.Channels != 2 {
+		t.Fatalf("Expected 2 channels to be recovered lazily, got %+v", rp)
+	}
+	checkState(t, getRecoveredChannel(t, rs, "foo").Msgs, 23, 32, 10)
+	waitForRecovery(t, s, 2, 0)
+	s.Close()
+
+	// A slice that cannot be recovered fails the lazy recovery of the
+	// channel, which is reported, but not the recovery of the store.
+	if _, err := os.Stat(stateFile("foo")); err != nil {
+		t.Fatalf("State should have been saved: %v", err)
+	}
+	datName := filepath.Join(testFSDefaultDatastore, "foo", fmt.Sprintf("%s5%s", msgFilesPrefix, datSuffix))
+	if err := os.Truncate(datName, 10); err != nil {
+		t.Fatalf("Error truncating file: %v", err)
+	}
+	os.Remove(strings.TrimSuffix(datName, datSuffix) + idxSuffix)
+	s, rs = openDefaultFileStoreWithLimits(t, &limits, SliceConfig(5, 0, 0, ""), LazyRecovery(true))
+	defer s.Close()
+	waitForRecovery(t, s, 2, 1)
+	foo = getRecoveredChannel(t, rs, "foo")
+	if _, err := foo.Msgs.Lookup(25); err == nil || !strings.Contains(err.Error(), "unable to recover message store for [foo]") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := foo.Msgs.Store(&pb.MsgProto{Sequence: 33, Subject: "foo", Data: []byte("msg")}); err == nil {
+		t.Fatal("Expected error storing message")
+	}
+	// The saved state is still reported.
+	checkState(t, foo.Msgs, 23, 32, 10)
+}
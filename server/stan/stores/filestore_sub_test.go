@@ -923,3 +923,254 @@ func TestFSSubscriptionsFileVersionError(t *testing.T) {
 		t.Fatalf("Unexpected error: %v", err)
 	}
 }
+
+func TestFSSubCheckpoints(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	s := createDefaultFileStore(t, SubCheckpointRecords(10))
+	defer s.Close()
+
+	cs := storeCreateChannel(t, s, "foo")
+	ss := cs.Subs.(*FileSubStore)
+	dir := filepath.Join(testFSDefaultDatastore, "foo")
+	// Returns the generations of the checkpoints and journals of the
+	// channel once the checkpoint in progress, if any, is complete.
+	subsFiles := func(t *testing.T) (ckps, jrns []uint64) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			ss.RLock()
+			inFlight := ss.ckpInFlight
+			ss.RUnlock()
+			if !inFlight {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Checkpoint still in progress")
+			}
+			time.Sleep(15 * time.Millisecond)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("Error reading directory: %v", err)
+		}
+		for _, entry := range entries {
+			if gen, suffix, ok := parseSubsGenFileName(entry.Name()); ok {
+				if suffix == subsCheckpointSuffix {
+					ckps = append(ckps, gen)
+				} else {
+					jrns = append(jrns, gen)
+				}
+			}
+		}
+		return ckps, jrns
+	}
+
+	subID := storeSub(t, cs, "foo")
+	otherID := storeSub(t, cs, "foo")
+	for seq := uint64(1); seq <= 100; seq++ {
+		storeSubPending(t, cs, "foo", subID, seq)
+	}
+	storeSubPending(t, cs, "foo", otherID, 1000000)
+	// Ack the odd sequences
+	for seq := uint64(1); seq <= 100; seq += 2 {
+		storeSubAck(t, cs, "foo", subID, seq)
+	}
+	// No checkpoint is started while one is in progress, so records may
+	// have been added to the journal since then. The next write starts a
+	// new checkpoint.
+	subsFiles(t)
+	storeSubPending(t, cs, "foo", otherID, 1000000)
+	ckps, jrns := subsFiles(t)
+	// There should be a single checkpoint, which includes all journals.
+	if len(ckps) != 1 || len(jrns) != 0 {
+		t.Fatalf("Expected a single checkpoint and no journal, got %v and %v", ckps, jrns)
+	}
+	ss.RLock()
+	journalRecs, fileSize := ss.journalRecs, ss.fileSize
+	ss.RUnlock()
+	if journalRecs != 0 || fileSize != 0 {
+		t.Fatalf("Expected empty journal, got %v records and size %v", journalRecs, fileSize)
+	}
+	s.Close()
+
+	// Checkpoint records are understood by the verifier.
+	report, err := VerifyFileStore(testFSDefaultDatastore, nil)
+	if err != nil {
+		t.Fatalf("Error on verify: %v", err)
+	}
+	if report.Subs != 2 {
+		t.Fatalf("Expected 2 subscriptions, got %v", report.Subs)
+	}
+	for _, p := range report.Problems {
+		if p.Kind == VerifyCorrupted {
+			t.Fatalf("Unexpected problem: %+v", p)
+		}
+	}
+
+	checkRecovered := func(t *testing.T, rs *RecoveredState) {
+		t.Helper()
+		rsubs := getRecoveredSubs(t, rs, "foo", 2)
+		for _, rsub := range rsubs {
+			switch rsub.Sub.ID {
+			case subID:
+				if rsub.Sub.LastSent != 100 {
+					t.Fatalf("Expected LastSent to be 100, got %v", rsub.Sub.LastSent)
+				}
+				if len(rsub.Pending) != 50 {
+					t.Fatalf("Expected 50 pending messages, got %v", len(rsub.Pending))
+				}
+				for seq := uint64(2); seq <= 100; seq += 2 {
+					if _, ok := rsub.Pending[seq]; !ok {
+						t.Fatalf("Seq %v should be pending", seq)
+					}
+				}
+			case otherID:
+				if _, ok := rsub.Pending[1000000]; !ok || len(rsub.Pending) != 1 {
+					t.Fatalf("Unexpected pending messages: %v", rsub.Pending)
+				}
+			default:
+				t.Fatalf("Unexpected subscription: %v", rsub.Sub)
+			}
+		}
+	}
+
+	s, rs := openDefaultFileStore(t, SubCheckpointRecords(10))
+	checkRecovered(t, rs)
+	cs = getRecoveredChannel(t, rs, "foo")
+	// Add records that are not checkpointed.
+	storeSubPending(t, cs, "foo", otherID, 1000000)
+	storeSubPending(t, cs, "foo", subID, 100)
+	s.Close()
+
+	// Simulate a stop while a checkpoint is written: the journal included
+	// in the last checkpoint is removed (it would delete a subscription if
+	// replayed), the one rotated after it is replayed.
+	ckpGen := ckps[0]
+	subsName := filepath.Join(dir, subsFileName)
+	stale, err := os.Create(filepath.Join(dir, subsGenFileName(ckpGen, subsJournalSuffix)))
+	if err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	del := &spb.SubStateDelete{ID: subID}
+	if err := util.WriteInt(stale, fileVersion); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	if _, _, err := writeRecord(stale, nil, subRecDel, del, del.Size(), crc32.IEEETable); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	stale.Close()
+	if err := os.Rename(subsName, filepath.Join(dir, subsGenFileName(ckpGen+1, subsJournalSuffix))); err != nil {
+		t.Fatalf("Error renaming file: %v", err)
+	}
+	s, rs = openDefaultFileStore(t, SubCheckpointRecords(10))
+	checkRecovered(t, rs)
+	ss = getRecoveredChannel(t, rs, "foo").Subs.(*FileSubStore)
+	ckps, jrns = subsFiles(t)
+	if len(ckps) != 1 || len(jrns) != 1 || jrns[0] != ckpGen+1 {
+		t.Fatalf("Expected checkpoint %v and journal %v, got %v and %v", ckpGen, ckpGen+1, ckps, jrns)
+	}
+	ss.RLock()
+	journalRecs = ss.journalRecs
+	nextGen := ss.ckpGen + 1
+	ss.RUnlock()
+	if journalRecs != 2 {
+		t.Fatalf("Expected 2 records since the checkpoint, got %v", journalRecs)
+	}
+	if nextGen != ckpGen+2 {
+		t.Fatalf("Expected next generation to be %v, got %v", ckpGen+2, nextGen)
+	}
+	s.Close()
+
+	// Checkpoints can be recovered without the option. Compaction then
+	// writes a new checkpoint, which includes the journals.
+	s, rs = openDefaultFileStore(t)
+	checkRecovered(t, rs)
+	ss = getRecoveredChannel(t, rs, "foo").Subs.(*FileSubStore)
+	ss.Lock()
+	ss.fm.closeFileIfOpened(ss.file)
+	err = ss.compact(ss.file.name)
+	ss.Unlock()
+	if err != nil {
+		t.Fatalf("Error on compact: %v", err)
+	}
+	ckps, jrns = subsFiles(t)
+	if len(ckps) != 1 || ckps[0] != ckpGen+2 || len(jrns) != 0 {
+		t.Fatalf("Expected checkpoint %v and no journal, got %v and %v", ckpGen+2, ckps, jrns)
+	}
+	s.Close()
+	s, rs = openDefaultFileStore(t, SubCheckpointRecords(10))
+	defer s.Close()
+	checkRecovered(t, rs)
+
+	// The ID of a deleted subscription is not reused after a checkpoint.
+	cs = getRecoveredChannel(t, rs, "foo")
+	ss = cs.Subs.(*FileSubStore)
+	lastID := storeSub(t, cs, "foo")
+	storeSubDelete(t, cs, "foo", lastID)
+	for i := 0; i < 10; i++ {
+		storeSubPending(t, cs, "foo", otherID, 1000000)
+	}
+	ckps, jrns = subsFiles(t)
+	if len(ckps) != 1 || ckps[0] != ckpGen+3 || len(jrns) != 0 {
+		t.Fatalf("Expected checkpoint %v and no journal, got %v and %v", ckpGen+3, ckps, jrns)
+	}
+	s.Close()
+	// Remove the journal to make sure that only the checkpoint is used.
+	if err := os.Remove(subsName); err != nil && !os.IsNotExist(err) {
+		t.Fatalf("Error removing file: %v", err)
+	}
+	s, rs = openDefaultFileStore(t, SubCheckpointRecords(10))
+	defer s.Close()
+	checkRecovered(t, rs)
+	if id := storeSub(t, getRecoveredChannel(t, rs, "foo"), "foo"); id <= lastID {
+		t.Fatalf("Expected subscription ID to be greater than %v, got %v", lastID, id)
+	}
+}
+
+func TestFSSubCheckpointRecord(t *testing.T) {
+	sub := &subscription{
+		sub:     &spb.SubState{ID: 1, ClientID: "me", Inbox: "inbox", AckInbox: "ackInbox", LastSent: 10000000},
+		pending: newSeqBitmap(),
+	}
+	// Enough sparse sequences to need several records.
+	total := subCheckpointMaxWords + 10
+	for i := 0; i < total; i++ {
+		sub.pending.add(uint64(100 + i*100))
+	}
+	sub.pending.add(101)
+	sub.pending.remove(101)
+	sub.pending.remove(12345)
+	if n := sub.pending.len(); n != total {
+		t.Fatalf("Expected %v sequences, got %v", total, n)
+	}
+	ckps := newSubCheckpoints(sub)
+	if len(ckps) != 2 {
+		t.Fatalf("Expected 2 records, got %v", len(ckps))
+	}
+	if ckps[0].lwm != 100 {
+		t.Fatalf("Expected low-water mark to be 100, got %v", ckps[0].lwm)
+	}
+	pending := newSeqBitmap()
+	for _, ckp := range ckps {
+		buf := make([]byte, ckp.Size())
+		n, err := ckp.MarshalTo(buf)
+		if err != nil || n != len(buf) {
+			t.Fatalf("Error marshaling record: n=%v size=%v err=%v", n, len(buf), err)
+		}
+		rckp := subCheckpoint{pending: pending}
+		if err := rckp.Unmarshal(buf); err != nil {
+			t.Fatalf("Error unmarshaling record: %v", err)
+		}
+		if !reflect.DeepEqual(rckp.sub, sub.sub) {
+			t.Fatalf("Expected sub %v, got %v", sub.sub, rckp.sub)
+		}
+		if err := rckp.Unmarshal(buf[:len(buf)-1]); err != errBadSubCheckpoint {
+			t.Fatalf("Expected error %v, got %v", errBadSubCheckpoint, err)
+		}
+	}
+	if !reflect.DeepEqual(pending, sub.pending) {
+		t.Fatal("Recovered pending sequences do not match")
+	}
+}
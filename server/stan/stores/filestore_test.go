@@ -577,6 +577,8 @@ func TestFSOptions(t *testing.T) {
 		ParallelRecovery:     5,
 		ReadBufferSize:       5 * 1024,
 		AutoSync:             2 * time.Minute,
+		SubCheckpointRecords: 1000,
+		LazyRecovery:         true,
 	}
 	// Create the file with custom options
 	fs, err := NewFileStore(testLogger, testFSDefaultDatastore, &testDefaultStoreLimits,
@@ -593,6 +595,8 @@ func TestFSOptions(t *testing.T) {
 		ParallelRecovery(5),
 		ReadBufferSize(5*1024),
 		AutoSync(2*time.Minute),
+		SubCheckpointRecords(1000),
+		LazyRecovery(true),
 	)
 	if err != nil {
 		t.Fatalf("Unexpected error on file store create: %v", err)
@@ -0,0 +1,364 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+	"github.com/kubemq-io/broker/server/stan/logger"
+)
+
+// DefaultHybridMemoryBudget is the default amount of memory, in bytes, that
+// the tails of all channels of a HybridStore can use.
+const DefaultHybridMemoryBudget = int64(256 * 1024 * 1024)
+
+// HybridStore is a FileStore that also keeps the most recent messages of
+// each channel in memory. Messages are written to the channel's files as
+// with the FileStore (and are therefore as durable), the in-memory tail is
+// only used to serve lookups without reading the files. When the memory
+// used by all channels exceeds the budget, the oldest in-memory messages of
+// the channels with the largest tails are evicted.
+type HybridStore struct {
+	// Atomic operations require 64bit aligned fields to be able
+	// to run with 32bit processes.
+	used int64 // memory used by the tails of all channels
+
+	sync.Mutex
+	Store
+	budget    int64
+	msgStores map[string]*HybridMsgStore
+}
+
+// HybridMsgStore is a per channel message store. Messages are stored in a
+// FileMsgStore, the most recent ones are also kept in memory.
+type HybridMsgStore struct {
+	// Atomic operations require 64bit aligned fields to be able
+	// to run with 32bit processes.
+	tailBytes int64
+
+	genericMsgStore
+	hs   *HybridStore
+	file *FileMsgStore
+	tail []*pb.MsgProto
+}
+
+////////////////////////////////////////////////////////////////////////////
+// HybridStore methods
+////////////////////////////////////////////////////////////////////////////
+
+// NewHybridStore returns a factory for stores that keep the messages in
+// files under the given root directory, and the recent ones in memory.
+// If `budget` is 0 or negative, DefaultHybridMemoryBudget is used.
+func NewHybridStore(log logger.Logger, rootDir string, limits *StoreLimits, budget int64, options ...FileStoreOption) (*HybridStore, error) {
+	fs, err := NewFileStore(log, rootDir, limits, options...)
+	if err != nil {
+		return nil, err
+	}
+	if budget <= 0 {
+		budget = DefaultHybridMemoryBudget
+	}
+	hs := &HybridStore{
+		Store:     fs,
+		budget:    budget,
+		msgStores: make(map[string]*HybridMsgStore),
+	}
+	return hs, nil
+}
+
+// Name implements the Store interface
+func (hs *HybridStore) Name() string {
+	return TypeHybrid
+}
+
+// newHybridMsgStore returns the message store of `channel`, using `ms`,
+// which is the FileMsgStore of the channel, to store the messages.
+// Store lock is assumed held on entry.
+func (hs *HybridStore) newHybridMsgStore(channel string, ms MsgStore) (*HybridMsgStore, error) {
+	fms := ms.(*FileMsgStore)
+	last, err := fms.LastSequence()
+	if err != nil {
+		return nil, err
+	}
+	hms := &HybridMsgStore{hs: hs, file: fms}
+	hms.init(channel, fms.log, &fms.limits)
+	hms.last = last
+	hs.msgStores[channel] = hms
+	return hms, nil
+}
+
+// Recover implements the Store interface
+func (hs *HybridStore) Recover() (*RecoveredState, error) {
+	hs.Lock()
+	defer hs.Unlock()
+	rs, err := hs.Store.Recover()
+	if rs == nil || err != nil {
+		return nil, err
+	}
+	for cn, rc := range rs.Channels {
+		hms, err := hs.newHybridMsgStore(cn, rc.Channel.Msgs)
+		if err != nil {
+			return nil, err
+		}
+		rc.Channel.Msgs = hms
+	}
+	return rs, nil
+}
+
+// CreateChannel implements the Store interface
+func (hs *HybridStore) CreateChannel(channel string) (*Channel, error) {
+	hs.Lock()
+	defer hs.Unlock()
+
+	c, err := hs.Store.CreateChannel(channel)
+	if err != nil {
+		return nil, err
+	}
+	hms, err := hs.newHybridMsgStore(channel, c.Msgs)
+	if err != nil {
+		return nil, err
+	}
+	c.Msgs = hms
+	return c, nil
+}
+
+// DeleteChannel implements the Store interface
+func (hs *HybridStore) DeleteChannel(channel string) error {
+	hs.Lock()
+	defer hs.Unlock()
+
+	if hms := hs.msgStores[channel]; hms != nil {
+		hms.dropTail()
+		delete(hs.msgStores, channel)
+	}
+	return hs.Store.DeleteChannel(channel)
+}
+
+// MemoryUsed returns the number of bytes used by the in-memory messages
+// of all channels.
+func (hs *HybridStore) MemoryUsed() int64 {
+	return atomic.LoadInt64(&hs.used)
+}
+
+// enforceBudget evicts the in-memory messages of the channels with the
+// largest tails until the memory used is below the budget.
+func (hs *HybridStore) enforceBudget() {
+	for {
+		used := atomic.LoadInt64(&hs.used)
+		if used <= hs.budget {
+			return
+		}
+		// Go a bit below the budget so that we don't evict on every
+		// message once the budget is reached.
+		target := used - hs.budget + hs.budget/10
+
+		var victim *HybridMsgStore
+		var max int64
+		hs.Lock()
+		for _, hms := range hs.msgStores {
+			if b := atomic.LoadInt64(&hms.tailBytes); b > max {
+				victim, max = hms, b
+			}
+		}
+		hs.Unlock()
+		if victim == nil || victim.evict(target) == 0 {
+			return
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////
+// HybridMsgStore methods
+////////////////////////////////////////////////////////////////////////////
+
+// Store implements the MsgStore interface
+func (ms *HybridMsgStore) Store(m *pb.MsgProto) (uint64, error) {
+	ms.Lock()
+	if m.Sequence <= ms.last {
+		ms.Unlock()
+		// We've already seen this message.
+		return m.Sequence, nil
+	}
+	seq, err := ms.file.Store(m)
+	if err != nil {
+		ms.Unlock()
+		return 0, err
+	}
+	ms.last = m.Sequence
+	ms.tail = append(ms.tail, m)
+	ms.addTailBytes(int64(m.Size()))
+	// Storing may have removed messages due to limits.
+	if err := ms.trimTail(); err != nil {
+		ms.log.Errorf("Unable to get first sequence of channel %q: %v", ms.subject, err)
+	}
+	ms.Unlock()
+
+	// This may evict messages of other channels, so do this without
+	// holding our lock.
+	ms.hs.enforceBudget()
+	return seq, nil
+}
+
+// addTailBytes updates the memory used by this store and the HybridStore.
+func (ms *HybridMsgStore) addTailBytes(n int64) {
+	atomic.AddInt64(&ms.tailBytes, n)
+	atomic.AddInt64(&ms.hs.used, n)
+}
+
+// trimTail evicts the in-memory messages that have been removed from the
+// files, due to limits or expiration.
+// Lock is assumed held on entry.
+func (ms *HybridMsgStore) trimTail() error {
+	if len(ms.tail) == 0 {
+		return nil
+	}
+	first, err := ms.file.FirstSequence()
+	if err != nil {
+		return err
+	}
+	for len(ms.tail) > 0 && ms.tail[0].Sequence < first {
+		ms.popTail()
+	}
+	return nil
+}
+
+// popTail removes the first in-memory message and returns its size.
+// Lock is assumed held on entry.
+func (ms *HybridMsgStore) popTail() int64 {
+	size := int64(ms.tail[0].Size())
+	ms.tail[0] = nil
+	ms.tail = ms.tail[1:]
+	ms.addTailBytes(-size)
+	return size
+}
+
+// dropTail discards the in-memory messages.
+func (ms *HybridMsgStore) dropTail() {
+	ms.Lock()
+	ms.addTailBytes(-atomic.LoadInt64(&ms.tailBytes))
+	ms.tail = nil
+	ms.Unlock()
+}
+
+// evict removes the oldest in-memory messages until at least `target`
+// bytes have been freed, and returns the number of bytes freed. The
+// messages are still in the files.
+func (ms *HybridMsgStore) evict(target int64) int64 {
+	ms.Lock()
+	defer ms.Unlock()
+	var freed int64
+	for len(ms.tail) > 0 && freed < target {
+		freed += ms.popTail()
+	}
+	return freed
+}
+
+// tailMsg returns the in-memory message with sequence `seq`, or nil if
+// it is not in memory.
+// Lock is assumed held on entry.
+func (ms *HybridMsgStore) tailMsg(seq uint64) *pb.MsgProto {
+	if len(ms.tail) == 0 || seq < ms.tail[0].Sequence {
+		return nil
+	}
+	i := sort.Search(len(ms.tail), func(i int) bool {
+		return ms.tail[i].Sequence >= seq
+	})
+	if i < len(ms.tail) && ms.tail[i].Sequence == seq {
+		return ms.tail[i]
+	}
+	return nil
+}
+
+// State implements the MsgStore interface
+func (ms *HybridMsgStore) State() (int, uint64, error) {
+	return ms.file.State()
+}
+
+// Lookup implements the MsgStore interface. Messages that are no longer
+// in memory are read from disk, which does not affect the in-memory ones.
+func (ms *HybridMsgStore) Lookup(seq uint64) (*pb.MsgProto, error) {
+	ms.RLock()
+	m := ms.tailMsg(seq)
+	ms.RUnlock()
+	if m == nil {
+		return ms.file.Lookup(seq)
+	}
+	// The message may have expired since it was stored.
+	first, err := ms.file.FirstSequence()
+	if err != nil || seq < first {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FirstSequence implements the MsgStore interface
+func (ms *HybridMsgStore) FirstSequence() (uint64, error) {
+	return ms.file.FirstSequence()
+}
+
+// LastSequence implements the MsgStore interface
+func (ms *HybridMsgStore) LastSequence() (uint64, error) {
+	return ms.file.LastSequence()
+}
+
+// FirstAndLastSequence implements the MsgStore interface
+func (ms *HybridMsgStore) FirstAndLastSequence() (uint64, uint64, error) {
+	return ms.file.FirstAndLastSequence()
+}
+
+// FirstMsg implements the MsgStore interface
+func (ms *HybridMsgStore) FirstMsg() (*pb.MsgProto, error) {
+	return ms.file.FirstMsg()
+}
+
+// LastMsg implements the MsgStore interface
+func (ms *HybridMsgStore) LastMsg() (*pb.MsgProto, error) {
+	return ms.file.LastMsg()
+}
+
+// GetSequenceFromTimestamp implements the MsgStore interface
+func (ms *HybridMsgStore) GetSequenceFromTimestamp(timestamp int64) (uint64, error) {
+	return ms.file.GetSequenceFromTimestamp(timestamp)
+}
+
+// Flush implements the MsgStore interface
+func (ms *HybridMsgStore) Flush() error {
+	return ms.file.Flush()
+}
+
+// Empty implements the MsgStore interface
+func (ms *HybridMsgStore) Empty() error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.addTailBytes(-atomic.LoadInt64(&ms.tailBytes))
+	ms.tail = nil
+	ms.empty()
+	return ms.file.Empty()
+}
+
+// Close implements the MsgStore interface
+func (ms *HybridMsgStore) Close() error {
+	ms.Lock()
+	if ms.closed {
+		ms.Unlock()
+		return nil
+	}
+	ms.closed = true
+	ms.addTailBytes(-atomic.LoadInt64(&ms.tailBytes))
+	ms.tail = nil
+	ms.Unlock()
+	return ms.file.Close()
+}
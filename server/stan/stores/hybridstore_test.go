@@ -0,0 +1,252 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+)
+
+var testHybridDefaultDatastore string
+
+func init() {
+	tmpDir, err := os.MkdirTemp(".", "hybrid_data_stores_")
+	if err != nil {
+		panic("Could not create tmp dir")
+	}
+	if err := os.Remove(tmpDir); err != nil {
+		panic(fmt.Errorf("Error removing temp directory: %v", err))
+	}
+	testHybridDefaultDatastore = tmpDir
+}
+
+func cleanupHybridDatastore(t tLogger) {
+	if err := os.RemoveAll(testHybridDefaultDatastore); err != nil {
+		stackFatalf(t, "Error cleaning up datastore: %v", err)
+	}
+}
+
+func newHybridStore(t tLogger, limits *StoreLimits, budget int64) (*HybridStore, *RecoveredState) {
+	hs, err := NewHybridStore(testLogger, testHybridDefaultDatastore, limits, budget)
+	if err != nil {
+		stackFatalf(t, "Error creating hybrid store: %v", err)
+	}
+	state, err := hs.Recover()
+	if err != nil {
+		hs.Close()
+		stackFatalf(t, "Error recovering hybrid store: %v", err)
+	}
+	return hs, state
+}
+
+func createDefaultHybridStore(t tLogger) *HybridStore {
+	return createHybridStoreWithBudget(t, 0)
+}
+
+func createHybridStoreWithBudget(t tLogger, budget int64) *HybridStore {
+	limits := testDefaultStoreLimits
+	hs, state := newHybridStore(t, &limits, budget)
+	if state == nil {
+		info := testDefaultServerInfo
+		if err := hs.Init(&info); err != nil {
+			stackFatalf(t, "Unexpected error during Init: %v", err)
+		}
+	}
+	return hs
+}
+
+func openDefaultHybridStoreWithLimits(t tLogger, limits *StoreLimits) (*HybridStore, *RecoveredState) {
+	if limits == nil {
+		l := testDefaultStoreLimits
+		limits = &l
+	}
+	return newHybridStore(t, limits, 0)
+}
+
+func hybridTailLen(ms MsgStore) int {
+	hms := ms.(*HybridMsgStore)
+	hms.RLock()
+	defer hms.RUnlock()
+	return len(hms.tail)
+}
+
+func hybridTailBytes(ms MsgStore) int64 {
+	hms := ms.(*HybridMsgStore)
+	hms.RLock()
+	defer hms.RUnlock()
+	var size int64
+	for _, m := range hms.tail {
+		size += int64(m.Size())
+	}
+	return size
+}
+
+func TestHybridEviction(t *testing.T) {
+	cleanupHybridDatastore(t)
+	defer cleanupHybridDatastore(t)
+
+	payload := make([]byte, 100)
+	// Budget for about 20 messages across all channels.
+	msgSize := int64((&pb.MsgProto{Sequence: 1, Subject: "foo", Data: payload, Timestamp: time.Now().UnixNano()}).Size())
+	budget := 20 * msgSize
+	hs := createHybridStoreWithBudget(t, budget)
+	defer hs.Close()
+
+	foo := storeCreateChannel(t, hs, "foo")
+	bar := storeCreateChannel(t, hs, "bar")
+	var msgs []*pb.MsgProto
+	for i := 0; i < 50; i++ {
+		msgs = append(msgs, storeMsg(t, foo, "foo", uint64(i+1), payload))
+		if used := hs.MemoryUsed(); used > budget {
+			t.Fatalf("Memory used %v is above budget %v", used, budget)
+		}
+	}
+	tailLen := hybridTailLen(foo.Msgs)
+	if tailLen == 0 || tailLen >= 50 {
+		t.Fatalf("Expected part of the messages to be in memory, got %v", tailLen)
+	}
+	if n, _ := msgStoreState(t, foo.Msgs); n != 50 {
+		t.Fatalf("Expected 50 messages, got %v", n)
+	}
+	if first, last := msgStoreFirstAndLastSequence(t, foo.Msgs); first != 1 || last != 50 {
+		t.Fatalf("Unexpected first/last: %v/%v", first, last)
+	}
+	// Catch-up reads are served from disk and don't evict the tail.
+	for i, m := range msgs {
+		if lm := msgStoreLookup(t, foo.Msgs, uint64(i+1)); !reflect.DeepEqual(lm, m) {
+			t.Fatalf("Unexpected message for seq %v: %v", i+1, lm)
+		}
+	}
+	if n := hybridTailLen(foo.Msgs); n != tailLen {
+		t.Fatalf("Expected tail to have %v messages, got %v", tailLen, n)
+	}
+	if fm := msgStoreFirstMsg(t, foo.Msgs); !reflect.DeepEqual(fm, msgs[0]) {
+		t.Fatalf("Unexpected first message: %v", fm)
+	}
+	if lm := msgStoreLastMsg(t, foo.Msgs); !reflect.DeepEqual(lm, msgs[49]) {
+		t.Fatalf("Unexpected last message: %v", lm)
+	}
+
+	// Storing in the other channel evicts from the largest tail.
+	for i := 0; i < 10; i++ {
+		storeMsg(t, bar, "bar", uint64(i+1), payload)
+	}
+	if n := hybridTailLen(bar.Msgs); n != 10 {
+		t.Fatalf("Expected all messages of bar to be in memory, got %v", n)
+	}
+	if n := hybridTailLen(foo.Msgs); n >= tailLen {
+		t.Fatalf("Expected tail of foo to shrink, got %v", n)
+	}
+	if used := hs.MemoryUsed(); used > budget {
+		t.Fatalf("Memory used %v is above budget %v", used, budget)
+	}
+
+	if err := hs.DeleteChannel("bar"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+	if used, expected := hs.MemoryUsed(), hybridTailBytes(foo.Msgs); used != expected {
+		t.Fatalf("Expected memory used to be %v, got %v", expected, used)
+	}
+}
+
+func TestHybridWriteThrough(t *testing.T) {
+	cleanupHybridDatastore(t)
+	defer cleanupHybridDatastore(t)
+
+	hs := createHybridStoreWithBudget(t, 0)
+	defer hs.Close()
+
+	foo := storeCreateChannel(t, hs, "foo")
+	for i := 0; i < 10; i++ {
+		storeMsg(t, foo, "foo", uint64(i+1), []byte("hello"))
+	}
+	if n := hybridTailLen(foo.Msgs); n != 10 {
+		t.Fatalf("Expected 10 messages in memory, got %v", n)
+	}
+	if err := foo.Msgs.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	// Messages are in the files once flushed, without closing the store.
+	report, err := VerifyFileStore(testHybridDefaultDatastore, nil)
+	if err != nil {
+		t.Fatalf("Error on verify: %v", err)
+	}
+	if report.Msgs != 10 {
+		t.Fatalf("Expected 10 messages on disk, got %v", report.Msgs)
+	}
+}
+
+func TestHybridLimitsAndRecovery(t *testing.T) {
+	cleanupHybridDatastore(t)
+	defer cleanupHybridDatastore(t)
+
+	payload := make([]byte, 100)
+	msgSize := int64((&pb.MsgProto{Sequence: 1, Subject: "foo", Data: payload, Timestamp: time.Now().UnixNano()}).Size())
+	limits := testDefaultStoreLimits
+	limits.MaxMsgs = 30
+	hs, _ := newHybridStore(t, &limits, 10*msgSize)
+	info := testDefaultServerInfo
+	if err := hs.Init(&info); err != nil {
+		t.Fatalf("Error on init: %v", err)
+	}
+	foo := storeCreateChannel(t, hs, "foo")
+	ts := make([]int64, 51)
+	for i := 1; i <= 50; i++ {
+		ts[i] = storeMsg(t, foo, "foo", uint64(i), payload).Timestamp
+	}
+	if n, _ := msgStoreState(t, foo.Msgs); n != 30 {
+		t.Fatalf("Expected 30 messages, got %v", n)
+	}
+	if first, last := msgStoreFirstAndLastSequence(t, foo.Msgs); first != 21 || last != 50 {
+		t.Fatalf("Unexpected first/last: %v/%v", first, last)
+	}
+	if m := msgStoreLookup(t, foo.Msgs, 20); m != nil {
+		t.Fatalf("Expected message 20 to be removed, got %v", m)
+	}
+	for _, seq := range []uint64{21, 35, 50} {
+		if s := msgStoreGetSequenceFromTimestamp(t, foo.Msgs, ts[seq]); s != seq {
+			t.Fatalf("Expected seq %v for timestamp, got %v", seq, s)
+		}
+	}
+	if s := msgStoreGetSequenceFromTimestamp(t, foo.Msgs, time.Now().UnixNano()+int64(time.Hour)); s != 51 {
+		t.Fatalf("Expected seq 51 for future timestamp, got %v", s)
+	}
+	hs.Close()
+
+	// Messages are recovered from the files, not kept in memory.
+	hs, state := openDefaultHybridStoreWithLimits(t, &limits)
+	defer hs.Close()
+	if state == nil {
+		t.Fatal("Expected state to be recovered")
+	}
+	foo = getRecoveredChannel(t, state, "foo")
+	if first, last := msgStoreFirstAndLastSequence(t, foo.Msgs); first != 21 || last != 50 {
+		t.Fatalf("Unexpected first/last after recovery: %v/%v", first, last)
+	}
+	if n := hybridTailLen(foo.Msgs); n != 0 {
+		t.Fatalf("Expected no message in memory after recovery, got %v", n)
+	}
+	m := storeMsg(t, foo, "foo", 51, payload)
+	if lm := msgStoreLookup(t, foo.Msgs, 51); !reflect.DeepEqual(lm, m) {
+		t.Fatalf("Unexpected message: %v", lm)
+	}
+	if first := msgStoreFirstSequence(t, foo.Msgs); first != 22 {
+		t.Fatalf("Expected first to be 22, got %v", first)
+	}
+}
@@ -15,6 +15,8 @@ package stores
 
 import (
 	"fmt"
+	"path"
+	"sort"
 	"time"
 
 	"github.com/kubemq-io/broker/server/stan/util"
@@ -31,6 +33,7 @@ const (
 func (sl *StoreLimits) Clone() *StoreLimits {
 	cloned := *sl
 	cloned.PerChannel = sl.ClonePerChannelMap()
+	cloned.PerClient = sl.ClonePerClientMap()
 	return &cloned
 }
 
@@ -58,6 +61,44 @@ func (sl *StoreLimits) AddPerChannel(name string, cl *ChannelLimits) {
 	sl.PerChannel[name] = cl
 }
 
+// ClonePerClientMap returns a deep copy of the StoreLimits's PerClient map
+func (sl *StoreLimits) ClonePerClientMap() map[string]*PubRateLimits {
+	if sl.PerClient == nil {
+		return nil
+	}
+	clone := make(map[string]*PubRateLimits, len(sl.PerClient))
+	for k, v := range sl.PerClient {
+		copyVal := *v
+		clone[k] = &copyVal
+	}
+	return clone
+}
+
+// AddPerClient stores publish rate limits for clients whose ID matches
+// the given `pattern`. The pattern is validated in StoreLimits.Build.
+func (sl *StoreLimits) AddPerClient(pattern string, pl *PubRateLimits) {
+	if sl.PerClient == nil {
+		sl.PerClient = make(map[string]*PubRateLimits)
+	}
+	sl.PerClient[pattern] = pl
+}
+
+// GetClientPubRateLimits returns the publish rate limits for the given
+// client ID, or nil if no per-client limit applies. If several patterns
+// match, the longest one is used.
+func (sl *StoreLimits) GetClientPubRateLimits(clientID string) *PubRateLimits {
+	var (
+		best    *PubRateLimits
+		bestLen = -1
+	)
+	for pattern, pl := range sl.PerClient {
+		if ok, _ := path.Match(pattern, clientID); ok && len(pattern) > bestLen {
+			best, bestLen = pl, len(pattern)
+		}
+	}
+	return best
+}
+
 type channelLimitInfo struct {
 	name        string
 	limits      *ChannelLimits
@@ -70,11 +111,15 @@ type channelLimitInfo struct {
 // * any global limit is set to a negative value.
 // * the number of per-channel is higher than StoreLimits.MaxChannels.
 // * a per-channel name is invalid
+// * a per-client pattern is invalid
 func (sl *StoreLimits) Build() error {
 	// Check that there is no negative value
 	if err := sl.checkGlobalLimits(); err != nil {
 		return err
 	}
+	if err := sl.buildPerClient(); err != nil {
+		return err
+	}
 	// If there is no per-channel, we are done.
 	if len(sl.PerChannel) == 0 {
 		return nil
@@ -106,6 +151,25 @@ func (sl *StoreLimits) Build() error {
 	return nil
 }
 
+func (sl *StoreLimits) buildPerClient() error {
+	for pattern, pl := range sl.PerClient {
+		if pattern == "" {
+			return fmt.Errorf("client pattern cannot be empty")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid client pattern %q: %v", pattern, err)
+		}
+		// There is no inheritance for clients, so negative means unlimited.
+		if pl.MaxPubMsgsRate < 0 {
+			pl.MaxPubMsgsRate = 0
+		}
+		if pl.MaxPubBytesRate < 0 {
+			pl.MaxPubBytesRate = 0
+		}
+	}
+	return nil
+}
+
 func (sl *StoreLimits) applyInheritance(sublist *util.Sublist) {
 	// Get the subjects from the sublist. This ensure that they are ordered
 	// from the widest to the narrowest of subjects.
@@ -159,6 +223,19 @@ func (sl *StoreLimits) inheritLimits(channel *channelLimitInfo, parentLimits *Ch
 	} else if cl.MaxInactivity == 0 {
 		cl.MaxInactivity = parentLimits.MaxInactivity
 	}
+	if cl.MaxPubMsgsRate < 0 {
+		cl.MaxPubMsgsRate = 0
+	} else if cl.MaxPubMsgsRate == 0 {
+		cl.MaxPubMsgsRate = parentLimits.MaxPubMsgsRate
+	}
+	if cl.MaxPubBytesRate < 0 {
+		cl.MaxPubBytesRate = 0
+	} else if cl.MaxPubBytesRate == 0 {
+		cl.MaxPubBytesRate = parentLimits.MaxPubBytesRate
+	}
+	if !cl.DedicatedKey {
+		cl.DedicatedKey = parentLimits.DedicatedKey
+	}
 	channel.isProcessed = true
 }
 
@@ -181,6 +258,12 @@ func (sl *StoreLimits) checkGlobalLimits() error {
 	if sl.MaxInactivity < 0 {
 		return fmt.Errorf("max inactivity limit cannot be negative (%v)", sl.MaxInactivity)
 	}
+	if sl.MaxPubMsgsRate < 0 {
+		return fmt.Errorf("max publish messages rate cannot be negative (%v)", sl.MaxPubMsgsRate)
+	}
+	if sl.MaxPubBytesRate < 0 {
+		return fmt.Errorf("max publish bytes rate cannot be negative (%v)", sl.MaxPubBytesRate)
+	}
 	return nil
 }
 
@@ -237,6 +320,34 @@ func (sl *StoreLimits) Print() []string {
 		txt = append(txt, title)
 		txt = append(txt, channelLines...)
 	}
+	if len(sl.PerClient) > 0 {
+		patterns := make([]string, 0, len(sl.PerClient))
+		for p := range sl.PerClient {
+			patterns = append(patterns, p)
+		}
+		sort.Strings(patterns)
+		clientLines := []string{}
+		for _, p := range patterns {
+			pl := sl.PerClient[p]
+			clientLines = append(clientLines, fmt.Sprintf(" %s", p))
+			clientLines = append(clientLines, fmt.Sprintf("  |-> Pub msgs/s    %s", getLimitStr(true, int64(pl.MaxPubMsgsRate), -1, limitCount)))
+			clientLines = append(clientLines, fmt.Sprintf("  |-> Pub bytes/s   %s", getLimitStr(true, pl.MaxPubBytesRate, -1, limitBytes)))
+		}
+		for _, l := range clientLines {
+			if len(l) > maxLen {
+				maxLen = len(l)
+			}
+		}
+		title := " List of Clients "
+		numberDashesLeft := (maxLen - len(title)) / 2
+		numberDashesRight := maxLen - len(title) - numberDashesLeft
+		title = fmt.Sprintf("%s%s%s",
+			repeatChar("-", numberDashesLeft),
+			title,
+			repeatChar("-", numberDashesRight))
+		txt = append(txt, title)
+		txt = append(txt, clientLines...)
+	}
 	txt = append(txt, repeatChar("-", maxLen))
 	return txt
 }
@@ -272,12 +383,19 @@ func getGlobalLimitsPrintLines(limits *ChannelLimits) []string {
 	defMaxBytes := defaultLimits.MaxBytes
 	defMaxAge := defaultLimits.MaxAge
 	defMaxInactivity := defaultLimits.MaxInactivity
+	defMaxPubMsgsRate := int64(defaultLimits.MaxPubMsgsRate)
+	defMaxPubBytesRate := defaultLimits.MaxPubBytesRate
 	txt := []string{}
 	txt = append(txt, fmt.Sprintf("  Subscriptions: %s", getLimitStr(true, int64(limits.MaxSubscriptions), defMaxSubs, limitCount)))
 	txt = append(txt, fmt.Sprintf("  Messages     : %s", getLimitStr(true, int64(limits.MaxMsgs), defMaxMsgs, limitCount)))
 	txt = append(txt, fmt.Sprintf("  Bytes        : %s", getLimitStr(true, limits.MaxBytes, defMaxBytes, limitBytes)))
 	txt = append(txt, fmt.Sprintf("  Age          : %s", getLimitStr(true, int64(limits.MaxAge), int64(defMaxAge), limitDuration)))
 	txt = append(txt, fmt.Sprintf("  Inactivity   : %s", getLimitStr(true, int64(limits.MaxInactivity), int64(defMaxInactivity), limitDuration)))
+	txt = append(txt, fmt.Sprintf("  Pub msgs/s   : %s", getLimitStr(true, int64(limits.MaxPubMsgsRate), defMaxPubMsgsRate, limitCount)))
+	txt = append(txt, fmt.Sprintf("  Pub bytes/s  : %s", getLimitStr(true, limits.MaxPubBytesRate, defMaxPubBytesRate, limitBytes)))
+	if limits.DedicatedKey {
+		txt = append(txt, fmt.Sprintf("  Dedicated key: %13s", "yes"))
+	}
 	return txt
 }
 
@@ -287,11 +405,15 @@ func getChannelLimitsPrintLines(level, maxLevels int, maxLen *int, channelName s
 	plMaxBytes := parentLimits.MaxBytes
 	plMaxAge := parentLimits.MaxAge
 	plMaxInactivity := parentLimits.MaxInactivity
+	plMaxPubMsgsRate := int64(parentLimits.MaxPubMsgsRate)
+	plMaxPubBytesRate := parentLimits.MaxPubBytesRate
 	maxSubsOverride := getLimitStr(false, int64(limits.MaxSubscriptions), plMaxSubs, limitCount)
 	maxMsgsOverride := getLimitStr(false, int64(limits.MaxMsgs), plMaxMsgs, limitCount)
 	maxBytesOverride := getLimitStr(false, limits.MaxBytes, plMaxBytes, limitBytes)
 	maxAgeOverride := getLimitStr(false, int64(limits.MaxAge), int64(plMaxAge), limitDuration)
 	MaxInactivityOverride := getLimitStr(false, int64(limits.MaxInactivity), int64(plMaxInactivity), limitDuration)
+	maxPubMsgsRateOverride := getLimitStr(false, int64(limits.MaxPubMsgsRate), plMaxPubMsgsRate, limitCount)
+	maxPubBytesRateOverride := getLimitStr(false, limits.MaxPubBytesRate, plMaxPubBytesRate, limitBytes)
 	paddingLeft := repeatChar(" ", level)
 	paddingRight := repeatChar(" ", maxLevels-level)
 	txt := []string{}
@@ -311,6 +433,15 @@ func getChannelLimitsPrintLines(level, maxLevels int, maxLen *int, channelName s
 	if MaxInactivityOverride != "" {
 		txt = append(txt, fmt.Sprintf("%s |-> Inactivity    %s%s", paddingLeft, paddingRight, MaxInactivityOverride))
 	}
+	if maxPubMsgsRateOverride != "" {
+		txt = append(txt, fmt.Sprintf("%s |-> Pub msgs/s    %s%s", paddingLeft, paddingRight, maxPubMsgsRateOverride))
+	}
+	if maxPubBytesRateOverride != "" {
+		txt = append(txt, fmt.Sprintf("%s |-> Pub bytes/s   %s%s", paddingLeft, paddingRight, maxPubBytesRateOverride))
+	}
+	if limits.DedicatedKey && !parentLimits.DedicatedKey {
+		txt = append(txt, fmt.Sprintf("%s |-> Dedicated key %s%13s", paddingLeft, paddingRight, "yes"))
+	}
 	for _, l := range txt {
 		if len(l) > *maxLen {
 			*maxLen = len(l)
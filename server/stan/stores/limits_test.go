@@ -31,7 +31,9 @@ func TestLimitsAddPerChannel(t *testing.T) {
 		SubStoreLimits{
 			MaxSubscriptions: 10,
 		},
+		PubRateLimits{},
 		2000,
+		false,
 	}
 	sl.AddPerChannel("foo", cl)
 	if len(sl.PerChannel) != 1 {
@@ -96,6 +98,14 @@ func TestLimitsBuildErrors(t *testing.T) {
 	sl.MaxInactivity = -1
 	expectError("Max inactivity")
 
+	sl.MaxInactivity = 1
+	sl.MaxPubMsgsRate = -1
+	expectError("Max publish messages rate")
+
+	sl.MaxPubMsgsRate = 1
+	sl.MaxPubBytesRate = -1
+	expectError("Max publish bytes rate")
+
 	// Reset sl
 	sl.MaxChannels = 1
 	sl.MaxSubscriptions = 1
@@ -103,6 +113,8 @@ func TestLimitsBuildErrors(t *testing.T) {
 	sl.MaxBytes = 1
 	sl.MaxAge = 1
 	sl.MaxInactivity = 1
+	sl.MaxPubMsgsRate = 1
+	sl.MaxPubBytesRate = 1
 
 	// Adding a second channel should cause build failures, AddPerChannel itself
 	// does not fail.
@@ -143,6 +155,15 @@ func TestLimitsBuildErrors(t *testing.T) {
 	cl = &ChannelLimits{}
 	sl.AddPerChannel("foo/bar", cl)
 	expectError("invalid channel name")
+
+	// Check invalid client patterns
+	sl = testDefaultStoreLimits
+	sl.AddPerClient("", &PubRateLimits{})
+	expectError("client pattern cannot be empty")
+
+	sl = testDefaultStoreLimits
+	sl.AddPerClient("foo[", &PubRateLimits{})
+	expectError("invalid client pattern")
 }
 
 func TestLimitsPerChannelOverride(t *testing.T) {
@@ -487,6 +508,49 @@ func TestLimitsPrint(t *testing.T) {
 	}
 }
 
+func TestLimitsPerClient(t *testing.T) {
+	sl := testDefaultStoreLimits
+	sl.MaxPubMsgsRate = 100
+	sl.AddPerChannel("foo", &ChannelLimits{PubRateLimits: PubRateLimits{MaxPubBytesRate: 1024}})
+	sl.AddPerChannel("bar", &ChannelLimits{PubRateLimits: PubRateLimits{MaxPubMsgsRate: -1}})
+	sl.AddPerClient("*", &PubRateLimits{MaxPubMsgsRate: 10})
+	sl.AddPerClient("loader_*", &PubRateLimits{MaxPubMsgsRate: 1000, MaxPubBytesRate: -1})
+	if err := sl.Build(); err != nil {
+		t.Fatalf("Error on build: %v", err)
+	}
+	if cl := sl.PerChannel["foo"]; cl.MaxPubMsgsRate != 100 || cl.MaxPubBytesRate != 1024 {
+		t.Fatalf("Unexpected limits for foo: %+v", cl.PubRateLimits)
+	}
+	if cl := sl.PerChannel["bar"]; cl.MaxPubMsgsRate != 0 || cl.MaxPubBytesRate != 0 {
+		t.Fatalf("Unexpected limits for bar: %+v", cl.PubRateLimits)
+	}
+	if pl := sl.GetClientPubRateLimits("me"); pl == nil || pl.MaxPubMsgsRate != 10 {
+		t.Fatalf("Unexpected limits for client me: %+v", pl)
+	}
+	pl := sl.GetClientPubRateLimits("loader_1")
+	if pl == nil || pl.MaxPubMsgsRate != 1000 || pl.MaxPubBytesRate != 0 {
+		t.Fatalf("Unexpected limits for client loader_1: %+v", pl)
+	}
+	sl.PerClient = nil
+	sl.AddPerClient("loader_?", &PubRateLimits{MaxPubMsgsRate: 1})
+	if pl := sl.GetClientPubRateLimits("loader_12"); pl != nil {
+		t.Fatalf("Expected no limits for client loader_12, got %+v", pl)
+	}
+	lines := sl.Print()
+	found := false
+	for i, l := range lines {
+		if l == " loader_?" {
+			if lines[i+1] != "  |-> Pub msgs/s                1" {
+				t.Fatalf("Unexpected content for %v: %q", l, lines[i+1])
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Client limits not printed: %v", lines)
+	}
+}
+
 func TestLimitsClone(t *testing.T) {
 	slo := testDefaultStoreLimits
 	sl := &slo
@@ -496,6 +560,8 @@ func TestLimitsClone(t *testing.T) {
 	cl.MaxAge = time.Second
 	cl.MaxInactivity = time.Second
 	sl.AddPerChannel("foo", cl)
+	pl := &PubRateLimits{MaxPubMsgsRate: 10}
+	sl.AddPerClient("foo_*", pl)
 
 	clone := sl.Clone()
 	if !reflect.DeepEqual(*clone, *sl) {
@@ -516,6 +582,12 @@ func TestLimitsClone(t *testing.T) {
 	if !reflect.DeepEqual(*clone, *sl) {
 		t.Fatalf("Expected %v, got %v", sl, *clone)
 	}
+	// Change a per-client limit
+	pl.MaxPubMsgsRate = 20
+	if reflect.DeepEqual(*clone, *sl) {
+		t.Fatal("Expected clone and original to now be different")
+	}
+	clone = sl.Clone()
 	// Change one of the global properties
 	sl.MaxBytes = 100
 	// They should now be different
@@ -0,0 +1,462 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+	"github.com/kubemq-io/broker/server/stan/spb"
+)
+
+// Operations measured by the MetricsStore.
+const (
+	opCreateChannel = iota
+	opDeleteChannel
+	opAddClient
+	opDeleteClient
+	opStore
+	opLookup
+	opGetSequenceFromTimestamp
+	opFirstMsg
+	opLastMsg
+	opMsgsFlush
+	opEmpty
+	opCreateSub
+	opUpdateSub
+	opDeleteSub
+	opAddSeqPending
+	opAckSeqPending
+	opSubsFlush
+	numStoreOps
+)
+
+// Names of the operations, as reported in the stats and to the hook.
+var storeOpNames = [numStoreOps]string{
+	opCreateChannel:            "CreateChannel",
+	opDeleteChannel:            "DeleteChannel",
+	opAddClient:                "AddClient",
+	opDeleteClient:             "DeleteClient",
+	opStore:                    "Store",
+	opLookup:                   "Lookup",
+	opGetSequenceFromTimestamp: "GetSequenceFromTimestamp",
+	opFirstMsg:                 "FirstMsg",
+	opLastMsg:                  "LastMsg",
+	opMsgsFlush:                "MsgsFlush",
+	opEmpty:                    "Empty",
+	opCreateSub:                "CreateSub",
+	opUpdateSub:                "UpdateSub",
+	opDeleteSub:                "DeleteSub",
+	opAddSeqPending:            "AddSeqPending",
+	opAckSeqPending:            "AckSeqPending",
+	opSubsFlush:                "SubsFlush",
+}
+
+// Upper bounds, in micro-seconds, of the latency histogram buckets.
+// Operations that take longer are counted in the overflow bucket.
+var latencyBuckets = [...]int64{
+	50, 100, 250, 500,
+	1000, 2500, 5000, 10000, 25000, 50000,
+	100000, 250000, 500000, 1000000,
+}
+
+// StoreOpHook is invoked by the MetricsStore after each operation with
+// the name of the channel (empty for operations that are not specific to
+// a channel), the name of the operation, its duration and error.
+// It is invoked from the go routine that called the store, so it should
+// not block.
+type StoreOpHook func(channel, op string, d time.Duration, err error)
+
+// LatencyBucket is the number of operations whose latency is lower or
+// equal to UpperBound micro-seconds, and greater than the previous bucket's.
+type LatencyBucket struct {
+	UpperBound int64  `json:"le_usec"`
+	Count      uint64 `json:"count"`
+}
+
+// StoreOpStats are the statistics of an operation since the server started.
+// Percentiles are estimated from the histogram and so are the upper bound
+// of the bucket in which they fall.
+type StoreOpStats struct {
+	Count    uint64          `json:"count"`
+	Errors   uint64          `json:"errors"`
+	AvgUsec  int64           `json:"avg_usec"`
+	P50Usec  int64           `json:"p50_usec"`
+	P99Usec  int64           `json:"p99_usec"`
+	MaxUsec  int64           `json:"max_usec"`
+	Buckets  []LatencyBucket `json:"buckets,omitempty"`
+	Overflow uint64          `json:"overflow,omitempty"`
+}
+
+// StoreMetrics are the statistics of the operations of a MetricsStore,
+// indexed by operation name. Only operations that have been invoked at
+// least once are reported.
+type StoreMetrics struct {
+	Store    map[string]*StoreOpStats            `json:"store,omitempty"`
+	Channels map[string]map[string]*StoreOpStats `json:"channels,omitempty"`
+}
+
+// latencyHistogram accumulates the latencies of an operation.
+// All fields are updated atomically.
+type latencyHistogram struct {
+	count    uint64
+	errors   uint64
+	sum      uint64 // micro-seconds
+	max      int64  // micro-seconds
+	buckets  [len(latencyBuckets)]uint64
+	overflow uint64
+}
+
+// opsMetrics holds the histograms of all operations for a channel, or
+// for the store itself.
+type opsMetrics [numStoreOps]latencyHistogram
+
+// MetricsStore is a store wrapping a store implementation
+// and records latency histograms and error counts per operation
+// and per channel.
+type MetricsStore struct {
+	sync.Mutex
+	Store
+	hook     StoreOpHook
+	ops      *opsMetrics
+	channels map[string]*opsMetrics
+}
+
+// MetricsMsgStore is a store wrapping a MsgStore implementation
+// and records latency of its operations.
+type MetricsMsgStore struct {
+	MsgStore
+	channel string
+	ms      *MetricsStore
+	ops     *opsMetrics
+}
+
+// MetricsSubStore is a store wrapping a SubStore implementation
+// and records latency of its operations.
+type MetricsSubStore struct {
+	SubStore
+	channel string
+	ms      *MetricsStore
+	ops     *opsMetrics
+}
+
+// NewMetricsStore returns a MetricsStore instance with given underlying
+// store. If `hook` is not nil, it is invoked after each measured operation.
+// To measure the actual cost of the storage, this should wrap the store
+// implementation directly, not a CryptoStore or CompressedStore.
+func NewMetricsStore(s Store, hook StoreOpHook) *MetricsStore {
+	return &MetricsStore{
+		Store:    s,
+		hook:     hook,
+		ops:      &opsMetrics{},
+		channels: make(map[string]*opsMetrics),
+	}
+}
+
+// record adds the latency of the operation `op` that started at `start`
+// to its histogram in `ops` and invokes the hook, if any.
+func (ms *MetricsStore) record(ops *opsMetrics, channel string, op int, start time.Time, err error) {
+	d := time.Since(start)
+	h := &ops[op]
+	usec := int64(d / time.Microsecond)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(usec))
+	if err != nil {
+		atomic.AddUint64(&h.errors, 1)
+	}
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if usec <= max || atomic.CompareAndSwapInt64(&h.max, max, usec) {
+			break
+		}
+	}
+	i := 0
+	for ; i < len(latencyBuckets); i++ {
+		if usec <= latencyBuckets[i] {
+			atomic.AddUint64(&h.buckets[i], 1)
+			break
+		}
+	}
+	if i == len(latencyBuckets) {
+		atomic.AddUint64(&h.overflow, 1)
+	}
+	if ms.hook != nil {
+		ms.hook(channel, storeOpNames[op], d, err)
+	}
+}
+
+// stats returns the statistics of this histogram, or nil if there was
+// no operation recorded.
+func (h *latencyHistogram) stats() *StoreOpStats {
+	count := atomic.LoadUint64(&h.count)
+	if count == 0 {
+		return nil
+	}
+	s := &StoreOpStats{
+		Count:    count,
+		Errors:   atomic.LoadUint64(&h.errors),
+		AvgUsec:  int64(atomic.LoadUint64(&h.sum) / count),
+		MaxUsec:  atomic.LoadInt64(&h.max),
+		Overflow: atomic.LoadUint64(&h.overflow),
+	}
+	// Counts are loaded independently, so use their sum for the percentiles.
+	total := s.Overflow
+	for i := range latencyBuckets {
+		n := atomic.LoadUint64(&h.buckets[i])
+		if n > 0 {
+			s.Buckets = append(s.Buckets, LatencyBucket{UpperBound: latencyBuckets[i], Count: n})
+			total += n
+		}
+	}
+	s.P50Usec = s.percentile(total, 50)
+	s.P99Usec = s.percentile(total, 99)
+	return s
+}
+
+// percentile returns the upper bound of the bucket that contains the
+// given percentile, or the max latency if in the overflow bucket.
+func (s *StoreOpStats) percentile(total uint64, p uint64) int64 {
+	rank := (total*p + 99) / 100
+	var n uint64
+	for _, b := range s.Buckets {
+		n += b.Count
+		if n >= rank {
+			return b.UpperBound
+		}
+	}
+	return s.MaxUsec
+}
+
+// stats returns the statistics of the operations that have been invoked.
+func (ops *opsMetrics) stats() map[string]*StoreOpStats {
+	var res map[string]*StoreOpStats
+	for op := range ops {
+		if s := ops[op].stats(); s != nil {
+			if res == nil {
+				res = make(map[string]*StoreOpStats)
+			}
+			res[storeOpNames[op]] = s
+		}
+	}
+	return res
+}
+
+// Stats returns the statistics of the store and its channels.
+func (ms *MetricsStore) Stats() *StoreMetrics {
+	ms.Lock()
+	channels := make(map[string]*opsMetrics, len(ms.channels))
+	for name, ops := range ms.channels {
+		channels[name] = ops
+	}
+	ms.Unlock()
+	sm := &StoreMetrics{Store: ms.ops.stats()}
+	for name, ops := range channels {
+		if s := ops.stats(); s != nil {
+			if sm.Channels == nil {
+				sm.Channels = make(map[string]map[string]*StoreOpStats)
+			}
+			sm.Channels[name] = s
+		}
+	}
+	return sm
+}
+
+// ChannelStats returns the statistics of the given channel, or nil if the
+// channel does not exist or there was no operation on this channel.
+func (ms *MetricsStore) ChannelStats(channel string) map[string]*StoreOpStats {
+	ms.Lock()
+	ops := ms.channels[channel]
+	ms.Unlock()
+	if ops == nil {
+		return nil
+	}
+	return ops.stats()
+}
+
+// Wraps the stores of the channel and tracks its metrics.
+// Store lock is held on entry.
+func (ms *MetricsStore) wrapChannel(name string, c *Channel) {
+	ops := &opsMetrics{}
+	ms.channels[name] = ops
+	c.Msgs = &MetricsMsgStore{MsgStore: c.Msgs, channel: name, ms: ms, ops: ops}
+	c.Subs = &MetricsSubStore{SubStore: c.Subs, channel: name, ms: ms, ops: ops}
+}
+
+// Recover implements the Store interface
+func (ms *MetricsStore) Recover() (*RecoveredState, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	rs, err := ms.Store.Recover()
+	if rs == nil || err != nil {
+		return rs, err
+	}
+	for cn, rc := range rs.Channels {
+		ms.wrapChannel(cn, rc.Channel)
+	}
+	return rs, nil
+}
+
+// CreateChannel implements the Store interface
+func (ms *MetricsStore) CreateChannel(channel string) (*Channel, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	start := time.Now()
+	c, err := ms.Store.CreateChannel(channel)
+	ms.record(ms.ops, channel, opCreateChannel, start, err)
+	if err != nil {
+		return nil, err
+	}
+	ms.wrapChannel(channel, c)
+	return c, nil
+}
+
+// DeleteChannel implements the Store interface
+func (ms *MetricsStore) DeleteChannel(channel string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	start := time.Now()
+	err := ms.Store.DeleteChannel(channel)
+	ms.record(ms.ops, channel, opDeleteChannel, start, err)
+	if err == nil {
+		delete(ms.channels, channel)
+	}
+	return err
+}
+
+// AddClient implements the Store interface
+func (ms *MetricsStore) AddClient(info *spb.ClientInfo) (*Client, error) {
+	start := time.Now()
+	c, err := ms.Store.AddClient(info)
+	ms.record(ms.ops, "", opAddClient, start, err)
+	return c, err
+}
+
+// DeleteClient implements the Store interface
+func (ms *MetricsStore) DeleteClient(clientID string) error {
+	start := time.Now()
+	err := ms.Store.DeleteClient(clientID)
+	ms.record(ms.ops, "", opDeleteClient, start, err)
+	return err
+}
+
+// unwrapMsgStore implements the msgStoreWrapper interface
+func (mms *MetricsMsgStore) unwrapMsgStore() MsgStore {
+	return mms.MsgStore
+}
+
+// Store implements the MsgStore interface
+func (mms *MetricsMsgStore) Store(msg *pb.MsgProto) (uint64, error) {
+	start := time.Now()
+	seq, err := mms.MsgStore.Store(msg)
+	mms.ms.record(mms.ops, mms.channel, opStore, start, err)
+	return seq, err
+}
+
+// Lookup implements the MsgStore interface
+func (mms *MetricsMsgStore) Lookup(seq uint64) (*pb.MsgProto, error) {
+	start := time.Now()
+	m, err := mms.MsgStore.Lookup(seq)
+	mms.ms.record(mms.ops, mms.channel, opLookup, start, err)
+	return m, err
+}
+
+// GetSequenceFromTimestamp implements the MsgStore interface
+func (mms *MetricsMsgStore) GetSequenceFromTimestamp(timestamp int64) (uint64, error) {
+	start := time.Now()
+	seq, err := mms.MsgStore.GetSequenceFromTimestamp(timestamp)
+	mms.ms.record(mms.ops, mms.channel, opGetSequenceFromTimestamp, start, err)
+	return seq, err
+}
+
+// FirstMsg implements the MsgStore interface
+func (mms *MetricsMsgStore) FirstMsg() (*pb.MsgProto, error) {
+	start := time.Now()
+	m, err := mms.MsgStore.FirstMsg()
+	mms.ms.record(mms.ops, mms.channel, opFirstMsg, start, err)
+	return m, err
+}
+
+// LastMsg implements the MsgStore interface
+func (mms *MetricsMsgStore) LastMsg() (*pb.MsgProto, error) {
+	start := time.Now()
+	m, err := mms.MsgStore.LastMsg()
+	mms.ms.record(mms.ops, mms.channel, opLastMsg, start, err)
+	return m, err
+}
+
+// Flush implements the MsgStore interface
+func (mms *MetricsMsgStore) Flush() error {
+	start := time.Now()
+	err := mms.MsgStore.Flush()
+	mms.ms.record(mms.ops, mms.channel, opMsgsFlush, start, err)
+	return err
+}
+
+// Empty implements the MsgStore interface
+func (mms *MetricsMsgStore) Empty() error {
+	start := time.Now()
+	err := mms.MsgStore.Empty()
+	mms.ms.record(mms.ops, mms.channel, opEmpty, start, err)
+	return err
+}
+
+// CreateSub implements the SubStore interface
+func (mss *MetricsSubStore) CreateSub(sub *spb.SubState) error {
+	start := time.Now()
+	err := mss.SubStore.CreateSub(sub)
+	mss.ms.record(mss.ops, mss.channel, opCreateSub, start, err)
+	return err
+}
+
+// UpdateSub implements the SubStore interface
+func (mss *MetricsSubStore) UpdateSub(sub *spb.SubState) error {
+	start := time.Now()
+	err := mss.SubStore.UpdateSub(sub)
+	mss.ms.record(mss.ops, mss.channel, opUpdateSub, start, err)
+	return err
+}
+
+// DeleteSub implements the SubStore interface
+func (mss *MetricsSubStore) DeleteSub(subid uint64) error {
+	start := time.Now()
+	err := mss.SubStore.DeleteSub(subid)
+	mss.ms.record(mss.ops, mss.channel, opDeleteSub, start, err)
+	return err
+}
+
+// AddSeqPending implements the SubStore interface
+func (mss *MetricsSubStore) AddSeqPending(subid, seqno uint64) error {
+	start := time.Now()
+	err := mss.SubStore.AddSeqPending(subid, seqno)
+	mss.ms.record(mss.ops, mss.channel, opAddSeqPending, start, err)
+	return err
+}
+
+// AckSeqPending implements the SubStore interface
+func (mss *MetricsSubStore) AckSeqPending(subid, seqno uint64) error {
+	start := time.Now()
+	err := mss.SubStore.AckSeqPending(subid, seqno)
+	mss.ms.record(mss.ops, mss.channel, opAckSeqPending, start, err)
+	return err
+}
+
+// Flush implements the SubStore interface
+func (mss *MetricsSubStore) Flush() error {
+	start := time.Now()
+	err := mss.SubStore.Flush()
+	mss.ms.record(mss.ops, mss.channel, opSubsFlush, start, err)
+	return err
+}
@@ -0,0 +1,153 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricsStore(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+
+	s := createDefaultFileStore(t)
+	defer s.Close()
+	c := storeCreateChannel(t, s, "foo")
+	storeMsg(t, c, "foo", 1, []byte("msg"))
+	s.Close()
+
+	type hookEvent struct {
+		channel string
+		op      string
+		err     error
+	}
+	var (
+		mu     sync.Mutex
+		events []hookEvent
+	)
+	hook := func(channel, op string, d time.Duration, err error) {
+		mu.Lock()
+		events = append(events, hookEvent{channel, op, err})
+		mu.Unlock()
+	}
+
+	fs, _ := openDefaultFileStore(t)
+	ms := NewMetricsStore(fs, hook)
+	defer ms.Close()
+	rs, err := ms.Recover()
+	if err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	// Recovered channels are measured too.
+	c = getRecoveredChannel(t, rs, "foo")
+	storeMsg(t, c, "foo", 2, []byte("msg"))
+	msgStoreLookup(t, c.Msgs, 1)
+	msgStoreGetSequenceFromTimestamp(t, c.Msgs, 0)
+	subID := storeSub(t, c, "foo")
+	storeSubPending(t, c, "foo", subID, 1, 2)
+	storeSubAck(t, c, "foo", subID, 1)
+	if err := c.Msgs.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+
+	bar := storeCreateChannel(t, ms, "bar")
+	storeMsg(t, bar, "bar", 1, []byte("msg"))
+	// Errors are counted
+	if _, err := ms.CreateChannel("bar"); err != ErrAlreadyExists {
+		t.Fatalf("Expected error %v, got %v", ErrAlreadyExists, err)
+	}
+	storeAddClient(t, ms, "me", "hbInbox")
+
+	stats := ms.Stats()
+	checkOp := func(ops map[string]*StoreOpStats, op string, count, errors uint64) {
+		t.Helper()
+		s := ops[op]
+		if s == nil {
+			t.Fatalf("No stats for %q: %v", op, ops)
+		}
+		if s.Count != count || s.Errors != errors {
+			t.Fatalf("Expected %q count and errors to be %v/%v, got %v/%v", op, count, errors, s.Count, s.Errors)
+		}
+		var n uint64
+		for _, b := range s.Buckets {
+			n += b.Count
+		}
+		if n+s.Overflow != count {
+			t.Fatalf("Expected %v operations in histogram, got %v", count, n+s.Overflow)
+		}
+		if s.P50Usec > s.P99Usec || s.AvgUsec > s.MaxUsec {
+			t.Fatalf("Unexpected stats for %q: %+v", op, s)
+		}
+	}
+	checkOp(stats.Store, "CreateChannel", 2, 1)
+	checkOp(stats.Store, "AddClient", 1, 0)
+	foo := stats.Channels["foo"]
+	checkOp(foo, "Store", 1, 0)
+	// storeMsg also looks up the stored message
+	checkOp(foo, "Lookup", 2, 0)
+	checkOp(foo, "GetSequenceFromTimestamp", 1, 0)
+	checkOp(foo, "MsgsFlush", 1, 0)
+	checkOp(foo, "CreateSub", 1, 0)
+	checkOp(foo, "AddSeqPending", 2, 0)
+	checkOp(foo, "AckSeqPending", 1, 0)
+	if _, ok := foo["Empty"]; ok {
+		t.Fatalf("Operations never invoked should not be reported: %v", foo)
+	}
+	checkOp(ms.ChannelStats("bar"), "Store", 1, 0)
+
+	mu.Lock()
+	n := len(events)
+	last := events[n-1]
+	mu.Unlock()
+	if n != 14 {
+		t.Fatalf("Expected hook to be invoked 14 times, got %v", n)
+	}
+	if last.channel != "" || last.op != "AddClient" || last.err != nil {
+		t.Fatalf("Unexpected last event: %+v", last)
+	}
+
+	if err := ms.DeleteChannel("bar"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+	if s := ms.ChannelStats("bar"); s != nil {
+		t.Fatalf("Stats of deleted channel should be gone, got %v", s)
+	}
+	checkOp(ms.Stats().Store, "DeleteChannel", 1, 0)
+}
+
+func TestMetricsStorePercentiles(t *testing.T) {
+	ms := NewMetricsStore(nil, nil)
+	ops := &opsMetrics{}
+	// 98 fast operations, one of 2ms and one of 2s
+	for i := 0; i < 98; i++ {
+		ms.record(ops, "foo", opStore, time.Now(), nil)
+	}
+	ms.record(ops, "foo", opStore, time.Now().Add(-2*time.Millisecond), nil)
+	ms.record(ops, "foo", opStore, time.Now().Add(-2*time.Second), nil)
+	s := ops.stats()["Store"]
+	if s.Count != 100 || s.Overflow != 1 {
+		t.Fatalf("Unexpected stats: %+v", s)
+	}
+	if s.P50Usec != latencyBuckets[0] {
+		t.Fatalf("Expected p50 to be %v, got %v", latencyBuckets[0], s.P50Usec)
+	}
+	if s.P99Usec != 2500 {
+		t.Fatalf("Expected p99 to be 2500, got %v", s.P99Usec)
+	}
+	if s.MaxUsec < int64(2*time.Second/time.Microsecond) {
+		t.Fatalf("Unexpected max: %v", s.MaxUsec)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+	"github.com/kubemq-io/broker/server/stan/logger"
+)
+
+// MigrateOptions are options for Migrate.
+type MigrateOptions struct {
+	// Verify compares, once a channel is migrated, the messages of the
+	// destination store with the ones of the source store.
+	Verify bool
+	// Log, if set, is used to report progress.
+	Log logger.Logger
+}
+
+// MigrateReport describes what has been migrated.
+type MigrateReport struct {
+	Clients  int
+	Channels []*MigratedChannel
+}
+
+// MigratedChannel describes the migration of a channel.
+type MigratedChannel struct {
+	Name string
+	// Number of messages in the source store.
+	SrcMsgs int
+	// Number of messages in the destination store, which may be lower
+	// than SrcMsgs if the destination store has lower limits.
+	DstMsgs int
+	// Number of messages stored by this migration, which is lower than
+	// DstMsgs if the migration is resumed.
+	Copied int
+	// Number of subscriptions created by this migration.
+	Subs     int
+	FirstSeq uint64
+	LastSeq  uint64
+}
+
+// Migrate copies the channels, messages, clients and subscriptions
+// of `src` into `dst`. Both stores must have been created but not
+// recovered. The destination store applies its own limits. Stores
+// can be wrapped with a CryptoStore (or a CompressedStore), payloads
+// are then decrypted from `src` and encrypted again in `dst`.
+//
+// Migrate can be invoked again after an interruption: messages that
+// are already in `dst` are skipped, and so are clients and subscriptions
+// (identified by their AckInbox) that already exist.
+//
+// The exclusive lock of both stores is acquired first, and Migrate fails
+// if one of them is held, for instance by a server running in FT mode.
+// The stores are not closed on return, which releases the locks.
+func Migrate(src, dst Store, opts *MigrateOptions) (*MigrateReport, error) {
+	if opts == nil {
+		opts = &MigrateOptions{}
+	}
+	if err := lockStore(src, "source"); err != nil {
+		return nil, err
+	}
+	if err := lockStore(dst, "destination"); err != nil {
+		return nil, err
+	}
+	srcState, err := src.Recover()
+	if err != nil {
+		return nil, fmt.Errorf("unable to recover source store: %v", err)
+	}
+	if srcState == nil {
+		return nil, fmt.Errorf("source store is empty")
+	}
+	dstState, err := dst.Recover()
+	if err != nil {
+		return nil, fmt.Errorf("unable to recover destination store: %v", err)
+	}
+	if dstState == nil {
+		if err := dst.Init(srcState.Info); err != nil {
+			return nil, fmt.Errorf("unable to initialize destination store: %v", err)
+		}
+		dstState = &RecoveredState{Channels: make(map[string]*RecoveredChannel)}
+	} else if dstState.Info.ClusterID != srcState.Info.ClusterID {
+		return nil, fmt.Errorf("destination store belongs to cluster %q, source to %q",
+			dstState.Info.ClusterID, srcState.Info.ClusterID)
+	}
+
+	report := &MigrateReport{}
+	dstClients := make(map[string]struct{}, len(dstState.Clients))
+	for _, c := range dstState.Clients {
+		dstClients[c.ID] = struct{}{}
+	}
+	for _, c := range srcState.Clients {
+		if _, exists := dstClients[c.ID]; exists {
+			continue
+		}
+		info := c.ClientInfo
+		if _, err := dst.AddClient(&info); err != nil {
+			return nil, fmt.Errorf("unable to add client %q: %v", c.ID, err)
+		}
+		report.Clients++
+	}
+
+	// Migrate in a predictable order so that progress is easier to follow.
+	names := make([]string, 0, len(srcState.Channels))
+	for name := range srcState.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mc, err := migrateChannel(name, srcState.Channels[name], dst, dstState.Channels[name], opts)
+		if err != nil {
+			return nil, fmt.Errorf("channel %q: %v", name, err)
+		}
+		if opts.Log != nil {
+			opts.Log.Noticef("Migrated channel %q: %v new message(s) (%v/%v in destination, sequences %v-%v), %v new subscription(s)",
+				name, mc.Copied, mc.DstMsgs, mc.SrcMsgs, mc.FirstSeq, mc.LastSeq, mc.Subs)
+		}
+		report.Channels = append(report.Channels, mc)
+	}
+	return report, nil
+}
+
+func migrateChannel(name string, src *RecoveredChannel, dst Store, existing *RecoveredChannel, opts *MigrateOptions) (*MigratedChannel, error) {
+	var dc *Channel
+	if existing != nil {
+		dc = existing.Channel
+	} else {
+		var err error
+		if dc, err = dst.CreateChannel(name); err != nil {
+			return nil, err
+		}
+	}
+	mc := &MigratedChannel{Name: name}
+	sms := src.Channel.Msgs
+	first, last, err := sms.FirstAndLastSequence()
+	if err != nil {
+		return nil, err
+	}
+	if mc.SrcMsgs, _, err = sms.State(); err != nil {
+		return nil, err
+	}
+	dstLast, err := dc.Msgs.LastSequence()
+	if err != nil {
+		return nil, err
+	}
+	start := first
+	if dstLast >= start {
+		start = dstLast + 1
+	}
+	for seq := start; first > 0 && seq <= last; seq++ {
+		m, err := sms.Lookup(seq)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			continue
+		}
+		// Wrapping stores may replace the payload of the stored
+		// message, so store a copy.
+		cm := *m
+		if _, err := dc.Msgs.Store(&cm); err != nil {
+			return nil, fmt.Errorf("unable to store message %v: %v", seq, err)
+		}
+		mc.Copied++
+	}
+	if err := dc.Msgs.Flush(); err != nil {
+		return nil, err
+	}
+
+	// A subscription that already exists may have been interrupted while
+	// its pending messages were added, so add them again.
+	dstSubs := make(map[string]uint64)
+	if existing != nil {
+		for _, rs := range existing.Subscriptions {
+			dstSubs[rs.Sub.AckInbox] = rs.Sub.ID
+		}
+	}
+	for _, rs := range src.Subscriptions {
+		subID, exists := dstSubs[rs.Sub.AckInbox]
+		if !exists {
+			// The destination store assigns its own subscription ID.
+			sub := *rs.Sub
+			if err := dc.Subs.CreateSub(&sub); err != nil {
+				return nil, fmt.Errorf("unable to create subscription %q: %v", rs.Sub.AckInbox, err)
+			}
+			subID = sub.ID
+			mc.Subs++
+		}
+		pending := make([]uint64, 0, len(rs.Pending))
+		for seq := range rs.Pending {
+			pending = append(pending, seq)
+		}
+		sort.Slice(pending, func(i, j int) bool { return pending[i] < pending[j] })
+		for _, seq := range pending {
+			if err := dc.Subs.AddSeqPending(subID, seq); err != nil {
+				return nil, fmt.Errorf("unable to add pending message %v to subscription %q: %v",
+					seq, rs.Sub.AckInbox, err)
+			}
+		}
+	}
+	if err := dc.Subs.Flush(); err != nil {
+		return nil, err
+	}
+
+	if mc.FirstSeq, mc.LastSeq, err = dc.Msgs.FirstAndLastSequence(); err != nil {
+		return nil, err
+	}
+	if mc.DstMsgs, _, err = dc.Msgs.State(); err != nil {
+		return nil, err
+	}
+	// A channel whose messages have all expired can't be migrated
+	// with its sequence, but then there is nothing to compare.
+	if mc.SrcMsgs > 0 && mc.LastSeq != last {
+		return nil, fmt.Errorf("last sequence is %v in destination, expected %v", mc.LastSeq, last)
+	}
+	if mc.DstMsgs > mc.SrcMsgs {
+		return nil, fmt.Errorf("destination has %v messages, source only %v", mc.DstMsgs, mc.SrcMsgs)
+	}
+	if opts.Verify {
+		if err := verifyMigratedMsgs(sms, dc.Msgs, mc.FirstSeq, mc.LastSeq); err != nil {
+			return nil, err
+		}
+	}
+	return mc, nil
+}
+
+// verifyMigratedMsgs checks that messages `first` to `last` of `dst` are
+// the same as in `src`.
+func verifyMigratedMsgs(src, dst MsgStore, first, last uint64) error {
+	for seq := first; first > 0 && seq <= last; seq++ {
+		dm, err := dst.Lookup(seq)
+		if err != nil {
+			return err
+		}
+		sm, err := src.Lookup(seq)
+		if err != nil {
+			return err
+		}
+		if (sm == nil) != (dm == nil) {
+			return fmt.Errorf("message %v is missing in one of the stores", seq)
+		}
+		if sm != nil && !sameMsg(sm, dm) {
+			return fmt.Errorf("message %v differs between the stores", seq)
+		}
+	}
+	return nil
+}
+
+func sameMsg(m1, m2 *pb.MsgProto) bool {
+	return m1.Sequence == m2.Sequence && m1.Subject == m2.Subject && m1.Reply == m2.Reply &&
+		m1.Timestamp == m2.Timestamp && bytes.Equal(m1.Data, m2.Data)
+}
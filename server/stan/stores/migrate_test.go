@@ -0,0 +1,206 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+	cleanupBoltDatastore(t)
+	defer cleanupBoltDatastore(t)
+
+	// Source is an encrypted FileStore.
+	fs := createDefaultFileStore(t)
+	defer fs.Close()
+	src, err := NewCryptoStore(fs, CryptoCipherAES, []byte("srckey"))
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer src.Close()
+	storeAddClient(t, src, "me", "hbInbox")
+	foo := storeCreateChannel(t, src, "foo")
+	for seq := uint64(1); seq <= 10; seq++ {
+		storeMsg(t, foo, "foo", seq, []byte(fmt.Sprintf("msg%d", seq)))
+	}
+	subID := storeSub(t, foo, "foo")
+	storeSubPending(t, foo, "foo", subID, 4, 5, 6)
+	storeCreateChannel(t, src, "empty")
+	src.Close()
+
+	openSrc := func() Store {
+		fs, err := NewFileStore(testLogger, testFSDefaultDatastore, &testDefaultStoreLimits)
+		if err != nil {
+			t.Fatalf("Error creating store: %v", err)
+		}
+		src, err := NewCryptoStore(fs, CryptoCipherAES, []byte("srckey"))
+		if err != nil {
+			t.Fatalf("Error creating store: %v", err)
+		}
+		return src
+	}
+	openDst := func(limits *StoreLimits) Store {
+		bs, err := NewBoltStore(testLogger, testBoltDefaultDatastore, limits)
+		if err != nil {
+			t.Fatalf("Error creating store: %v", err)
+		}
+		return bs
+	}
+
+	// Simulate an interrupted migration: the destination already has
+	// the client and some messages.
+	s := openSrc()
+	rs, err := s.Recover()
+	if err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	d := openDst(&testDefaultStoreLimits)
+	if err := d.Init(rs.Info); err != nil {
+		t.Fatalf("Error on init: %v", err)
+	}
+	storeAddClient(t, d, "me", "hbInbox")
+	dfoo := storeCreateChannel(t, d, "foo")
+	for seq := uint64(1); seq <= 3; seq++ {
+		m := *msgStoreLookup(t, rs.Channels["foo"].Channel.Msgs, seq)
+		if _, err := dfoo.Msgs.Store(&m); err != nil {
+			t.Fatalf("Error storing message: %v", err)
+		}
+	}
+	s.Close()
+	d.Close()
+
+	// Lower limits in destination: only the last 8 messages are kept.
+	limits := testDefaultStoreLimits
+	limits.MaxMsgs = 8
+	s = openSrc()
+	defer s.Close()
+	d = openDst(&limits)
+	defer d.Close()
+	report, err := Migrate(s, d, &MigrateOptions{Verify: true, Log: testLogger})
+	if err != nil {
+		t.Fatalf("Error on migrate: %v", err)
+	}
+	if report.Clients != 0 || len(report.Channels) != 2 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if mc := report.Channels[1]; mc.Name != "foo" || mc.Copied != 7 || mc.SrcMsgs != 10 ||
+		mc.DstMsgs != 8 || mc.FirstSeq != 3 || mc.LastSeq != 10 || mc.Subs != 1 {
+		t.Fatalf("Unexpected report for foo: %+v", mc)
+	}
+	s.Close()
+	d.Close()
+
+	// Migrating again should not change anything.
+	s = openSrc()
+	defer s.Close()
+	d = openDst(&limits)
+	defer d.Close()
+	report, err = Migrate(s, d, &MigrateOptions{Verify: true})
+	if err != nil {
+		t.Fatalf("Error on migrate: %v", err)
+	}
+	if mc := report.Channels[1]; mc.Copied != 0 || mc.DstMsgs != 8 || mc.Subs != 0 {
+		t.Fatalf("Unexpected report for foo: %+v", mc)
+	}
+	d.Close()
+
+	bs, state := openDefaultBoltStoreWithLimits(t, &limits)
+	defer bs.Close()
+	if len(state.Clients) != 1 || state.Clients[0].ID != "me" {
+		t.Fatalf("Unexpected clients: %v", state.Clients)
+	}
+	getRecoveredChannel(t, state, "empty")
+	ms := getRecoveredChannel(t, state, "foo").Msgs
+	for seq := uint64(3); seq <= 10; seq++ {
+		if m := msgStoreLookup(t, ms, seq); !bytes.Equal(m.Data, []byte(fmt.Sprintf("msg%d", seq))) {
+			t.Fatalf("Unexpected message %v: %q", seq, m.Data)
+		}
+	}
+	subs := getRecoveredSubs(t, state, "foo", 1)
+	if len(subs[0].Pending) != 3 || subs[0].Sub.LastSent != 6 {
+		t.Fatalf("Unexpected subscription: %v - %v", subs[0].Sub, subs[0].Pending)
+	}
+}
+
+func TestMigrateErrors(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+	cleanupBoltDatastore(t)
+	defer cleanupBoltDatastore(t)
+
+	limits := testDefaultStoreLimits
+	fs, err := NewFileStore(testLogger, testFSDefaultDatastore, &limits)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer fs.Close()
+	bs, err := NewBoltStore(testLogger, testBoltDefaultDatastore, &limits)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer bs.Close()
+	if _, err := Migrate(fs, bs, nil); err == nil {
+		t.Fatal("Expected error with empty source store")
+	}
+	fs.Close()
+	bs.Close()
+
+	fs = createDefaultFileStore(t)
+	defer fs.Close()
+	fs.Close()
+	bs = createDefaultBoltStore(t)
+	defer bs.Close()
+	info := testDefaultServerInfo
+	info.ClusterID = "other"
+	if err := bs.Init(&info); err != nil {
+		t.Fatalf("Error on init: %v", err)
+	}
+	bs.Close()
+
+	fs, _ = NewFileStore(testLogger, testFSDefaultDatastore, &limits)
+	defer fs.Close()
+	bs, _ = NewBoltStore(testLogger, testBoltDefaultDatastore, &limits)
+	defer bs.Close()
+	if _, err := Migrate(fs, bs, nil); err == nil {
+		t.Fatal("Expected error with different cluster IDs")
+	}
+	fs.Close()
+	bs.Close()
+
+	// Stores locked by another process can't be migrated.
+	fs, _ = NewFileStore(testLogger, testFSDefaultDatastore, &limits)
+	defer fs.Close()
+	bs, _ = NewBoltStore(testLogger, testBoltDefaultDatastore, &limits)
+	defer bs.Close()
+	if _, err := Migrate(&lockedStore{Store: fs}, bs, nil); err == nil || !strings.Contains(err.Error(), "source store is locked") {
+		t.Fatalf("Expected error about locked source store, got %v", err)
+	}
+	if _, err := Migrate(fs, &lockedStore{Store: bs}, nil); err == nil || !strings.Contains(err.Error(), "destination store is locked") {
+		t.Fatalf("Expected error about locked destination store, got %v", err)
+	}
+}
+
+// lockedStore is a store whose exclusive lock is held by another process.
+type lockedStore struct {
+	Store
+}
+
+func (ls *lockedStore) GetExclusiveLock() (bool, error) {
+	return false, nil
+}
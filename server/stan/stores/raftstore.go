@@ -95,19 +95,34 @@ func (s *RaftStore) Recover() (*RecoveredState, error) {
 			rc.Channel.Subs = s.replaceSubStore(channel, rc.Channel.Subs, maxSubID)
 			rc.Subscriptions = nil
 		}
-		state.Clients = nil
+		// Clients are restored from the Raft log, except for the
+		// compression marker that is owned by the stores.
+		var clients []*Client
+		for _, c := range state.Clients {
+			if c.ID == compressMarkerClientID {
+				clients = append(clients, c)
+			}
+		}
+		state.Clients = clients
 	}
 	return state, nil
 }
 
 // AddClient implements the Store interface
 func (s *RaftStore) AddClient(info *spb.ClientInfo) (*Client, error) {
+	// The compression marker is not replicated, so store it locally.
+	if info.ID == compressMarkerClientID {
+		return s.Store.AddClient(info)
+	}
 	// No need for storage
 	return &Client{*info}, nil
 }
 
 // DeleteClient implements the Store interface
 func (s *RaftStore) DeleteClient(clientID string) error {
+	if clientID == compressMarkerClientID {
+		return s.Store.DeleteClient(clientID)
+	}
 	// Make this a no-op
 	return nil
 }
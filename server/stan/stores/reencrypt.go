@@ -0,0 +1,343 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+	"github.com/kubemq-io/broker/server/stan/logger"
+	"github.com/kubemq-io/broker/server/stan/util"
+)
+
+// The nonce counter used when re-encrypting starts in the upper half of
+// the counter space, so that it does not overlap the one of the server,
+// which starts after the last sequence of the channel.
+const reEncryptNonceIndex = uint64(1) << 63
+
+// ReEncryptOptions are the options of a re-encryption pass.
+type ReEncryptOptions struct {
+	// The cipher, current key and old keys, as for NewCryptoStoreWithOldKeys.
+	// The keys are erased, and the environment variables of the CryptoStore
+	// take precedence.
+	Cipher  string
+	Key     []byte
+	OldKeys [][]byte
+	// The directory of the channel keys, see CryptoStoreOptions. Payloads of
+	// channels that have a key are re-encrypted with it, and keys wrapped
+	// with an old master key are wrapped again with the current one.
+	ChannelKeysDir string
+	// If set, progress is logged.
+	Log logger.Logger
+}
+
+// ReEncryptReport is the result of a re-encryption pass.
+type ReEncryptReport struct {
+	Channels    int // Number of channels
+	Msgs        int // Number of messages
+	ReEncrypted int // Number of messages that have been re-encrypted
+	Rewrapped   int // Number of channel keys that have been wrapped again
+}
+
+type reEncrypter struct {
+	code   byte
+	mkhs   []*cryptoKey
+	opts   *ReEncryptOptions
+	report *ReEncryptReport
+}
+
+func newReEncrypter(opts *ReEncryptOptions) (*reEncrypter, error) {
+	code, mkhs, err := createMasterKeyHashes(opts.Cipher, opts.Key, opts.OldKeys)
+	if err != nil {
+		return nil, err
+	}
+	eraseKeys(opts.Key, opts.OldKeys)
+	return &reEncrypter{code: code, mkhs: mkhs, opts: opts, report: &ReEncryptReport{}}, nil
+}
+
+// newChannelEDStore returns the EDStore to re-encrypt the payloads of
+// `channel`, after wrapping its key again if needed.
+func (r *reEncrypter) newChannelEDStore(channel string) (*EDStore, error) {
+	var dataKey *cryptoKey
+	if dir := r.opts.ChannelKeysDir; dir != "" {
+		rewrapped, err := rewrapChannelKey(dir, r.code, r.mkhs, channel)
+		if err != nil {
+			return nil, err
+		}
+		if rewrapped {
+			r.report.Rewrapped++
+			r.logf("Wrapped key of channel %q with the current key", channel)
+		}
+		if dataKey, err = loadChannelKey(dir, r.code, r.mkhs, channel); err != nil {
+			return nil, err
+		}
+	}
+	return newChannelEDStore(r.code, r.mkhs, dataKey, channel, reEncryptNonceIndex)
+}
+
+// reEncrypt decodes the message in `payload` and, if its data is not
+// encrypted with the current key, re-encrypts it and returns true.
+func (r *reEncrypter) reEncrypt(eds *EDStore, payload []byte) (*pb.MsgProto, bool, error) {
+	m := &pb.MsgProto{}
+	if err := m.Unmarshal(payload); err != nil {
+		return nil, false, err
+	}
+	r.report.Msgs++
+	if !eds.needsReEncryption(m.Data) {
+		return m, false, nil
+	}
+	dd, err := eds.Decrypt(nil, m.Data)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to decrypt message %v: %v", m.Sequence, err)
+	}
+	if m.Data, err = eds.Encrypt(nil, dd); err != nil {
+		return nil, false, err
+	}
+	r.report.ReEncrypted++
+	return m, true, nil
+}
+
+func (r *reEncrypter) logf(format string, args ...interface{}) {
+	if r.opts.Log != nil {
+		r.opts.Log.Noticef(format, args...)
+	}
+}
+
+// ReEncryptFileStore re-encrypts, with the current key, the payloads of the
+// messages of the FileStore in `rootDir` that have been encrypted with one
+// of the old keys, or before key IDs were recorded. Once done, the old keys
+// can be removed from the configuration. The server must not be running,
+// and the store should have been checked with VerifyFileStore first, since
+// a corrupted record stops the pass. `fsOptions` are needed for the CRC
+// polynomial. The lock file of the store is acquired first, and
+// ReEncryptFileStore fails if it is held by another process.
+func ReEncryptFileStore(rootDir string, opts *ReEncryptOptions, fsOptions ...FileStoreOption) (*ReEncryptReport, error) {
+	fsOpts := DefaultFileStoreOptions
+	for _, opt := range fsOptions {
+		if err := opt(&fsOpts); err != nil {
+			return nil, err
+		}
+	}
+	r, err := newReEncrypter(opts)
+	if err != nil {
+		return nil, err
+	}
+	lf, err := lockFileStoreDir(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	defer lf.Close()
+	// The verifier is used to read the data files and write index files.
+	v := &fileVerifier{rootDir: rootDir, opts: &VerifyOptions{Log: opts.Log}, crcTable: crc32.IEEETable, report: &VerifyReport{}}
+	if fsOpts.CRCPolynomial != int64(crc32.IEEE) {
+		v.crcTable = crc32.MakeTable(uint32(fsOpts.CRCPolynomial))
+	}
+	files, err := os.ReadDir(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		if err := r.reEncryptChannel(v, f.Name()); err != nil {
+			return nil, fmt.Errorf("channel %q: %v", f.Name(), err)
+		}
+	}
+	return r.report, nil
+}
+
+func (r *reEncrypter) reEncryptChannel(v *fileVerifier, channel string) error {
+	dir := filepath.Join(v.rootDir, channel)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	eds, err := r.newChannelEDStore(channel)
+	if err != nil {
+		return err
+	}
+	r.report.Channels++
+	count := r.report.ReEncrypted
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, msgFilesPrefix) || !strings.HasSuffix(name, datSuffix) {
+			continue
+		}
+		fseq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, msgFilesPrefix), datSuffix))
+		if err != nil {
+			continue
+		}
+		if err := r.reEncryptSlice(v, eds, channel, fseq); err != nil {
+			return err
+		}
+	}
+	r.logf("Re-encrypted %v message(s) of channel %q", r.report.ReEncrypted-count, channel)
+	return nil
+}
+
+// reEncryptSlice rewrites the data file of a slice if any of its messages
+// needs to be re-encrypted. Since the size of records may change, the
+// index file is rewritten too.
+func (r *reEncrypter) reEncryptSlice(v *fileVerifier, eds *EDStore, channel string, fseq int) error {
+	datName := filepath.Join(v.rootDir, channel, fmt.Sprintf("%s%v%s", msgFilesPrefix, fseq, datSuffix))
+	idxName := filepath.Join(v.rootDir, channel, fmt.Sprintf("%s%v%s", msgFilesPrefix, fseq, idxSuffix))
+	var (
+		recs    []*sliceRecord
+		changed bool
+		buf     []byte
+		out     bytes.Buffer
+	)
+	out.Write(make([]byte, 4))
+	util.ByteOrder.PutUint32(out.Bytes(), fileVersion)
+	bad, err := v.scanFile(datName, false, func(_ int64, _ recordType, payload []byte) error {
+		m, reEncrypted, err := r.reEncrypt(eds, payload)
+		if err != nil {
+			return err
+		}
+		changed = changed || reEncrypted
+		offset := int64(out.Len())
+		var size int
+		buf, size, err = writeRecord(&out, buf, recNoType, m, m.Size(), v.crcTable)
+		if err != nil {
+			return err
+		}
+		recs = append(recs, &sliceRecord{seq: m.Sequence, offset: offset, timestamp: m.Timestamp, size: size - recordHeaderSize})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%q: record at offset %v: %v", v.rel(datName), bad[0].offset, bad[0].err)
+	}
+	if !changed {
+		return nil
+	}
+	// The state saved for LazyRecovery would no longer match the slice.
+	if err := removeMsgsState(filepath.Dir(datName)); err != nil {
+		return err
+	}
+	if err := writeFileAtomically(datName, out.Bytes()); err != nil {
+		return fmt.Errorf("unable to rewrite data file %q: %v", datName, err)
+	}
+	return v.writeIndex(idxName, recs)
+}
+
+// ReEncryptSQLStore re-encrypts, with the current key, the payloads of the
+// messages of the SQL store that have been encrypted with one of the old
+// keys, or before key IDs were recorded. Each message is updated in its
+// own statement, so the pass can be interrupted and run again. The server
+// must not be running. The lock of the store is acquired first, and
+// ReEncryptSQLStore fails if it is held by another process.
+func ReEncryptSQLStore(driver, source string, opts *ReEncryptOptions) (*ReEncryptReport, error) {
+	r, err := newReEncrypter(opts)
+	if err != nil {
+		return nil, err
+	}
+	ls, err := lockSQLStore(driver, source, opts.Log)
+	if err != nil {
+		return nil, err
+	}
+	defer ls.Close()
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	// Used to adapt the statements to the driver.
+	v := &sqlVerifier{driver: driver}
+
+	type channel struct {
+		id   int64
+		name string
+	}
+	var channels []channel
+	rows, err := db.Query("SELECT id, name FROM Channels WHERE deleted=FALSE ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c channel
+		if err := rows.Scan(&c.id, &c.name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// The table does not exist before version 2 of the schema.
+	partitions, _ := sqlMsgsPartitions(db)
+
+	for _, c := range channels {
+		table := sqlMsgsTable(c.id, partitions[c.id])
+		eds, err := r.newChannelEDStore(c.name)
+		if err != nil {
+			return nil, err
+		}
+		r.report.Channels++
+		count := r.report.ReEncrypted
+		// Collect the messages first, since some drivers do not allow
+		// statements while rows are being read.
+		var msgs []*pb.MsgProto
+		rows, err := db.Query(v.stmt(sqlMsgsTableStmt("SELECT data FROM Messages WHERE id=? ORDER BY seq", table)), c.id)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var data []byte
+			if err := rows.Scan(&data); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			m, reEncrypted, err := r.reEncrypt(eds, data)
+			if err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("channel %q: %v", c.name, err)
+			}
+			if reEncrypted {
+				msgs = append(msgs, m)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			data, err := m.Marshal()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := db.Exec(v.stmt(sqlMsgsTableStmt("UPDATE Messages SET data=?, size=? WHERE id=? AND seq=?", table)),
+				data, len(data), c.id, m.Sequence); err != nil {
+				return nil, fmt.Errorf("channel %q: unable to update message %v: %v", c.name, m.Sequence, err)
+			}
+		}
+		r.logf("Re-encrypted %v message(s) of channel %q", r.report.ReEncrypted-count, c.name)
+	}
+	return r.report, nil
+}
@@ -0,0 +1,297 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// sqlMigration describes how to bring the schema from `version-1` to
+// `version`. Statements must be safe to execute on a database where
+// the operator already created the tables with the provided scripts.
+type sqlMigration struct {
+	version  int
+	desc     string
+	mysql    []string
+	postgres []string
+	sqlite   []string
+}
+
+// sqlMigrations is the ordered list of schema migrations. The version of
+// the last one must be sqlVersion. Never modify an existing entry, add
+// a new one instead.
+var sqlMigrations = []*sqlMigration{
+	{
+		version: 1,
+		desc:    "initial schema",
+		mysql: []string{
+			"CREATE TABLE IF NOT EXISTS ServerInfo (uniquerow INT DEFAULT 1, id VARCHAR(1024), proto BLOB, version INTEGER, PRIMARY KEY (uniquerow))",
+			"CREATE TABLE IF NOT EXISTS Clients (id VARCHAR(1024), hbinbox TEXT, proto BLOB, PRIMARY KEY (id(256)))",
+			"CREATE TABLE IF NOT EXISTS Channels (id INTEGER, name VARCHAR(1024) NOT NULL, maxseq BIGINT UNSIGNED DEFAULT 0, maxmsgs INTEGER DEFAULT 0, maxbytes BIGINT DEFAULT 0, maxage BIGINT DEFAULT 0, deleted BOOL DEFAULT FALSE, PRIMARY KEY (id), INDEX Idx_ChannelsName (name(256)))",
+			"CREATE TABLE IF NOT EXISTS Messages (id INTEGER, seq BIGINT UNSIGNED, timestamp BIGINT, size INTEGER, data BLOB, CONSTRAINT PK_MsgKey PRIMARY KEY(id, seq), INDEX Idx_MsgsTimestamp (timestamp))",
+			"CREATE TABLE IF NOT EXISTS Subscriptions (id INTEGER, subid BIGINT UNSIGNED, lastsent BIGINT UNSIGNED DEFAULT 0, proto BLOB, deleted BOOL DEFAULT FALSE, CONSTRAINT PK_SubKey PRIMARY KEY(id, subid))",
+			"CREATE TABLE IF NOT EXISTS SubsPending (subid BIGINT UNSIGNED, `row` BIGINT UNSIGNED, seq BIGINT UNSIGNED DEFAULT 0, lastsent BIGINT UNSIGNED DEFAULT 0, pending BLOB, acks BLOB, CONSTRAINT PK_MsgPendingKey PRIMARY KEY(subid, `row`), INDEX Idx_SubsPendingSeq(seq))",
+			"CREATE TABLE IF NOT EXISTS StoreLock (id VARCHAR(30), tick BIGINT UNSIGNED DEFAULT 0)",
+		},
+		postgres: []string{
+			"CREATE TABLE IF NOT EXISTS ServerInfo (uniquerow INTEGER DEFAULT 1, id VARCHAR(1024), proto BYTEA, version INTEGER, PRIMARY KEY (uniquerow))",
+			"CREATE TABLE IF NOT EXISTS Clients (id VARCHAR(1024), hbinbox TEXT, proto BYTEA, PRIMARY KEY (id))",
+			"CREATE TABLE IF NOT EXISTS Channels (id INTEGER, name VARCHAR(1024) NOT NULL, maxseq BIGINT DEFAULT 0, maxmsgs INTEGER DEFAULT 0, maxbytes BIGINT DEFAULT 0, maxage BIGINT DEFAULT 0, deleted BOOL DEFAULT FALSE, PRIMARY KEY (id))",
+			"CREATE INDEX IF NOT EXISTS Idx_ChannelsName ON Channels (name)",
+			"CREATE TABLE IF NOT EXISTS Messages (id INTEGER, seq BIGINT, timestamp BIGINT, size INTEGER, data BYTEA, CONSTRAINT PK_MsgKey PRIMARY KEY(id, seq))",
+			"CREATE INDEX IF NOT EXISTS Idx_MsgsTimestamp ON Messages (timestamp)",
+			"CREATE TABLE IF NOT EXISTS Subscriptions (id INTEGER, subid BIGINT, lastsent BIGINT DEFAULT 0, proto BYTEA, deleted BOOL DEFAULT FALSE, CONSTRAINT PK_SubKey PRIMARY KEY(id, subid))",
+			"CREATE TABLE IF NOT EXISTS SubsPending (subid BIGINT, row BIGINT, seq BIGINT DEFAULT 0, lastsent BIGINT DEFAULT 0, pending BYTEA, acks BYTEA, CONSTRAINT PK_MsgPendingKey PRIMARY KEY(subid, row))",
+			"CREATE INDEX IF NOT EXISTS Idx_SubsPendingSeq ON SubsPending (seq)",
+			"CREATE TABLE IF NOT EXISTS StoreLock (id VARCHAR(30), tick BIGINT DEFAULT 0)",
+		},
+		sqlite: []string{
+			"CREATE TABLE IF NOT EXISTS ServerInfo (uniquerow INT DEFAULT 1, id VARCHAR(1024), proto BLOB, version INTEGER, PRIMARY KEY (uniquerow))",
+			"CREATE TABLE IF NOT EXISTS Clients (id VARCHAR(1024), hbinbox TEXT, proto BLOB, PRIMARY KEY (id))",
+			"CREATE TABLE IF NOT EXISTS Channels (id INTEGER, name VARCHAR(1024) NOT NULL, maxseq BIGINT DEFAULT 0, maxmsgs INTEGER DEFAULT 0, maxbytes BIGINT DEFAULT 0, maxage BIGINT DEFAULT 0, deleted BOOL DEFAULT FALSE, PRIMARY KEY (id))",
+			"CREATE INDEX IF NOT EXISTS Idx_ChannelsName ON Channels (name)",
+			"CREATE TABLE IF NOT EXISTS Messages (id INTEGER, seq BIGINT, timestamp BIGINT, size INTEGER, data BLOB, CONSTRAINT PK_MsgKey PRIMARY KEY(id, seq))",
+			"CREATE INDEX IF NOT EXISTS Idx_MsgsTimestamp ON Messages (timestamp)",
+			"CREATE TABLE IF NOT EXISTS Subscriptions (id INTEGER, subid BIGINT, lastsent BIGINT DEFAULT 0, proto BLOB, deleted BOOL DEFAULT FALSE, CONSTRAINT PK_SubKey PRIMARY KEY(id, subid))",
+			"CREATE TABLE IF NOT EXISTS SubsPending (subid BIGINT, `row` BIGINT, seq BIGINT DEFAULT 0, lastsent BIGINT DEFAULT 0, pending BLOB, acks BLOB, CONSTRAINT PK_MsgPendingKey PRIMARY KEY(subid, `row`))",
+			"CREATE INDEX IF NOT EXISTS Idx_SubsPendingSeq ON SubsPending (seq)",
+			"CREATE TABLE IF NOT EXISTS StoreLock (id VARCHAR(30), tick BIGINT DEFAULT 0)",
+		},
+	},
+	{
+		version: 2,
+		desc:    "messages partitions",
+		mysql: []string{
+			"CREATE TABLE IF NOT EXISTS MsgsPartitions (id INTEGER, PRIMARY KEY (id))",
+		},
+		postgres: []string{
+			"CREATE TABLE IF NOT EXISTS MsgsPartitions (id INTEGER, PRIMARY KEY (id))",
+		},
+		sqlite: []string{
+			"CREATE TABLE IF NOT EXISTS MsgsPartitions (id INTEGER, PRIMARY KEY (id))",
+		},
+	},
+}
+
+// sqlMsgsTable returns the name of the table holding the messages of the
+// channel `id`, depending on whether the channel has its own partition.
+func sqlMsgsTable(id int64, partitioned bool) string {
+	if !partitioned {
+		return "Messages"
+	}
+	return fmt.Sprintf("Messages_%d", id)
+}
+
+// sqlMsgsTableStmt returns `stmt`, written for the Messages table, for
+// the table `table`.
+func sqlMsgsTableStmt(stmt, table string) string {
+	if table == "Messages" {
+		return stmt
+	}
+	return strings.Replace(stmt, "Messages", table, -1)
+}
+
+// sqlMsgsPartitionStmts returns the statements creating the partition
+// table `table`. It has the same columns as the Messages table so that
+// rows can be moved between the two.
+func sqlMsgsPartitionStmts(driver, table string) []string {
+	switch driver {
+	case driverPostgres:
+		return []string{
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER, seq BIGINT, timestamp BIGINT, size INTEGER, data BYTEA, CONSTRAINT PK_%s PRIMARY KEY(id, seq))", table, table),
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS Idx_%s_Timestamp ON %s (timestamp)", table, table),
+		}
+	case driverSQLite:
+		return []string{
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER, seq BIGINT, timestamp BIGINT, size INTEGER, data BLOB, CONSTRAINT PK_%s PRIMARY KEY(id, seq))", table, table),
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS Idx_%s_Timestamp ON %s (timestamp)", table, table),
+		}
+	}
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER, seq BIGINT UNSIGNED, timestamp BIGINT, size INTEGER, data BLOB, CONSTRAINT PK_MsgKey PRIMARY KEY(id, seq), INDEX Idx_MsgsTimestamp (timestamp))", table),
+	}
+}
+
+// sqlMsgsPartitions returns the IDs of the channels whose messages are
+// stored in their own table.
+func sqlMsgsPartitions(db *sql.DB) (map[int64]bool, error) {
+	rows, err := db.Query(sqlStmts[sqlRecoverMsgsPartitions])
+	if err != nil {
+		return nil, sqlStmtError(sqlRecoverMsgsPartitions, err)
+	}
+	defer rows.Close()
+	parts := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		parts[id] = true
+	}
+	return parts, rows.Err()
+}
+
+// SQLMigration is a schema migration as reported by SQLSchemaMigrations.
+type SQLMigration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+// SQLSchemaMigrations returns the schema version of the database pointed
+// to by `driver` and `source` and the migrations that NewSQLStore would
+// run to bring it to the current version. Nothing is executed.
+func SQLSchemaMigrations(driver, source string) (int, []*SQLMigration, error) {
+	initSQLStmts.Do(func() { initSQLStmtsTable(driver) })
+	db, err := openSQLDB(driver, source)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer db.Close()
+	version, _, err := sqlSchemaVersion(db, driver)
+	if err != nil {
+		return 0, nil, err
+	}
+	var migrations []*SQLMigration
+	for _, m := range sqlMigrations {
+		if m.version <= version {
+			continue
+		}
+		migrations = append(migrations, &SQLMigration{
+			Version:     m.version,
+			Description: m.desc,
+			Statements:  m.stmts(driver),
+		})
+	}
+	return version, migrations, nil
+}
+
+func (m *sqlMigration) stmts(driver string) []string {
+	switch driver {
+	case driverPostgres:
+		return m.postgres
+	case driverSQLite:
+		return m.sqlite
+	}
+	return m.mysql
+}
+
+// sqlCreateStoreLockTable creates the StoreLock table, which is part of
+// the initial schema but needs to exist before the store lock can be
+// acquired to run the migrations.
+var sqlCreateStoreLockTable = map[string]string{
+	driverMySQL:    "CREATE TABLE IF NOT EXISTS StoreLock (id VARCHAR(30), tick BIGINT UNSIGNED DEFAULT 0)",
+	driverPostgres: "CREATE TABLE IF NOT EXISTS StoreLock (id VARCHAR(30), tick BIGINT DEFAULT 0)",
+	driverSQLite:   "CREATE TABLE IF NOT EXISTS StoreLock (id VARCHAR(30), tick BIGINT DEFAULT 0)",
+}
+
+// sqlSchemaVersion returns the version recorded in the ServerInfo table,
+// 0 if there is no such table or row, and whether the row exists.
+// Returns an error if the schema is newer than what this server supports,
+// or if the version could not be read for any other reason.
+func sqlSchemaVersion(db *sql.DB, driver string) (int, bool, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow(sqlStmts[sqlGetSchemaVersion]).Scan(&version); err != nil {
+		// Either the table does not exist yet or it is empty. In both
+		// cases, running the migrations from the start is safe.
+		if err == sql.ErrNoRows || isSQLNoSuchTableErr(driver, err) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("sql: unable to read schema version: %v", err)
+	}
+	v := int(version.Int64)
+	if v > sqlVersion {
+		return 0, true, fmt.Errorf("sql: schema version %v is newer than the supported version %v", v, sqlVersion)
+	}
+	return v, true, nil
+}
+
+// isSQLNoSuchTableErr returns true if `err` is the error reported by
+// `driver` when querying a table that does not exist.
+func isSQLNoSuchTableErr(driver string, err error) bool {
+	msg := err.Error()
+	switch driver {
+	case driverMySQL:
+		// Error 1146 (42S02): Table 'db.ServerInfo' doesn't exist
+		return strings.Contains(msg, "1146") && strings.Contains(msg, "doesn't exist")
+	case driverPostgres:
+		// pq: relation "serverinfo" does not exist
+		return strings.Contains(msg, "relation") && strings.Contains(msg, "does not exist")
+	case driverSQLite:
+		return strings.Contains(msg, "no such table")
+	}
+	return false
+}
+
+// migrateSchema creates the missing tables and runs the migrations needed
+// to bring the schema to sqlVersion. The version is recorded after each
+// migration so that an interrupted upgrade resumes where it stopped.
+// Migrations are run only once the store lock has been acquired, so
+// that the schema is never modified under another server.
+func (s *SQLStore) migrateSchema(driver string) error {
+	version, hasRow, err := sqlSchemaVersion(s.db, driver)
+	if err != nil {
+		return err
+	}
+	if s.opts.NoMigration {
+		// A version of 0 means that the tables were created by the operator
+		// but the server has not been initialized yet.
+		if version != 0 && version < sqlVersion {
+			return fmt.Errorf("sql: schema version %v needs to be migrated to version %v", version, sqlVersion)
+		}
+		return nil
+	}
+	if version == sqlVersion {
+		return nil
+	}
+	if _, err := s.db.Exec(sqlCreateStoreLockTable[driver]); err != nil {
+		return fmt.Errorf("sql: error creating store lock table: %v", err)
+	}
+	locked, err := s.GetExclusiveLock()
+	if err != nil {
+		return fmt.Errorf("sql: unable to acquire the store lock to migrate the schema: %v", err)
+	}
+	if !locked {
+		return fmt.Errorf("sql: schema version %v needs to be migrated to version %v, but the store is locked by another process", version, sqlVersion)
+	}
+	// The schema may have been migrated while waiting for the lock.
+	if version, hasRow, err = sqlSchemaVersion(s.db, driver); err != nil {
+		return err
+	}
+	for _, m := range sqlMigrations {
+		if m.version <= version {
+			continue
+		}
+		s.log.Noticef("SQL: migrating schema to version %v (%s)", m.version, m.desc)
+		for _, stmt := range m.stmts(driver) {
+			if _, err := s.db.Exec(stmt); err != nil {
+				return fmt.Errorf("sql: error migrating schema to version %v, executing %q: %v", m.version, stmt, err)
+			}
+		}
+		// The ServerInfo table may just have been created. The row added here
+		// has no proto and is replaced on Init().
+		if !hasRow {
+			_, err = s.db.Exec(sqlStmts[sqlAddSchemaVersion], "", m.version)
+			hasRow = err == nil
+		} else {
+			_, err = s.db.Exec(sqlStmts[sqlUpdateSchemaVersion], m.version)
+		}
+		if err != nil {
+			return fmt.Errorf("sql: error recording schema version %v: %v", m.version, err)
+		}
+	}
+	return nil
+}
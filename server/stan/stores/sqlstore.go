@@ -33,6 +33,7 @@ import (
 const (
 	driverMySQL    = "mysql"
 	driverPostgres = "postgres"
+	driverSQLite   = "sqlite3"
 )
 
 const (
@@ -47,6 +48,7 @@ const (
 	sqlAddChannel
 	sqlStoreMsg
 	sqlLookupMsg
+	sqlLookupMsgRange
 	sqlGetSequenceFromTimestamp
 	sqlUpdateChannelMaxSeq
 	sqlGetExpiredMessages
@@ -90,6 +92,12 @@ const (
 	sqlDeleteChannelDelSomeMessages
 	sqlDeleteChannelDelChannel
 	sqlGetLastSeq
+	sqlGetSchemaVersion
+	sqlAddSchemaVersion
+	sqlUpdateSchemaVersion
+	sqlAddMsgsPartition
+	sqlDeleteMsgsPartition
+	sqlRecoverMsgsPartitions
 )
 
 var sqlStmts = []string{
@@ -104,6 +112,7 @@ var sqlStmts = []string{
 	"INSERT INTO Channels (id, name, maxmsgs, maxbytes, maxage) VALUES (?, ?, ?, ?, ?)",                          // sqlAddChannel
 	"INSERT INTO Messages VALUES (?, ?, ?, ?, ?)",                                                                // sqlStoreMsg
 	"SELECT timestamp, data FROM Messages WHERE id=? AND seq=?",                                                  // sqlLookupMsg
+	"SELECT seq, timestamp, data FROM Messages WHERE id=? AND seq>=? AND seq<=? ORDER BY seq",                    // sqlLookupMsgRange
 	"SELECT seq FROM Messages WHERE id=? AND timestamp>=? ORDER BY seq LIMIT 1",                                  // sqlGetSequenceFromTimestamp
 	"UPDATE Channels SET maxseq=? WHERE id=?",                                                                    // sqlUpdateChannelMaxSeq
 	"SELECT COUNT(seq), COALESCE(MAX(seq), 0), COALESCE(SUM(size), 0) FROM Messages WHERE id=? AND timestamp<=?", // sqlGetExpiredMessages
@@ -147,13 +156,41 @@ var sqlStmts = []string{
 	"DELETE FROM Messages WHERE id=? AND seq<=?",                                                                                                                   // sqlDeleteChannelDelSomeMessages
 	"DELETE FROM Channels WHERE id=?",                                                                                                                              // sqlDeleteChannelDelChannel
 	"SELECT COALESCE(MAX(seq), 0) FROM Messages WHERE id=?",                                                                                                        // sqlGetLastSeq
+	"SELECT version FROM ServerInfo WHERE uniquerow=1",                                                                                                             // sqlGetSchemaVersion
+	"INSERT INTO ServerInfo (id, version) VALUES (?, ?)",                                                                                                           // sqlAddSchemaVersion
+	"UPDATE ServerInfo SET version=? WHERE uniquerow=1",                                                                                                            // sqlUpdateSchemaVersion
+	"INSERT INTO MsgsPartitions (id) VALUES (?)",                                                                                                                   // sqlAddMsgsPartition
+	"DELETE FROM MsgsPartitions WHERE id=?",                                                                                                                        // sqlDeleteMsgsPartition
+	"SELECT id FROM MsgsPartitions",                                                                                                                                // sqlRecoverMsgsPartitions
+}
+
+// sqlMsgsStmts are the statements on the Messages table. If the messages of
+// a channel are partitioned, they are prepared for the channel's table.
+var sqlMsgsStmts = []int{
+	sqlStoreMsg,
+	sqlLookupMsg,
+	sqlLookupMsgRange,
+	sqlGetSequenceFromTimestamp,
+	sqlGetExpiredMessages,
+	sqlGetFirstMsgTimestamp,
+	sqlDeletedMsgsWithSeqLowerThan,
+	sqlGetSizeOfMessage,
+	sqlDeleteMessage,
+	sqlRecoverChannelMsgs,
+	sqlRecoverDoExpireMsgs,
+	sqlRecoverGetMessagesCount,
+	sqlRecoverGetSeqFloorForMaxMsgs,
+	sqlRecoverGetChannelTotalSize,
+	sqlRecoverGetSeqFloorForMaxBytes,
+	sqlGetLastSeq,
 }
 
 var initSQLStmts = sync.Once{}
 
 const (
 	// This is to detect changes in the tables, etc...
-	sqlVersion = 1
+	// It must match the version of the last entry in sqlMigrations.
+	sqlVersion = 2
 
 	// If any of the SQL queries fail when finding out messages that
 	// need to be expired, use this as the default retry interval
@@ -189,6 +226,10 @@ const (
 	// If bulk insert limit is set, the server will still insert messages
 	// using tx if the limit is below this threshold.
 	sqlMinBulkInsertLimit = 5
+
+	// SQLite versions before 3.32 limit the number of parameters of a
+	// statement to 999, which is 199 messages in a bulk insert.
+	sqlSQLiteMaxBulkInsertLimit = 199
 )
 
 // These are initialized based on the constants that have reasonable values.
@@ -204,6 +245,7 @@ var (
 	sqlLockLostCount             = sqlDefaultLockLostCount
 	sqlNoPanic                   = false // Used in tests to avoid go-routine to panic
 	sqlMsgCacheLimit             = sqlDefaultMsgCacheLimit
+	sqlReadCacheWindows          = 4
 )
 
 // SQLStoreOptions are used to configure the SQL Store.
@@ -228,6 +270,31 @@ type SQLStoreOptions struct {
 	// If <= 0, then there is no limit on the number of open connections.
 	// The default is 0 (unlimited).
 	MaxOpenConns int
+
+	// By default, the store creates the missing tables and migrates the
+	// schema to the version supported by this server. The migration runs
+	// only after acquiring the store lock, and fails if the lock is held
+	// by another server. Set this to `true` if the schema is managed by
+	// the operator.
+	NoMigration bool
+
+	// If this is greater than 1, a lookup of a message that is not cached
+	// fetches this number of messages, starting at the requested sequence,
+	// with a single query. Fetched messages are kept in a per channel cache,
+	// bounded to a few times this number of messages, so that subscribers
+	// of the same channel catching up do not each query the database.
+	ReadAhead int
+
+	// If this is set to `true`, the messages of each channel are stored in
+	// their own table instead of the shared Messages table, so that deleting
+	// a channel drops its table instead of deleting its rows. This only
+	// applies to channel deletion: the tables are not partitioned by
+	// sequence or time, so messages removed due to limits (including
+	// expiration) are still deleted row by row, as with the Messages table,
+	// but only in the table of their channel. Statements on these tables are
+	// not prepared. Existing channels are moved to, or back from, their own
+	// table on startup.
+	PartitionMessages bool
 }
 
 // DefaultSQLStoreOptions returns default store options for an SQL Store
@@ -265,6 +332,30 @@ func SQLMaxOpenConns(max int) SQLStoreOption {
 	}
 }
 
+// SQLNoMigration sets the NoMigration option
+func SQLNoMigration(noMigration bool) SQLStoreOption {
+	return func(o *SQLStoreOptions) error {
+		o.NoMigration = noMigration
+		return nil
+	}
+}
+
+// SQLReadAhead sets the ReadAhead option
+func SQLReadAhead(readAhead int) SQLStoreOption {
+	return func(o *SQLStoreOptions) error {
+		o.ReadAhead = readAhead
+		return nil
+	}
+}
+
+// SQLPartitionMessages sets the PartitionMessages option
+func SQLPartitionMessages(partition bool) SQLStoreOption {
+	return func(o *SQLStoreOptions) error {
+		o.PartitionMessages = partition
+		return nil
+	}
+}
+
 // SQLAllOptions is a convenient option to pass all options from a SQLStoreOptions
 // structure to the constructor.
 func SQLAllOptions(opts *SQLStoreOptions) SQLStoreOption {
@@ -272,6 +363,9 @@ func SQLAllOptions(opts *SQLStoreOptions) SQLStoreOption {
 		o.NoCaching = opts.NoCaching
 		o.MaxOpenConns = opts.MaxOpenConns
 		o.BulkInsertLimit = opts.BulkInsertLimit
+		o.NoMigration = opts.NoMigration
+		o.ReadAhead = opts.ReadAhead
+		o.PartitionMessages = opts.PartitionMessages
 		return nil
 	}
 }
@@ -293,7 +387,9 @@ type SQLStore struct {
 	wg            sync.WaitGroup
 	preparedStmts []*sql.Stmt
 	ssFlusher     *subStoresFlusher
+	driver        string
 	postgres      bool
+	sqlite        bool
 	bulkInserts   []string
 }
 
@@ -348,8 +444,12 @@ type sqlSubsPendingRow struct {
 // SQLMsgStore is a per channel message store backed by an SQL Database
 type SQLMsgStore struct {
 	genericMsgStore
-	channelID   int64
-	sqlStore    *SQLStore // Reference to "parent" store
+	channelID int64
+	sqlStore  *SQLStore // Reference to "parent" store
+	table     string    // Table holding the messages of this channel
+	// Text of the statements on the table of this channel, if it is not
+	// the Messages table, indexed by statement code.
+	queries     map[int]string
 	expireTimer *time.Timer
 	fTimestamp  int64
 	wg          sync.WaitGroup
@@ -360,6 +460,10 @@ type SQLMsgStore struct {
 	// The cache is also used in Lookup since messages may not be yet in the
 	// database.
 	writeCache *sqlMsgsCache
+
+	// If option ReadAhead is set, messages fetched from the database are
+	// cached here to serve subsequent lookups.
+	readCache *sqlReadCache
 }
 
 type sqlMsgsCache struct {
@@ -376,6 +480,17 @@ type sqlCachedMsg struct {
 	next *sqlCachedMsg
 }
 
+type sqlReadCache struct {
+	msgs    map[uint64]*pb.MsgProto
+	windows []sqlReadWindow // In the order they were fetched
+	limit   int
+}
+
+type sqlReadWindow struct {
+	first uint64
+	last  uint64
+}
+
 // sqlStmtError returns an error including the text of the offending SQL statement.
 func sqlStmtError(code int, err error) error {
 	return fmt.Errorf("sql: error executing %q: %v", sqlStmts[code], err)
@@ -404,18 +519,14 @@ var sqlSeqArrayPool = &sync.Pool{
 // DefaultStoreLimits.
 func NewSQLStore(log logger.Logger, driver, source string, limits *StoreLimits, options ...SQLStoreOption) (*SQLStore, error) {
 	initSQLStmts.Do(func() { initSQLStmtsTable(driver) })
-	realDriver := driver
-	if driver == driverPostgres {
-		realDriver = "pq-deadlines"
+	// Each connection of the pool would have its own database.
+	if driver == driverSQLite && (source == "" || source == ":memory:") {
+		return nil, fmt.Errorf("sql: SQLite source must be a database file")
 	}
-	db, err := sql.Open(realDriver, source)
+	db, err := openSQLDB(driver, source)
 	if err != nil {
 		return nil, err
 	}
-	if err := db.Ping(); err != nil {
-		db.Close()
-		return nil, err
-	}
 	// Start with empty options
 	opts := DefaultSQLStoreOptions()
 	// And apply whatever is given to us as options.
@@ -430,12 +541,23 @@ func NewSQLStore(log logger.Logger, driver, source string, limits *StoreLimits,
 		db:            db,
 		doneCh:        make(chan struct{}),
 		preparedStmts: make([]*sql.Stmt, 0, len(sqlStmts)),
+		driver:        driver,
 		postgres:      driver == driverPostgres,
+		sqlite:        driver == driverSQLite,
 	}
 	if err := s.init(TypeSQL, log, limits); err != nil {
 		s.Close()
 		return nil, err
 	}
+	// With the default rollback journal, writers wait for all readers and
+	// recovery, which deletes rows while iterating others, would fail.
+	// This setting is persisted in the database file.
+	if s.sqlite {
+		if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
 	if s.postgres && opts.BulkInsertLimit > 0 {
 		limit := opts.BulkInsertLimit
 		s.bulkInserts = make([]string, limit)
@@ -444,6 +566,11 @@ func NewSQLStore(log logger.Logger, driver, source string, limits *StoreLimits,
 			s.bulkInserts[i] = fmt.Sprintf("($%d,$%d,$%d,$%d,$%d)", j+1, j+2, j+3, j+4, j+5)
 		}
 	}
+	// Tables need to exist before statements can be prepared.
+	if err := s.migrateSchema(driver); err != nil {
+		s.Close()
+		return nil, err
+	}
 	if err := s.createPreparedStmts(); err != nil {
 		s.Close()
 		return nil, err
@@ -463,6 +590,23 @@ func NewSQLStore(log logger.Logger, driver, source string, limits *StoreLimits,
 	return s, nil
 }
 
+// openSQLDB opens and checks the connection to the database.
+func openSQLDB(driver, source string) (*sql.DB, error) {
+	realDriver := driver
+	if driver == driverPostgres {
+		realDriver = "pq-deadlines"
+	}
+	db, err := sql.Open(realDriver, source)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
 // GetExclusiveLock implements the Store interface
 func (s *SQLStore) GetExclusiveLock() (bool, error) {
 	s.Lock()
@@ -686,16 +830,100 @@ func (s *SQLStore) scheduleSubStoreFlush(ss *SQLSubStore) {
 }
 
 // creates an instance of a SQLMsgStore
-func (s *SQLStore) newSQLMsgStore(channel string, channelID int64, limits *MsgStoreLimits) *SQLMsgStore {
+func (s *SQLStore) newSQLMsgStore(channel string, channelID int64, limits *MsgStoreLimits, partitioned bool) (*SQLMsgStore, error) {
 	msgStore := &SQLMsgStore{
 		sqlStore:  s,
 		channelID: channelID,
+		table:     sqlMsgsTable(channelID, partitioned),
+	}
+	if partitioned {
+		msgStore.queries = make(map[int]string, len(sqlMsgsStmts))
+		for _, code := range sqlMsgsStmts {
+			msgStore.queries[code] = sqlMsgsTableStmt(sqlStmts[code], msgStore.table)
+		}
 	}
 	msgStore.init(channel, s.log, limits)
 	if !s.opts.NoCaching {
 		msgStore.writeCache = &sqlMsgsCache{msgs: make(map[uint64]*sqlCachedMsg)}
 	}
-	return msgStore
+	if s.opts.ReadAhead > 1 {
+		msgStore.readCache = &sqlReadCache{
+			msgs:  make(map[uint64]*pb.MsgProto),
+			limit: s.opts.ReadAhead * sqlReadCacheWindows,
+		}
+	}
+	return msgStore, nil
+}
+
+// createMsgsPartition creates the table holding the messages of the new
+// channel `channelID`. A table left by a previous channel with the same ID
+// is replaced.
+func (s *SQLStore) createMsgsPartition(channelID int64) error {
+	table := sqlMsgsTable(channelID, true)
+	if _, err := s.db.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+		return fmt.Errorf("sql: unable to drop table %q: %v", table, err)
+	}
+	for _, stmt := range sqlMsgsPartitionStmts(s.driver, table) {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return fmt.Errorf("sql: error executing %q: %v", stmt, err)
+		}
+	}
+	if _, err := s.preparedStmts[sqlDeleteMsgsPartition].Exec(channelID); err != nil {
+		return sqlStmtError(sqlDeleteMsgsPartition, err)
+	}
+	if _, err := s.preparedStmts[sqlAddMsgsPartition].Exec(channelID); err != nil {
+		return sqlStmtError(sqlAddMsgsPartition, err)
+	}
+	return nil
+}
+
+// moveMsgsPartition moves the messages of the channel `channelID` to its
+// own table if `partition` is true, or back to the Messages table otherwise.
+// Messages are moved in a transaction that also records the new location.
+func (s *SQLStore) moveMsgsPartition(channelID int64, partition bool) error {
+	table := sqlMsgsTable(channelID, true)
+	from, to := "Messages", table
+	if partition {
+		for _, stmt := range sqlMsgsPartitionStmts(s.driver, table) {
+			if _, err := s.db.Exec(stmt); err != nil {
+				return fmt.Errorf("sql: error executing %q: %v", stmt, err)
+			}
+		}
+	} else {
+		from, to = table, "Messages"
+	}
+	s.log.Noticef("SQL: moving messages of channel ID %v from table %q to %q", channelID, from, to)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := []string{
+		fmt.Sprintf("DELETE FROM %s WHERE id=?", to),
+		fmt.Sprintf("INSERT INTO %s (id, seq, timestamp, size, data) SELECT id, seq, timestamp, size, data FROM %s WHERE id=?", to, from),
+		fmt.Sprintf("DELETE FROM %s WHERE id=?", from),
+		sqlStmts[sqlDeleteMsgsPartition],
+	}
+	if partition {
+		stmts[3] = sqlStmts[sqlAddMsgsPartition]
+	}
+	for _, stmt := range stmts {
+		if s.postgres {
+			stmt = strings.Replace(stmt, "?", "$1", 1)
+		}
+		if _, err := tx.Exec(stmt, channelID); err != nil {
+			return fmt.Errorf("sql: error executing %q: %v", stmt, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if !partition {
+		if _, err := s.db.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			s.log.Errorf("SQL: unable to drop table %q: %v", table, err)
+		}
+	}
+	return nil
 }
 
 // creates an instance of SQLSubStore
@@ -753,6 +981,12 @@ func initSQLStmtsTable(driver string) {
 		// So the default SQL statement is specific to MySQL and uses variables.
 		// For Postgres, replace with this statement:
 		sqlStmts[sqlRecoverGetSeqFloorForMaxBytes] = "SELECT COALESCE(MIN(seq), 0) FROM (SELECT seq, SUM(size) OVER (PARTITION BY id ORDER BY seq DESC) AS total FROM Messages WHERE id=$1)t WHERE t.total<=$2"
+	} else if driver == driverSQLite {
+		// SQLite does not support SELECT ... FOR UPDATE. An UPDATE takes the
+		// database write lock for the rest of the transaction, so that
+		// two stores can't both see the lock as free.
+		sqlStmts[sqlDBLockSelect] = "UPDATE StoreLock SET tick=tick RETURNING id, tick"
+		sqlStmts[sqlRecoverGetSeqFloorForMaxBytes] = "SELECT COALESCE(MIN(seq), 0) FROM (SELECT seq, SUM(size) OVER (PARTITION BY id ORDER BY seq DESC) AS total FROM Messages WHERE id=?)t WHERE t.total<=?"
 	}
 }
 
@@ -789,21 +1023,21 @@ func (s *SQLStore) Recover() (*RecoveredState, error) {
 		err       error
 	)
 	r := s.db.QueryRow(sqlStmts[sqlRecoverServerInfo])
-	if err := r.Scan(&clusterID, &data, &version); err != nil {
-		// If there is no row, that means nothing to recover. Return nil for the
-		// state and no error.
-		if err == sql.ErrNoRows {
-			// If there are channels, we should return an error.
-			var maxChannelID int64
-			r := s.db.QueryRow(sqlStmts[sqlRecoverMaxChannelID])
-			r.Scan(&maxChannelID)
-			if maxChannelID > 0 {
-				return nil, ErrNoSrvButChannels
-			}
-			return nil, nil
-		}
+	if err := r.Scan(&clusterID, &data, &version); err != nil && err != sql.ErrNoRows {
 		return nil, sqlStmtError(sqlRecoverServerInfo, err)
 	}
+	// If there is no row, or only the one recording the schema version,
+	// that means nothing to recover. Return nil for the state and no error.
+	if len(data) == 0 {
+		// If there are channels, we should return an error.
+		var maxChannelID int64
+		r := s.db.QueryRow(sqlStmts[sqlRecoverMaxChannelID])
+		r.Scan(&maxChannelID)
+		if maxChannelID > 0 {
+			return nil, ErrNoSrvButChannels
+		}
+		return nil, nil
+	}
 	if version != sqlVersion {
 		return nil, fmt.Errorf("sql: unsupported version: %v (supports [1..%v])", version, sqlVersion)
 	}
@@ -865,6 +1099,11 @@ func (s *SQLStore) Recover() (*RecoveredState, error) {
 		return nil, sqlStmtError(sqlRecoverMaxSubID, err)
 	}
 
+	partitions, err := sqlMsgsPartitions(s.db)
+	if err != nil {
+		return nil, err
+	}
+
 	// Recover individual channels
 	var channels map[string]*RecoveredChannel
 	channelRows, err := s.db.Query(sqlStmts[sqlRecoverChannelsList])
@@ -885,10 +1124,20 @@ func (s *SQLStore) Recover() (*RecoveredState, error) {
 
 		channelLimits := s.genericStore.getChannelLimits(name)
 
-		msgStore := s.newSQLMsgStore(name, channelID, &channelLimits.MsgStoreLimits)
+		partitioned := partitions[channelID]
+		if partitioned != s.opts.PartitionMessages {
+			if err := s.moveMsgsPartition(channelID, s.opts.PartitionMessages); err != nil {
+				return nil, err
+			}
+			partitioned = s.opts.PartitionMessages
+		}
+		msgStore, err := s.newSQLMsgStore(name, channelID, &channelLimits.MsgStoreLimits, partitioned)
+		if err != nil {
+			return nil, err
+		}
 
 		// We need to get the last seq from messages table before possibly expiring messages.
-		r = s.preparedStmts[sqlGetLastSeq].QueryRow(channelID)
+		r = msgStore.queryRow(sqlGetLastSeq, channelID)
 		if err := r.Scan(&mmseq); err != nil {
 			return nil, sqlStmtError(sqlGetLastSeq, err)
 		}
@@ -901,7 +1150,7 @@ func (s *SQLStore) Recover() (*RecoveredState, error) {
 			return nil, err
 		}
 
-		r = s.preparedStmts[sqlRecoverChannelMsgs].QueryRow(channelID)
+		r = msgStore.queryRow(sqlRecoverChannelMsgs, channelID)
 		var (
 			totalCount    int
 			first         uint64
@@ -1055,7 +1304,7 @@ func (s *SQLStore) applyLimitsOnRecovery(ms *SQLMsgStore) error {
 	// should have expired.
 	if maxAge > 0 {
 		expiredTimestamp := time.Now().UnixNano() - int64(limits.MaxAge)
-		if _, err := s.preparedStmts[sqlRecoverDoExpireMsgs].Exec(ms.channelID, expiredTimestamp); err != nil {
+		if _, err := ms.exec(sqlRecoverDoExpireMsgs, ms.channelID, expiredTimestamp); err != nil {
 			return sqlStmtError(sqlRecoverDoExpireMsgs, err)
 		}
 	}
@@ -1064,25 +1313,25 @@ func (s *SQLStore) applyLimitsOnRecovery(ms *SQLMsgStore) error {
 	// if the limit has not been lowered, we should be good).
 	if limits.MaxMsgs > 0 && limits.MaxMsgs < storedMsgsLimit {
 		count := 0
-		r := s.preparedStmts[sqlRecoverGetMessagesCount].QueryRow(ms.channelID)
+		r := ms.queryRow(sqlRecoverGetMessagesCount, ms.channelID)
 		if err := r.Scan(&count); err != nil {
 			return sqlStmtError(sqlRecoverGetMessagesCount, err)
 		}
 		// We leave at least 1 message
 		if count > 1 && count > limits.MaxMsgs {
 			seq := uint64(0)
-			r = s.preparedStmts[sqlRecoverGetSeqFloorForMaxMsgs].QueryRow(ms.channelID, limits.MaxMsgs)
+			r = ms.queryRow(sqlRecoverGetSeqFloorForMaxMsgs, ms.channelID, limits.MaxMsgs)
 			if err := r.Scan(&seq); err != nil {
 				return sqlStmtError(sqlRecoverGetSeqFloorForMaxMsgs, err)
 			}
-			if _, err := s.preparedStmts[sqlDeletedMsgsWithSeqLowerThan].Exec(ms.channelID, seq-1); err != nil {
+			if _, err := ms.exec(sqlDeletedMsgsWithSeqLowerThan, ms.channelID, seq-1); err != nil {
 				return sqlStmtError(sqlDeletedMsgsWithSeqLowerThan, err)
 			}
 		}
 	}
 	if limits.MaxBytes > 0 && limits.MaxBytes < storedBytesLimit {
 		currentBytes := uint64(0)
-		r := s.preparedStmts[sqlRecoverGetChannelTotalSize].QueryRow(ms.channelID)
+		r := ms.queryRow(sqlRecoverGetChannelTotalSize, ms.channelID)
 		if err := r.Scan(&currentBytes); err != nil {
 			return sqlStmtError(sqlRecoverGetChannelTotalSize, err)
 		}
@@ -1090,7 +1339,7 @@ func (s *SQLStore) applyLimitsOnRecovery(ms *SQLMsgStore) error {
 			seq := 0
 			// This query finds the first seq (inclusive) for which the running total
 			// size is <= max bytes.
-			r := s.preparedStmts[sqlRecoverGetSeqFloorForMaxBytes].QueryRow(ms.channelID, uint64(limits.MaxBytes))
+			r := ms.queryRow(sqlRecoverGetSeqFloorForMaxBytes, ms.channelID, uint64(limits.MaxBytes))
 			if err := r.Scan(&seq); err != nil {
 				return sqlStmtError(sqlRecoverGetSeqFloorForMaxBytes, err)
 			}
@@ -1098,7 +1347,7 @@ func (s *SQLStore) applyLimitsOnRecovery(ms *SQLMsgStore) error {
 			// but then we should try to delete anything before the last (keep at least
 			// one).
 			if seq == 0 {
-				r = s.preparedStmts[sqlGetLastSeq].QueryRow(ms.channelID)
+				r = ms.queryRow(sqlGetLastSeq, ms.channelID)
 				if err := r.Scan(&seq); err != nil {
 					return sqlStmtError(sqlGetLastSeq, err)
 				}
@@ -1108,7 +1357,7 @@ func (s *SQLStore) applyLimitsOnRecovery(ms *SQLMsgStore) error {
 				seq--
 			}
 			if seq > 0 {
-				if _, err := s.preparedStmts[sqlDeletedMsgsWithSeqLowerThan].Exec(ms.channelID, seq); err != nil {
+				if _, err := ms.exec(sqlDeletedMsgsWithSeqLowerThan, ms.channelID, seq); err != nil {
 					return sqlStmtError(sqlDeletedMsgsWithSeqLowerThan, err)
 				}
 			}
@@ -1138,13 +1387,21 @@ func (s *SQLStore) CreateChannel(channel string) (*Channel, error) {
 	channelLimits := s.genericStore.getChannelLimits(channel)
 
 	cid := s.maxChannelID + 1
+	if s.opts.PartitionMessages {
+		if err := s.createMsgsPartition(cid); err != nil {
+			return nil, err
+		}
+	}
 	if _, err := s.preparedStmts[sqlAddChannel].Exec(cid, channel,
 		channelLimits.MaxMsgs, channelLimits.MaxBytes, int64(channelLimits.MaxAge)); err != nil {
 		return nil, sqlStmtError(sqlAddChannel, err)
 	}
 	s.maxChannelID = cid
 
-	msgStore := s.newSQLMsgStore(channel, cid, &channelLimits.MsgStoreLimits)
+	msgStore, err := s.newSQLMsgStore(channel, cid, &channelLimits.MsgStoreLimits, s.opts.PartitionMessages)
+	if err != nil {
+		return nil, err
+	}
 	subStore := s.newSQLSubStore(cid, &channelLimits.SubStoreLimits)
 
 	c := &Channel{
@@ -1156,6 +1413,18 @@ func (s *SQLStore) CreateChannel(channel string) (*Channel, error) {
 	return c, nil
 }
 
+// sqlMsgStoreOf returns the SQLMsgStore wrapped by `ms`. The wrapping
+// stores replace the MsgStore of the channel they get from the SQLStore.
+func sqlMsgStoreOf(ms MsgStore) *SQLMsgStore {
+	for {
+		w, ok := ms.(msgStoreWrapper)
+		if !ok {
+			return ms.(*SQLMsgStore)
+		}
+		ms = w.unwrapMsgStore()
+	}
+}
+
 // DeleteChannel implements the Store interface
 func (s *SQLStore) DeleteChannel(channel string) error {
 	s.Lock()
@@ -1165,12 +1434,7 @@ func (s *SQLStore) DeleteChannel(channel string) error {
 		return ErrNotFound
 	}
 	// Get the channel ID from Msgs store
-	var cid int64
-	if cms, ok := c.Msgs.(*CryptoMsgStore); ok {
-		cid = cms.MsgStore.(*SQLMsgStore).channelID
-	} else {
-		cid = c.Msgs.(*SQLMsgStore).channelID
-	}
+	cid := sqlMsgStoreOf(c.Msgs).channelID
 	// Fast delete just marks the channel row as deleted
 	if _, err := s.preparedStmts[sqlDeleteChannelFast].Exec(cid); err != nil {
 		return err
@@ -1242,6 +1506,16 @@ func (s *SQLStore) deepChannelDelete(channelID int64) error {
 			break
 		}
 	}
+	// If the messages are in their own table, simply drop it. Messages
+	// would still be in the Messages table if the channel was deleted
+	// before they could be moved on startup.
+	table := sqlMsgsTable(channelID, true)
+	if _, err := s.db.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+		return fmt.Errorf("unable to drop table %q: %v", table, err)
+	}
+	if _, err := s.preparedStmts[sqlDeleteMsgsPartition].Exec(channelID); err != nil {
+		return err
+	}
 	// Same for messages, we will get a certain number of messages
 	// to delete and repeat the operation.
 	for {
@@ -1409,6 +1683,30 @@ func (mc *sqlMsgsCache) transferToFreeList() {
 	mc.count = 0
 }
 
+// add adds the messages fetched from the database, evicting the messages
+// of the oldest windows if the cache would otherwise exceed its limit.
+func (rc *sqlReadCache) add(msgs []*pb.MsgProto) {
+	if len(msgs) == 0 {
+		return
+	}
+	for len(rc.windows) > 0 && len(rc.msgs)+len(msgs) > rc.limit {
+		w := rc.windows[0]
+		for seq := w.first; seq <= w.last; seq++ {
+			delete(rc.msgs, seq)
+		}
+		rc.windows = rc.windows[1:]
+	}
+	for _, m := range msgs {
+		rc.msgs[m.Sequence] = m
+	}
+	rc.windows = append(rc.windows, sqlReadWindow{first: msgs[0].Sequence, last: msgs[len(msgs)-1].Sequence})
+}
+
+func (rc *sqlReadCache) clear() {
+	rc.msgs = make(map[uint64]*pb.MsgProto)
+	rc.windows = nil
+}
+
 func (mc *sqlMsgsCache) pop() *sqlCachedMsg {
 	cm := mc.head
 	if cm != nil {
@@ -1446,7 +1744,7 @@ func (ms *SQLMsgStore) Store(m *pb.MsgProto) (uint64, error) {
 		}
 		ms.writeCache.add(m, msgBytes)
 	} else {
-		if _, err := ms.sqlStore.preparedStmts[sqlStoreMsg].Exec(ms.channelID, seq, m.Timestamp, dataLen, msgBytes); err != nil {
+		if _, err := ms.exec(sqlStoreMsg, ms.channelID, seq, m.Timestamp, dataLen, msgBytes); err != nil {
 			return 0, sqlStmtError(sqlStoreMsg, err)
 		}
 	}
@@ -1473,7 +1771,7 @@ func (ms *SQLMsgStore) Store(m *pb.MsgProto) (uint64, error) {
 				firstCachedMsg := ms.writeCache.pop()
 				delBytes = uint64(len(firstCachedMsg.data))
 			} else {
-				r := ms.sqlStore.preparedStmts[sqlGetSizeOfMessage].QueryRow(ms.channelID, ms.first)
+				r := ms.queryRow(sqlGetSizeOfMessage, ms.channelID, ms.first)
 				if err := r.Scan(&delBytes); err != nil && err != sql.ErrNoRows {
 					return 0, sqlStmtError(sqlGetSizeOfMessage, err)
 				}
@@ -1481,7 +1779,7 @@ func (ms *SQLMsgStore) Store(m *pb.MsgProto) (uint64, error) {
 			}
 			if delBytes > 0 {
 				if didSQL {
-					if _, err := ms.sqlStore.preparedStmts[sqlDeleteMessage].Exec(ms.channelID, ms.first); err != nil {
+					if _, err := ms.exec(sqlDeleteMessage, ms.channelID, ms.first); err != nil {
 						return 0, sqlStmtError(sqlDeleteMessage, err)
 					}
 				}
@@ -1532,8 +1830,18 @@ func (ms *SQLMsgStore) lookup(seq uint64) (*pb.MsgProto, error) {
 			timestamp = msg.Timestamp
 		}
 	}
+	if msg == nil && ms.readCache != nil {
+		msg = ms.readCache.msgs[seq]
+		if msg == nil {
+			var err error
+			if msg, err = ms.readAhead(seq); msg == nil || err != nil {
+				return nil, err
+			}
+		}
+		timestamp = msg.Timestamp
+	}
 	if msg == nil {
-		r := ms.sqlStore.preparedStmts[sqlLookupMsg].QueryRow(ms.channelID, seq)
+		r := ms.queryRow(sqlLookupMsg, ms.channelID, seq)
 		err := r.Scan(&timestamp, &data)
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -1552,6 +1860,47 @@ func (ms *SQLMsgStore) lookup(seq uint64) (*pb.MsgProto, error) {
 	return msg, nil
 }
 
+// readAhead fetches up to ReadAhead messages starting at `seq` with a single
+// query, adds them to the read cache and returns the message for `seq`,
+// which may be nil if it does not exist.
+// Lock held on entry.
+func (ms *SQLMsgStore) readAhead(seq uint64) (*pb.MsgProto, error) {
+	last := seq + uint64(ms.sqlStore.opts.ReadAhead) - 1
+	if last > ms.last {
+		last = ms.last
+	}
+	rows, err := ms.query(sqlLookupMsgRange, ms.channelID, seq, last)
+	if err != nil {
+		return nil, sqlStmtError(sqlLookupMsgRange, err)
+	}
+	defer rows.Close()
+	var (
+		msgs []*pb.MsgProto
+		msg  *pb.MsgProto
+	)
+	for rows.Next() {
+		var (
+			mseq      uint64
+			timestamp int64
+			data      []byte
+		)
+		if err := rows.Scan(&mseq, &timestamp, &data); err != nil {
+			return nil, sqlStmtError(sqlLookupMsgRange, err)
+		}
+		m := &pb.MsgProto{}
+		m.Unmarshal(data)
+		if mseq == seq {
+			msg = m
+		}
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqlStmtError(sqlLookupMsgRange, err)
+	}
+	ms.readCache.add(msgs)
+	return msg, nil
+}
+
 // GetSequenceFromTimestamp implements the MsgStore interface
 func (ms *SQLMsgStore) GetSequenceFromTimestamp(timestamp int64) (uint64, error) {
 	ms.Lock()
@@ -1564,7 +1913,7 @@ func (ms *SQLMsgStore) GetSequenceFromTimestamp(timestamp int64) (uint64, error)
 	if ms.first > ms.last {
 		return ms.last + 1, nil
 	}
-	r := ms.sqlStore.preparedStmts[sqlGetSequenceFromTimestamp].QueryRow(ms.channelID, timestamp)
+	r := ms.queryRow(sqlGetSequenceFromTimestamp, ms.channelID, timestamp)
 	seq := uint64(0)
 	err := r.Scan(&seq)
 	if err == sql.ErrNoRows {
@@ -1614,7 +1963,7 @@ func (ms *SQLMsgStore) expireMsgs() {
 	}
 	for {
 		expiredTimestamp := time.Now().UnixNano() - int64(ms.limits.MaxAge)
-		r := ms.sqlStore.preparedStmts[sqlGetExpiredMessages].QueryRow(ms.channelID, expiredTimestamp)
+		r := ms.queryRow(sqlGetExpiredMessages, ms.channelID, expiredTimestamp)
 		if err := r.Scan(&count, &maxSeq, &totalSize); err != nil {
 			processErr(sqlGetExpiredMessages, err)
 			return
@@ -1624,12 +1973,12 @@ func (ms *SQLMsgStore) expireMsgs() {
 		// expiration timer based on the first message that need to expire.
 		if count > 0 {
 			if maxSeq == ms.last {
-				if _, err := ms.sqlStore.preparedStmts[sqlUpdateChannelMaxSeq].Exec(maxSeq, ms.channelID); err != nil {
+				if _, err := ms.exec(sqlUpdateChannelMaxSeq, maxSeq, ms.channelID); err != nil {
 					processErr(sqlUpdateChannelMaxSeq, err)
 					return
 				}
 			}
-			if _, err := ms.sqlStore.preparedStmts[sqlDeletedMsgsWithSeqLowerThan].Exec(ms.channelID, maxSeq); err != nil {
+			if _, err := ms.exec(sqlDeletedMsgsWithSeqLowerThan, ms.channelID, maxSeq); err != nil {
 				processErr(sqlDeletedMsgsWithSeqLowerThan, err)
 				return
 			}
@@ -1642,7 +1991,7 @@ func (ms *SQLMsgStore) expireMsgs() {
 		// If there is any message left in the channel, find out what the expiration
 		// timer needs to be set to.
 		if ms.totalCount > 0 {
-			r = ms.sqlStore.preparedStmts[sqlGetFirstMsgTimestamp].QueryRow(ms.channelID, ms.first)
+			r = ms.queryRow(sqlGetFirstMsgTimestamp, ms.channelID, ms.first)
 			if err := r.Scan(&ms.fTimestamp); err != nil {
 				processErr(sqlGetFirstMsgTimestamp, err)
 				return
@@ -1688,13 +2037,16 @@ func (ms *SQLMsgStore) flush() error {
 		}
 	}()
 	if limit := ms.sqlStore.opts.BulkInsertLimit; limit >= sqlMinBulkInsertLimit {
+		if ms.sqlStore.sqlite && limit > sqlSQLiteMaxBulkInsertLimit {
+			limit = sqlSQLiteMaxBulkInsertLimit
+		}
 		return ms.bulkInsert(limit)
 	}
 	tx, err := ms.sqlStore.db.Begin()
 	if err != nil {
 		return err
 	}
-	ps, err = tx.Prepare(sqlStmts[sqlStoreMsg])
+	ps, err = tx.Prepare(ms.stmt(sqlStoreMsg))
 	if err != nil {
 		return err
 	}
@@ -1723,7 +2075,7 @@ func (ms *SQLMsgStore) flush() error {
 func (ms *SQLMsgStore) bulkInsert(limit int) error {
 	s := ms.sqlStore
 
-	const insertStmt = "INSERT INTO Messages (id, seq, timestamp, size, data) VALUES "
+	insertStmt := "INSERT INTO " + ms.table + " (id, seq, timestamp, size, data) VALUES "
 	const valArgs = "(?,?,?,?,?)"
 
 	count := ms.writeCache.count
@@ -1812,7 +2164,7 @@ func (ms *SQLMsgStore) Empty() error {
 		return err
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec(sqlStmts[sqlDeletedMsgsWithSeqLowerThan], ms.channelID, ms.last); err != nil {
+	if _, err := tx.Exec(ms.stmt(sqlDeletedMsgsWithSeqLowerThan), ms.channelID, ms.last); err != nil {
 		return err
 	}
 	if _, err := tx.Exec(sqlStmts[sqlUpdateChannelMaxSeq], 0, ms.channelID); err != nil {
@@ -1831,6 +2183,10 @@ func (ms *SQLMsgStore) Empty() error {
 	if ms.writeCache != nil {
 		ms.writeCache.transferToFreeList()
 	}
+	// Sequences may be reused after the store is emptied.
+	if ms.readCache != nil {
+		ms.readCache.clear()
+	}
 	ms.Unlock()
 	return err
 }
@@ -1864,6 +2220,45 @@ func (ms *SQLMsgStore) Close() error {
 	return err
 }
 
+// The statements on the table of a channel are not prepared: with one
+// table per channel, this would be a number of prepared statements per
+// connection proportional to the number of channels, and databases limit
+// it (see max_prepared_stmt_count in MySQL). The ones on the Messages
+// table, shared by all channels, are prepared once by the SQLStore.
+
+// exec executes the statement `code` on the table of this channel.
+func (ms *SQLMsgStore) exec(code int, args ...interface{}) (sql.Result, error) {
+	if q, ok := ms.queries[code]; ok {
+		return ms.sqlStore.db.Exec(q, args...)
+	}
+	return ms.sqlStore.preparedStmts[code].Exec(args...)
+}
+
+// query executes the query `code` on the table of this channel.
+func (ms *SQLMsgStore) query(code int, args ...interface{}) (*sql.Rows, error) {
+	if q, ok := ms.queries[code]; ok {
+		return ms.sqlStore.db.Query(q, args...)
+	}
+	return ms.sqlStore.preparedStmts[code].Query(args...)
+}
+
+// queryRow executes the query `code`, which returns at most one row, on
+// the table of this channel.
+func (ms *SQLMsgStore) queryRow(code int, args ...interface{}) *sql.Row {
+	if q, ok := ms.queries[code]; ok {
+		return ms.sqlStore.db.QueryRow(q, args...)
+	}
+	return ms.sqlStore.preparedStmts[code].QueryRow(args...)
+}
+
+// stmt returns the text of the statement `code` for the table of this channel.
+func (ms *SQLMsgStore) stmt(code int) string {
+	if q, ok := ms.queries[code]; ok {
+		return q
+	}
+	return sqlStmts[code]
+}
+
 ////////////////////////////////////////////////////////////////////////////
 // SQLSubStore methods
 ////////////////////////////////////////////////////////////////////////////
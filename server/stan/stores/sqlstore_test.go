@@ -32,6 +32,7 @@ import (
 	mysql "github.com/go-sql-driver/mysql"                         // mysql driver
 	_ "github.com/kubemq-io/broker/server/stan/stores/pqdeadlines" // wrapper for postgres that gives read/write deadlines
 	_ "github.com/lib/pq"                                          // postgres driver
+	_ "github.com/mattn/go-sqlite3"                                // sqlite driver
 )
 
 // The SourceAdmin is used by the test setup to have access
@@ -46,6 +47,8 @@ const (
 
 	testDefaultPostgresSource      = "dbname=" + testDefaultDatabaseName + " sslmode=disable"
 	testDefaultPostgresSourceAdmin = "sslmode=disable"
+
+	testDefaultSQLiteSource = testDefaultDatabaseName
 )
 
 var (
@@ -160,9 +163,12 @@ func TestSQLAllOptions(t *testing.T) {
 	defer cleanupSQLDatastore(t)
 
 	opts := &SQLStoreOptions{
-		NoCaching:       true,
-		MaxOpenConns:    123,
-		BulkInsertLimit: 456,
+		NoCaching:         true,
+		MaxOpenConns:      123,
+		BulkInsertLimit:   456,
+		NoMigration:       true,
+		ReadAhead:         789,
+		PartitionMessages: true,
 	}
 	s, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil, SQLAllOptions(opts))
 	if err != nil {
@@ -182,6 +188,15 @@ func TestSQLAllOptions(t *testing.T) {
 	if so.BulkInsertLimit != 456 {
 		t.Fatalf("BulkInsertLimit should be 456, got %v", so.BulkInsertLimit)
 	}
+	if !so.NoMigration {
+		t.Fatal("NoMigration should be true")
+	}
+	if so.ReadAhead != 789 {
+		t.Fatalf("ReadAhead should be 789, got %v", so.ReadAhead)
+	}
+	if !so.PartitionMessages {
+		t.Fatal("PartitionMessages should be true")
+	}
 }
 
 func TestSQLPostgresDriverInit(t *testing.T) {
@@ -654,8 +669,15 @@ func TestSQLRecoverErrors(t *testing.T) {
 
 	db := getDBConnection(t)
 	defer db.Close()
-	// Change version
-	test.MustExecuteSQL(t, db, "UPDATE ServerInfo SET version=2 WHERE uniquerow=1")
+	// Change to a version newer than supported, the store should refuse to start.
+	test.MustExecuteSQL(t, db, fmt.Sprintf("UPDATE ServerInfo SET version=%d WHERE uniquerow=1", sqlVersion+1))
+	if s, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil); s != nil || err == nil || !strings.Contains(err.Error(), "newer") {
+		if s != nil {
+			s.Close()
+		}
+		t.Fatalf("Expected error about newer schema, got %v", err)
+	}
+	test.MustExecuteSQL(t, db, fmt.Sprintf("UPDATE ServerInfo SET version=%d WHERE uniquerow=1", sqlVersion))
 
 	s, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil)
 	if err != nil {
@@ -669,6 +691,8 @@ func TestSQLRecoverErrors(t *testing.T) {
 			t.Fatalf("Expected no state and error about %q, got %v - %v", errTxt, state, err)
 		}
 	}
+	// Version changed while the store is running.
+	test.MustExecuteSQL(t, db, "UPDATE ServerInfo SET version=0 WHERE uniquerow=1")
 	expectRecoverFailure("version")
 
 	// Reset to proper version but change name of cluster
@@ -1688,6 +1712,66 @@ func TestSQLDeleteChannel(t *testing.T) {
 	checkTables("bar", true)
 }
 
+func TestSQLDeleteChannelWrappedStore(t *testing.T) {
+	if !doSQL {
+		t.SkipNow()
+	}
+	cleanupSQLDatastore(t)
+	defer cleanupSQLDatastore(t)
+
+	ss := createDefaultSQLStore(t)
+	cs, err := NewCompressedStore(NewMetricsStore(ss, nil), CompressionDeflate, nil)
+	if err != nil {
+		ss.Close()
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer cs.Close()
+
+	c := storeCreateChannel(t, cs, "foo")
+	storeMsg(t, c, "foo", 1, []byte("msg"))
+	// The SQLStore needs to find its own MsgStore behind the wrappers.
+	if err := cs.DeleteChannel("foo"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+}
+
+func TestSQLDeleteChannelFaultStore(t *testing.T) {
+	if !doSQL {
+		t.SkipNow()
+	}
+	cleanupSQLDatastore(t)
+	defer cleanupSQLDatastore(t)
+
+	ss := createDefaultSQLStore(t)
+	fs := NewFaultStore(ss, NewFaultInjector())
+	defer fs.Close()
+
+	c := storeCreateChannel(t, fs, "foo")
+	storeMsg(t, c, "foo", 1, []byte("msg"))
+	if err := fs.DeleteChannel("foo"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+	// Recovered channels are wrapped too.
+	c = storeCreateChannel(t, fs, "bar")
+	storeMsg(t, c, "bar", 1, []byte("msg"))
+	fs.Close()
+
+	ss, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil, SQLNoCaching(true))
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	fs = NewFaultStore(ss, NewFaultInjector())
+	defer fs.Close()
+	rs, err := fs.Recover()
+	if err != nil {
+		t.Fatalf("Error recovering store: %v", err)
+	}
+	getRecoveredChannel(t, rs, "bar")
+	if err := fs.DeleteChannel("bar"); err != nil {
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+}
+
 func TestSQLRecoverWithMaxBytes(t *testing.T) {
 	if !doSQL {
 		t.SkipNow()
@@ -2031,7 +2115,8 @@ type silenceMySQLLogger struct{}
 func (l *silenceMySQLLogger) Print(v ...interface{}) {}
 
 func TestSQLDeadlines(t *testing.T) {
-	if !doSQL {
+	// Deadlines only apply to connections to a database server.
+	if !doSQL || testSQLDriver == driverSQLite {
 		t.SkipNow()
 	}
 
@@ -2213,6 +2298,216 @@ func TestSQLBulkInsertLimit(t *testing.T) {
 	}
 }
 
+func TestSQLReadAhead(t *testing.T) {
+	if !doSQL {
+		t.SkipNow()
+	}
+
+	cleanupSQLDatastore(t)
+	defer cleanupSQLDatastore(t)
+
+	s, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil,
+		SQLNoCaching(false), SQLReadAhead(10))
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+	info := testDefaultServerInfo
+	if err := s.Init(&info); err != nil {
+		t.Fatalf("Error on Init: %v", err)
+	}
+
+	cs := storeCreateChannel(t, s, "foo")
+	for seq := uint64(1); seq <= 100; seq++ {
+		storeMsg(t, cs, "foo", seq, []byte(fmt.Sprintf("%v", seq)))
+	}
+	s.Close()
+
+	// Restart so that messages are not in the write cache.
+	s, err = NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil,
+		SQLNoCaching(false), SQLReadAhead(10))
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	defer s.Close()
+	state, err := s.Recover()
+	if err != nil {
+		t.Fatalf("Error recovering state: %v", err)
+	}
+	cs = getRecoveredChannel(t, state, "foo")
+	ms := cs.Msgs.(*SQLMsgStore)
+	cacheLen := func() (int, int) {
+		ms.RLock()
+		defer ms.RUnlock()
+		return len(ms.readCache.msgs), len(ms.readCache.windows)
+	}
+	m := msgStoreLookup(t, cs.Msgs, 1)
+	if string(m.Data) != "1" {
+		t.Fatalf("Unexpected message: %q", m.Data)
+	}
+	if n, w := cacheLen(); n != 10 || w != 1 {
+		t.Fatalf("Expected 10 messages in 1 window, got %v in %v", n, w)
+	}
+
+	// Remove the messages from the database, lookups in the window
+	// should be served from the cache.
+	db := getDBConnection(t)
+	defer db.Close()
+	if _, err := db.Exec(fmt.Sprintf("DELETE FROM Messages WHERE id=%v AND seq<=20", ms.channelID)); err != nil {
+		t.Fatalf("Error deleting messages: %v", err)
+	}
+	for seq := uint64(1); seq <= 10; seq++ {
+		m := msgStoreLookup(t, cs.Msgs, seq)
+		if m == nil || string(m.Data) != fmt.Sprintf("%v", seq) {
+			t.Fatalf("Unexpected message for seq %v: %v", seq, m)
+		}
+	}
+	if m := msgStoreLookup(t, cs.Msgs, 11); m != nil {
+		t.Fatalf("Message 11 should have been fetched from the database, got %v", m)
+	}
+
+	// The cache is bounded
+	for seq := uint64(21); seq <= 100; seq++ {
+		m := msgStoreLookup(t, cs.Msgs, seq)
+		if string(m.Data) != fmt.Sprintf("%v", seq) {
+			t.Fatalf("Unexpected message for seq %v: %q", seq, m.Data)
+		}
+	}
+	if n, w := cacheLen(); n != 10*sqlReadCacheWindows || w != sqlReadCacheWindows {
+		t.Fatalf("Expected %v messages in %v windows, got %v in %v",
+			10*sqlReadCacheWindows, sqlReadCacheWindows, n, w)
+	}
+	// Evicted messages are not returned
+	if m := msgStoreLookup(t, cs.Msgs, 1); m != nil {
+		t.Fatalf("Message 1 should have been evicted, got %v", m)
+	}
+
+	if err := cs.Msgs.Empty(); err != nil {
+		t.Fatalf("Error on Empty(): %v", err)
+	}
+	if n, w := cacheLen(); n != 0 || w != 0 {
+		t.Fatalf("Expected read cache to be empty, got %v messages in %v windows", n, w)
+	}
+}
+
+func TestSQLPartitionMessages(t *testing.T) {
+	if !doSQL {
+		t.SkipNow()
+	}
+
+	cleanupSQLDatastore(t)
+	defer cleanupSQLDatastore(t)
+
+	db := getDBConnection(t)
+	defer db.Close()
+
+	countRows := func(t *testing.T, table string, cid int64) int {
+		t.Helper()
+		count := 0
+		r := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id=%v", table, cid))
+		if err := r.Scan(&count); err != nil {
+			t.Fatalf("Error counting rows of %s: %v", table, err)
+		}
+		return count
+	}
+	tableExists := func(table string) bool {
+		_, err := db.Exec("SELECT COUNT(*) FROM " + table)
+		return err == nil
+	}
+	open := func(t *testing.T, partitioned bool) (*SQLStore, *RecoveredState) {
+		t.Helper()
+		s, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil,
+			SQLNoCaching(true), SQLPartitionMessages(partitioned))
+		if err != nil {
+			t.Fatalf("Error creating store: %v", err)
+		}
+		state, err := s.Recover()
+		if err != nil {
+			s.Close()
+			t.Fatalf("Error recovering state: %v", err)
+		}
+		return s, state
+	}
+	checkMsgs := func(t *testing.T, cs *Channel) {
+		t.Helper()
+		for seq := uint64(1); seq <= 10; seq++ {
+			m := msgStoreLookup(t, cs.Msgs, seq)
+			if m == nil || string(m.Data) != fmt.Sprintf("%v", seq) {
+				t.Fatalf("Unexpected message for seq %v: %v", seq, m)
+			}
+		}
+	}
+
+	s, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil,
+		SQLNoCaching(true), SQLPartitionMessages(true))
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	info := testDefaultServerInfo
+	if err := s.Init(&info); err != nil {
+		s.Close()
+		t.Fatalf("Error on Init: %v", err)
+	}
+	cs := storeCreateChannel(t, s, "foo")
+	for seq := uint64(1); seq <= 10; seq++ {
+		storeMsg(t, cs, "foo", seq, []byte(fmt.Sprintf("%v", seq)))
+	}
+	cid := cs.Msgs.(*SQLMsgStore).channelID
+	table := sqlMsgsTable(cid, true)
+	s.Close()
+
+	if n := countRows(t, table, cid); n != 10 {
+		t.Fatalf("Expected 10 messages in %s, got %v", table, n)
+	}
+	if n := countRows(t, "Messages", cid); n != 0 {
+		t.Fatalf("Expected no message in Messages, got %v", n)
+	}
+	if n := countRows(t, "MsgsPartitions", cid); n != 1 {
+		t.Fatalf("Expected channel to be recorded in MsgsPartitions, got %v", n)
+	}
+
+	// Restart without the option, messages are moved back to Messages.
+	s, state := open(t, false)
+	cs = getRecoveredChannel(t, state, "foo")
+	checkMsgs(t, cs)
+	s.Close()
+	if tableExists(table) {
+		t.Fatalf("Table %s should have been dropped", table)
+	}
+	if n := countRows(t, "Messages", cid); n != 10 {
+		t.Fatalf("Expected 10 messages in Messages, got %v", n)
+	}
+	if n := countRows(t, "MsgsPartitions", cid); n != 0 {
+		t.Fatalf("Expected channel to be removed from MsgsPartitions, got %v", n)
+	}
+
+	// Restart with the option, messages are moved to the channel's table.
+	s, state = open(t, true)
+	cs = getRecoveredChannel(t, state, "foo")
+	checkMsgs(t, cs)
+	storeMsg(t, cs, "foo", 11, []byte("11"))
+	if n := countRows(t, table, cid); n != 11 {
+		t.Fatalf("Expected 11 messages in %s, got %v", table, n)
+	}
+	if n := countRows(t, "Messages", cid); n != 0 {
+		t.Fatalf("Expected no message in Messages, got %v", n)
+	}
+
+	// Deleting the channel drops its table. Closing the store waits for
+	// the deletion to complete.
+	if err := s.DeleteChannel("foo"); err != nil {
+		s.Close()
+		t.Fatalf("Error deleting channel: %v", err)
+	}
+	s.Close()
+	if tableExists(table) {
+		t.Fatalf("Table %s should have been dropped", table)
+	}
+	if n := countRows(t, "MsgsPartitions", cid); n != 0 {
+		t.Fatalf("Expected channel to be removed from MsgsPartitions, got %v", n)
+	}
+}
+
 func TestSQLStoreMaxSeqWithExpiredMsgs(t *testing.T) {
 	if !doSQL {
 		t.SkipNow()
@@ -2317,3 +2612,127 @@ func TestSQLStoreMaxSeqWithExpiredMsgs(t *testing.T) {
 		s.Close()
 	}
 }
+
+func TestSQLMigrationsList(t *testing.T) {
+	if last := sqlMigrations[len(sqlMigrations)-1].version; last != sqlVersion {
+		t.Fatalf("Last migration is for version %v, but sqlVersion is %v", last, sqlVersion)
+	}
+	for i, m := range sqlMigrations {
+		if m.version != i+1 {
+			t.Fatalf("Expected migration %v to be for version %v, got %v", i, i+1, m.version)
+		}
+		if len(m.mysql) == 0 || len(m.postgres) == 0 || len(m.sqlite) == 0 {
+			t.Fatalf("Migration for version %v is missing statements for a driver", m.version)
+		}
+		for _, stmt := range m.postgres {
+			if strings.Contains(stmt, "`") {
+				t.Fatalf("Statement %q incorrect for Postgres driver", stmt)
+			}
+		}
+	}
+}
+
+func TestSQLSchemaMigration(t *testing.T) {
+	if !doSQL {
+		t.SkipNow()
+	}
+	cleanupSQLDatastore(t)
+	defer cleanupSQLDatastore(t)
+
+	db := getDBConnection(t)
+	defer db.Close()
+	for _, table := range []string{"ServerInfo", "Clients", "Channels", "Messages", "Subscriptions", "SubsPending", "StoreLock", "MsgsPartitions"} {
+		test.MustExecuteSQL(t, db, "DROP TABLE "+table)
+	}
+
+	version, migrations, err := SQLSchemaMigrations(testSQLDriver, testSQLSource)
+	if err != nil {
+		t.Fatalf("Error getting migrations: %v", err)
+	}
+	if version != 0 || len(migrations) != sqlVersion {
+		t.Fatalf("Unexpected version %v and migrations %v", version, migrations)
+	}
+	// Nothing should have been created
+	if _, err := db.Exec("SELECT COUNT(*) FROM ServerInfo"); err == nil {
+		t.Fatal("Dry run should not have created the tables")
+	}
+
+	// Tables are created on startup, but there is nothing to recover.
+	s := createDefaultSQLStore(t)
+	cs := storeCreateChannel(t, s, "foo")
+	storeMsg(t, cs, "foo", 1, []byte("hello"))
+	s.Close()
+
+	version, migrations, err = SQLSchemaMigrations(testSQLDriver, testSQLSource)
+	if err != nil || version != sqlVersion || len(migrations) != 0 {
+		t.Fatalf("Unexpected version %v and migrations %v (err=%v)", version, migrations, err)
+	}
+	s, state := openDefaultSQLStoreWithLimits(t, nil)
+	if state == nil {
+		t.Fatal("Expected state to be recovered")
+	}
+	cs = getRecoveredChannel(t, state, "foo")
+	if m := msgStoreLookup(t, cs.Msgs, 1); m == nil || string(m.Data) != "hello" {
+		t.Fatalf("Unexpected message: %v", m)
+	}
+	s.Close()
+
+	// An older version can't be used without migration.
+	test.MustExecuteSQL(t, db, fmt.Sprintf("UPDATE ServerInfo SET version=%d WHERE uniquerow=1", sqlVersion-1))
+	if sqlVersion > 1 {
+		if s, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil, SQLNoMigration(true)); err == nil {
+			s.Close()
+			t.Fatal("Expected error about schema needing migration")
+		}
+	}
+	s, state = openDefaultSQLStoreWithLimits(t, nil)
+	s.Close()
+	if state == nil {
+		t.Fatal("Expected state to be recovered")
+	}
+	r := db.QueryRow("SELECT version FROM ServerInfo WHERE uniquerow=1")
+	if err := r.Scan(&version); err != nil || version != sqlVersion {
+		t.Fatalf("Expected version to be %v, got %v (err=%v)", sqlVersion, version, err)
+	}
+
+	// The schema is not migrated while another store holds the lock.
+	sqlLockUpdateInterval = 250 * time.Millisecond
+	sqlLockLostCount = 2
+	defer func() {
+		sqlLockUpdateInterval = sqlDefaultLockUpdateInterval
+		sqlLockLostCount = sqlDefaultLockLostCount
+	}()
+	s = createDefaultSQLStore(t)
+	if locked, err := s.GetExclusiveLock(); err != nil || !locked {
+		s.Close()
+		t.Fatalf("Expected to get the store lock, got %v (err=%v)", locked, err)
+	}
+	test.MustExecuteSQL(t, db, fmt.Sprintf("UPDATE ServerInfo SET version=%d WHERE uniquerow=1", sqlVersion-1))
+	if s2, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil); err == nil || !strings.Contains(err.Error(), "locked") {
+		if s2 != nil {
+			s2.Close()
+		}
+		s.Close()
+		t.Fatalf("Expected error about store being locked, got %v", err)
+	}
+	s.Close()
+	s, _ = openDefaultSQLStoreWithLimits(t, nil)
+	s.Close()
+
+	// Errors other than a missing table or row are reported.
+	closedDB := getDBConnection(t)
+	closedDB.Close()
+	if _, _, err := sqlSchemaVersion(closedDB, testSQLDriver); err == nil {
+		t.Fatal("Expected error reading the version from a closed connection")
+	}
+
+	// A newer version is rejected.
+	test.MustExecuteSQL(t, db, fmt.Sprintf("UPDATE ServerInfo SET version=%d WHERE uniquerow=1", sqlVersion+1))
+	if _, _, err := SQLSchemaMigrations(testSQLDriver, testSQLSource); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("Expected error about newer schema, got %v", err)
+	}
+	if s, err := NewSQLStore(testLogger, testSQLDriver, testSQLSource, nil, SQLNoMigration(true)); err == nil {
+		s.Close()
+		t.Fatal("Expected error about newer schema")
+	}
+}
@@ -30,6 +30,11 @@ const (
 	TypeSQL = "SQL"
 	// TypeRaft is the store type name for the raft stores
 	TypeRaft = "RAFT"
+	// TypeBolt is the store type name for bolt based stores
+	TypeBolt = "BOLT"
+	// TypeHybrid is the store type name for file stores keeping recent
+	// messages in memory
+	TypeHybrid = "HYBRID"
 )
 
 // Errors.
@@ -52,6 +57,10 @@ type StoreLimits struct {
 	// - == 0 means that the corresponding global limit is used.
 	// -  < 0 means that limit is ignored (unlimited).
 	PerChannel map[string]*ChannelLimits `json:"channels,omitempty"`
+	// Per-client publish rate limits. The key is a client ID pattern where
+	// '*' matches any sequence of characters and '?' any single character.
+	// A limit set to 0 (or negative) means unlimited.
+	PerClient map[string]*PubRateLimits `json:"clients,omitempty"`
 }
 
 // ChannelLimits defines limits for a given channel
@@ -60,9 +69,15 @@ type ChannelLimits struct {
 	MsgStoreLimits
 	// Limits for subscriptions stores
 	SubStoreLimits
+	// Limits for the rate at which messages are published
+	PubRateLimits
 	// How long without any active subscription and no new message
 	// before this channel can be deleted.
 	MaxInactivity time.Duration `json:"max_inactivity"`
+	// If true, and the store is a CryptoStore with a directory for channel
+	// keys, the payloads of this channel are encrypted with a key of its own.
+	// A per-channel value of false means that the global value is used.
+	DedicatedKey bool `json:"dedicated_key,omitempty"`
 }
 
 // MsgStoreLimits defines limits for a MsgStore.
@@ -84,6 +99,17 @@ type SubStoreLimits struct {
 	MaxSubscriptions int `json:"max_subscriptions"`
 }
 
+// PubRateLimits defines limits for the rate at which messages are published.
+// For global limits, a value of 0 means "unlimited".
+// For per-channel limits, it means that the corresponding global
+// limit is used.
+type PubRateLimits struct {
+	// How many messages per second can be published.
+	MaxPubMsgsRate int `json:"max_pub_msgs_rate"`
+	// How many bytes per second can be published.
+	MaxPubBytesRate int64 `json:"max_pub_bytes_rate"`
+}
+
 // DefaultStoreLimits are the limits that a Store must
 // use when none are specified to the Store constructor.
 // Store limits can be changed with the Store.SetLimits() method.
@@ -97,9 +123,12 @@ var DefaultStoreLimits = StoreLimits{
 		SubStoreLimits{
 			MaxSubscriptions: 1000,
 		},
+		PubRateLimits{},
 		0,
+		false,
 	},
 	nil,
+	nil,
 }
 
 // RecoveredState allows the server to reconstruct its state after a restart.
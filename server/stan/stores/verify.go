@@ -0,0 +1,1041 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kubemq-io/broker/client/stan/pb"
+	"github.com/kubemq-io/broker/server/stan/logger"
+	"github.com/kubemq-io/broker/server/stan/spb"
+	"github.com/kubemq-io/broker/server/stan/util"
+)
+
+// Kinds of problems reported by VerifyFileStore and VerifySQLStore.
+const (
+	// VerifyCorrupted is for a record that can't be read: bad CRC,
+	// truncated record or payload that can't be decoded.
+	VerifyCorrupted = "corrupted"
+	// VerifySequence is for message sequences that are not contiguous,
+	// or pending messages beyond the last message of a channel.
+	VerifySequence = "sequence"
+	// VerifyIndex is for a FileStore index file that does not match
+	// its data file.
+	VerifyIndex = "index"
+	// VerifyOrphan is for records that reference a client, channel or
+	// subscription that does not exist.
+	VerifyOrphan = "orphan"
+)
+
+// VerifyOptions are options for VerifyFileStore and VerifySQLStore.
+type VerifyOptions struct {
+	// RebuildIndex rewrites the FileStore index files that do not match
+	// their data file. It has no effect on an SQL store.
+	RebuildIndex bool
+	// QuarantineDir, if set, is where corrupted records are moved: they
+	// are removed from the store and each is written to its own file.
+	QuarantineDir string
+	// Log, if set, is used to report progress.
+	Log logger.Logger
+}
+
+// repair returns true if these options may cause the store to be modified.
+func (o *VerifyOptions) repair() bool {
+	return o.RebuildIndex || o.QuarantineDir != ""
+}
+
+// VerifyProblem is a problem found by VerifyFileStore or VerifySQLStore.
+type VerifyProblem struct {
+	Kind    string
+	Channel string
+	// File, relative to the store directory, or SQL table.
+	Location string
+	// Offset of the record in the file, -1 if not applicable.
+	Offset int64
+	// Sequence of the message, or ID of the subscription, if applicable.
+	Seq         uint64
+	Description string
+	// Repaired is true if the index has been rebuilt or the record moved
+	// to the quarantine directory.
+	Repaired bool
+}
+
+func (p *VerifyProblem) String() string {
+	var sb strings.Builder
+	sb.WriteString(p.Kind)
+	sb.WriteString(": ")
+	sb.WriteString(p.Location)
+	if p.Offset >= 0 {
+		fmt.Fprintf(&sb, " (offset %v)", p.Offset)
+	}
+	if p.Channel != "" {
+		fmt.Fprintf(&sb, " channel %q", p.Channel)
+	}
+	if p.Seq != 0 {
+		fmt.Fprintf(&sb, " #%v", p.Seq)
+	}
+	sb.WriteString(": ")
+	sb.WriteString(p.Description)
+	if p.Repaired {
+		sb.WriteString(" [repaired]")
+	}
+	return sb.String()
+}
+
+// VerifyReport is the result of VerifyFileStore and VerifySQLStore.
+type VerifyReport struct {
+	Clients  int
+	Channels int
+	Msgs     int
+	Subs     int
+	Problems []*VerifyProblem
+}
+
+// Unrepaired returns the number of problems that have not been repaired.
+func (r *VerifyReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *VerifyReport) add(p *VerifyProblem) *VerifyProblem {
+	r.Problems = append(r.Problems, p)
+	return p
+}
+
+// Returns true if the subscription belongs to a client that must exist:
+// durable subscriptions keep their client ID when the client is gone.
+func subNeedsClient(sub *spb.SubState) bool {
+	return sub.ClientID != "" && sub.DurableName == ""
+}
+
+type fileVerifier struct {
+	rootDir  string
+	opts     *VerifyOptions
+	crcTable *crc32.Table
+	report   *VerifyReport
+}
+
+// A record that can't be read. Size includes the record header.
+type badRecord struct {
+	offset int64
+	size   int64
+	err    error
+}
+
+// A message record of a data file.
+type sliceRecord struct {
+	seq       uint64
+	offset    int64
+	timestamp int64
+	size      int
+}
+
+// VerifyFileStore checks the files of the FileStore in `rootDir`: the CRC
+// of every record, the continuity of message sequences, the consistency
+// of index files with data files and the presence of the clients of
+// subscriptions. The server must not be running. `fsOptions` are needed
+// for the CRC polynomial. Index files can be rebuilt and corrupted records
+// moved to a quarantine directory, see VerifyOptions. In that case, the
+// lock file of the store is acquired first, and VerifyFileStore fails if
+// it is held by another process.
+func VerifyFileStore(rootDir string, opts *VerifyOptions, fsOptions ...FileStoreOption) (*VerifyReport, error) {
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+	if opts.repair() {
+		lf, err := lockFileStoreDir(rootDir)
+		if err != nil {
+			return nil, err
+		}
+		defer lf.Close()
+	}
+	fsOpts := DefaultFileStoreOptions
+	for _, opt := range fsOptions {
+		if err := opt(&fsOpts); err != nil {
+			return nil, err
+		}
+	}
+	v := &fileVerifier{rootDir: rootDir, opts: opts, crcTable: crc32.IEEETable, report: &VerifyReport{}}
+	if fsOpts.CRCPolynomial != int64(crc32.IEEE) {
+		v.crcTable = crc32.MakeTable(uint32(fsOpts.CRCPolynomial))
+	}
+	files, err := os.ReadDir(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	hasChannels := false
+	for _, f := range files {
+		hasChannels = hasChannels || f.IsDir()
+	}
+	if err := v.verifyServerFile(hasChannels); err != nil {
+		return nil, err
+	}
+	clients, err := v.verifyClientsFile()
+	if err != nil {
+		return nil, err
+	}
+	// ReadDir returns the entries sorted by name.
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		if err := v.verifyChannel(f.Name(), clients); err != nil {
+			return nil, fmt.Errorf("channel %q: %v", f.Name(), err)
+		}
+	}
+	return v.report, nil
+}
+
+// lockFileStoreDir acquires the lock file of the FileStore in `rootDir`,
+// the one acquired by FileStore.GetExclusiveLock. Closing the returned
+// file releases the lock.
+func lockFileStoreDir(rootDir string) (util.LockFile, error) {
+	lf, err := util.CreateLockFile(filepath.Join(rootDir, lockFileName))
+	if err == util.ErrUnableToLockNow {
+		return nil, fmt.Errorf("store is locked by another process")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire the lock of the store: %v", err)
+	}
+	return lf, nil
+}
+
+func (v *fileVerifier) logf(format string, args ...interface{}) {
+	if v.opts.Log != nil {
+		v.opts.Log.Noticef(format, args...)
+	}
+}
+
+// verifyServerFile checks the server file. It may be missing or empty only
+// if the store has no channel.
+func (v *fileVerifier) verifyServerFile(hasChannels bool) error {
+	name := filepath.Join(v.rootDir, serverFileName)
+	if fi, err := os.Stat(name); (err == nil && fi.Size() <= 4) || os.IsNotExist(err) {
+		if hasChannels {
+			v.report.add(&VerifyProblem{Kind: VerifyCorrupted, Location: serverFileName, Offset: -1,
+				Description: "server file is missing or empty"})
+		}
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	problem := func(err error) {
+		v.report.add(&VerifyProblem{Kind: VerifyCorrupted, Location: serverFileName, Offset: 0, Description: err.Error()})
+	}
+	if err := checkFileVersion(f); err != nil {
+		problem(err)
+		return nil
+	}
+	buf, size, _, err := readRecord(f, nil, false, v.crcTable, true, 0)
+	if err != nil {
+		problem(err)
+		return nil
+	}
+	info := &spb.ServerInfo{}
+	if err := info.Unmarshal(buf[:size]); err != nil {
+		problem(err)
+	}
+	return nil
+}
+
+func (v *fileVerifier) verifyClientsFile() (map[string]struct{}, error) {
+	clients := make(map[string]struct{})
+	name := filepath.Join(v.rootDir, clientsFileName)
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return clients, nil
+	}
+	bad, err := v.scanFile(name, true, func(_ int64, recType recordType, payload []byte) error {
+		switch recType {
+		case addClient:
+			c := &spb.ClientInfo{}
+			if err := c.Unmarshal(payload); err != nil {
+				return err
+			}
+			clients[c.ID] = struct{}{}
+		case delClient:
+			c := &spb.ClientDelete{}
+			if err := c.Unmarshal(payload); err != nil {
+				return err
+			}
+			delete(clients, c.ID)
+		default:
+			return fmt.Errorf("invalid client record type: %v", recType)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := v.handleBadRecords(name, "", bad); err != nil {
+		return nil, err
+	}
+	v.report.Clients = len(clients)
+	return clients, nil
+}
+
+func (v *fileVerifier) verifyChannel(channel string, clients map[string]struct{}) error {
+	dir := filepath.Join(v.rootDir, channel)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	v.report.Channels++
+	var slices []int
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, msgFilesPrefix) || !strings.HasSuffix(name, datSuffix) {
+			continue
+		}
+		fseq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, msgFilesPrefix), datSuffix))
+		if err != nil {
+			continue
+		}
+		slices = append(slices, fseq)
+	}
+	sort.Ints(slices)
+	var last uint64
+	for _, fseq := range slices {
+		if last, err = v.verifySlice(channel, fseq, last); err != nil {
+			return err
+		}
+	}
+	v.logf("Verified channel %q: %v slice(s), last sequence %v", channel, len(slices), last)
+	return v.verifySubs(channel, last, clients)
+}
+
+// verifySlice verifies the data and index files of a slice. `last` is the
+// last sequence of the previous slice, the last sequence of this slice is
+// returned (or `last` if it is empty).
+func (v *fileVerifier) verifySlice(channel string, fseq int, last uint64) (uint64, error) {
+	datName := filepath.Join(v.rootDir, channel, fmt.Sprintf("%s%v%s", msgFilesPrefix, fseq, datSuffix))
+	idxName := filepath.Join(v.rootDir, channel, fmt.Sprintf("%s%v%s", msgFilesPrefix, fseq, idxSuffix))
+	var recs []*sliceRecord
+	bad, err := v.scanFile(datName, false, func(offset int64, _ recordType, payload []byte) error {
+		m := &pb.MsgProto{}
+		if err := m.Unmarshal(payload); err != nil {
+			return err
+		}
+		if m.Sequence == 0 {
+			return fmt.Errorf("invalid message sequence 0")
+		}
+		recs = append(recs, &sliceRecord{seq: m.Sequence, offset: offset, timestamp: m.Timestamp, size: len(payload)})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range recs {
+		switch {
+		case last != 0 && r.seq <= last:
+			v.report.add(&VerifyProblem{Kind: VerifySequence, Channel: channel, Location: v.rel(datName),
+				Offset: r.offset, Seq: r.seq, Description: fmt.Sprintf("message is out of order, previous is %v", last)})
+		case last != 0 && r.seq != last+1:
+			v.report.add(&VerifyProblem{Kind: VerifySequence, Channel: channel, Location: v.rel(datName),
+				Offset: r.offset, Seq: r.seq, Description: fmt.Sprintf("messages %v to %v are missing", last+1, r.seq-1)})
+		}
+		if r.seq > last {
+			last = r.seq
+		}
+	}
+	v.report.Msgs += len(recs)
+
+	quarantined, err := v.handleBadRecords(datName, channel, bad)
+	if err != nil {
+		return 0, err
+	}
+	if quarantined {
+		// Records have moved, update their offsets.
+		shift, i := int64(0), 0
+		for _, r := range recs {
+			for ; i < len(bad) && bad[i].offset < r.offset; i++ {
+				shift += bad[i].size
+			}
+			r.offset -= shift
+		}
+		return last, v.writeIndex(idxName, recs)
+	}
+	// If there are corrupted records, the index is rebuilt when they are
+	// moved to quarantine, and would not match anyway.
+	if len(bad) > 0 {
+		return last, nil
+	}
+	if p := v.checkIndex(channel, idxName, recs); p != nil && v.opts.RebuildIndex {
+		if err := v.writeIndex(idxName, recs); err != nil {
+			return 0, err
+		}
+		p.Repaired = true
+	}
+	return last, nil
+}
+
+// checkIndex compares the index file with the records of the data file and
+// reports the first difference.
+func (v *fileVerifier) checkIndex(channel, idxName string, recs []*sliceRecord) *VerifyProblem {
+	problem := func(offset int64, seq uint64, format string, args ...interface{}) *VerifyProblem {
+		return v.report.add(&VerifyProblem{Kind: VerifyIndex, Channel: channel, Location: v.rel(idxName),
+			Offset: offset, Seq: seq, Description: fmt.Sprintf(format, args...)})
+	}
+	f, err := os.Open(idxName)
+	if err != nil {
+		return problem(-1, 0, "unable to open index file: %v", err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if err := checkFileVersion(br); err != nil {
+		return problem(0, 0, "%v", err)
+	}
+	buf := make([]byte, msgIndexRecSize)
+	offset := int64(4)
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(br, buf)
+		if err == io.EOF {
+			if i != len(recs) {
+				return problem(offset, 0, "index has %v entries, data file has %v messages", i, len(recs))
+			}
+			return nil
+		}
+		if err != nil {
+			return problem(offset, 0, "truncated index entry (%v bytes)", n)
+		}
+		seq, mindex, err := decodeIndex(buf, v.crcTable, true)
+		if err != nil {
+			if err == errNeedRewind {
+				return problem(offset, 0, "index is padded with zeros")
+			}
+			return problem(offset, 0, "%v", err)
+		}
+		if i >= len(recs) {
+			return problem(offset, seq, "index has more entries than the %v messages of the data file", len(recs))
+		}
+		r := recs[i]
+		if seq != r.seq || mindex.offset != r.offset || mindex.timestamp != r.timestamp || int(mindex.msgSize) != r.size {
+			return problem(offset, r.seq, "index entry does not match the data file (sequence %v, offset %v, size %v)",
+				seq, mindex.offset, mindex.msgSize)
+		}
+		offset += msgIndexRecSize
+	}
+}
+
+func (v *fileVerifier) writeIndex(idxName string, recs []*sliceRecord) error {
+	buf := make([]byte, 4+len(recs)*msgIndexRecSize)
+	util.ByteOrder.PutUint32(buf, fileVersion)
+	for i, r := range recs {
+		encodeIndex(buf[4+i*msgIndexRecSize:], r.seq, r.offset, r.timestamp, r.size, v.crcTable)
+	}
+	if err := writeFileAtomically(idxName, buf); err != nil {
+		return fmt.Errorf("unable to rebuild index file %q: %v", idxName, err)
+	}
+	v.logf("Rebuilt index file %q", idxName)
+	return nil
+}
+
+func (v *fileVerifier) verifySubs(channel string, last uint64, clients map[string]struct{}) error {
+	// The last checkpoint and the journals that follow it, if any, are
+	// read before the subscriptions file, as on recovery.
+	dir := filepath.Join(v.rootDir, channel)
+	files, _, _, err := subsFilesToRecover(dir)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, subsFileName)
+	if _, err := os.Stat(name); err == nil {
+		files = append(files, name)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	subs := make(map[uint64]*spb.SubState)
+	pending := make(map[uint64]map[uint64]struct{})
+	apply := func(_ int64, recType recordType, payload []byte) error {
+		switch recType {
+		case subRecCheckpoint:
+			ckp := subCheckpoint{pending: newSeqBitmap()}
+			if err := ckp.Unmarshal(payload); err != nil {
+				return err
+			}
+			subs[ckp.sub.ID] = ckp.sub
+			p := pending[ckp.sub.ID]
+			if p == nil {
+				p = make(map[uint64]struct{})
+				pending[ckp.sub.ID] = p
+			}
+			ckp.pending.forEach(func(seq uint64) error {
+				p[seq] = struct{}{}
+				return nil
+			})
+		case subRecNew, subRecUpdate:
+			sub := &spb.SubState{}
+			if err := sub.Unmarshal(payload); err != nil {
+				return err
+			}
+			subs[sub.ID] = sub
+			if pending[sub.ID] == nil {
+				pending[sub.ID] = make(map[uint64]struct{})
+			}
+		case subRecDel:
+			del := &spb.SubStateDelete{}
+			if err := del.Unmarshal(payload); err != nil {
+				return err
+			}
+			delete(subs, del.ID)
+			delete(pending, del.ID)
+		case subRecMsg, subRecAck:
+			update := &spb.SubStateUpdate{}
+			if err := update.Unmarshal(payload); err != nil {
+				return err
+			}
+			// Records for deleted subscriptions are expected, the
+			// server may process acks after the deletion.
+			if p := pending[update.ID]; p != nil {
+				if recType == subRecMsg {
+					p[update.Seqno] = struct{}{}
+				} else {
+					delete(p, update.Seqno)
+				}
+			}
+		default:
+			return fmt.Errorf("invalid subscription record type: %v", recType)
+		}
+		return nil
+	}
+	for _, fileName := range files {
+		bad, err := v.scanFile(fileName, true, apply)
+		if err != nil {
+			return err
+		}
+		if _, err := v.handleBadRecords(fileName, channel, bad); err != nil {
+			return err
+		}
+	}
+	ids := make([]uint64, 0, len(subs))
+	for id := range subs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		sub := subs[id]
+		if _, ok := clients[sub.ClientID]; !ok && subNeedsClient(sub) {
+			v.report.add(&VerifyProblem{Kind: VerifyOrphan, Channel: channel, Location: v.rel(name), Offset: -1,
+				Seq: id, Description: fmt.Sprintf("subscription %q belongs to unknown client %q", sub.Inbox, sub.ClientID)})
+		}
+		var maxPending uint64
+		for seq := range pending[id] {
+			if seq > maxPending {
+				maxPending = seq
+			}
+		}
+		if maxPending > last {
+			v.report.add(&VerifyProblem{Kind: VerifySequence, Channel: channel, Location: v.rel(name), Offset: -1,
+				Seq: id, Description: fmt.Sprintf("pending message %v is beyond the last message %v", maxPending, last)})
+		}
+	}
+	v.report.Subs += len(subs)
+	return nil
+}
+
+// scanFile reads the records of file `name` and invokes `cb` for each of
+// them. Records that can't be read or for which `cb` returns an error are
+// returned. After a record whose size is invalid, the next records can't
+// be located, so it is returned with the rest of the file.
+func (v *fileVerifier) scanFile(name string, typed bool, cb func(offset int64, recType recordType, payload []byte) error) ([]*badRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	br := bufio.NewReader(f)
+	if err := checkFileVersion(br); err != nil {
+		return []*badRecord{{offset: 0, size: size, err: err}}, nil
+	}
+	var (
+		bad    []*badRecord
+		header [recordHeaderSize]byte
+		buf    []byte
+	)
+	for offset := int64(4); offset < size; {
+		if size-offset < recordHeaderSize {
+			bad = append(bad, &badRecord{offset, size - offset, fmt.Errorf("truncated record header")})
+			break
+		}
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return nil, err
+		}
+		firstInt := int(util.ByteOrder.Uint32(header[:4]))
+		crc := util.ByteOrder.Uint32(header[4:])
+		recType, recSize := recNoType, firstInt
+		if typed {
+			recType = recordType(firstInt >> 24 & 0xFF)
+			recSize = firstInt & 0xFFFFFF
+		}
+		if firstInt == 0 && crc == 0 {
+			bad = append(bad, &badRecord{offset, size - offset, fmt.Errorf("end of file padded with zeros")})
+			break
+		}
+		total := int64(recordHeaderSize + recSize)
+		if offset+total > size {
+			bad = append(bad, &badRecord{offset, size - offset, fmt.Errorf("truncated record, expected %v bytes, got %v", total, size-offset)})
+			break
+		}
+		buf = util.EnsureBufBigEnough(buf, recSize)
+		if _, err := io.ReadFull(br, buf[:recSize]); err != nil {
+			return nil, err
+		}
+		if c := crc32.Checksum(buf[:recSize], v.crcTable); c != crc {
+			bad = append(bad, &badRecord{offset, total, fmt.Errorf("corrupted data, expected crc to be 0x%08x, got 0x%08x", crc, c)})
+		} else if err := cb(offset, recType, buf[:recSize]); err != nil {
+			bad = append(bad, &badRecord{offset, total, err})
+		}
+		offset += total
+	}
+	return bad, nil
+}
+
+// handleBadRecords reports the bad records of file `name` and, if a
+// quarantine directory is configured, moves them there. Returns true if
+// the file has been rewritten.
+func (v *fileVerifier) handleBadRecords(name, channel string, bad []*badRecord) (bool, error) {
+	if len(bad) == 0 {
+		return false, nil
+	}
+	rel := v.rel(name)
+	problems := make([]*VerifyProblem, len(bad))
+	for i, br := range bad {
+		problems[i] = v.report.add(&VerifyProblem{Kind: VerifyCorrupted, Channel: channel, Location: rel,
+			Offset: br.offset, Description: br.err.Error()})
+	}
+	if v.opts.QuarantineDir == "" {
+		return false, nil
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return false, err
+	}
+	qdir := filepath.Join(v.opts.QuarantineDir, filepath.Dir(rel))
+	if err := os.MkdirAll(qdir, os.ModeDir+os.ModePerm); err != nil {
+		return false, err
+	}
+	// If the file version is bad, the whole file is moved to quarantine
+	// and the file is rewritten with a valid version.
+	good := make([]byte, 4, len(content))
+	util.ByteOrder.PutUint32(good, fileVersion)
+	start := int64(4)
+	for _, br := range bad {
+		qname := filepath.Join(qdir, fmt.Sprintf("%s.%v", filepath.Base(name), br.offset))
+		if err := os.WriteFile(qname, content[br.offset:br.offset+br.size], 0666); err != nil {
+			return false, err
+		}
+		if br.offset > start {
+			good = append(good, content[start:br.offset]...)
+		}
+		start = br.offset + br.size
+	}
+	if start < int64(len(content)) {
+		good = append(good, content[start:]...)
+	}
+	// The state saved for LazyRecovery would no longer match the slices.
+	if err := removeMsgsState(filepath.Dir(name)); err != nil {
+		return false, err
+	}
+	if err := writeFileAtomically(name, good); err != nil {
+		return false, err
+	}
+	for _, p := range problems {
+		p.Repaired = true
+	}
+	v.logf("Moved %v corrupted record(s) of %q to %q", len(bad), name, qdir)
+	return true, nil
+}
+
+func (v *fileVerifier) rel(name string) string {
+	if rel, err := filepath.Rel(v.rootDir, name); err == nil {
+		return rel
+	}
+	return name
+}
+
+// writeFileAtomically replaces the content of file `name` with `content`.
+func writeFileAtomically(name string, content []byte) error {
+	tmpName := name + ".tmp"
+	f, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, name)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+type sqlVerifier struct {
+	db     *sql.DB
+	driver string
+	opts   *VerifyOptions
+	report *VerifyReport
+}
+
+// VerifySQLStore checks the tables of the SQL store: that records can be
+// decoded, the continuity of message sequences and that subscriptions,
+// messages and pending messages reference existing clients, channels and
+// subscriptions. Corrupted messages and subscriptions can be moved to a
+// quarantine directory, see VerifyOptions. In that case, the lock of the
+// store is acquired first, and VerifySQLStore fails if it is held by
+// another process. The server must not be running.
+func VerifySQLStore(driver, source string, opts *VerifyOptions) (*VerifyReport, error) {
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+	if opts.repair() {
+		ls, err := lockSQLStore(driver, source, opts.Log)
+		if err != nil {
+			return nil, err
+		}
+		defer ls.Close()
+	}
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	v := &sqlVerifier{db: db, driver: driver, opts: opts, report: &VerifyReport{}}
+	if err := v.verify(); err != nil {
+		return nil, err
+	}
+	return v.report, nil
+}
+
+// lockSQLStore opens the SQL store and acquires its lock, the one acquired
+// by SQLStore.GetExclusiveLock. Closing the returned store releases the
+// lock.
+func lockSQLStore(driver, source string, log logger.Logger) (*SQLStore, error) {
+	if log == nil {
+		log = logger.NewStanLogger()
+	}
+	s, err := NewSQLStore(log, driver, source, nil, SQLNoCaching(true))
+	if err != nil {
+		return nil, err
+	}
+	if err := lockStore(s, "SQL"); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// stmt adapts a statement written for MySQL to the driver.
+func (v *sqlVerifier) stmt(stmt string) string {
+	if v.driver != driverPostgres {
+		return stmt
+	}
+	for n := 1; strings.IndexByte(stmt, '?') != -1; n++ {
+		stmt = strings.Replace(stmt, "?", "$"+strconv.Itoa(n), 1)
+	}
+	return stmt
+}
+
+func (v *sqlVerifier) verify() error {
+	var (
+		count int
+		proto []byte
+	)
+	rows, err := v.db.Query("SELECT proto FROM ServerInfo")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		if err := rows.Scan(&proto); err != nil {
+			rows.Close()
+			return err
+		}
+		count++
+		if err := (&spb.ServerInfo{}).Unmarshal(proto); err != nil {
+			v.report.add(&VerifyProblem{Kind: VerifyCorrupted, Location: "ServerInfo", Offset: -1, Description: err.Error()})
+		}
+	}
+	rows.Close()
+	if count != 1 {
+		v.report.add(&VerifyProblem{Kind: VerifyCorrupted, Location: "ServerInfo", Offset: -1,
+			Description: fmt.Sprintf("expected 1 row, got %v", count)})
+	}
+
+	clients := make(map[string]struct{})
+	rows, err = v.db.Query("SELECT id FROM Clients")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		clients[id] = struct{}{}
+	}
+	rows.Close()
+	v.report.Clients = len(clients)
+
+	// Channels marked as deleted are being removed in the background,
+	// their messages and subscriptions are not orphans.
+	type sqlChannel struct {
+		id   int64
+		name string
+		last uint64
+	}
+	var channels []*sqlChannel
+	allChannels := make(map[int64]*sqlChannel)
+	rows, err = v.db.Query("SELECT id, name, deleted FROM Channels ORDER BY id")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var deleted bool
+		c := &sqlChannel{}
+		if err := rows.Scan(&c.id, &c.name, &deleted); err != nil {
+			rows.Close()
+			return err
+		}
+		allChannels[c.id] = c
+		if !deleted {
+			channels = append(channels, c)
+		}
+	}
+	rows.Close()
+	// The table does not exist before version 2 of the schema.
+	partitions, _ := sqlMsgsPartitions(v.db)
+	for id := range partitions {
+		if allChannels[id] == nil {
+			v.report.add(&VerifyProblem{Kind: VerifyOrphan, Location: "MsgsPartitions", Offset: -1,
+				Description: fmt.Sprintf("messages table belongs to unknown channel ID %v", id)})
+		}
+	}
+	for _, c := range channels {
+		if c.last, err = v.verifyMsgs(c.id, c.name, sqlMsgsTable(c.id, partitions[c.id])); err != nil {
+			return fmt.Errorf("channel %q: %v", c.name, err)
+		}
+		v.report.Channels++
+	}
+
+	var ids []int64
+	rows, err = v.db.Query("SELECT DISTINCT id FROM Messages")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		if allChannels[id] == nil {
+			v.report.add(&VerifyProblem{Kind: VerifyOrphan, Location: "Messages", Offset: -1,
+				Description: fmt.Sprintf("messages belong to unknown channel ID %v", id)})
+		}
+	}
+
+	subIDs := make(map[uint64]struct{})
+	type badSub struct {
+		id    int64
+		subID uint64
+		proto []byte
+	}
+	var badSubs []*badSub
+	rows, err = v.db.Query("SELECT id, subid, deleted, proto FROM Subscriptions ORDER BY id, subid")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			id      int64
+			subID   uint64
+			deleted bool
+		)
+		if err := rows.Scan(&id, &subID, &deleted, &proto); err != nil {
+			rows.Close()
+			return err
+		}
+		subIDs[subID] = struct{}{}
+		if deleted {
+			continue
+		}
+		c := allChannels[id]
+		if c == nil {
+			v.report.add(&VerifyProblem{Kind: VerifyOrphan, Location: "Subscriptions", Offset: -1, Seq: subID,
+				Description: fmt.Sprintf("subscription belongs to unknown channel ID %v", id)})
+			continue
+		}
+		sub := &spb.SubState{}
+		if err := sub.Unmarshal(proto); err != nil {
+			badSubs = append(badSubs, &badSub{id, subID, append([]byte(nil), proto...)})
+			continue
+		}
+		v.report.Subs++
+		if _, ok := clients[sub.ClientID]; !ok && subNeedsClient(sub) {
+			v.report.add(&VerifyProblem{Kind: VerifyOrphan, Channel: c.name, Location: "Subscriptions", Offset: -1,
+				Seq: subID, Description: fmt.Sprintf("subscription %q belongs to unknown client %q", sub.Inbox, sub.ClientID)})
+		}
+	}
+	rows.Close()
+	for _, bs := range badSubs {
+		p := v.report.add(&VerifyProblem{Kind: VerifyCorrupted, Channel: allChannels[bs.id].name, Location: "Subscriptions",
+			Offset: -1, Seq: bs.subID, Description: "unable to decode subscription"})
+		if v.opts.QuarantineDir != "" {
+			if err := v.quarantine("Subscriptions", fmt.Sprintf("%v.%v", bs.id, bs.subID), bs.proto,
+				"DELETE FROM Subscriptions WHERE id=? AND subid=?", bs.id, bs.subID); err != nil {
+				return err
+			}
+			p.Repaired = true
+		}
+	}
+
+	var pendingIDs []uint64
+	rows, err = v.db.Query("SELECT DISTINCT subid FROM SubsPending")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var subID uint64
+		if err := rows.Scan(&subID); err != nil {
+			rows.Close()
+			return err
+		}
+		pendingIDs = append(pendingIDs, subID)
+	}
+	rows.Close()
+	for _, subID := range pendingIDs {
+		if _, ok := subIDs[subID]; !ok {
+			v.report.add(&VerifyProblem{Kind: VerifyOrphan, Location: "SubsPending", Offset: -1, Seq: subID,
+				Description: "pending messages belong to unknown subscription"})
+		}
+	}
+	return nil
+}
+
+// verifyMsgs checks the messages of a channel, stored in `table`, and
+// returns its last sequence.
+func (v *sqlVerifier) verifyMsgs(id int64, channel, table string) (uint64, error) {
+	type badMsg struct {
+		seq  uint64
+		data []byte
+		err  error
+	}
+	var (
+		last uint64
+		bad  []*badMsg
+	)
+	rows, err := v.db.Query(v.stmt(sqlMsgsTableStmt("SELECT seq, timestamp, size, data FROM Messages WHERE id=? ORDER BY seq", table)), id)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var (
+			seq       uint64
+			timestamp int64
+			size      int
+			data      []byte
+		)
+		if err := rows.Scan(&seq, &timestamp, &size, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		v.report.Msgs++
+		if last != 0 && seq != last+1 {
+			v.report.add(&VerifyProblem{Kind: VerifySequence, Channel: channel, Location: "Messages", Offset: -1,
+				Seq: seq, Description: fmt.Sprintf("messages %v to %v are missing", last+1, seq-1)})
+		}
+		last = seq
+		m := &pb.MsgProto{}
+		var merr error
+		if size != len(data) {
+			merr = fmt.Errorf("size is %v, data has %v bytes", size, len(data))
+		} else if err := m.Unmarshal(data); err != nil {
+			merr = fmt.Errorf("unable to decode message: %v", err)
+		} else if m.Sequence != seq || m.Timestamp != timestamp {
+			merr = fmt.Errorf("message has sequence %v and timestamp %v, row has %v and %v",
+				m.Sequence, m.Timestamp, seq, timestamp)
+		}
+		if merr != nil {
+			bad = append(bad, &badMsg{seq, append([]byte(nil), data...), merr})
+		}
+	}
+	rows.Close()
+	for _, bm := range bad {
+		p := v.report.add(&VerifyProblem{Kind: VerifyCorrupted, Channel: channel, Location: "Messages", Offset: -1,
+			Seq: bm.seq, Description: bm.err.Error()})
+		if v.opts.QuarantineDir != "" {
+			if err := v.quarantine("Messages", fmt.Sprintf("%v.%v", id, bm.seq), bm.data,
+				sqlMsgsTableStmt("DELETE FROM Messages WHERE id=? AND seq=?", table), id, bm.seq); err != nil {
+				return 0, err
+			}
+			p.Repaired = true
+		}
+	}
+	if v.opts.Log != nil {
+		v.opts.Log.Noticef("Verified channel %q: last sequence %v", channel, last)
+	}
+	return last, nil
+}
+
+// quarantine writes `data` to a file named after the table and `key` in
+// the quarantine directory, and then removes the row with `deleteStmt`.
+func (v *sqlVerifier) quarantine(table, key string, data []byte, deleteStmt string, args ...interface{}) error {
+	dir := filepath.Join(v.opts.QuarantineDir, table)
+	if err := os.MkdirAll(dir, os.ModeDir+os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, key), data, 0666); err != nil {
+		return err
+	}
+	if _, err := v.db.Exec(v.stmt(deleteStmt), args...); err != nil {
+		return fmt.Errorf("unable to remove %s %v: %v", table, key, err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubemq-io/broker/server/stan/spb"
+)
+
+func checkVerifyProblems(t *testing.T, report *VerifyReport, expected ...string) {
+	t.Helper()
+	var problems []string
+	for _, p := range report.Problems {
+		if p.Repaired {
+			problems = append(problems, p.Kind+" [repaired]")
+		} else {
+			problems = append(problems, p.Kind)
+		}
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Fatalf("Expected problems %q, got %q", expected, report.Problems)
+	}
+}
+
+func TestFSVerify(t *testing.T) {
+	cleanupFSDatastore(t)
+	defer cleanupFSDatastore(t)
+	qdir := filepath.Join(testFSDefaultDatastore, "..", "quarantine")
+	os.RemoveAll(qdir)
+	defer os.RemoveAll(qdir)
+
+	s := createDefaultFileStore(t, SliceConfig(5, 0, 0, ""))
+	defer s.Close()
+	storeAddClient(t, s, "me", "hbInbox")
+	c := storeCreateChannel(t, s, "foo")
+	for seq := uint64(1); seq <= 12; seq++ {
+		storeMsg(t, c, "foo", seq, []byte(fmt.Sprintf("msg%d", seq)))
+	}
+	subID := storeSub(t, c, "foo")
+	storeSubPending(t, c, "foo", subID, 1, 2)
+	orphan := &spb.SubState{ClientID: "gone", Inbox: "inbox", AckInbox: "ackInbox", AckWaitInSecs: 10}
+	if err := c.Subs.CreateSub(orphan); err != nil {
+		t.Fatalf("Error creating subscription: %v", err)
+	}
+	s.Close()
+
+	verify := func(opts *VerifyOptions) *VerifyReport {
+		t.Helper()
+		report, err := VerifyFileStore(testFSDefaultDatastore, opts)
+		if err != nil {
+			t.Fatalf("Error on verify: %v", err)
+		}
+		return report
+	}
+	report := verify(nil)
+	checkVerifyProblems(t, report, VerifyOrphan)
+	if report.Clients != 1 || report.Channels != 1 || report.Msgs != 12 || report.Subs != 2 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+
+	// Corrupt the payload of the last message of the first slice, and
+	// truncate the index file of the second slice.
+	dat := filepath.Join(testFSDefaultDatastore, "foo", msgFilesPrefix+"1"+datSuffix)
+	content, err := os.ReadFile(dat)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+	content[len(content)-1] ^= 0xFF
+	if err := os.WriteFile(dat, content, 0666); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	idx := filepath.Join(testFSDefaultDatastore, "foo", msgFilesPrefix+"2"+idxSuffix)
+	fi, err := os.Stat(idx)
+	if err != nil {
+		t.Fatalf("Error on stat: %v", err)
+	}
+	if err := os.Truncate(idx, fi.Size()-4); err != nil {
+		t.Fatalf("Error truncating file: %v", err)
+	}
+	checkVerifyProblems(t, verify(nil), VerifyCorrupted, VerifySequence, VerifyIndex, VerifyOrphan)
+
+	report = verify(&VerifyOptions{RebuildIndex: true, QuarantineDir: qdir, Log: testLogger})
+	checkVerifyProblems(t, report, VerifyCorrupted+" [repaired]", VerifySequence, VerifyIndex+" [repaired]", VerifyOrphan)
+	if report.Unrepaired() != 2 {
+		t.Fatalf("Expected 2 unrepaired problems, got %v", report.Unrepaired())
+	}
+	quarantined, err := os.ReadFile(filepath.Join(qdir, "foo", fmt.Sprintf("%s.%v", filepath.Base(dat), report.Problems[0].Offset)))
+	if err != nil {
+		t.Fatalf("Error reading quarantined record: %v", err)
+	}
+	if !bytes.Equal(quarantined, content[report.Problems[0].Offset:]) {
+		t.Fatalf("Unexpected quarantined record: %v", quarantined)
+	}
+	checkVerifyProblems(t, verify(nil), VerifySequence, VerifyOrphan)
+
+	// The store should recover what is left.
+	s, state := openDefaultFileStore(t, SliceConfig(5, 0, 0, ""))
+	defer s.Close()
+	ms := getRecoveredChannel(t, state, "foo").Msgs
+	for seq := uint64(1); seq <= 12; seq++ {
+		m := msgStoreLookup(t, ms, seq)
+		if seq == 5 {
+			if m != nil {
+				t.Fatalf("Message 5 should have been removed, got %v", m)
+			}
+		} else if m == nil || string(m.Data) != fmt.Sprintf("msg%d", seq) {
+			t.Fatalf("Unexpected message %v: %v", seq, m)
+		}
+	}
+	if n, _ := msgStoreState(t, ms); n != 11 {
+		t.Fatalf("Expected 11 messages, got %v", n)
+	}
+}
+
+func TestSQLVerify(t *testing.T) {
+	if !doSQL {
+		t.SkipNow()
+	}
+	cleanupSQLDatastore(t)
+	defer cleanupSQLDatastore(t)
+	qdir, err := os.MkdirTemp("", "sql_quarantine")
+	if err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	defer os.RemoveAll(qdir)
+
+	s := createDefaultSQLStore(t)
+	defer s.Close()
+	storeAddClient(t, s, "me", "hbInbox")
+	c := storeCreateChannel(t, s, "foo")
+	for seq := uint64(1); seq <= 3; seq++ {
+		storeMsg(t, c, "foo", seq, []byte(fmt.Sprintf("msg%d", seq)))
+	}
+	storeSub(t, c, "foo")
+	orphan := &spb.SubState{ClientID: "gone", Inbox: "inbox", AckInbox: "ackInbox", AckWaitInSecs: 10}
+	if err := c.Subs.CreateSub(orphan); err != nil {
+		t.Fatalf("Error creating subscription: %v", err)
+	}
+	s.Close()
+
+	verify := func(opts *VerifyOptions) *VerifyReport {
+		t.Helper()
+		report, err := VerifySQLStore(testSQLDriver, testSQLSource, opts)
+		if err != nil {
+			t.Fatalf("Error on verify: %v", err)
+		}
+		return report
+	}
+	report := verify(nil)
+	checkVerifyProblems(t, report, VerifyOrphan)
+	if report.Clients != 1 || report.Channels != 1 || report.Msgs != 3 || report.Subs != 2 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+
+	db := getDBConnection(t)
+	defer db.Close()
+	v := &sqlVerifier{driver: testSQLDriver}
+	if _, err := db.Exec(v.stmt("UPDATE Messages SET data=? WHERE seq=?"), []byte{1, 2, 3}, 2); err != nil {
+		t.Fatalf("Error updating message: %v", err)
+	}
+	checkVerifyProblems(t, verify(nil), VerifyCorrupted, VerifyOrphan)
+	checkVerifyProblems(t, verify(&VerifyOptions{QuarantineDir: qdir}), VerifyCorrupted+" [repaired]", VerifyOrphan)
+	if _, err := os.Stat(filepath.Join(qdir, "Messages", fmt.Sprintf("%v.2", c.Msgs.(*SQLMsgStore).channelID))); err != nil {
+		t.Fatalf("Message should have been quarantined: %v", err)
+	}
+	checkVerifyProblems(t, verify(nil), VerifySequence, VerifyOrphan)
+}
+
+func TestSQLVerifyLocked(t *testing.T) {
+	if !doSQL {
+		t.SkipNow()
+	}
+	cleanupSQLDatastore(t)
+	defer cleanupSQLDatastore(t)
+
+	sqlLockUpdateInterval = 250 * time.Millisecond
+	sqlLockLostCount = 2
+	defer func() {
+		sqlLockUpdateInterval = sqlDefaultLockUpdateInterval
+		sqlLockLostCount = sqlDefaultLockLostCount
+	}()
+
+	s := createDefaultSQLStore(t)
+	defer s.Close()
+	if locked, err := s.GetExclusiveLock(); !locked || err != nil {
+		t.Fatalf("Error getting lock: %v", err)
+	}
+	// The store can be checked, but not repaired, while it is locked.
+	if _, err := VerifySQLStore(testSQLDriver, testSQLSource, nil); err != nil {
+		t.Fatalf("Error on verify: %v", err)
+	}
+	qdir, err := os.MkdirTemp("", "sql_quarantine")
+	if err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	defer os.RemoveAll(qdir)
+	if _, err := VerifySQLStore(testSQLDriver, testSQLSource, &VerifyOptions{QuarantineDir: qdir}); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Fatalf("Expected error about locked store, got %v", err)
+	}
+	s.Close()
+	if _, err := VerifySQLStore(testSQLDriver, testSQLSource, &VerifyOptions{QuarantineDir: qdir}); err != nil {
+		t.Fatalf("Error on verify: %v", err)
+	}
+}
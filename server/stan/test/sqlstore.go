@@ -19,6 +19,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -26,10 +27,15 @@ import (
 const (
 	DriverMySQL    = "mysql"
 	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite3"
 )
 
 // CreateSQLDatabase initializes a SQL Database for NATS Streaming testing.
+// For SQLite, `dbName` is the name of the database file.
 func CreateSQLDatabase(driver, sourceAdmin, source, dbName string) error {
+	if driver == DriverSQLite {
+		return createSQLiteDatabase(source, dbName)
+	}
 	db, err := sql.Open(driver, sourceAdmin)
 	if err != nil {
 		return fmt.Errorf("error opening connection to SQL datastore %q: %v", sourceAdmin, err)
@@ -71,8 +77,38 @@ func CreateSQLDatabase(driver, sourceAdmin, source, dbName string) error {
 	return nil
 }
 
+func createSQLiteDatabase(source, dbName string) error {
+	if err := removeSQLiteFiles(dbName); err != nil {
+		return fmt.Errorf("error removing database: %v", err)
+	}
+	db, err := sql.Open(DriverSQLite, source)
+	if err != nil {
+		return fmt.Errorf("error opening connection to SQL datastore %q: %v", source, err)
+	}
+	defer db.Close()
+	sqlCreateDatabase, err := loadCreateDatabaseStmts(DriverSQLite)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range sqlCreateDatabase {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("error executing statement (%s): %v", stmt, err)
+		}
+	}
+	return nil
+}
+
+func removeSQLiteFiles(dbName string) error {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := os.Remove(dbName + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 func loadCreateDatabaseStmts(driver string) ([]string, error) {
-	fileName := "../scripts/" + driver + ".db.sql"
+	fileName := "../" + driver + ".db.sql"
 	file, err := os.Open(fileName)
 	if err != nil {
 		return nil, fmt.Errorf("error opening file: %v", err)
@@ -108,10 +144,14 @@ func CleanupSQLDatastore(t TLogger, driver, source string) {
 	MustExecuteSQL(t, db, "DELETE FROM Messages")
 	MustExecuteSQL(t, db, "DELETE FROM Subscriptions")
 	MustExecuteSQL(t, db, "DELETE FROM SubsPending")
+	MustExecuteSQL(t, db, "DELETE FROM MsgsPartitions")
 }
 
 // DeleteSQLDatabase drops the given database.
 func DeleteSQLDatabase(driver, sourceAdmin, dbName string) error {
+	if driver == DriverSQLite {
+		return removeSQLiteFiles(dbName)
+	}
 	db, err := sql.Open(driver, sourceAdmin)
 	if err != nil {
 		return err
@@ -145,14 +185,23 @@ func AddSQLFlags(fs *flag.FlagSet, driver, source, sourceAdmin, dbName *string)
 func ProcessSQLFlags(fs *flag.FlagSet, defaults map[string][]string) error {
 	driver := fs.Lookup("sql_driver").Value.String()
 	switch driver {
-	case DriverMySQL, DriverPostgres:
+	case DriverMySQL, DriverPostgres, DriverSQLite:
 	default:
 		return fmt.Errorf("unsupported SQL driver %q", driver)
 	}
 	defaultsSources := defaults[driver]
 	source := fs.Lookup("sql_source")
 	if source.Value.String() == "" {
-		source.Value.Set(defaultsSources[0])
+		if driver == DriverSQLite {
+			// The database is a file, keep it out of the source tree. The
+			// process ID avoids collisions between packages tested in parallel.
+			dbName := fs.Lookup("sql_db_name")
+			path := filepath.Join(os.TempDir(), fmt.Sprintf("%s_%d", dbName.Value.String(), os.Getpid()))
+			source.Value.Set(path)
+			dbName.Value.Set(path)
+		} else {
+			source.Value.Set(defaultsSources[0])
+		}
 	}
 	sourceAdmin := fs.Lookup("sql_source_admin")
 	if sourceAdmin.Value.String() == "" {
@@ -0,0 +1,146 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command export writes the messages of a channel of a NATS Streaming store
+// as JSON lines or length-delimited protobuf messages. The server must not
+// be running, use the /streaming/export monitoring endpoint otherwise.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	natsdLogger "github.com/kubemq-io/broker/server/gnatsd/logger"
+	stand "github.com/kubemq-io/broker/server/stan/server"
+	"github.com/kubemq-io/broker/server/stan/stores"
+
+	_ "github.com/go-sql-driver/mysql"                             // mysql driver
+	_ "github.com/kubemq-io/broker/server/stan/stores/pqdeadlines" // wrapper for postgres that gives read/write deadlines
+	_ "github.com/lib/pq"                                          // postgres driver
+	_ "github.com/mattn/go-sqlite3"                                // sqlite driver
+)
+
+var usageStr = `
+Usage: export -config <file> -channel <name> [options]
+
+The store is described with a Streaming Server configuration file, using
+the store type, directory, SQL, Bolt, encryption and compression settings.
+
+Options:
+    -config <file>       Configuration file of the store
+    -channel <name>      Channel to export
+    -format <string>     Format of the output: json (default) or proto
+    -o <file>            Output file (default: standard output)
+    -first_seq <seq>     Sequence of the first message to export
+    -last_seq <seq>      Sequence of the last message to export
+    -start <time>        Export messages stored at or after this time (RFC3339)
+    -end <time>          Export messages stored before this time (RFC3339)
+    -D                   Enable debug output
+
+Payloads are exported decrypted and decompressed.
+`
+
+func usage() {
+	fmt.Printf("%s\n", usageStr)
+	os.Exit(0)
+}
+
+func main() {
+	var (
+		configFile string
+		channel    string
+		output     string
+		start      string
+		end        string
+		opts       stand.ExportOptions
+		debug      bool
+	)
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = usage
+	fs.StringVar(&configFile, "config", "", "")
+	fs.StringVar(&channel, "channel", "", "")
+	fs.StringVar(&opts.Format, "format", stand.ExportFormatJSON, "")
+	fs.StringVar(&output, "o", "", "")
+	fs.Uint64Var(&opts.FirstSeq, "first_seq", 0, "")
+	fs.Uint64Var(&opts.LastSeq, "last_seq", 0, "")
+	fs.StringVar(&start, "start", "", "")
+	fs.StringVar(&end, "end", "", "")
+	fs.BoolVar(&debug, "D", false, "")
+	fs.Parse(os.Args[1:])
+	if configFile == "" || channel == "" {
+		usage()
+	}
+	log := natsdLogger.NewStdLogger(true, debug, false, true, false)
+
+	var err error
+	if start != "" {
+		if opts.Start, err = time.Parse(time.RFC3339Nano, start); err != nil {
+			log.Fatalf("Invalid start time: %v", err)
+		}
+	}
+	if end != "" {
+		if opts.End, err = time.Parse(time.RFC3339Nano, end); err != nil {
+			log.Fatalf("Invalid end time: %v", err)
+		}
+	}
+
+	sOpts := stand.GetDefaultOptions()
+	if err := stand.ProcessConfigFile(configFile, sOpts); err != nil {
+		log.Fatalf("Error processing %q: %v", configFile, err)
+	}
+	// Limits are applied when the store is recovered, and we want
+	// everything that is in there.
+	sOpts.StoreLimits = stores.StoreLimits{}
+	s, err := stand.NewStore(log, sOpts)
+	if err != nil {
+		log.Fatalf("Unable to open store: %v", err)
+	}
+	defer s.Close()
+	state, err := s.Recover()
+	if err != nil {
+		s.Close()
+		log.Fatalf("Unable to recover store: %v", err)
+	}
+	var rc *stores.RecoveredChannel
+	if state != nil {
+		rc = state.Channels[channel]
+	}
+	if rc == nil {
+		s.Close()
+		log.Fatalf("Channel %q not found", channel)
+	}
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if output != "" {
+		if f, err = os.Create(output); err != nil {
+			s.Close()
+			log.Fatalf("Unable to create output file: %v", err)
+		}
+		w = f
+	}
+	n, err := stand.ExportMsgs(rc.Channel.Msgs, w, &opts)
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		s.Close()
+		log.Fatalf("Export failed after %v message(s): %v", n, err)
+	}
+	log.Noticef("Exported %v message(s) of channel %q", n, channel)
+}
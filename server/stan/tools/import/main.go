@@ -0,0 +1,132 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command import stores in a channel of a NATS Streaming store the messages
+// written by the export command. The server must not be running, use the
+// /streaming/import monitoring endpoint otherwise.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	natsdLogger "github.com/kubemq-io/broker/server/gnatsd/logger"
+	stand "github.com/kubemq-io/broker/server/stan/server"
+	"github.com/kubemq-io/broker/server/stan/stores"
+
+	_ "github.com/go-sql-driver/mysql"                             // mysql driver
+	_ "github.com/kubemq-io/broker/server/stan/stores/pqdeadlines" // wrapper for postgres that gives read/write deadlines
+	_ "github.com/lib/pq"                                          // postgres driver
+	_ "github.com/mattn/go-sqlite3"                                // sqlite driver
+)
+
+var usageStr = `
+Usage: import -config <file> -channel <name> [options]
+
+The store is described with a Streaming Server configuration file, using
+the store type, directory, SQL, Bolt, encryption, compression and limits
+settings.
+
+Options:
+    -config <file>       Configuration file of the store
+    -channel <name>      Channel to import into, created if needed
+    -format <string>     Format of the input: json (default) or proto
+    -i <file>            Input file (default: standard input)
+    -D                   Enable debug output
+
+Messages are added after the last message of the channel, with new
+sequences. Their timestamps are preserved, unless older than the ones
+already in the channel. The server must have been started once with this
+store, and must not be part of a cluster: use the /streaming/import
+monitoring endpoint of the leader instead.
+`
+
+func usage() {
+	fmt.Printf("%s\n", usageStr)
+	os.Exit(0)
+}
+
+func main() {
+	var (
+		configFile string
+		channel    string
+		input      string
+		opts       stand.ImportOptions
+		debug      bool
+	)
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = usage
+	fs.StringVar(&configFile, "config", "", "")
+	fs.StringVar(&channel, "channel", "", "")
+	fs.StringVar(&opts.Format, "format", stand.ExportFormatJSON, "")
+	fs.StringVar(&input, "i", "", "")
+	fs.BoolVar(&debug, "D", false, "")
+	fs.Parse(os.Args[1:])
+	if configFile == "" || channel == "" {
+		usage()
+	}
+	log := natsdLogger.NewStdLogger(true, debug, false, true, false)
+
+	sOpts := stand.GetDefaultOptions()
+	if err := stand.ProcessConfigFile(configFile, sOpts); err != nil {
+		log.Fatalf("Error processing %q: %v", configFile, err)
+	}
+	if sOpts.StoreType == stores.TypeMemory {
+		log.Fatalf("Can't import into a MEMORY store")
+	}
+	if sOpts.Clustering.Clustered {
+		log.Fatalf("Can't import into the store of a clustered server")
+	}
+	var r io.Reader = os.Stdin
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			log.Fatalf("Unable to open input file: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	s, err := stand.NewStore(log, sOpts)
+	if err != nil {
+		log.Fatalf("Unable to open store: %v", err)
+	}
+	defer s.Close()
+	state, err := s.Recover()
+	if err != nil {
+		s.Close()
+		log.Fatalf("Unable to recover store: %v", err)
+	}
+	if state == nil {
+		s.Close()
+		log.Fatalf("Store has not been initialized, start the server once first")
+	}
+	var c *stores.Channel
+	if rc := state.Channels[channel]; rc != nil {
+		c = rc.Channel
+	} else if c, err = s.CreateChannel(channel); err != nil {
+		s.Close()
+		log.Fatalf("Unable to create channel %q: %v", channel, err)
+	}
+	res, err := stand.ImportMsgs(channel, c.Msgs, r, &opts)
+	if err != nil {
+		s.Close()
+		log.Fatalf("Import failed after %v message(s): %v", res.Msgs, err)
+	}
+	log.Noticef("Imported %v message(s) in channel %q", res.Msgs, channel)
+	if res.AdjustedTimestamps > 0 {
+		log.Warnf("Timestamp of %v message(s) raised to the one of the last message of the channel", res.AdjustedTimestamps)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command migrate copies the content of a NATS Streaming store into a store
+// of another type (or the same type with different options), for instance
+// from a FileStore to a SQLStore. The server must not be running.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	natsdLogger "github.com/kubemq-io/broker/server/gnatsd/logger"
+	stand "github.com/kubemq-io/broker/server/stan/server"
+	"github.com/kubemq-io/broker/server/stan/stores"
+
+	_ "github.com/go-sql-driver/mysql"                             // mysql driver
+	_ "github.com/kubemq-io/broker/server/stan/stores/pqdeadlines" // wrapper for postgres that gives read/write deadlines
+	_ "github.com/lib/pq"                                          // postgres driver
+	_ "github.com/mattn/go-sqlite3"                                // sqlite driver
+)
+
+var usageStr = `
+Usage: migrate -src <file> -dst <file> [options]
+
+Stores are described with Streaming Server configuration files, using
+the store type, directory, SQL, Bolt, encryption and compression settings.
+The limits of the destination configuration are applied, the ones of the
+source configuration are ignored.
+
+Options:
+    -src <file>      Configuration file of the source store
+    -dst <file>      Configuration file of the destination store
+    -verify          Compare the migrated messages with the source ones
+    -D               Enable debug output
+
+If both stores are encrypted with different keys, the keys must be set in
+the configuration files: the NATS_STREAMING_ENCRYPTION_KEY environment
+variable would otherwise be used for both stores.
+
+The migration can be run again after an interruption: messages, clients and
+subscriptions that are already in the destination store are skipped.
+
+The exclusive lock of both stores (the one used in FT mode) is acquired, and
+the migration fails if one of them is held by another process.
+`
+
+func usage() {
+	fmt.Printf("%s\n", usageStr)
+	os.Exit(0)
+}
+
+func main() {
+	var (
+		srcFile string
+		dstFile string
+		verify  bool
+		debug   bool
+	)
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = usage
+	fs.StringVar(&srcFile, "src", "", "")
+	fs.StringVar(&dstFile, "dst", "", "")
+	fs.BoolVar(&verify, "verify", false, "")
+	fs.BoolVar(&debug, "D", false, "")
+	fs.Parse(os.Args[1:])
+	if srcFile == "" || dstFile == "" {
+		usage()
+	}
+	log := natsdLogger.NewStdLogger(true, debug, false, true, false)
+
+	srcOpts, err := loadOptions(srcFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	// Limits are applied when the source store is recovered, and we
+	// want everything that is in there.
+	srcOpts.StoreLimits = stores.StoreLimits{}
+	dstOpts, err := loadOptions(dstFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if srcOpts.StoreType == stores.TypeMemory || dstOpts.StoreType == stores.TypeMemory {
+		log.Fatalf("Can't migrate from or to a MEMORY store")
+	}
+
+	src, err := stand.NewStore(log, srcOpts)
+	if err != nil {
+		log.Fatalf("Unable to open source store: %v", err)
+	}
+	defer src.Close()
+	dst, err := stand.NewStore(log, dstOpts)
+	if err != nil {
+		src.Close()
+		log.Fatalf("Unable to open destination store: %v", err)
+	}
+	defer dst.Close()
+
+	report, err := stores.Migrate(src, dst, &stores.MigrateOptions{Verify: verify, Log: log})
+	if err != nil {
+		src.Close()
+		dst.Close()
+		log.Fatalf("Migration failed: %v", err)
+	}
+	var copied, subs int
+	for _, mc := range report.Channels {
+		copied += mc.Copied
+		subs += mc.Subs
+	}
+	log.Noticef("Migrated %v channel(s), %v message(s), %v client(s), %v subscription(s)",
+		len(report.Channels), copied, report.Clients, subs)
+}
+
+func loadOptions(configFile string) (*stand.Options, error) {
+	opts := stand.GetDefaultOptions()
+	if err := stand.ProcessConfigFile(configFile, opts); err != nil {
+		return nil, fmt.Errorf("error processing %q: %v", configFile, err)
+	}
+	return opts, nil
+}
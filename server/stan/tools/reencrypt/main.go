@@ -0,0 +1,95 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command reencrypt re-encrypts the payloads of the messages of a NATS
+// Streaming FILE or SQL store with the current encryption key, so that
+// old keys can be retired. The server must not be running.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	natsdLogger "github.com/kubemq-io/broker/server/gnatsd/logger"
+	stand "github.com/kubemq-io/broker/server/stan/server"
+	"github.com/kubemq-io/broker/server/stan/stores"
+
+	_ "github.com/go-sql-driver/mysql"                             // mysql driver
+	_ "github.com/kubemq-io/broker/server/stan/stores/pqdeadlines" // wrapper for postgres that gives read/write deadlines
+	_ "github.com/lib/pq"                                          // postgres driver
+	_ "github.com/mattn/go-sqlite3"                                // sqlite driver
+)
+
+var usageStr = `
+Usage: reencrypt -config <file> [options]
+
+The store is described with a Streaming Server configuration file, using
+the store type, directory, file and SQL settings, and the encryption_cipher,
+encryption_key, encryption_old_keys and encryption_keys_dir settings. As for the server, the
+NATS_STREAMING_ENCRYPTION_KEY and NATS_STREAMING_ENCRYPTION_OLD_KEYS
+environment variables take precedence.
+
+Options:
+    -config <file>       Configuration file of the store
+
+Payloads encrypted with one of the old keys are re-encrypted with the
+encryption key. Once done, the old keys can be removed from the configuration.
+`
+
+func usage() {
+	fmt.Printf("%s\n", usageStr)
+	os.Exit(0)
+}
+
+func main() {
+	var configFile string
+	fs := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	fs.Usage = usage
+	fs.StringVar(&configFile, "config", "", "")
+	fs.Parse(os.Args[1:])
+	if configFile == "" {
+		usage()
+	}
+	log := natsdLogger.NewStdLogger(true, false, false, true, false)
+
+	sOpts := stand.GetDefaultOptions()
+	if err := stand.ProcessConfigFile(configFile, sOpts); err != nil {
+		log.Fatalf("Error processing %q: %v", configFile, err)
+	}
+	opts := &stores.ReEncryptOptions{
+		Cipher:         sOpts.EncryptionCipher,
+		Key:            sOpts.EncryptionKey,
+		OldKeys:        sOpts.EncryptionOldKeys,
+		ChannelKeysDir: sOpts.EncryptionKeysDir,
+		Log:            log,
+	}
+	var (
+		report *stores.ReEncryptReport
+		err    error
+	)
+	switch strings.ToUpper(sOpts.StoreType) {
+	case stores.TypeFile, stores.TypeHybrid:
+		report, err = stores.ReEncryptFileStore(sOpts.FilestoreDir, opts, stores.AllOptions(&sOpts.FileStoreOpts))
+	case stores.TypeSQL:
+		report, err = stores.ReEncryptSQLStore(sOpts.SQLStoreOpts.Driver, sOpts.SQLStoreOpts.Source, opts)
+	default:
+		log.Fatalf("Can't re-encrypt a %v store", sOpts.StoreType)
+	}
+	if err != nil {
+		log.Fatalf("Re-encryption failed: %v", err)
+	}
+	fmt.Printf("Re-encrypted %v of %v message(s) in %v channel(s), wrapped %v channel key(s) again\n",
+		report.ReEncrypted, report.Msgs, report.Channels, report.Rewrapped)
+}
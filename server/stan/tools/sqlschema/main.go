@@ -0,0 +1,103 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command sqlschema creates or migrates the schema of a NATS Streaming SQL
+// store, or prints the statements that would be executed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	natsdLogger "github.com/kubemq-io/broker/server/gnatsd/logger"
+	stand "github.com/kubemq-io/broker/server/stan/server"
+	"github.com/kubemq-io/broker/server/stan/stores"
+
+	_ "github.com/go-sql-driver/mysql"                             // mysql driver
+	_ "github.com/kubemq-io/broker/server/stan/stores/pqdeadlines" // wrapper for postgres that gives read/write deadlines
+	_ "github.com/lib/pq"                                          // postgres driver
+	_ "github.com/mattn/go-sqlite3"                                // sqlite driver
+)
+
+var usageStr = `
+Usage: sqlschema -config <file> [options]
+
+The database is described with a Streaming Server configuration file,
+using the SQL driver and source settings.
+
+Options:
+    -config <file>       Configuration file of the store
+    -dry_run             Print the statements that would be executed, without executing them
+    -D                   Enable debug output
+
+The server creates and migrates the schema on startup unless the SQL
+option no_migration is set. This command allows to do it beforehand.
+`
+
+func usage() {
+	fmt.Printf("%s\n", usageStr)
+	os.Exit(0)
+}
+
+func main() {
+	var (
+		configFile string
+		dryRun     bool
+		debug      bool
+	)
+	fs := flag.NewFlagSet("sqlschema", flag.ExitOnError)
+	fs.Usage = usage
+	fs.StringVar(&configFile, "config", "", "")
+	fs.BoolVar(&dryRun, "dry_run", false, "")
+	fs.BoolVar(&debug, "D", false, "")
+	fs.Parse(os.Args[1:])
+	if configFile == "" {
+		usage()
+	}
+	log := natsdLogger.NewStdLogger(true, debug, false, true, false)
+
+	sOpts := stand.GetDefaultOptions()
+	if err := stand.ProcessConfigFile(configFile, sOpts); err != nil {
+		log.Fatalf("Error processing %q: %v", configFile, err)
+	}
+	if st := strings.ToUpper(sOpts.StoreType); st != stores.TypeSQL {
+		log.Fatalf("Store type is %v, not %v", st, stores.TypeSQL)
+	}
+	driver, source := sOpts.SQLStoreOpts.Driver, sOpts.SQLStoreOpts.Source
+
+	version, migrations, err := stores.SQLSchemaMigrations(driver, source)
+	if err != nil {
+		log.Fatalf("Unable to get schema version: %v", err)
+	}
+	if len(migrations) == 0 {
+		fmt.Printf("Schema is up to date (version %v)\n", version)
+		return
+	}
+	if dryRun {
+		for _, m := range migrations {
+			fmt.Printf("-- Version %v: %s\n", m.Version, m.Description)
+			for _, stmt := range m.Statements {
+				fmt.Printf("%s;\n", stmt)
+			}
+		}
+		return
+	}
+	s, err := stores.NewSQLStore(log, driver, source, nil, stores.SQLMaxOpenConns(1))
+	if err != nil {
+		log.Fatalf("Unable to migrate schema: %v", err)
+	}
+	s.Close()
+	fmt.Printf("Schema migrated from version %v to version %v\n", version, migrations[len(migrations)-1].Version)
+}
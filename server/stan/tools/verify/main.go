@@ -0,0 +1,106 @@
+// Copyright 2016-2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command verify checks the integrity of a NATS Streaming FILE or SQL store
+// and optionally repairs it. The server must not be running.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	natsdLogger "github.com/kubemq-io/broker/server/gnatsd/logger"
+	stand "github.com/kubemq-io/broker/server/stan/server"
+	"github.com/kubemq-io/broker/server/stan/stores"
+
+	_ "github.com/go-sql-driver/mysql"                             // mysql driver
+	_ "github.com/kubemq-io/broker/server/stan/stores/pqdeadlines" // wrapper for postgres that gives read/write deadlines
+	_ "github.com/lib/pq"                                          // postgres driver
+	_ "github.com/mattn/go-sqlite3"                                // sqlite driver
+)
+
+var usageStr = `
+Usage: verify -config <file> [options]
+
+The store is described with a Streaming Server configuration file, using
+the store type, directory, file and SQL settings.
+
+Options:
+    -config <file>       Configuration file of the store
+    -rebuild_index       Rewrite the index files that do not match their data file (FILE store only)
+    -quarantine <dir>    Move corrupted records to this directory, removing them from the store
+    -D                   Enable debug output
+
+The command exits with status 1 if problems remain after the repairs.
+Sequence gaps and orphaned records are reported but never repaired.
+Repairs require the exclusive lock of the store (the one used in FT mode),
+and fail if it is held by another process.
+`
+
+func usage() {
+	fmt.Printf("%s\n", usageStr)
+	os.Exit(0)
+}
+
+func main() {
+	var (
+		configFile string
+		opts       stores.VerifyOptions
+		debug      bool
+	)
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = usage
+	fs.StringVar(&configFile, "config", "", "")
+	fs.BoolVar(&opts.RebuildIndex, "rebuild_index", false, "")
+	fs.StringVar(&opts.QuarantineDir, "quarantine", "", "")
+	fs.BoolVar(&debug, "D", false, "")
+	fs.Parse(os.Args[1:])
+	if configFile == "" {
+		usage()
+	}
+	log := natsdLogger.NewStdLogger(true, debug, false, true, false)
+	if debug {
+		opts.Log = log
+	}
+
+	sOpts := stand.GetDefaultOptions()
+	if err := stand.ProcessConfigFile(configFile, sOpts); err != nil {
+		log.Fatalf("Error processing %q: %v", configFile, err)
+	}
+	var (
+		report *stores.VerifyReport
+		err    error
+	)
+	switch strings.ToUpper(sOpts.StoreType) {
+	case stores.TypeFile, stores.TypeHybrid:
+		report, err = stores.VerifyFileStore(sOpts.FilestoreDir, &opts, stores.AllOptions(&sOpts.FileStoreOpts))
+	case stores.TypeSQL:
+		report, err = stores.VerifySQLStore(sOpts.SQLStoreOpts.Driver, sOpts.SQLStoreOpts.Source, &opts)
+	default:
+		log.Fatalf("Can't verify a %v store", sOpts.StoreType)
+	}
+	if err != nil {
+		log.Fatalf("Verification failed: %v", err)
+	}
+	for _, p := range report.Problems {
+		fmt.Println(p)
+	}
+	unrepaired := report.Unrepaired()
+	fmt.Printf("Verified %v client(s), %v channel(s), %v message(s), %v subscription(s): %v problem(s), %v repaired\n",
+		report.Clients, report.Channels, report.Msgs, report.Subs, len(report.Problems), len(report.Problems)-unrepaired)
+	if unrepaired > 0 {
+		os.Exit(1)
+	}
+}